@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/client"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+)
+
+// Итог команды, которая меняет баннер
+type result struct {
+	BannerID int    `json:"banner_id"`
+	Status   string `json:"status"`
+}
+
+// parse разбирает флаги команды и проверяет число позиционных аргументов
+func parse(flags *flag.FlagSet, args []string, positional ...string) ([]string, error) {
+
+	flags.Usage = func() {}
+	if err := flags.Parse(args); err != nil {
+		return nil, usageError{err}
+	}
+
+	if flags.NArg() != len(positional) {
+		return nil, usagef("expected arguments: %v, got %v", positional, flags.Args())
+	}
+
+	return flags.Args(), nil
+}
+
+func parseID(name, value string) (int, error) {
+	id, err := strconv.Atoi(value)
+	if err != nil || id <= 0 {
+		return 0, usagef("%s must be positive integer, got %q", name, value)
+	}
+	return id, nil
+}
+
+// Один id баннера в аргументах
+func parseBannerID(flags *flag.FlagSet, args []string) (int, error) {
+	positional, err := parse(flags, args, "id")
+	if err != nil {
+		return 0, err
+	}
+	return parseID("id", positional[0])
+}
+
+func (c cli) list(ctx context.Context, flags *flag.FlagSet, args []string) error {
+
+	var filter client.Filter
+
+	flags.IntVar(&filter.FeatureID, "feature", 0, "feature id")
+	flags.IntVar(&filter.TagID, "tag", 0, "tag id")
+	flags.IntVar(&filter.Limit, "limit", 0, "max number of banners")
+	flags.IntVar(&filter.Offset, "offset", 0, "number of banners to skip")
+
+	if _, err := parse(flags, args); err != nil {
+		return err
+	}
+
+	banners, err := c.client.Banners(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	return c.print(banners, func(w io.Writer) { printBanners(w, banners) })
+}
+
+func (c cli) show(ctx context.Context, flags *flag.FlagSet, args []string) error {
+
+	bannerID, err := parseBannerID(flags, args)
+	if err != nil {
+		return err
+	}
+
+	banner, err := c.client.Banner(ctx, bannerID)
+	if err != nil {
+		return err
+	}
+
+	return c.print(banner, func(w io.Writer) { printBanner(w, banner) })
+}
+
+func (c cli) create(ctx context.Context, flags *flag.FlagSet, args []string) error {
+
+	file := flags.String("f", "", "JSON or YAML file with banner, - for stdin")
+	if _, err := parse(flags, args); err != nil {
+		return err
+	}
+
+	var banner models.BannerBody
+	if err := c.readBanner(*file, &banner); err != nil {
+		return err
+	}
+
+	bannerID, err := c.client.CreateBanner(ctx, banner)
+	if err != nil {
+		return err
+	}
+
+	return c.done(bannerID, "created")
+}
+
+// Поля файла накладываются на текущее состояние баннера, поэтому в файле достаточно изменений
+func (c cli) update(ctx context.Context, flags *flag.FlagSet, args []string) error {
+
+	file := flags.String("f", "", "JSON or YAML file with changed fields of banner, - for stdin")
+	bannerID, err := parseBannerID(flags, args)
+	if err != nil {
+		return err
+	}
+
+	current, err := c.client.Banner(ctx, bannerID)
+	if err != nil {
+		return err
+	}
+
+	banner := client.Body(current)
+	if err = c.readBanner(*file, &banner); err != nil {
+		return err
+	}
+
+	if err = c.client.UpdateBanner(ctx, bannerID, banner); err != nil {
+		return err
+	}
+
+	return c.done(bannerID, "updated")
+}
+
+func (c cli) activate(active bool) func(ctx context.Context, flags *flag.FlagSet, args []string) error {
+	return func(ctx context.Context, flags *flag.FlagSet, args []string) error {
+
+		bannerID, err := parseBannerID(flags, args)
+		if err != nil {
+			return err
+		}
+
+		if err = c.client.SetActive(ctx, bannerID, active); err != nil {
+			return err
+		}
+
+		if active {
+			return c.done(bannerID, "activated")
+		}
+		return c.done(bannerID, "deactivated")
+	}
+}
+
+func (c cli) delete(ctx context.Context, flags *flag.FlagSet, args []string) error {
+
+	bannerID, err := parseBannerID(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if err = c.client.DeleteBanner(ctx, bannerID); err != nil {
+		return err
+	}
+
+	return c.done(bannerID, "deleted")
+}
+
+func (c cli) version(ctx context.Context, flags *flag.FlagSet, args []string) error {
+
+	positional, err := parse(flags, args, "id", "version")
+	if err != nil {
+		return err
+	}
+
+	bannerID, err := parseID("id", positional[0])
+	if err != nil {
+		return err
+	}
+	version, err := parseID("version", positional[1])
+	if err != nil {
+		return err
+	}
+
+	if err = c.client.SwitchVersion(ctx, bannerID, version); err != nil {
+		return err
+	}
+
+	return c.done(bannerID, "switched to version "+strconv.Itoa(version))
+}
+
+// Выгрузка пишется как есть, формат вывода на нее не влияет
+func (c cli) export(ctx context.Context, flags *flag.FlagSet, args []string) error {
+
+	format := flags.String("format", models.FormatJSONL, "jsonl or csv")
+	history := flags.Bool("history", false, "export history of versions, jsonl only")
+	out := flags.String("out", "-", "output file, - for stdout")
+
+	if _, err := parse(flags, args); err != nil {
+		return err
+	}
+
+	if *out == "-" {
+		return c.client.Export(ctx, c.stdout, *format, *history)
+	}
+
+	file, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+
+	if err = c.client.Export(ctx, file, *format, *history); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func (c cli) importBanners(ctx context.Context, flags *flag.FlagSet, args []string) error {
+
+	options := models.ImportOptions{}
+
+	flags.StringVar(&options.Format, "format", models.FormatJSONL, "jsonl or csv")
+	flags.StringVar(&options.Mode, "mode", models.ImportAtomic, "atomic or best_effort")
+	flags.StringVar(&options.Conflict, "conflict", models.ConflictSkip, "skip or overwrite existing banners")
+	flags.BoolVar(&options.DryRun, "dry-run", false, "validate without applying")
+
+	positional, err := parse(flags, args, "file")
+	if err != nil {
+		return err
+	}
+
+	reader, closeFile, err := c.open(positional[0])
+	if err != nil {
+		return err
+	}
+	defer closeFile()
+
+	report, err := c.client.Import(ctx, reader, options)
+
+	// Отчет печатается и тогда, когда загрузка отклонена
+	var apiErr *client.APIError
+	if err != nil && !(errors.As(err, &apiErr) && report.Mode != "") {
+		return err
+	}
+
+	if printErr := c.print(report, func(w io.Writer) { printReport(w, report) }); printErr != nil {
+		return printErr
+	}
+
+	if err != nil {
+		return err
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("%d rows failed", report.Failed)
+	}
+
+	return nil
+}
+
+func (c cli) done(bannerID int, status string) error {
+	r := result{BannerID: bannerID, Status: status}
+	return c.print(r, func(w io.Writer) { fmt.Fprintf(w, "banner %d %s\n", r.BannerID, r.Status) })
+}
+
+// Файл или stdin для имени "-"
+func (c cli) open(name string) (io.Reader, func() error, error) {
+
+	if name == "-" {
+		return c.stdin, func() error { return nil }, nil
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, nil, usageError{err}
+	}
+
+	return file, file.Close, nil
+}
+
+func (c cli) readBanner(name string, banner *models.BannerBody) error {
+
+	if name == "" {
+		return usagef("file with banner is not set, use -f")
+	}
+
+	reader, closeFile, err := c.open(name)
+	if err != nil {
+		return err
+	}
+	defer closeFile()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+
+	if err = client.DecodeBanner(data, banner); err != nil {
+		return usagef("reading banner from %s: %w", name, err)
+	}
+
+	return nil
+}
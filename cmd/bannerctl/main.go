@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/client"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+)
+
+// Коды завершения
+const (
+	exitOK      = 0
+	exitFailure = 1 // request failed or API returned error
+	exitUsage   = 2 // bad command line or input file
+)
+
+const usageText = `Usage: bannerctl [-addr url] [-token token] [-o table|json] [-timeout 30s] <command> [flags] [args]
+
+Commands:
+  list [-feature id] [-tag id] [-limit n] [-offset n]   list banners
+  show <id>                                             banner with history of versions
+  create -f file                                        create banner from JSON or YAML file
+  update -f file <id>                                   update banner with fields of JSON or YAML file
+  activate <id>                                         turn banner on
+  deactivate <id>                                       turn banner off
+  delete <id>                                           delete banner
+  version <id> <version>                                switch banner to version from history
+  export [-format jsonl|csv] [-history] [-out file]     export all banners
+  import [-format jsonl|csv] [-mode atomic|best_effort] [-conflict skip|overwrite] [-dry-run] <file>
+
+Address and token are also taken from BANNERCTL_ADDR and BANNERCTL_TOKEN.
+`
+
+// Ошибка командной строки или входного файла
+type usageError struct{ error }
+
+func usagef(format string, args ...any) error {
+	return usageError{fmt.Errorf(format, args...)}
+}
+
+// Общие параметры всех команд
+type cli struct {
+	client *client.Client
+	output string // table or json
+	stdout io.Writer
+	stdin  io.Reader
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+
+	flags := flag.NewFlagSet("bannerctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, usageText) }
+
+	addr := flags.String("addr", env(models.BannerctlAddrEnv, models.DefaultBannerctlAddr), "base url of API")
+	token := flags.String("token", os.Getenv(models.BannerctlTokenEnv), "admin token")
+	output := flags.String("o", "table", "output format: table or json")
+	timeout := flags.Duration("timeout", models.DefaultBannerctlTimeout, "timeout of command")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+	if *output != "table" && *output != "json" {
+		fmt.Fprintf(stderr, "bannerctl: unknown output %q, expected table or json\n", *output)
+		return exitUsage
+	}
+	if *token == "" {
+		fmt.Fprintf(stderr, "bannerctl: token is not set, use -token or %s\n", models.BannerctlTokenEnv)
+		return exitUsage
+	}
+
+	c := cli{
+		client: client.New(*addr, *token),
+		output: *output,
+		stdout: stdout,
+		stdin:  stdin,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	command, rest := flags.Arg(0), flags.Args()[1:]
+
+	err := c.execute(ctx, command, rest, stderr)
+	if err == nil {
+		return exitOK
+	}
+
+	fmt.Fprintf(stderr, "bannerctl %s: %v\n", command, err)
+
+	var usage usageError
+	if errors.As(err, &usage) {
+		return exitUsage
+	}
+	return exitFailure
+}
+
+func (c cli) execute(ctx context.Context, command string, args []string, stderr io.Writer) error {
+
+	var run func(ctx context.Context, flags *flag.FlagSet, args []string) error
+
+	switch command {
+	case "list":
+		run = c.list
+	case "show":
+		run = c.show
+	case "create":
+		run = c.create
+	case "update":
+		run = c.update
+	case "activate":
+		run = c.activate(true)
+	case "deactivate":
+		run = c.activate(false)
+	case "delete":
+		run = c.delete
+	case "version":
+		run = c.version
+	case "export":
+		run = c.export
+	case "import":
+		run = c.importBanners
+	default:
+		return usagef("unknown command, see bannerctl -h")
+	}
+
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	return run(ctx, flags, args)
+}
+
+func env(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+)
+
+// print выводит value в JSON или таблицей через table
+func (c cli) print(value any, table func(w io.Writer)) error {
+
+	if c.output == "json" {
+		encoder := json.NewEncoder(c.stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+func printBanners(w io.Writer, banners []models.ResponseBody) {
+
+	fmt.Fprintln(w, "ID\tFEATURE\tTAGS\tACTIVE\tTITLE\tURL")
+	for _, b := range banners {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%t\t%s\t%s\n", b.BannerID, b.FeatureID, tags(b.TagID), b.Active, b.Content.Title, b.Content.Url)
+	}
+}
+
+// Версия, совпадающая с текущим содержимым, отмечается звездочкой
+func printBanner(w io.Writer, banner models.ExportBanner) {
+
+	fmt.Fprintf(w, "ID:\t%d\n", banner.BannerID)
+	fmt.Fprintf(w, "Feature:\t%d\n", banner.FeatureID)
+	fmt.Fprintf(w, "Tags:\t%s\n", tags(banner.TagID))
+	fmt.Fprintf(w, "Active:\t%t\n", banner.Active)
+	fmt.Fprintf(w, "Title:\t%s\n", banner.Content.Title)
+	fmt.Fprintf(w, "Text:\t%s\n", banner.Content.Text)
+	fmt.Fprintf(w, "URL:\t%s\n", banner.Content.Url)
+
+	if len(banner.History) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "VERSION\tTITLE\tTEXT\tURL")
+	for _, h := range banner.History {
+		current := " "
+		if h.Title == banner.Content.Title && h.Text == banner.Content.Text && h.Url == banner.Content.Url {
+			current = "*"
+		}
+		fmt.Fprintf(w, "%s%d\t%s\t%s\t%s\n", current, h.Version, h.Title, h.Text, h.Url)
+	}
+}
+
+func printReport(w io.Writer, report models.ImportReport) {
+
+	fmt.Fprintf(w, "mode %s, dry run %t, committed %t\n", report.Mode, report.DryRun, report.Committed)
+	fmt.Fprintf(w, "created %d, updated %d, skipped %d, failed %d\n", report.Created, report.Updated, report.Skipped, report.Failed)
+
+	if report.Failed == 0 {
+		return
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "ROW\tERROR")
+	for _, row := range report.Rows {
+		if row.Status == models.ImportFailed {
+			fmt.Fprintf(w, "%d\t%s\n", row.Row, row.Error)
+		}
+	}
+}
+
+func tags(ids []uint32) string {
+
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		parts = append(parts, fmt.Sprint(id))
+	}
+
+	return strings.Join(parts, ",")
+}
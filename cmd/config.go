@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/config"
+)
+
+// Print resolved config with secrets redacted, exit code is non-zero if config is invalid
+//
+//	main config print -config ./app.env -CacheTTL 10m
+func runConfig(args []string) error {
+
+	if len(args) == 0 || args[0] != "print" {
+		return fmt.Errorf("usage: config print [flags]")
+	}
+
+	flags := flag.NewFlagSet("config print", flag.ContinueOnError)
+	configFlags := config.RegisterFlags(flags)
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	cfg, err := configFlags.Read()
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(cfg)
+
+	// Config is printed even if invalid, so it is visible which setting is wrong
+	if err = cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return fmt.Errorf("config is invalid")
+	}
+
+	return nil
+}
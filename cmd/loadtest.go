@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/config"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/loadtest"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/repository"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+)
+
+// Seed banners through repository, mint user tokens and load GET /api/user_banner of running instance
+//
+//	main loadtest -addr http://localhost:8080 -features 100 -tags 10 -c 64 -d 30s -last 0.1
+func runLoadtest(args []string) error {
+
+	var options loadtest.Options
+
+	flags := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	configFlags := config.RegisterFlags(flags)
+	seed := flags.Bool("seed", true, "create banners for all feature and tag pairs before load")
+	flags.StringVar(&options.Addr, "addr", models.DefaultLoadtestAddr, "base url of running instance")
+	flags.IntVar(&options.Features, "features", 100, "number of features, ids 1..features")
+	flags.IntVar(&options.Tags, "tags", 10, "number of tags, ids 1..tags")
+	flags.IntVar(&options.Users, "users", 100, "number of distinct user tokens")
+	flags.IntVar(&options.Concurrency, "c", 64, "parallel clients")
+	flags.DurationVar(&options.Duration, "d", 30*time.Second, "duration of load")
+	flags.IntVar(&options.Requests, "n", 0, "stop after this many requests, 0 means only by duration")
+	flags.IntVar(&options.RPS, "rps", 0, "overall request rate, 0 means unlimited")
+	flags.Float64Var(&options.LastRatio, "last", 0.1, "share of requests with use_last_revision=true")
+	flags.DurationVar(&options.Timeout, "timeout", 5*time.Second, "timeout of one request")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if options.LastRatio < 0 || options.LastRatio > 1 {
+		return fmt.Errorf("last must be between 0 and 1")
+	}
+	if options.Users <= 0 {
+		return fmt.Errorf("users must be positive")
+	}
+
+	// Reading config
+	cfg, err := configFlags.Load()
+	if err != nil {
+		return err
+	}
+
+	// Ctrl+C stops load and prints report of what is done
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if *seed {
+		repository, err := repository.New(cfg)
+		if err != nil {
+			return err
+		}
+
+		created, err := loadtest.Seed(ctx, repository, options.Features, options.Tags)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("seeded %d banners, %d pairs in total\n", created, options.Features*options.Tags)
+	}
+
+	// Токены живут дольше нагрузки
+	tokens, err := loadtest.Tokens(token.NewIssuer(cfg), options.Users, options.Duration+time.Hour)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("loading %s/api/user_banner with %d clients\n", options.Addr, options.Concurrency)
+
+	report, err := loadtest.Run(ctx, options, tokens)
+	if err != nil {
+		return err
+	}
+
+	report.Print(os.Stdout)
+	return nil
+}
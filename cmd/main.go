@@ -1,40 +1,58 @@
 package main
 
 import (
-	"context"
 	"log"
-	"net/http"
-	"time"
+	"os"
 
 	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/app"
 )
 
 func main() {
 
+	// Subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "token":
+			if err := runToken(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "loadtest":
+			if err := runLoadtest(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "config":
+			if err := runConfig(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
 	// Init application
-	app, err := app.New()
+	app, err := app.New(os.Args[1:])
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Start server for proccesiing request
-	go func() {
-		log.Println("Server is start")
-		if err := app.Server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("HTTP server ListenAndServe error: %v", err)
-		}
-	}()
-
-	// Given signal for shutdown
-	sig := <-app.Sigint
-	log.Printf("Received signal: %v", sig)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	// Shutdown server
-	if err := app.Server.Shutdown(ctx); err != nil {
-		log.Printf("server shutdown error: %v", err)
+	// Start storages, background workers, gRPC and HTTP servers
+	if err = app.Start(); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Server is start")
+
+	// Given signal for shutdown or server failure
+	select {
+	case sig := <-app.Sigint:
+		log.Printf("Received signal: %v", sig)
+	case err = <-app.Errors():
+		log.Printf("server error: %v", err)
 	}
 
+	// Servers are stopped first, storages last
+	if err = app.Stop(); err != nil {
+		log.Printf("shutdown error: %v", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/config"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+)
+
+// Mint signed token with keys from config and print it to stdout
+//
+//	main token -role admin -sub qa -scopes banner:read,banner:write -ttl 1h -tenant shop
+func runToken(args []string) error {
+
+	flags := flag.NewFlagSet("token", flag.ContinueOnError)
+	configFlags := config.RegisterFlags(flags)
+	role := flags.String("role", models.RoleUser, "token role: admin or user")
+	subject := flags.String("sub", "", "subject of token")
+	scopes := flags.String("scopes", "", "comma separated scopes")
+	ttl := flags.Duration("ttl", models.DefaultTokenTTL, "token lifetime")
+	tenant := flags.String("tenant", "", "tenant (project) of token, default tenant if empty")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	// Reading config
+	cfg, err := configFlags.Load()
+	if err != nil {
+		return err
+	}
+
+	tokenRequest := models.TokenRequest{
+		Role:    *role,
+		Subject: *subject,
+		TTL:     ttl.String(),
+		Tenant:  *tenant,
+	}
+	if *scopes != "" {
+		tokenRequest.Scopes = strings.Split(*scopes, ",")
+	}
+
+	tokenResponse, err := token.NewIssuer(cfg).Issue(tokenRequest)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(tokenResponse.Token)
+	return nil
+}
@@ -1,6 +1,8 @@
 package app
 
 import (
+	"context"
+	"flag"
 	"net"
 	"net/http"
 	"os"
@@ -8,20 +10,35 @@ import (
 	"syscall"
 
 	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/config"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/events"
 	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/logger"
 	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/middlewares"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/ratelimit"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/repository"
 	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/route"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/rpc"
 	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/service"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/storage/cache"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/storage/database"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/webhook"
+	"google.golang.org/grpc"
 )
 
 // Application struct
 type application struct {
-	Server  *http.Server     // the server that processes requests for funds transfer
-	Service service.Servicer // service for processing request
-	Sigint  chan os.Signal   // channel for given signal for graceful shutdown
+	Server    *http.Server     // the server that processes requests for funds transfer
+	GRPC      *grpc.Server     // gRPC server with the same banner API
+	GRPCAddr  string           // address of gRPC server
+	Service   service.Servicer // service for processing request
+	Sigint    chan os.Signal   // channel for given signal for graceful shutdown
+	lifecycle *lifecycle       // starts and stops components in dependency order
+	errs      chan error       // servers which stopped serving unexpectedly
 }
 
-func New() (application, error) {
+// New builds application, args are command-line flags overriding config file and environment.
+// Nothing is served until Start
+func New(args []string) (application, error) {
 
 	// Create new connect to logger
 	log, err := logger.New()
@@ -29,38 +46,238 @@ func New() (application, error) {
 		return application{}, err
 	}
 
-	// Reading config file
-	cfg, err := config.LoadConfig("../")
+	// Reading config
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	configFlags := config.RegisterFlags(flags)
+	if err = flags.Parse(args); err != nil {
+		return application{}, err
+	}
+
+	cfg, err := configFlags.Load()
 	if err != nil {
-		log.Log.Error("read config file is fail: ", err)
+		log.Log.Error("load config is fail: ", err)
+		return application{}, err
+	}
+
+	if err = log.SetLevel(cfg.LogLevel); err != nil {
 		return application{}, err
 	}
 
-	// Initialization service
-	service, err := service.New(log, cfg)
+	lifecycle := &lifecycle{log: log}
+
+	// Connect to postgreSQL database
+	db, err := database.Connect(cfg.DSN, database.Pool{
+		MaxOpenConns:    cfg.DBMaxOpenConns,
+		MaxIdleConns:    cfg.DBMaxIdleConns,
+		ConnMaxLifetime: cfg.DBConnMaxLifetime,
+	})
 	if err != nil {
 		return application{}, err
 	}
 
+	// Connect to redis database
+	bannerCache := cache.New(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisPoolSize, cfg.CacheTTL)
+
+	// Хранилища закрываются последними, когда их уже никто не использует
+	lifecycle.add(component{name: "database", timeout: cfg.StorageShutdownTimeout, stop: closer(db.Close)})
+	lifecycle.add(component{name: "cache", timeout: cfg.StorageShutdownTimeout, stop: closer(bannerCache.Close)})
+
+	// Create a new repository
+	repository := repository.NewWithStorage(db, bannerCache, cfg)
+
+	// Initialization service, issuer is kept to swap its keys on reload
+	issuer := token.NewIssuer(cfg)
+	service := service.NewWithIssuer(log, repository, issuer)
+
+	// Init rate limiter
+	var limiter ratelimit.Limiter
+	switch cfg.RateLimitBackend {
+	case "redis":
+		client := cache.NewClient(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisPoolSize)
+		limiter = ratelimit.NewRedis(client)
+		lifecycle.add(component{name: "rate limiter", timeout: cfg.StorageShutdownTimeout, stop: closer(client.Close)})
+	default:
+		limiter = ratelimit.NewMemory()
+	}
+
+	// Init events publisher
+	var publisher events.Publisher
+	switch cfg.EventsPublisher {
+	case "amqp":
+		publisher = events.NewAMQP(cfg.Rabbit, cfg.EventsExchange)
+	default:
+		publisher = events.NewLog(log)
+	}
+	lifecycle.add(component{name: "events publisher", timeout: cfg.StorageShutdownTimeout, stop: closer(publisher.Close)})
+
+	// Impressions and clicks buffered after last flush are saved when workers and servers are stopped
+	lifecycle.add(component{name: "stats", timeout: cfg.StorageShutdownTimeout, stop: repository.FlushStats})
+
 	// Init middlewares
-	middlewares := middlewares.New(cfg.AdminSecretKey, cfg.UserSecretKey, log)
+	middlewares := middlewares.New(cfg, limiter, log)
 
 	// Init new router
 	route := route.New(service, middlewares)
 
+	// Init gRPC server on its own port
+	auth := rpc.NewAuthenticator(cfg, log)
+	grpcServer := rpc.NewWithAuthenticator(log, auth, repository)
+
+	// Background workers
+	workers := &workers{log: log}
+
 	// Init server
-	server := &http.Server{
-		Addr:    net.JoinHostPort(cfg.Host, cfg.Port),
-		Handler: route,
+	server, err := newHTTPServer(cfg, route, log, workers)
+	if err != nil {
+		_ = lifecycle.abort()
+		return application{}, err
 	}
 
+	// Purge deleted banners after retention window
+	workers.Every("purge", cfg.PurgeInterval, func(ctx context.Context) error {
+		n, err := repository.PurgeBanners(ctx)
+		if n > 0 {
+			log.Log.Infof("purged %d deleted banners", n)
+		}
+		return err
+	})
+
+	// Flush buffered impressions and clicks
+	workers.Every("stats", cfg.StatsFlushInterval, repository.FlushStats)
+
+	// Relay banner events from outbox to broker
+	workers.Every("outbox", cfg.OutboxInterval, relayEvents(repository, publisher))
+
+	// Deliver webhooks to subscribers
+	deliverer := webhook.NewDeliverer(cfg.WebhookTimeout, cfg.WebhookMaxAttempts, cfg.WebhookBackoff, cfg.WebhookMaxBackoff)
+	workers.Every("webhook", cfg.WebhookInterval, deliverWebhooks(repository, deliverer, log))
+
+	// Reload config on SIGHUP and, if enabled, on change of config file
+	reloader := &reloader{
+		flags:       configFlags,
+		current:     cfg,
+		log:         log,
+		middlewares: middlewares,
+		auth:        auth,
+		issuer:      issuer,
+		repository:  repository,
+	}
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	workers.Go("reload", func(ctx context.Context) {
+		reloader.run(ctx, sighup, cfg.ConfigWatch)
+	})
+
+	lifecycle.add(component{name: "workers", timeout: cfg.WorkersShutdownTimeout, start: workers.start, stop: workers.stop})
+
+	// Servers are started last and stopped first, so requests in progress can use all components
+	errs := make(chan error, 2)
+	grpcAddr := net.JoinHostPort(cfg.Host, cfg.GRPCPort)
+
+	lifecycle.add(component{
+		name:    "gRPC server",
+		timeout: cfg.ShutdownTimeout,
+		start: func() error {
+			listener, err := net.Listen("tcp", grpcAddr)
+			if err != nil {
+				return err
+			}
+			log.Log.Infof("gRPC server is listening on %s", grpcAddr)
+			go func() {
+				if err := grpcServer.Serve(listener); err != nil {
+					errs <- err
+				}
+			}()
+			return nil
+		},
+		stop: func(ctx context.Context) error {
+			return stopGRPC(ctx, grpcServer)
+		},
+	})
+
+	lifecycle.add(component{
+		name:    "HTTP server",
+		timeout: cfg.ShutdownTimeout,
+		start: func() error {
+			listener, err := net.Listen("tcp", server.Addr)
+			if err != nil {
+				return err
+			}
+			log.Log.Infof("HTTP server is listening on %s, TLS %t", server.Addr, server.TLSConfig != nil)
+			go func() {
+				if err := serve(server, listener); err != nil && err != http.ErrServerClosed {
+					errs <- err
+				}
+			}()
+			return nil
+		},
+		stop: server.Shutdown,
+	})
+
 	// Creating channel for graceful shutdown
 	sigint := make(chan os.Signal, 1)
 	signal.Notify(sigint, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
 
 	return application{
-		Server: server,
-		Sigint: sigint,
+		Server:    server,
+		GRPC:      grpcServer,
+		GRPCAddr:  grpcAddr,
+		Service:   service,
+		Sigint:    sigint,
+		lifecycle: lifecycle,
+		errs:      errs,
 	}, nil
 
 }
+
+// Start starts components in dependency order: storages, workers, gRPC and HTTP servers
+func (a application) Start() error {
+	return a.lifecycle.Start()
+}
+
+// Stop stops servers, drains workers, flushes buffered stats and closes storages
+func (a application) Stop() error {
+	return a.lifecycle.Stop()
+}
+
+// Errors reports servers which stopped serving without Stop
+func (a application) Errors() <-chan error {
+	return a.errs
+}
+
+// Unfinished calls are cancelled after deadline
+func stopGRPC(ctx context.Context, server *grpc.Server) error {
+
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		server.Stop()
+		return ctx.Err()
+	}
+}
+
+// Close of client does not take context, so deadline only stops waiting for it
+func closer(close func() error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+
+		closed := make(chan error, 1)
+		go func() {
+			closed <- close()
+		}()
+
+		select {
+		case err := <-closed:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/logger"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/worker"
+)
+
+// Part of application with its own start and stop
+type component struct {
+	name    string
+	timeout time.Duration                   // deadline of stop
+	start   func() error                    // must not block, nil if component is ready after build
+	stop    func(ctx context.Context) error // nil if nothing to release
+}
+
+// Lifecycle starts components in order they are added, so each one is started after its dependencies,
+// and stops started components in reverse order, each with its own deadline
+type lifecycle struct {
+	log        *logger.Logger
+	components []component
+	started    int // number of started components
+}
+
+func (l *lifecycle) add(c component) {
+	l.components = append(l.components, c)
+}
+
+// Start starts components one by one. If one fails, already started components are stopped
+func (l *lifecycle) Start() error {
+
+	for _, c := range l.components[l.started:] {
+		if c.start != nil {
+			if err := c.start(); err != nil {
+				err = fmt.Errorf("start %s: %w", c.name, err)
+				if stopErr := l.Stop(); stopErr != nil {
+					err = errors.Join(err, stopErr)
+				}
+				return err
+			}
+		}
+		l.started++
+		l.log.Log.Debugf("%s is started", c.name)
+	}
+
+	return nil
+}
+
+// abort releases components of application which failed to build and will never be started
+func (l *lifecycle) abort() error {
+	l.started = len(l.components)
+	return l.Stop()
+}
+
+// Stop stops started components in reverse order. Component which misses its deadline
+// is reported and the next one is stopped anyway
+func (l *lifecycle) Stop() error {
+
+	var errs []error
+
+	for ; l.started > 0; l.started-- {
+		c := l.components[l.started-1]
+		if c.stop == nil {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		begin := time.Now()
+		err := c.stop(ctx)
+		cancel()
+
+		if err != nil {
+			l.log.Log.Errorf("%s is not stopped cleanly in %s: %v", c.name, time.Since(begin).Round(time.Millisecond), err)
+			errs = append(errs, fmt.Errorf("stop %s: %w", c.name, err))
+			continue
+		}
+		l.log.Log.Infof("%s is stopped in %s", c.name, time.Since(begin).Round(time.Millisecond))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Background jobs of application, they are started and stopped together
+type workers struct {
+	log  *logger.Logger
+	jobs []workerJob
+
+	cancel  context.CancelFunc
+	done    sync.WaitGroup
+	mu      sync.Mutex
+	running map[string]struct{} // jobs which have not returned yet
+}
+
+type workerJob struct {
+	name string
+	run  func(ctx context.Context)
+}
+
+// Go registers job running until context is cancelled
+func (w *workers) Go(name string, run func(ctx context.Context)) {
+	w.jobs = append(w.jobs, workerJob{name: name, run: run})
+}
+
+// Every registers job repeated with interval, see worker.Every
+func (w *workers) Every(name string, interval time.Duration, job func(ctx context.Context) error) {
+	w.Go(name, func(ctx context.Context) {
+		worker.Every(ctx, name, interval, w.log, job)
+	})
+}
+
+func (w *workers) start() error {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	w.running = make(map[string]struct{}, len(w.jobs))
+
+	for _, job := range w.jobs {
+		w.running[job.name] = struct{}{}
+		w.done.Add(1)
+
+		go func(job workerJob) {
+			defer func() {
+				w.mu.Lock()
+				delete(w.running, job.name)
+				w.mu.Unlock()
+				w.done.Done()
+			}()
+			job.run(ctx)
+		}(job)
+	}
+
+	return nil
+}
+
+// stop cancels jobs and waits for them, jobs still running at deadline are reported
+func (w *workers) stop(ctx context.Context) error {
+
+	w.cancel()
+
+	stopped := make(chan struct{})
+	go func() {
+		w.done.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+	}
+
+	w.mu.Lock()
+	names := make([]string, 0, len(w.running))
+	for name := range w.running {
+		names = append(names, name)
+	}
+	w.mu.Unlock()
+	sort.Strings(names)
+
+	return fmt.Errorf("still running: %s", strings.Join(names, ", "))
+}
@@ -0,0 +1,172 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/logger"
+)
+
+func newLogger(t *testing.T) *logger.Logger {
+	t.Helper()
+
+	log, err := logger.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return log
+}
+
+// Компонент, записывающий запуск и остановку в общий журнал
+func recorded(journal *[]string, name string, startErr error) component {
+	return component{
+		name:    name,
+		timeout: time.Second,
+		start: func() error {
+			*journal = append(*journal, "start "+name)
+			return startErr
+		},
+		stop: func(ctx context.Context) error {
+			*journal = append(*journal, "stop "+name)
+			return nil
+		},
+	}
+}
+
+func TestLifecycleOrder(t *testing.T) {
+
+	var journal []string
+
+	l := &lifecycle{log: newLogger(t)}
+	l.add(recorded(&journal, "database", nil))
+	l.add(recorded(&journal, "workers", nil))
+	l.add(recorded(&journal, "server", nil))
+
+	if err := l.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Повторная остановка ничего не делает
+	if err := l.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "start database, start workers, start server, stop server, stop workers, stop database"
+	if got := strings.Join(journal, ", "); got != want {
+		t.Fatalf("journal = %s", got)
+	}
+}
+
+func TestLifecycleStartFailure(t *testing.T) {
+
+	var journal []string
+
+	l := &lifecycle{log: newLogger(t)}
+	l.add(recorded(&journal, "database", nil))
+	l.add(recorded(&journal, "workers", nil))
+	l.add(recorded(&journal, "server", errors.New("address in use")))
+
+	err := l.Start()
+	if err == nil || !strings.Contains(err.Error(), "start server: address in use") {
+		t.Fatalf("start error = %v", err)
+	}
+
+	// Уже запущенные компоненты останавливаются
+	want := "start database, start workers, start server, stop workers, stop database"
+	if got := strings.Join(journal, ", "); got != want {
+		t.Fatalf("journal = %s", got)
+	}
+}
+
+func TestLifecycleDeadlines(t *testing.T) {
+
+	var journal []string
+
+	l := &lifecycle{log: newLogger(t)}
+	l.add(recorded(&journal, "database", nil))
+	l.add(component{
+		name:    "stuck",
+		timeout: 50 * time.Millisecond,
+		stop: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	})
+
+	if err := l.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Зависший компонент не мешает остановить следующий
+	err := l.Stop()
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "stop stuck") {
+		t.Fatalf("stop error = %v", err)
+	}
+	if got := strings.Join(journal, ", "); got != "start database, stop database" {
+		t.Fatalf("journal = %s", got)
+	}
+}
+
+func TestWorkersStop(t *testing.T) {
+
+	w := &workers{log: newLogger(t)}
+
+	ticks := make(chan struct{}, 100)
+	w.Every("ticker", 10*time.Millisecond, func(ctx context.Context) error {
+		ticks <- struct{}{}
+		return nil
+	})
+
+	release := make(chan struct{})
+	w.Go("slow", func(ctx context.Context) {
+		<-ctx.Done()
+		<-release
+	})
+
+	if err := w.start(); err != nil {
+		t.Fatal(err)
+	}
+	<-ticks
+
+	// Задачи, не завершившиеся к сроку, перечисляются в ошибке
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := w.stop(ctx)
+	if err == nil || err.Error() != "still running: slow" {
+		t.Fatalf("stop error = %v", err)
+	}
+
+	close(release)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = w.stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCloser(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	block := make(chan struct{})
+	defer close(block)
+
+	err := closer(func() error { <-block; return nil })(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("close error = %v", err)
+	}
+
+	if err = closer(func() error { return nil })(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
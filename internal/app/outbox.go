@@ -0,0 +1,16 @@
+package app
+
+import (
+	"context"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/events"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/repository"
+)
+
+// Outbox worker: publishes stored banner events, event is marked published only after broker accepts it
+func relayEvents(repository repository.Repositorer, publisher events.Publisher) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := repository.RelayEvents(ctx, publisher.Publish)
+		return err
+	}
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/config"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/events"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/repository"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/storage/cache"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/storage/database"
+)
+
+var errBrokerDown = errors.New("broker is down")
+
+// Публикатор, который принимает limit событий, а затем отказывает
+type flakyPublisher struct {
+	*events.MemoryPublisher
+	limit int
+}
+
+func (p *flakyPublisher) Publish(ctx context.Context, event models.Event) error {
+	if len(p.Events()) >= p.limit {
+		return errBrokerDown
+	}
+	return p.MemoryPublisher.Publish(ctx, event)
+}
+
+func TestRelayEvents(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewWithStorage(database.NewMemory(), cache.NewMemory(time.Minute), config.Config{OutboxBatch: 2})
+
+	for _, kind := range []string{models.RegistryFeature, models.RegistryTag} {
+		if _, err := repo.CreateRegistryEntry(ctx, kind, models.RegistryEntry{ID: 1, Name: kind}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bannerID, err := repo.CreateBanner(ctx, models.BannerBody{TagID: 1, FeatureID: 1, Content: models.BannerContent{Title: "first"}, Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.UpdateBanner(ctx, models.BannerBody{TagID: 1, FeatureID: 1, Content: models.BannerContent{Title: "second"}, Active: true}, bannerID, false); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.DeleteBanner(ctx, bannerID); err != nil {
+		t.Fatal(err)
+	}
+
+	publisher := &flakyPublisher{MemoryPublisher: events.NewMemory()}
+	relay := relayEvents(repo, publisher)
+
+	tests := []struct {
+		name  string
+		limit int
+		err   error
+		types []string
+	}{
+		// Событие, которое брокер не принял, остается в outbox
+		{"broker fails", 0, errBrokerDown, []string{}},
+		// За один проход публикуется не больше OutboxBatch событий
+		{"batch", 10, nil, []string{models.EventBannerCreated, models.EventBannerUpdated}},
+		{"rest", 10, nil, []string{models.EventBannerCreated, models.EventBannerUpdated, models.EventBannerDeleted}},
+		{"nothing left", 10, nil, []string{models.EventBannerCreated, models.EventBannerUpdated, models.EventBannerDeleted}},
+	}
+
+	for _, tt := range tests {
+		publisher.limit = tt.limit
+		if err = relay(ctx); !errors.Is(err, tt.err) {
+			t.Fatalf("%s: err = %v, want %v", tt.name, err, tt.err)
+		}
+
+		published := publisher.Events()
+		types := make([]string, 0, len(published))
+		for _, event := range published {
+			if int(event.BannerID) != bannerID {
+				t.Fatalf("%s: event of banner %d", tt.name, event.BannerID)
+			}
+			types = append(types, event.Type)
+		}
+		if !reflect.DeepEqual(types, tt.types) {
+			t.Fatalf("%s: published %v, want %v", tt.name, types, tt.types)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/config"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/logger"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/middlewares"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/repository"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/rpc"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+	"github.com/fsnotify/fsnotify"
+)
+
+// Settings applied without restart, changes of others are only logged
+var hotSettings = map[string]bool{
+	"AdminSecretKey": true,
+	"UserSecretKey":  true,
+	"AdminToken":     true,
+	"UserToken":      true,
+	"CacheTTL":       true,
+	"LogLevel":       true,
+}
+
+// Reloads config on SIGHUP or change of config file and swaps settings in running components
+type reloader struct {
+	mu          sync.Mutex
+	flags       *config.Flags
+	current     config.Config // settings components are running with
+	log         *logger.Logger
+	middlewares *middlewares.Middlewares
+	auth        *rpc.Authenticator
+	issuer      *token.Issuer
+	repository  repository.Repositorer
+}
+
+// Invalid config is rejected and components keep running with old one
+func (r *reloader) reload() error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := r.flags.Load()
+	if err != nil {
+		r.log.Log.Errorf("config reload is rejected, old config is kept: %v", err)
+		return err
+	}
+
+	changes := config.Diff(r.current, cfg)
+	if len(changes) == 0 {
+		r.log.Log.Info("config reloaded, nothing changed")
+		return nil
+	}
+
+	// Уровень уже проверен при валидации, поэтому замена не оборвется на середине
+	if err = r.log.SetLevel(cfg.LogLevel); err != nil {
+		r.log.Log.Errorf("config reload is rejected, old config is kept: %v", err)
+		return err
+	}
+	r.middlewares.SetSecrets(cfg.AdminSecretKey, cfg.UserSecretKey)
+	r.auth.SetSecrets(cfg.AdminSecretKey, cfg.UserSecretKey)
+	r.issuer.SetKeys(cfg)
+	r.repository.SetCacheTTL(cfg.CacheTTL)
+
+	running := r.current
+	running.AdminSecretKey, running.UserSecretKey = cfg.AdminSecretKey, cfg.UserSecretKey
+	running.AdminToken, running.UserToken = cfg.AdminToken, cfg.UserToken
+	running.CacheTTL, running.LogLevel = cfg.CacheTTL, cfg.LogLevel
+	r.current = running
+
+	var applied, restart []string
+	for _, change := range changes {
+		if hotSettings[change.Key] {
+			applied = append(applied, change.String())
+		} else {
+			restart = append(restart, change.String())
+		}
+	}
+
+	if len(applied) > 0 {
+		r.log.Log.Infof("config reloaded: %s", strings.Join(applied, ", "))
+	}
+	if len(restart) > 0 {
+		r.log.Log.Warnf("config changes take effect after restart: %s", strings.Join(restart, ", "))
+	}
+
+	return nil
+}
+
+// run reloads config on every signal from hup and, if watch is set, on changes of config file
+func (r *reloader) run(ctx context.Context, hup <-chan os.Signal, watch bool) {
+
+	var changed <-chan struct{}
+
+	if file := r.flags.File(); watch && file != "" {
+		var err error
+		if changed, err = watchFile(ctx, file, r.log); err != nil {
+			r.log.Log.Errorf("watching config file %s is failed, reload only on SIGHUP: %v", file, err)
+		} else {
+			r.log.Log.Infof("watching config file %s", file)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.log.Log.Info("SIGHUP received, reloading config")
+			_ = r.reload()
+		case <-changed:
+			r.log.Log.Info("config file changed, reloading config")
+			_ = r.reload()
+		}
+	}
+}
+
+// Сигнал об изменении файла. Следим за каталогом, так как редакторы заменяют файл,
+// и ждем паузы в событиях, чтобы не читать файл, записанный наполовину
+func watchFile(ctx context.Context, file string, log *logger.Logger) (<-chan struct{}, error) {
+
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	changed := make(chan struct{}, 1)
+
+	go func() {
+		defer watcher.Close()
+
+		var settled <-chan time.Time
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) == file && event.Has(fsnotify.Write|fsnotify.Create) {
+					settled = time.After(models.ConfigWatchDelay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Log.Error("config watcher error: ", err)
+			case <-settled:
+				settled = nil
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return changed, nil
+}
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/certs"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/config"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/logger"
+)
+
+// HTTP server with timeouts and limits from config. With TLSCertFile server works over TLS
+// with HTTP/2, certificate is reread by background worker
+func newHTTPServer(cfg config.Config, handler http.Handler, log *logger.Logger, workers *workers) (*http.Server, error) {
+
+	server := &http.Server{
+		Addr:              net.JoinHostPort(cfg.Host, cfg.Port),
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+
+	if cfg.TLSCertFile == "" {
+		return server, nil
+	}
+
+	reloader, err := certs.NewReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	server.TLSConfig = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+
+	// Сертификат клиента проверяется, если передан, а нужен ли он, решают admin маршруты
+	if cfg.TLSClientCAFile != "" {
+		pool, err := certs.LoadCAPool(cfg.TLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		server.TLSConfig.ClientCAs = pool
+		server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	log.Log.Infof("TLS is enabled, certificate %s expires at %s", cfg.TLSCertFile, reloader.Certificate().Leaf.NotAfter)
+
+	workers.Every("certificate", cfg.TLSReloadInterval, func(ctx context.Context) error {
+		reloaded, err := reloader.ReloadIfChanged(ctx)
+		if reloaded {
+			log.Log.Infof("certificate %s is reloaded, expires at %s", cfg.TLSCertFile, reloader.Certificate().Leaf.NotAfter)
+		}
+		return err
+	})
+
+	return server, nil
+}
+
+// Serve HTTP or, if TLS is configured, HTTPS with HTTP/2
+func serve(server *http.Server, listener net.Listener) error {
+
+	if server.TLSConfig != nil {
+		// Сертификат берется из GetCertificate
+		return server.ServeTLS(listener, "", "")
+	}
+
+	return server.Serve(listener)
+}
@@ -0,0 +1,273 @@
+package app
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/config"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/logger"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/middlewares"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/ratelimit"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/repository"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/route"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/service"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/storage/cache"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/storage/database"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+)
+
+// Удостоверяющий центр для сертификатов сервера и клиентов
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+func newTestCA(t *testing.T, dir string) testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(dir, "ca.crt")
+	if err = os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return testCA{cert: cert, key: key, file: file}
+}
+
+// Сертификат, подписанный центром, записывается в файлы name.crt и name.key
+func (ca testCA) issue(t *testing.T, dir, name string, usage x509.ExtKeyUsage) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+// Запуск сервера на свободном порту, возвращает его адрес
+func serveTLS(t *testing.T, cfg config.Config) (*http.Server, string) {
+	t.Helper()
+
+	log, err := logger.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := repository.NewWithStorage(database.NewMemory(), cache.NewMemory(time.Minute), cfg)
+	handler := route.New(service.NewWithIssuer(log, repo, token.NewIssuer(cfg)), middlewares.New(cfg, ratelimit.NewMemory(), log))
+
+	workers := &workers{log: log}
+
+	server, err := newHTTPServer(cfg, handler, log, workers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = workers.start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := workers.stop(ctx); err != nil {
+			t.Error(err)
+		}
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go serve(server, listener)
+	t.Cleanup(func() { server.Close() })
+
+	return server, "https://" + listener.Addr().String()
+}
+
+func newClient(ca testCA, certFile, keyFile string) (*http.Client, error) {
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	tlsConfig := &tls.Config{RootCAs: roots}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig, ForceAttemptHTTP2: true},
+	}, nil
+}
+
+func TestServerTLSAndClientCertificates(t *testing.T) {
+
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	serverCert, serverKey := ca.issue(t, dir, "server", x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, dir, "deploy-bot", x509.ExtKeyUsageClientAuth)
+
+	cfg := config.Config{
+		AdminSecretKey:    "admin-secret",
+		UserSecretKey:     "user-secret",
+		ReadTimeout:       time.Second,
+		ReadHeaderTimeout: time.Second,
+		WriteTimeout:      2 * time.Second,
+		IdleTimeout:       3 * time.Second,
+		MaxHeaderBytes:    8 << 10,
+		TLSCertFile:       serverCert,
+		TLSKeyFile:        serverKey,
+		TLSReloadInterval: time.Minute,
+		TLSClientCAFile:   ca.file,
+		AdminClientAuth:   models.AdminClientAuthOptional,
+	}
+
+	server, addr := serveTLS(t, cfg)
+
+	if server.ReadTimeout != time.Second || server.ReadHeaderTimeout != time.Second || server.WriteTimeout != 2*time.Second ||
+		server.IdleTimeout != 3*time.Second || server.MaxHeaderBytes != 8<<10 {
+		t.Fatalf("server limits are not applied: %+v", server)
+	}
+
+	withCert, err := newClient(ca, clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withoutCert, err := newClient(ca, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(client *http.Client, adminToken string) *http.Response {
+		t.Helper()
+
+		request, err := http.NewRequest(http.MethodGet, addr+"/api/banner", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if adminToken != "" {
+			request.Header.Set("Token", adminToken)
+		}
+
+		response, err := client.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+
+		return response
+	}
+
+	// Сертификат клиента заменяет токен, соединение идет по HTTP/2
+	response := get(withCert, "")
+	if response.StatusCode != http.StatusOK || response.ProtoMajor != 2 {
+		t.Fatalf("client certificate: %d %s", response.StatusCode, response.Proto)
+	}
+
+	// Без сертификата admin маршруты по-прежнему доступны по токену
+	if response = get(withoutCert, ""); response.StatusCode != http.StatusBadRequest {
+		t.Fatalf("without certificate and token: %d", response.StatusCode)
+	}
+
+	issued, err := token.NewIssuer(cfg).Issue(models.TokenRequest{Role: models.RoleAdmin, TTL: "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response = get(withoutCert, issued.Token); response.StatusCode != http.StatusOK {
+		t.Fatalf("admin token: %d", response.StatusCode)
+	}
+
+	// В режиме require токена недостаточно
+	cfg.AdminClientAuth = models.AdminClientAuthRequire
+	_, addr = serveTLS(t, cfg)
+
+	if response = get(withoutCert, issued.Token); response.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("admin token when certificate is required: %d", response.StatusCode)
+	}
+	if response = get(withCert, ""); response.StatusCode != http.StatusOK {
+		t.Fatalf("client certificate when it is required: %d", response.StatusCode)
+	}
+
+	// Сертификат, подписанный другим центром, отклоняется при рукопожатии
+	otherDir := t.TempDir()
+	other := newTestCA(t, otherDir)
+	otherCert, otherKey := other.issue(t, otherDir, "stranger", x509.ExtKeyUsageClientAuth)
+	stranger, err := newClient(ca, otherCert, otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stranger.Get(addr + "/api/banner"); err == nil {
+		t.Fatal("certificate of unknown CA is accepted")
+	}
+}
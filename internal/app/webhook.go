@@ -0,0 +1,43 @@
+package app
+
+import (
+	"context"
+	"sync"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/logger"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/repository"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/webhook"
+)
+
+// Delivery worker: claims pending webhooks, posts them concurrently and stores results
+func deliverWebhooks(repository repository.Repositorer, deliverer *webhook.Deliverer, log *logger.Logger) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+
+		deliveries, err := repository.ClaimDeliveries(ctx)
+		if err != nil {
+			return err
+		}
+
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			go func(delivery models.WebhookDelivery) {
+				defer wg.Done()
+
+				result := deliverer.Deliver(ctx, delivery)
+				if result.Status == models.DeliveryDead {
+					log.Log.Warnf("webhook delivery %d to %s is dead after %d attempts: %s",
+						delivery.ID, delivery.URL, delivery.Attempts, result.LastError)
+				}
+
+				if err := repository.CompleteDelivery(ctx, result); err != nil {
+					log.Log.Errorf("saving webhook delivery %d is failed: %v", delivery.ID, err)
+				}
+			}(delivery)
+		}
+		wg.Wait()
+
+		return nil
+	}
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/config"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/repository"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/storage/cache"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/storage/database"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/webhook"
+)
+
+// Получатель, отвечающий заданным статусом и считающий запросы
+func receiver(t *testing.T, status int, calls *atomic.Int32) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL
+}
+
+func TestDeliverWebhooks(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewWithStorage(database.NewMemory(), cache.NewMemory(time.Minute), config.Config{WebhookBatch: 10})
+
+	var okCalls, failCalls atomic.Int32
+	subscriptions := map[string]int64{}
+	for name, url := range map[string]string{
+		"ok":   receiver(t, http.StatusNoContent, &okCalls),
+		"fail": receiver(t, http.StatusServiceUnavailable, &failCalls),
+	} {
+		subscription, err := repo.CreateWebhook(ctx, models.WebhookSubscription{URL: url})
+		if err != nil {
+			t.Fatal(err)
+		}
+		subscriptions[name] = subscription.ID
+	}
+
+	for _, kind := range []string{models.RegistryFeature, models.RegistryTag} {
+		if _, err := repo.CreateRegistryEntry(ctx, kind, models.RegistryEntry{ID: 1, Name: kind}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := repo.CreateBanner(ctx, models.BannerBody{TagID: 1, FeatureID: 1, Content: models.BannerContent{Title: "first"}, Active: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Вторая неудача исчерпывает попытки, повтор без задержки
+	deliver := deliverWebhooks(repo, webhook.NewDeliverer(time.Second, 2, 0, 0), newLogger(t))
+
+	tests := []struct {
+		name      string
+		okCalls   int32
+		failCalls int32
+		ok        string
+		fail      string
+	}{
+		{"first attempt", 1, 1, models.DeliveryDelivered, models.DeliveryPending},
+		{"retry", 1, 2, models.DeliveryDelivered, models.DeliveryDead},
+		{"nothing left", 1, 2, models.DeliveryDelivered, models.DeliveryDead},
+	}
+
+	for _, tt := range tests {
+		if err := deliver(ctx); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if okCalls.Load() != tt.okCalls || failCalls.Load() != tt.failCalls {
+			t.Fatalf("%s: calls = %d/%d, want %d/%d", tt.name, okCalls.Load(), failCalls.Load(), tt.okCalls, tt.failCalls)
+		}
+
+		for name, want := range map[string]string{"ok": tt.ok, "fail": tt.fail} {
+			deliveries, err := repo.GetDeliveries(ctx, models.DeliveryQuery{SubscriptionID: subscriptions[name], Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(deliveries) != 1 || deliveries[0].Status != want {
+				t.Fatalf("%s: %s deliveries = %+v, want status %s", tt.name, name, deliveries, want)
+			}
+		}
+	}
+}
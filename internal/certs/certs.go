@@ -0,0 +1,112 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reloader serves certificate of server and rereads it when files change,
+// so renewed certificate is used without restart
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	cert atomic.Pointer[tls.Certificate]
+
+	mu       sync.Mutex
+	modified time.Time // latest modification time of loaded files
+}
+
+// NewReloader loads certificate and key, invalid files fail start
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.ReloadIfChanged(context.Background()); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// GetCertificate is used as tls.Config.GetCertificate
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// ReloadIfChanged rereads files if any of them is modified after last load.
+// Invalid files are reported and server keeps the previous certificate.
+// First value is true if certificate is replaced
+func (r *Reloader) ReloadIfChanged(ctx context.Context) (bool, error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modified, err := r.modTime()
+	if err != nil {
+		return false, err
+	}
+	if !modified.After(r.modified) {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("load certificate %s: %w", r.certFile, err)
+	}
+
+	// Parsed leaf is needed to log expiration and saves parsing on handshakes
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return false, fmt.Errorf("parse certificate %s: %w", r.certFile, err)
+		}
+	}
+
+	r.cert.Store(&cert)
+	r.modified = modified
+
+	return true, nil
+}
+
+// Certificate returns certificate which is served now
+func (r *Reloader) Certificate() *tls.Certificate {
+	return r.cert.Load()
+}
+
+func (r *Reloader) modTime() (time.Time, error) {
+
+	var latest time.Time
+
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// LoadCAPool reads PEM certificates of CA which signs client certificates
+func LoadCAPool(caFile string) (*x509.CertPool, error) {
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", caFile)
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,131 @@
+package certs_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/certs"
+)
+
+// Самоподписанный сертификат с переданным common name
+func writeCertificate(t *testing.T, certFile, keyFile, name string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Время изменения файлов сдвигается вперед, чтобы не зависеть от точности часов файловой системы
+func touch(t *testing.T, shift time.Duration, files ...string) {
+	t.Helper()
+
+	at := time.Now().Add(shift)
+	for _, file := range files {
+		if err := os.Chtimes(file, at, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReloader(t *testing.T) {
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeCertificate(t, certFile, keyFile, "first")
+
+	reloader, err := certs.NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	served := func() string {
+		cert, err := reloader.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert.Leaf.Subject.CommonName
+	}
+
+	if served() != "first" {
+		t.Fatalf("certificate = %s", served())
+	}
+
+	// Без изменений файлы не перечитываются
+	if reloaded, err := reloader.ReloadIfChanged(context.Background()); err != nil || reloaded {
+		t.Fatalf("unchanged files: %v, %v", reloaded, err)
+	}
+
+	writeCertificate(t, certFile, keyFile, "second")
+	touch(t, time.Minute, certFile, keyFile)
+
+	if reloaded, err := reloader.ReloadIfChanged(context.Background()); err != nil || !reloaded || served() != "second" {
+		t.Fatalf("renewed certificate: %v, %v, %s", reloaded, err, served())
+	}
+
+	// Испорченный файл не заменяет действующий сертификат
+	if err = os.WriteFile(keyFile, []byte("broken"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, 2*time.Minute, keyFile)
+
+	if reloaded, err := reloader.ReloadIfChanged(context.Background()); err == nil || reloaded || served() != "second" {
+		t.Fatalf("broken key: %v, %v, %s", reloaded, err, served())
+	}
+}
+
+func TestNewReloaderFailsOnBadFiles(t *testing.T) {
+
+	dir := t.TempDir()
+
+	if _, err := certs.NewReloader(filepath.Join(dir, "missing.crt"), filepath.Join(dir, "missing.key")); err == nil {
+		t.Fatal("missing files are accepted")
+	}
+
+	if _, err := certs.LoadCAPool(filepath.Join(dir, "missing.pem")); err == nil {
+		t.Fatal("missing CA is accepted")
+	}
+
+	empty := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(empty, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := certs.LoadCAPool(empty); err == nil {
+		t.Fatal("CA without certificates is accepted")
+	}
+}
@@ -0,0 +1,421 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrBannerNotFound  = errors.New("banner not found")
+	ErrVersionNotFound = errors.New("version not found in history of banner")
+)
+
+// Ошибка, которую вернул API
+type APIError struct {
+	Status  int    // HTTP status of response
+	Message string // error of response body or status text
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d %s", e.Status, e.Message)
+}
+
+// Клиент admin REST API, все запросы идут с токеном администратора
+type Client struct {
+	addr  string
+	token string
+	http  *http.Client
+}
+
+// Фильтр списка баннеров, нулевые значения не ограничивают выборку
+type Filter struct {
+	FeatureID int
+	TagID     int
+	Limit     int
+	Offset    int
+}
+
+func New(addr, token string) *Client {
+	return NewWithHTTPClient(addr, token, &http.Client{Timeout: models.DefaultBannerctlTimeout})
+}
+
+func NewWithHTTPClient(addr, token string, httpClient *http.Client) *Client {
+	return &Client{
+		addr:  strings.TrimRight(addr, "/"),
+		token: token,
+		http:  httpClient,
+	}
+}
+
+// Banners возвращает баннеры фичи или тэга. API ищет только по фиче или тэгу,
+// поэтому список без фильтра строится по выгрузке всех баннеров
+func (c *Client) Banners(ctx context.Context, filter Filter) ([]models.ResponseBody, error) {
+
+	if filter.FeatureID == 0 && filter.TagID == 0 {
+		return c.allBanners(ctx, filter)
+	}
+
+	query := url.Values{}
+	if filter.FeatureID != 0 {
+		query.Set("feature_id", strconv.Itoa(filter.FeatureID))
+	}
+	if filter.TagID != 0 {
+		query.Set("tag_id", strconv.Itoa(filter.TagID))
+	}
+	if filter.Limit != 0 {
+		query.Set("limit", strconv.Itoa(filter.Limit))
+	}
+	if filter.Offset != 0 {
+		query.Set("offset", strconv.Itoa(filter.Offset))
+	}
+
+	banners := make([]models.ResponseBody, 0)
+	if err := c.do(ctx, http.MethodGet, "/api/banner", query, nil, &banners); err != nil {
+		return nil, err
+	}
+
+	return banners, nil
+}
+
+func (c *Client) allBanners(ctx context.Context, filter Filter) ([]models.ResponseBody, error) {
+
+	banners := make([]models.ResponseBody, 0)
+
+	err := c.exported(ctx, false, func(banner models.ExportBanner) bool {
+		banners = append(banners, models.ResponseBody{
+			BannerID:       banner.BannerID,
+			TagID:          banner.TagID,
+			FeatureID:      banner.FeatureID,
+			Content:        banner.Content,
+			Active:         banner.Active,
+			Priority:       banner.Priority,
+			FeatureDefault: banner.FeatureDefault,
+		})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if filter.Offset >= len(banners) {
+		return banners[:0], nil
+	}
+	banners = banners[filter.Offset:]
+	if filter.Limit > 0 && filter.Limit < len(banners) {
+		banners = banners[:filter.Limit]
+	}
+
+	return banners, nil
+}
+
+// Banner возвращает баннер вместе с историей версий
+func (c *Client) Banner(ctx context.Context, bannerID int) (models.ExportBanner, error) {
+
+	var banner models.ResponseBody
+
+	err := c.do(ctx, http.MethodGet, "/api/banner/"+strconv.Itoa(bannerID), nil, nil, &banner)
+	if err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+			return models.ExportBanner{}, ErrBannerNotFound
+		}
+		return models.ExportBanner{}, err
+	}
+
+	history, err := c.History(ctx, bannerID)
+	if err != nil {
+		return models.ExportBanner{}, err
+	}
+
+	return models.ExportBanner{
+		BannerID:       banner.BannerID,
+		TagID:          banner.TagID,
+		FeatureID:      banner.FeatureID,
+		Content:        banner.Content,
+		Active:         banner.Active,
+		Priority:       banner.Priority,
+		FeatureDefault: banner.FeatureDefault,
+		History:        history,
+	}, nil
+}
+
+// Чтение выгрузки в JSON Lines до тех пор, пока next возвращает true
+func (c *Client) exported(ctx context.Context, history bool, next func(models.ExportBanner) bool) error {
+
+	query := url.Values{"format": {models.FormatJSONL}, "history": {strconv.FormatBool(history)}}
+
+	response, err := c.send(ctx, http.MethodGet, "/api/banner/export", query, "", nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	scanner := bufio.NewScanner(response.Body)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var banner models.ExportBanner
+		if err = json.Unmarshal(scanner.Bytes(), &banner); err != nil {
+			return fmt.Errorf("reading export: %w", err)
+		}
+		if !next(banner) {
+			return nil
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (c *Client) History(ctx context.Context, bannerID int) ([]models.BannerHistory, error) {
+
+	history := make([]models.BannerHistory, 0)
+	if err := c.do(ctx, http.MethodGet, "/api/history_banner/"+strconv.Itoa(bannerID), nil, nil, &history); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// CreateBanner создает баннер и возвращает его id
+func (c *Client) CreateBanner(ctx context.Context, banner models.BannerBody) (int, error) {
+
+	var response struct {
+		BannerID int `json:"banner_id"`
+	}
+
+	if err := c.do(ctx, http.MethodPost, "/api/banner", nil, banner, &response); err != nil {
+		return 0, err
+	}
+
+	return response.BannerID, nil
+}
+
+func (c *Client) UpdateBanner(ctx context.Context, bannerID int, banner models.BannerBody) error {
+	return c.do(ctx, http.MethodPatch, "/api/banner/"+strconv.Itoa(bannerID), nil, banner, nil)
+}
+
+// SetActive включает или выключает баннер. Передается только is_active,
+// остальные поля API оставляет как есть
+func (c *Client) SetActive(ctx context.Context, bannerID int, active bool) error {
+
+	body := struct {
+		Active bool `json:"is_active"`
+	}{Active: active}
+
+	return c.do(ctx, http.MethodPatch, "/api/banner/"+strconv.Itoa(bannerID), nil, body, nil)
+}
+
+func (c *Client) DeleteBanner(ctx context.Context, bannerID int) error {
+	return c.do(ctx, http.MethodDelete, "/api/banner/"+strconv.Itoa(bannerID), nil, nil, nil)
+}
+
+// SwitchVersion делает действующим содержимое версии из истории баннера
+func (c *Client) SwitchVersion(ctx context.Context, bannerID, version int) error {
+
+	history, err := c.History(ctx, bannerID)
+	if err != nil {
+		return err
+	}
+
+	for _, h := range history {
+		if h.Version == version {
+			h.BannerID = uint32(bannerID)
+			return c.do(ctx, http.MethodPost, "/api/version_banner", nil, h, nil)
+		}
+	}
+
+	return ErrVersionNotFound
+}
+
+// Export пишет выгрузку баннеров в writer в формате jsonl или csv
+func (c *Client) Export(ctx context.Context, writer io.Writer, format string, history bool) error {
+
+	query := url.Values{"format": {format}, "history": {strconv.FormatBool(history)}}
+
+	response, err := c.send(ctx, http.MethodGet, "/api/banner/export", query, "", nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	_, err = io.Copy(writer, response.Body)
+	return err
+}
+
+// Import загружает баннеры. Отчет возвращается и тогда, когда загрузка в режиме atomic
+// не применена из-за ошибок в строках, вместе с ошибкой API
+func (c *Client) Import(ctx context.Context, reader io.Reader, options models.ImportOptions) (models.ImportReport, error) {
+
+	var report models.ImportReport
+
+	query := url.Values{
+		"format":   {options.Format},
+		"mode":     {options.Mode},
+		"conflict": {options.Conflict},
+		"dry_run":  {strconv.FormatBool(options.DryRun)},
+	}
+
+	contentType := "application/x-ndjson"
+	if options.Format == models.FormatCSV {
+		contentType = "text/csv"
+	}
+
+	response, err := c.send(ctx, http.MethodPost, "/api/banner/import", query, contentType, reader)
+	if err != nil {
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.Status != http.StatusUnprocessableEntity || response == nil {
+			return report, err
+		}
+		defer response.Body.Close()
+
+		if decodeErr := json.NewDecoder(response.Body).Decode(&report); decodeErr != nil {
+			return report, err
+		}
+		apiErr.Message = fmt.Sprintf("import is not applied, %d rows failed", report.Failed)
+		return report, err
+	}
+	defer response.Body.Close()
+
+	if err = json.NewDecoder(response.Body).Decode(&report); err != nil {
+		return report, fmt.Errorf("reading import report: %w", err)
+	}
+
+	return report, nil
+}
+
+// Body переводит баннер из выгрузки в тело запроса на обновление
+func Body(banner models.ExportBanner) models.BannerBody {
+
+	body := models.BannerBody{
+		BannerID:       banner.BannerID,
+		FeatureID:      banner.FeatureID,
+		Content:        banner.Content,
+		Active:         banner.Active,
+		Priority:       banner.Priority,
+		FeatureDefault: banner.FeatureDefault,
+	}
+	if len(banner.TagID) > 0 {
+		body.TagID = banner.TagID[0]
+	}
+
+	return body
+}
+
+// DecodeBanner читает баннер из JSON или YAML поверх переданного,
+// поля, которых нет в документе, сохраняют прежние значения. Имена полей те же, что в API
+func DecodeBanner(data []byte, banner *models.BannerBody) error {
+
+	// JSON является частным случаем YAML, поэтому документ любого формата приводится к JSON
+	var document any
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return err
+	}
+	if document == nil {
+		return errors.New("banner document is empty")
+	}
+
+	normalized, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(normalized))
+	decoder.DisallowUnknownFields()
+
+	return decoder.Decode(banner)
+}
+
+// Запрос с телом в JSON, ответ раскладывается в out, если он не nil
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out any) error {
+
+	var (
+		body        io.Reader
+		contentType string
+	)
+
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body, contentType = bytes.NewReader(data), "application/json"
+	}
+
+	response, err := c.send(ctx, method, path, query, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+
+	if err = json.NewDecoder(response.Body).Decode(out); err != nil {
+		return fmt.Errorf("reading response of %s %s: %w", method, path, err)
+	}
+
+	return nil
+}
+
+// send возвращает ответ с кодом 2xx. На другие коды возвращается *APIError
+// и ответ с уже прочитанным телом
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, contentType string, body io.Reader) (*http.Response, error) {
+
+	target := c.addr + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Token", c.token)
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return response, nil
+	}
+
+	data, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	response.Body = io.NopCloser(bytes.NewReader(data))
+
+	apiErr := &APIError{Status: response.StatusCode, Message: http.StatusText(response.StatusCode)}
+
+	var message struct {
+		Error *string `json:"error"`
+	}
+	if json.Unmarshal(data, &message) == nil && message.Error != nil && *message.Error != "" {
+		apiErr.Message = *message.Error
+	}
+
+	return response, apiErr
+}
@@ -0,0 +1,291 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/client"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/config"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/logger"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/middlewares"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/ratelimit"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/repository"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/route"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/service"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/storage/cache"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/storage/database"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+)
+
+// Сервер API на хранилищах в памяти и клиент с токеном администратора
+func newClient(t *testing.T) *client.Client {
+	t.Helper()
+
+	addr, adminToken := newServer(t)
+	return client.New(addr, adminToken)
+}
+
+// Адрес сервера API на хранилищах в памяти и токен администратора
+func newServer(t *testing.T) (string, string) {
+	t.Helper()
+
+	log, err := logger.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Config{AdminSecretKey: "admin-secret", UserSecretKey: "user-secret"}
+	repo := repository.NewWithStorage(database.NewMemory(), cache.NewMemory(time.Minute), cfg)
+	issuer := token.NewIssuer(cfg)
+
+	// Фичи и тэги, на которые ссылаются баннеры тестов
+	for id := uint32(1); id <= 3; id++ {
+		for _, kind := range []string{models.RegistryFeature, models.RegistryTag} {
+			entry := models.RegistryEntry{ID: id, Name: fmt.Sprintf("%s %d", kind, id)}
+			if _, err := repo.CreateRegistryEntry(context.Background(), kind, entry); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	server := httptest.NewServer(route.New(service.NewWithIssuer(log, repo, issuer), middlewares.New(cfg, ratelimit.NewMemory(), log)))
+	t.Cleanup(server.Close)
+
+	issued, err := issuer.Issue(models.TokenRequest{Role: models.RoleAdmin, TTL: "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return server.URL, issued.Token
+}
+
+func banner(feature, tag uint32, title string) models.BannerBody {
+	return models.BannerBody{
+		FeatureID: feature,
+		TagID:     tag,
+		Content:   models.BannerContent{Title: title, Text: "text", Url: "https://example.com/" + title},
+		Active:    true,
+	}
+}
+
+func TestBanners(t *testing.T) {
+
+	ctx := context.Background()
+	c := newClient(t)
+
+	first, err := c.CreateBanner(ctx, banner(1, 1, "first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.CreateBanner(ctx, banner(2, 1, "second")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.CreateBanner(ctx, banner(3, 2, "third")); err != nil {
+		t.Fatal(err)
+	}
+
+	banners, err := c.Banners(ctx, client.Filter{FeatureID: 1})
+	if err != nil || len(banners) != 1 || int(banners[0].BannerID) != first {
+		t.Fatalf("banners of feature: %+v, %v", banners, err)
+	}
+
+	// Без фильтра возвращаются все баннеры, limit и offset применяются к выгрузке
+	if banners, err = c.Banners(ctx, client.Filter{}); err != nil || len(banners) != 3 {
+		t.Fatalf("all banners: %+v, %v", banners, err)
+	}
+	if banners, err = c.Banners(ctx, client.Filter{Limit: 1, Offset: 1}); err != nil || len(banners) != 1 || banners[0].Content.Title != "second" {
+		t.Fatalf("page of banners: %+v, %v", banners, err)
+	}
+
+	// Обновление и выключение
+	updated := banner(1, 1, "first v2")
+	if err = c.UpdateBanner(ctx, first, updated); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.SetActive(ctx, first, false); err != nil {
+		t.Fatal(err)
+	}
+
+	shown, err := c.Banner(ctx, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shown.Active || shown.Content.Title != "first v2" || len(shown.History) != 2 {
+		t.Fatalf("banner = %+v", shown)
+	}
+
+	// Возврат к первой версии
+	if err = c.SwitchVersion(ctx, first, 1); err != nil {
+		t.Fatal(err)
+	}
+	if shown, err = c.Banner(ctx, first); err != nil || shown.Content.Title != "first" {
+		t.Fatalf("switched banner: %+v, %v", shown, err)
+	}
+	if err = c.SwitchVersion(ctx, first, 7); !errors.Is(err, client.ErrVersionNotFound) {
+		t.Fatalf("unknown version: %v", err)
+	}
+
+	if err = c.DeleteBanner(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Banner(ctx, first); !errors.Is(err, client.ErrBannerNotFound) {
+		t.Fatalf("deleted banner: %v", err)
+	}
+
+	// Код и текст ошибки API доступны вызывающему
+	var apiErr *client.APIError
+	if err = c.UpdateBanner(ctx, 1000, updated); !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotFound {
+		t.Fatalf("update of missing banner: %v", err)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+
+	ctx := context.Background()
+	source, target := newClient(t), newClient(t)
+
+	for _, b := range []models.BannerBody{banner(1, 1, "first"), banner(2, 2, "second")} {
+		if _, err := source.CreateBanner(ctx, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var exported strings.Builder
+	if err := source.Export(ctx, &exported, models.FormatJSONL, false); err != nil {
+		t.Fatal(err)
+	}
+
+	options := models.ImportOptions{Format: models.FormatJSONL, Mode: models.ImportAtomic, Conflict: models.ConflictSkip}
+
+	report, err := target.Import(ctx, strings.NewReader(exported.String()), options)
+	if err != nil || report.Created != 2 || !report.Committed {
+		t.Fatalf("import: %+v, %v", report, err)
+	}
+
+	// Отклоненная загрузка возвращает и отчет, и ошибку
+	broken := exported.String() + "{not json}\n"
+	report, err = target.Import(ctx, strings.NewReader(broken), options)
+
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusUnprocessableEntity || report.Failed != 1 || report.Committed {
+		t.Fatalf("rejected import: %+v, %v", report, err)
+	}
+}
+
+// Баннер читается по id без выгрузки, а выключение передает только is_active,
+// поэтому не затирает чужие изменения содержимого
+func TestBannerRequests(t *testing.T) {
+
+	addr, adminToken := newServer(t)
+
+	var requests []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, strings.TrimSpace(r.Method+" "+r.URL.Path+" "+string(body)))
+
+		forward, err := http.NewRequestWithContext(r.Context(), r.Method, addr+r.URL.RequestURI(), bytes.NewReader(body))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		forward.Header = r.Header
+		response, err := http.DefaultClient.Do(forward)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer response.Body.Close()
+		w.WriteHeader(response.StatusCode)
+		io.Copy(w, response.Body)
+	}))
+	t.Cleanup(proxy.Close)
+
+	ctx := context.Background()
+	c := client.New(proxy.URL, adminToken)
+
+	bannerID, err := c.CreateBanner(ctx, banner(1, 1, "first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.UpdateBanner(ctx, bannerID, banner(1, 1, "second")); err != nil {
+		t.Fatal(err)
+	}
+
+	requests = nil
+	if err = c.SetActive(ctx, bannerID, false); err != nil {
+		t.Fatal(err)
+	}
+	shown, err := c.Banner(ctx, bannerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shown.Active || shown.Content.Title != "second" || len(shown.TagID) != 1 || len(shown.History) != 2 {
+		t.Fatalf("banner = %+v", shown)
+	}
+
+	want := []string{
+		`PATCH /api/banner/1 {"is_active":false}`,
+		"GET /api/banner/1",
+		"GET /api/history_banner/1",
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Fatalf("requests = %q, want %q", requests, want)
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+
+	addr, _ := newServer(t)
+	stranger := client.New(addr, "not a token")
+
+	var apiErr *client.APIError
+	if _, err := stranger.Banners(context.Background(), client.Filter{TagID: 1}); !errors.As(err, &apiErr) || apiErr.Status < 400 {
+		t.Fatalf("bad token: %v", err)
+	}
+}
+
+func TestDecodeBanner(t *testing.T) {
+
+	yamlDocument := `
+feature_id: 3
+tag_id: 4
+is_active: true
+content:
+  title: from yaml
+  url: https://example.com
+`
+
+	var b models.BannerBody
+	if err := client.DecodeBanner([]byte(yamlDocument), &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.FeatureID != 3 || b.TagID != 4 || !b.Active || b.Content.Title != "from yaml" {
+		t.Fatalf("yaml banner = %+v", b)
+	}
+
+	// Поля, которых нет в документе, не меняются
+	if err := client.DecodeBanner([]byte(`{"content": {"text": "from json"}, "is_active": false}`), &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.FeatureID != 3 || b.Active || b.Content.Title != "from yaml" || b.Content.Text != "from json" {
+		t.Fatalf("json banner = %+v", b)
+	}
+
+	// Опечатка в имени поля не проходит молча
+	if err := client.DecodeBanner([]byte("featureid: 1\n"), &b); err == nil {
+		t.Fatal("unknown field is accepted")
+	}
+	if err := client.DecodeBanner(nil, &b); err == nil {
+		t.Fatal("empty document is accepted")
+	}
+}
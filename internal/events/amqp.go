@@ -0,0 +1,123 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Implementation check
+var _ Publisher = (*AMQPPublisher)(nil)
+
+var errNotConfirmed = errors.New("event is not confirmed by broker")
+
+// AMQPPublisher publishes events to topic exchange, routing key is event type.
+// Connection is opened lazily and reopened after failure.
+type AMQPPublisher struct {
+	mu       sync.Mutex
+	dsn      string
+	exchange string
+	conn     *amqp.Connection
+	ch       *amqp.Channel
+}
+
+func NewAMQP(dsn, exchange string) *AMQPPublisher {
+	return &AMQPPublisher{
+		dsn:      dsn,
+		exchange: exchange,
+	}
+}
+
+func (p *AMQPPublisher) Publish(ctx context.Context, event models.Event) error {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.connect(); err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	confirmation, err := p.ch.PublishWithDeferredConfirmWithContext(ctx, p.exchange, event.Type, false, false,
+		amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			MessageId:    strconv.FormatInt(event.ID, 10),
+			Timestamp:    event.CreatedAt,
+			Type:         event.Type,
+			Body:         body,
+		})
+	if err != nil {
+		p.reset()
+		return err
+	}
+
+	// Событие считается опубликованным только после подтверждения брокера
+	ok, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		p.reset()
+		return err
+	}
+	if !ok {
+		return errNotConfirmed
+	}
+
+	return nil
+}
+
+// Open connection and channel in confirm mode if they are closed
+func (p *AMQPPublisher) connect() error {
+
+	if p.ch != nil && !p.ch.IsClosed() {
+		return nil
+	}
+	p.reset()
+
+	conn, err := amqp.Dial(p.dsn)
+	if err != nil {
+		return err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	if err = ch.ExchangeDeclare(p.exchange, amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+		conn.Close()
+		return err
+	}
+
+	if err = ch.Confirm(false); err != nil {
+		conn.Close()
+		return err
+	}
+
+	p.conn, p.ch = conn, ch
+	return nil
+}
+
+func (p *AMQPPublisher) reset() {
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	p.conn, p.ch = nil, nil
+}
+
+func (p *AMQPPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.reset()
+	return nil
+}
@@ -0,0 +1,69 @@
+package events
+
+import (
+	"context"
+	"sync"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/logger"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+)
+
+// Publisher delivers banner events to downstream services
+type Publisher interface {
+	Publish(ctx context.Context, event models.Event) error
+	Close() error
+}
+
+// Implementation check
+var (
+	_ Publisher = (*LogPublisher)(nil)
+	_ Publisher = (*MemoryPublisher)(nil)
+)
+
+// LogPublisher writes events to log, used for local runs without broker
+type LogPublisher struct {
+	log *logger.Logger
+}
+
+func NewLog(log *logger.Logger) *LogPublisher {
+	return &LogPublisher{log: log}
+}
+
+func (p *LogPublisher) Publish(ctx context.Context, event models.Event) error {
+	p.log.Log.Infow("banner event", "id", event.ID, "type", event.Type, "banner_id", event.BannerID, "actor", event.Actor)
+	return nil
+}
+
+func (p *LogPublisher) Close() error {
+	return nil
+}
+
+// MemoryPublisher keeps published events in memory, used in tests of outbox relay
+type MemoryPublisher struct {
+	mu     sync.Mutex
+	events []models.Event
+}
+
+func NewMemory() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+func (p *MemoryPublisher) Publish(ctx context.Context, event models.Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.events = append(p.events, event)
+	return nil
+}
+
+// Events returns copy of published events
+func (p *MemoryPublisher) Events() []models.Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]models.Event(nil), p.events...)
+}
+
+func (p *MemoryPublisher) Close() error {
+	return nil
+}
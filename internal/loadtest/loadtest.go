@@ -0,0 +1,296 @@
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/repository"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+)
+
+// Параметры нагрузки на GET /api/user_banner
+type Options struct {
+	Addr        string        // base url of running instance
+	Features    int           // features of seeded banners, ids 1..Features
+	Tags        int           // tags of seeded banners, ids 1..Tags
+	Users       int           // number of distinct user tokens
+	Concurrency int           // parallel clients
+	Duration    time.Duration // how long load is driven
+	Requests    int           // stop after this many requests, 0 means only by duration
+	RPS         int           // overall request rate, 0 means unlimited
+	LastRatio   float64       // share of requests with use_last_revision=true
+	Timeout     time.Duration // timeout of one request
+}
+
+// Одна выполненная заявка
+type sample struct {
+	latency time.Duration
+	status  int    // 0 if request failed before response
+	cache   string // X-Cache header of response
+	last    bool   // use_last_revision=true
+}
+
+// Seed создает через репозиторий баннеры для всех пар фича + тэг,
+// пары, у которых уже есть баннер, в том числе выключенный, пропускаются. Возвращает число созданных баннеров
+func Seed(ctx context.Context, repository repository.Repositorer, features, tags int) (int, error) {
+
+	created := 0
+
+	// Баннеры можно создавать только на зарегистрированные фичи и тэги
+	for _, registry := range []struct {
+		kind  string
+		count int
+	}{{models.RegistryFeature, features}, {models.RegistryTag, tags}} {
+		for id := 1; id <= registry.count; id++ {
+			if err := register(ctx, repository, registry.kind, id); err != nil {
+				return created, err
+			}
+		}
+	}
+
+	for feature := 1; feature <= features; feature++ {
+		for tag := 1; tag <= tags; tag++ {
+
+			// Баннер фичи по умолчанию не занимает пару
+			banners, err := repository.GetBanner(ctx, feature, []int{tag})
+			if err != nil {
+				return created, err
+			}
+			if len(banners) > 0 && banners[0].Rule == models.RuleExact {
+				continue
+			}
+
+			_, err = repository.CreateBanner(ctx, models.BannerBody{
+				FeatureID: uint32(feature),
+				TagID:     uint32(tag),
+				Content: models.BannerContent{
+					Title: fmt.Sprintf("loadtest %d/%d", feature, tag),
+					Text:  "banner for load testing",
+					Url:   fmt.Sprintf("https://example.com/loadtest/%d/%d", feature, tag),
+				},
+				Active: true,
+			})
+			// Пару занимает выключенный баннер, пользователям он не отдается
+			if errors.Is(err, models.ErrPairTaken) {
+				continue
+			}
+			if err != nil {
+				return created, fmt.Errorf("seeding banner %d/%d: %w", feature, tag, err)
+			}
+			created++
+		}
+	}
+
+	return created, nil
+}
+
+// Регистрирует фичу или тэг, если их еще нет в справочнике
+func register(ctx context.Context, repository repository.Repositorer, kind string, id int) error {
+
+	_, ok, err := repository.GetRegistryEntry(ctx, kind, id)
+	if err != nil || ok {
+		return err
+	}
+
+	_, err = repository.CreateRegistryEntry(ctx, kind, models.RegistryEntry{
+		ID:          uint32(id),
+		Name:        fmt.Sprintf("loadtest %s %d", kind, id),
+		Description: "registered for load testing",
+	})
+	if err != nil {
+		return fmt.Errorf("registering %s %d: %w", kind, id, err)
+	}
+
+	return nil
+}
+
+// Tokens выпускает токены пользователей с разными subject,
+// чтобы запросы распределялись по вариантам экспериментов и лимитам как у реальных клиентов
+func Tokens(issuer *token.Issuer, users int, ttl time.Duration) ([]string, error) {
+
+	tokens := make([]string, 0, users)
+
+	for i := 1; i <= users; i++ {
+		issued, err := issuer.Issue(models.TokenRequest{
+			Role:    models.RoleUser,
+			Subject: "loadtest-" + strconv.Itoa(i),
+			TTL:     ttl.String(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, issued.Token)
+	}
+
+	return tokens, nil
+}
+
+// Run нагружает запущенный сервис и собирает отчет.
+// Нагрузка останавливается по истечении Duration, после Requests запросов или отмене контекста
+func Run(ctx context.Context, options Options, tokens []string) (Report, error) {
+
+	if len(tokens) == 0 {
+		return Report{}, fmt.Errorf("no user tokens")
+	}
+	if options.Features <= 0 || options.Tags <= 0 || options.Concurrency <= 0 {
+		return Report{}, fmt.Errorf("features, tags and concurrency must be positive")
+	}
+
+	target, err := url.Parse(options.Addr)
+	if err != nil {
+		return Report{}, err
+	}
+	target.Path = "/api/user_banner"
+
+	if options.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Duration)
+		defer cancel()
+	}
+
+	client := &http.Client{
+		Timeout: options.Timeout,
+		Transport: &http.Transport{
+			MaxIdleConns:        options.Concurrency,
+			MaxIdleConnsPerHost: options.Concurrency,
+		},
+	}
+	defer client.CloseIdleConnections()
+
+	// Заявки на запросы, без ограничения частоты воркеры их не ждут
+	tickets := pace(ctx, options.RPS)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		samples = make([]sample, 0, 1024)
+		sent    int
+	)
+
+	// Резервирование номера запроса с учетом лимита Requests
+	next := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if options.Requests > 0 && sent >= options.Requests {
+			return false
+		}
+		sent++
+		return true
+	}
+
+	started := time.Now()
+
+	for worker := 0; worker < options.Concurrency; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+
+			random := rand.New(rand.NewSource(time.Now().UnixNano() + int64(worker)))
+			local := make([]sample, 0, 1024)
+
+			for ctx.Err() == nil && next() {
+
+				if tickets != nil {
+					select {
+					case <-ctx.Done():
+					case <-tickets:
+					}
+					if ctx.Err() != nil {
+						break
+					}
+				}
+
+				if s, ok := do(ctx, client, target, tokens[random.Intn(len(tokens))], options, random); ok {
+					local = append(local, s)
+				}
+			}
+
+			mu.Lock()
+			samples = append(samples, local...)
+			mu.Unlock()
+		}(worker)
+	}
+
+	wg.Wait()
+
+	return newReport(samples, time.Since(started)), nil
+}
+
+// Канал заявок с частотой rps, nil если частота не ограничена
+func pace(ctx context.Context, rps int) <-chan struct{} {
+
+	if rps <= 0 {
+		return nil
+	}
+
+	tickets := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Second / time.Duration(rps))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				select {
+				case tickets <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return tickets
+}
+
+// Один запрос, второе значение false, если запрос прерван окончанием нагрузки
+func do(ctx context.Context, client *http.Client, target *url.URL, userToken string, options Options, random *rand.Rand) (sample, bool) {
+
+	last := random.Float64() < options.LastRatio
+
+	query := url.Values{}
+	query.Set("feature_id", strconv.Itoa(random.Intn(options.Features)+1))
+	query.Set("tag_id", strconv.Itoa(random.Intn(options.Tags)+1))
+	if last {
+		query.Set("use_last_revision", "true")
+	}
+
+	u := *target
+	u.RawQuery = query.Encode()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return sample{last: last}, true
+	}
+	request.Header.Set("Token", userToken)
+
+	start := time.Now()
+	response, err := client.Do(request)
+	if err != nil {
+		if ctx.Err() != nil {
+			return sample{}, false
+		}
+		return sample{latency: time.Since(start), last: last}, true
+	}
+
+	_, _ = io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+
+	return sample{
+		latency: time.Since(start),
+		status:  response.StatusCode,
+		cache:   response.Header.Get("X-Cache"),
+		last:    last,
+	}, true
+}
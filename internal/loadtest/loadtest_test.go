@@ -0,0 +1,133 @@
+package loadtest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/config"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/loadtest"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/logger"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/middlewares"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/ratelimit"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/repository"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/route"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/service"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/storage/cache"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/storage/database"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+)
+
+func TestRun(t *testing.T) {
+
+	log, err := logger.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Config{AdminSecretKey: "admin-secret", UserSecretKey: "user-secret"}
+	repo := repository.NewWithStorage(database.NewMemory(), cache.NewMemory(time.Minute), cfg)
+	issuer := token.NewIssuer(cfg)
+
+	server := httptest.NewServer(route.New(service.NewWithIssuer(log, repo, issuer), middlewares.New(cfg, ratelimit.NewMemory(), log)))
+	defer server.Close()
+
+	created, err := loadtest.Seed(context.Background(), repo, 3, 2)
+	if err != nil || created != 6 {
+		t.Fatalf("seed: %d, %v", created, err)
+	}
+
+	// Повторный запуск не создает баннеры заново
+	if created, err = loadtest.Seed(context.Background(), repo, 3, 2); err != nil || created != 0 {
+		t.Fatalf("second seed: %d, %v", created, err)
+	}
+
+	tokens, err := loadtest.Tokens(issuer, 5, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := loadtest.Run(context.Background(), loadtest.Options{
+		Addr:        server.URL,
+		Features:    3,
+		Tags:        2,
+		Concurrency: 4,
+		Requests:    200,
+		LastRatio:   0.25,
+		Timeout:     time.Second,
+	}, tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Requests != 200 || report.Statuses[http.StatusOK] != 200 || report.Errors != 0 {
+		t.Fatalf("report = %+v", report)
+	}
+
+	// Кэш прогревается первыми запросами каждой пары
+	if report.Cache[models.CacheBypass] != report.Last || report.CacheHitRatio() < 0.5 {
+		t.Fatalf("cache = %v, last = %d", report.Cache, report.Last)
+	}
+	if report.P50 > report.P99 || report.P99 > report.Max || report.Throughput <= 0 {
+		t.Fatalf("report = %+v", report)
+	}
+
+	var out strings.Builder
+	report.Print(&out)
+	if !strings.Contains(out.String(), "hit ratio") {
+		t.Fatalf("report output = %q", out.String())
+	}
+}
+
+// Пара с выключенным баннером занята, Seed ее пропускает
+func TestSeedSkipsDisabledBanner(t *testing.T) {
+
+	ctx := context.Background()
+	repo := repository.NewWithStorage(database.NewMemory(), cache.NewMemory(time.Minute), config.Config{})
+
+	if created, err := loadtest.Seed(ctx, repo, 1, 2); err != nil || created != 2 {
+		t.Fatalf("seed: %d, %v", created, err)
+	}
+
+	disabled := models.BannerBody{FeatureID: 1, TagID: 1, Content: models.BannerContent{Title: "disabled"}}
+	if ok, err := repo.UpdateBanner(ctx, disabled, 1, false); err != nil || !ok {
+		t.Fatalf("disabling banner: %v, %v", ok, err)
+	}
+
+	if created, err := loadtest.Seed(ctx, repo, 1, 3); err != nil || created != 1 {
+		t.Fatalf("seed over disabled banner: %d, %v", created, err)
+	}
+}
+
+func TestRunCountsErrors(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Query().Get("feature_id") == "1" {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writer.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	report, err := loadtest.Run(context.Background(), loadtest.Options{
+		Addr:        server.URL,
+		Features:    2,
+		Tags:        1,
+		Concurrency: 2,
+		Requests:    100,
+		Timeout:     time.Second,
+	}, []string{"token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Отсутствие баннера не считается ошибкой
+	if report.Errors != report.Statuses[http.StatusInternalServerError] || report.Errors+report.Statuses[http.StatusNotFound] != 100 {
+		t.Fatalf("report = %+v", report)
+	}
+}
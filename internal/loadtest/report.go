@@ -0,0 +1,141 @@
+package loadtest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+)
+
+// Итоги нагрузки
+type Report struct {
+	Requests   int           // completed requests
+	Elapsed    time.Duration // wall time of load
+	Throughput float64       // requests per second
+	P50        time.Duration // latency percentiles
+	P90        time.Duration
+	P99        time.Duration
+	Max        time.Duration
+	Statuses   map[int]int    // responses by status code, 0 for transport errors
+	Errors     int            // transport errors and responses other than 200 and 404
+	Cache      map[string]int // responses by X-Cache header
+	Last       int            // requests with use_last_revision=true
+}
+
+func newReport(samples []sample, elapsed time.Duration) Report {
+
+	report := Report{
+		Requests: len(samples),
+		Elapsed:  elapsed,
+		Statuses: make(map[int]int),
+		Cache:    make(map[string]int),
+	}
+
+	if elapsed > 0 {
+		report.Throughput = float64(len(samples)) / elapsed.Seconds()
+	}
+
+	latencies := make([]time.Duration, 0, len(samples))
+	for _, s := range samples {
+
+		latencies = append(latencies, s.latency)
+		report.Statuses[s.status]++
+
+		// Отсутствие баннера пары не ошибка сервиса
+		if s.status != http.StatusOK && s.status != http.StatusNotFound {
+			report.Errors++
+		}
+		if s.cache != "" {
+			report.Cache[s.cache]++
+		}
+		if s.last {
+			report.Last++
+		}
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	report.P50 = percentile(latencies, 50)
+	report.P90 = percentile(latencies, 90)
+	report.P99 = percentile(latencies, 99)
+	if len(latencies) > 0 {
+		report.Max = latencies[len(latencies)-1]
+	}
+
+	return report
+}
+
+// Перцентиль по отсортированным задержкам, метод ближайшего ранга
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// Доля ошибок от всех запросов
+func (r Report) ErrorRate() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return float64(r.Errors) / float64(r.Requests)
+}
+
+// Доля попаданий в кэш среди запросов, которые могли идти в кэш
+func (r Report) CacheHitRatio() float64 {
+	cacheable := r.Cache[models.CacheHit] + r.Cache[models.CacheMiss]
+	if cacheable == 0 {
+		return 0
+	}
+	return float64(r.Cache[models.CacheHit]) / float64(cacheable)
+}
+
+// Print выводит отчет в человекочитаемом виде вместе со сравнением с целями задания
+func (r Report) Print(w io.Writer) {
+
+	fmt.Fprintf(w, "requests:     %d in %s (%d with use_last_revision=true)\n", r.Requests, r.Elapsed.Round(time.Millisecond), r.Last)
+	fmt.Fprintf(w, "throughput:   %.1f req/s\n", r.Throughput)
+	fmt.Fprintf(w, "latency:      p50 %s, p90 %s, p99 %s, max %s\n", round(r.P50), round(r.P90), round(r.P99), round(r.Max))
+
+	statuses := make([]int, 0, len(r.Statuses))
+	for status := range r.Statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+
+	fmt.Fprint(w, "statuses:    ")
+	for _, status := range statuses {
+		name := strconv.Itoa(status)
+		if status == 0 {
+			name = "failed"
+		}
+		fmt.Fprintf(w, " %s=%d", name, r.Statuses[status])
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintf(w, "errors:       %d (%.2f%%)\n", r.Errors, 100*r.ErrorRate())
+	fmt.Fprintf(w, "cache:        hit %d, miss %d, bypass %d, hit ratio %.2f%%\n",
+		r.Cache[models.CacheHit], r.Cache[models.CacheMiss], r.Cache[models.CacheBypass], 100*r.CacheHitRatio())
+
+	fmt.Fprintf(w, "targets:      %s %.0f req/s, %s p99 %s\n",
+		verdict(r.Throughput >= models.LoadtestTargetRPS), models.LoadtestTargetRPS,
+		verdict(r.Requests > 0 && r.P99 <= models.LoadtestTargetP99), models.LoadtestTargetP99)
+}
+
+func verdict(ok bool) string {
+	if ok {
+		return "ok"
+	}
+	return "MISSED"
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(10 * time.Microsecond)
+}
@@ -3,13 +3,15 @@ package logger
 import "go.uber.org/zap"
 
 type Logger struct {
-	Log zap.SugaredLogger
+	Log   zap.SugaredLogger
+	level zap.AtomicLevel // level can be changed without restart
 }
 
 func New() (*Logger, error) {
 
-	// Create installed registrator zap
-	log, err := zap.NewDevelopment()
+	// Create installed registrator zap, level is shared with logger to change it at runtime
+	cfg := zap.NewDevelopmentConfig()
+	log, err := cfg.Build()
 	if err != nil {
 		return nil, err
 	}
@@ -19,5 +21,15 @@ func New() (*Logger, error) {
 	// Create registrator SugaredLogger
 	sugar := *log.Sugar()
 
-	return &Logger{Log: sugar}, nil
+	return &Logger{Log: sugar, level: cfg.Level}, nil
+}
+
+// SetLevel changes level of all messages logged after call: debug, info, warn, error
+func (l *Logger) SetLevel(level string) error {
+	return l.level.UnmarshalText([]byte(level))
+}
+
+// Level returns current level of logger
+func (l *Logger) Level() string {
+	return l.level.String()
 }
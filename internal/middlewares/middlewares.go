@@ -1,25 +1,53 @@
 package middlewares
 
 import (
-	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"sync/atomic"
 
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/config"
 	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/logger"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/ratelimit"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
 	"github.com/golang-jwt/jwt"
 )
 
 type Middlewares struct {
+	secrets    atomic.Pointer[secrets] // Secret keys, swapped on config reload
+	limiter    ratelimit.Limiter       // Limiter of requests per client
+	userLimit  ratelimit.Limit         // Limit for user routes
+	adminLimit ratelimit.Limit         // Limit for admin routes
+	ipLimit    ratelimit.Limit         // Limit for requests from IP before authorization
+	clientAuth string                  // Client certificate on admin routes: none, optional or require
+	log        *logger.Logger
+}
+
+type secrets struct {
 	adminSecretKey string // Admin secret key
 	userSecretKey  string // User secret key
-	log            *logger.Logger
 }
 
-func New(adminSecretKey, userSecretKey string, log *logger.Logger) *Middlewares {
-	return &Middlewares{
-		adminSecretKey: adminSecretKey,
-		log:            log,
-		userSecretKey:  userSecretKey,
+func New(cfg config.Config, limiter ratelimit.Limiter, log *logger.Logger) *Middlewares {
+
+	middlewares := &Middlewares{
+		log:        log,
+		limiter:    limiter,
+		userLimit:  ratelimit.Limit{Rate: cfg.UserRateLimit, Burst: cfg.UserRateBurst},
+		adminLimit: ratelimit.Limit{Rate: cfg.AdminRateLimit, Burst: cfg.AdminRateBurst},
+		ipLimit:    ratelimit.Limit{Rate: cfg.IPRateLimit, Burst: cfg.IPRateBurst},
+		clientAuth: cfg.AdminClientAuth,
 	}
+	middlewares.SetSecrets(cfg.AdminSecretKey, cfg.UserSecretKey)
+
+	return middlewares
+}
+
+// SetSecrets swaps secret keys, requests in flight finish with keys they started with
+func (middlewares *Middlewares) SetSecrets(adminSecretKey, userSecretKey string) {
+	middlewares.secrets.Store(&secrets{adminSecretKey: adminSecretKey, userSecretKey: userSecretKey})
 }
 
 func (middlewares *Middlewares) AdminAuthorization(h http.HandlerFunc) http.HandlerFunc {
@@ -27,15 +55,26 @@ func (middlewares *Middlewares) AdminAuthorization(h http.HandlerFunc) http.Hand
 
 		ctx := request.Context()
 
-		token := request.Header["Token"][0]
-		if len(token) == 0 {
+		// Проверенный TLS сертификат клиента заменяет admin токен
+		if claims, ok := middlewares.clientCertificate(request); ok {
+			h.ServeHTTP(writer, request.WithContext(token.WithClaims(ctx, claims)))
+			return
+		}
+		if middlewares.clientAuth == models.AdminClientAuthRequire {
+			middlewares.log.Log.Info("client certificate is required")
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		tokenString := request.Header.Get("Token")
+		if len(tokenString) == 0 {
 			middlewares.log.Log.Error("token is empty")
 			writer.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
 		// Validation admin token
-		ok, err := middlewares.validation(token, middlewares.adminSecretKey)
+		claims, ok, err := middlewares.validation(tokenString, middlewares.secrets.Load().adminSecretKey)
 		if err != nil {
 			middlewares.log.Log.Errorf("token validation is failed: ", err)
 			writer.WriteHeader(http.StatusForbidden)
@@ -48,7 +87,7 @@ func (middlewares *Middlewares) AdminAuthorization(h http.HandlerFunc) http.Hand
 			return
 		}
 
-		h.ServeHTTP(writer, request.WithContext(ctx))
+		h.ServeHTTP(writer, request.WithContext(token.WithClaims(ctx, claims)))
 	})
 }
 
@@ -57,15 +96,16 @@ func (middlewares *Middlewares) UserAuthorization(h http.HandlerFunc) http.Handl
 
 		ctx := request.Context()
 
-		token := request.Header["Token"][0]
-		if len(token) == 0 {
+		tokenString := request.Header.Get("Token")
+		if len(tokenString) == 0 {
 			middlewares.log.Log.Error("token is empty")
 			writer.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
 		// Пробуем сначала аутентифицировать по UserToken
-		ok, err := middlewares.validation(token, middlewares.userSecretKey)
+		secrets := middlewares.secrets.Load()
+		claims, ok, err := middlewares.validation(tokenString, secrets.userSecretKey)
 		if err != nil {
 			middlewares.log.Log.Errorf("token validation is failed: ", err)
 			writer.WriteHeader(http.StatusForbidden)
@@ -74,7 +114,7 @@ func (middlewares *Middlewares) UserAuthorization(h http.HandlerFunc) http.Handl
 
 		// Если переданный токен не user проверяем на admin, у них тоже есть доступ
 		if !ok {
-			ok, err = middlewares.validation(token, middlewares.adminSecretKey)
+			claims, ok, err = middlewares.validation(tokenString, secrets.adminSecretKey)
 			if err != nil {
 				middlewares.log.Log.Errorf("token validation is failed: ", err)
 				writer.WriteHeader(http.StatusForbidden)
@@ -87,25 +127,88 @@ func (middlewares *Middlewares) UserAuthorization(h http.HandlerFunc) http.Handl
 			}
 		}
 
-		h.ServeHTTP(writer, request.WithContext(ctx))
+		h.ServeHTTP(writer, request.WithContext(token.WithClaims(ctx, claims)))
 
 	})
 }
 
-func (middlewares *Middlewares) validation(token, secretkey string) (bool, error) {
-	tok, err := jwt.Parse(token,
-		func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
-			}
-			return []byte(secretkey), nil
-		})
+// Limit requests to user routes, must be placed after authorization to key by subject
+func (middlewares *Middlewares) UserRateLimit(h http.HandlerFunc) http.HandlerFunc {
+	return middlewares.rateLimit("user", middlewares.userLimit, h)
+}
+
+// Limit requests to admin routes, must be placed after authorization to key by subject
+func (middlewares *Middlewares) AdminRateLimit(h http.HandlerFunc) http.HandlerFunc {
+	return middlewares.rateLimit("admin", middlewares.adminLimit, h)
+}
+
+// Limit requests by IP, must be placed before authorization so that requests
+// with bad or missing token are limited too
+func (middlewares *Middlewares) IPRateLimit(h http.HandlerFunc) http.HandlerFunc {
+	return middlewares.rateLimit("ip", middlewares.ipLimit, h)
+}
+
+func (middlewares *Middlewares) rateLimit(scope string, limit ratelimit.Limit, h http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+
+		if middlewares.limiter == nil || limit.Disabled() {
+			h.ServeHTTP(writer, request)
+			return
+		}
 
-	if !tok.Valid {
-		return false, nil
+		ok, wait, err := middlewares.limiter.Allow(scope+":"+clientKey(request), limit)
+		if err != nil {
+			// Не блокируем клиентов, если хранилище лимитов недоступно
+			middlewares.log.Log.Error("rate limiter is failed: ", err)
+			h.ServeHTTP(writer, request)
+			return
+		}
+
+		if !ok {
+			writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writer.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		h.ServeHTTP(writer, request)
+	})
+}
+
+// Client is identified by subject of token, otherwise by IP address
+func clientKey(request *http.Request) string {
+
+	if claims, ok := token.FromContext(request.Context()); ok && claims.Subject != "" {
+		return "sub:" + claims.Subject
 	}
+
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
 	if err != nil {
-		return false, err
+		host = request.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// Claims of admin authenticated by client certificate verified against TLSClientCAFile,
+// common name of certificate is subject for audit
+func (middlewares *Middlewares) clientCertificate(request *http.Request) (*token.Claims, bool) {
+
+	if middlewares.clientAuth == "" || middlewares.clientAuth == models.AdminClientAuthNone {
+		return nil, false
 	}
-	return true, nil
+	if request.TLS == nil || len(request.TLS.VerifiedChains) == 0 || len(request.TLS.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+
+	cert := request.TLS.VerifiedChains[0][0]
+
+	return &token.Claims{
+		Role: models.RoleAdmin,
+		StandardClaims: jwt.StandardClaims{
+			Subject: cert.Subject.CommonName,
+		},
+	}, true
+}
+
+func (middlewares *Middlewares) validation(tokenString, secretkey string) (*token.Claims, bool, error) {
+	return token.Parse(tokenString, secretkey)
 }
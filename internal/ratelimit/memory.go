@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Implementation check
+var _ Limiter = (*memory)(nil)
+
+// How often idle buckets are checked
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	refill time.Duration // time to refill empty bucket, after it bucket is the same as new one
+}
+
+// In-memory limiter for single instance
+type memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemory() Limiter {
+	return &memory{
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+func (m *memory) Allow(key string, limit Limit) (bool, time.Duration, error) {
+
+	if limit.Disabled() {
+		return true, 0, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		m.buckets[key] = b
+	}
+
+	tokens, allowed, wait := take(b.tokens, b.last, now, limit)
+	b.tokens, b.last = tokens, now
+	b.refill = limit.refill()
+
+	return allowed, wait, nil
+}
+
+// Remove buckets which had enough time to refill completely
+func (m *memory) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+
+	for key, b := range m.buckets {
+		if now.Sub(b.last) >= b.refill {
+			delete(m.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// Limiter with manual clock
+func newTestMemory() (*memory, *time.Time) {
+	now := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+	m := NewMemory().(*memory)
+	m.lastSweep = now
+	m.now = func() time.Time { return now }
+	return m, &now
+}
+
+type step struct {
+	advance time.Duration // clock shift before request
+	allowed bool
+	wait    time.Duration // Retry-After of rejected request
+}
+
+func TestMemoryAllow(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit Limit
+		steps []step
+	}{
+		{
+			name:  "burst then reject",
+			limit: Limit{Rate: 1, Burst: 3},
+			steps: []step{{0, true, 0}, {0, true, 0}, {0, true, 0}, {0, false, time.Second}},
+		},
+		{
+			name:  "retry after partial refill",
+			limit: Limit{Rate: 2, Burst: 1},
+			steps: []step{{0, true, 0}, {0, false, 500 * time.Millisecond}, {200 * time.Millisecond, false, 300 * time.Millisecond}, {300 * time.Millisecond, true, 0}},
+		},
+		{
+			name:  "refill is capped by burst",
+			limit: Limit{Rate: 10, Burst: 2},
+			steps: []step{{0, true, 0}, {0, true, 0}, {0, false, 100 * time.Millisecond}, {time.Hour, true, 0}, {0, true, 0}, {0, false, 100 * time.Millisecond}},
+		},
+		{
+			name:  "disabled",
+			limit: Limit{},
+			steps: []step{{0, true, 0}, {0, true, 0}, {0, true, 0}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, now := newTestMemory()
+
+			for i, s := range tt.steps {
+				*now = now.Add(s.advance)
+
+				allowed, wait, err := m.Allow("key", tt.limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if allowed != s.allowed || wait != s.wait {
+					t.Fatalf("step %d: allowed = %v, wait = %v, want %v, %v", i, allowed, wait, s.allowed, s.wait)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryKeys(t *testing.T) {
+	m, _ := newTestMemory()
+	limit := Limit{Rate: 1, Burst: 1}
+
+	if allowed, _, _ := m.Allow("first", limit); !allowed {
+		t.Fatal("first key is rejected")
+	}
+	if allowed, _, _ := m.Allow("second", limit); !allowed {
+		t.Fatal("second key shares bucket with first one")
+	}
+	if allowed, _, _ := m.Allow("first", limit); allowed {
+		t.Fatal("first key is allowed over burst")
+	}
+}
+
+// Бакет удаляется только когда успел бы наполниться, иначе удаление сбрасывало бы лимит
+func TestMemorySweep(t *testing.T) {
+	m, now := newTestMemory()
+
+	// Наполняется за 100 секунд, дольше интервала очистки
+	slow := Limit{Rate: 0.1, Burst: 10}
+	fast := Limit{Rate: 10, Burst: 10}
+
+	for i := 0; i < 10; i++ {
+		m.Allow("slow", slow)
+		m.Allow("fast", fast)
+	}
+
+	*now = now.Add(sweepInterval)
+	if allowed, _, _ := m.Allow("other", fast); !allowed {
+		t.Fatal("other key is rejected")
+	}
+
+	if _, ok := m.buckets["fast"]; ok {
+		t.Fatal("refilled bucket is not removed")
+	}
+	b, ok := m.buckets["slow"]
+	if !ok {
+		t.Fatal("bucket is removed before refill")
+	}
+	if b.tokens != 0 {
+		t.Fatalf("swept bucket is changed: %v tokens", b.tokens)
+	}
+
+	// За минуту набралось 6 токенов из 10
+	for i := 0; i < 6; i++ {
+		if allowed, _, _ := m.Allow("slow", slow); !allowed {
+			t.Fatalf("request %d is rejected", i)
+		}
+	}
+	if allowed, wait, _ := m.Allow("slow", slow); allowed || wait != 10*time.Second {
+		t.Fatalf("request over refilled tokens: allowed = %v, wait = %v", allowed, wait)
+	}
+
+	// Простоявший полное время наполнения бакет удаляется
+	*now = now.Add(100 * time.Second)
+	m.Allow("other", fast)
+	if _, ok := m.buckets["slow"]; ok {
+		t.Fatal("refilled bucket is not removed")
+	}
+}
@@ -0,0 +1,45 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// Limit of token bucket
+type Limit struct {
+	Rate  float64 // Tokens refilled per second
+	Burst int     // Bucket capacity
+}
+
+// Disabled reports that limit is not configured and requests are not limited
+func (l Limit) Disabled() bool {
+	return l.Rate <= 0 || l.Burst <= 0
+}
+
+// refill returns time in which empty bucket becomes full
+func (l Limit) refill() time.Duration {
+	return time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+}
+
+// Limiter decides whether request with passed key may proceed.
+// If not, it returns time after which next token will be available.
+type Limiter interface {
+	Allow(key string, limit Limit) (bool, time.Duration, error)
+}
+
+// take refills bucket by elapsed time and tries to take one token from it
+func take(tokens float64, last, now time.Time, limit Limit) (float64, bool, time.Duration) {
+
+	elapsed := now.Sub(last).Seconds()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	tokens = math.Min(float64(limit.Burst), tokens+elapsed*limit.Rate)
+	if tokens >= 1 {
+		return tokens - 1, true, 0
+	}
+
+	wait := time.Duration((1 - tokens) / limit.Rate * float64(time.Second))
+	return tokens, false, wait
+}
@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// Implementation check
+var _ Limiter = (*redisLimiter)(nil)
+
+var errUnexpectedReply = errors.New("unexpected reply of rate limit script")
+
+// Prefix of keys with bucket state
+const keyPrefix = "ratelimit:"
+
+// Token bucket in redis hash, evaluated atomically.
+// KEYS[1] - bucket key
+// ARGV - rate per second, burst, now in milliseconds
+// Returns {allowed, wait in milliseconds}
+var takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "last")
+local tokens = tonumber(state[1])
+local last = tonumber(state[2])
+if tokens == nil then
+	tokens = burst
+	last = now
+end
+
+local elapsed = math.max(0, now - last) / 1000
+tokens = math.min(burst, tokens + elapsed * rate)
+
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate * 1000)
+end
+
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "last", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+
+return {allowed, wait}
+`)
+
+// Limiter shared between instances through redis
+type redisLimiter struct {
+	rdb *redis.Client
+}
+
+func NewRedis(rdb *redis.Client) Limiter {
+	return &redisLimiter{rdb: rdb}
+}
+
+func (r *redisLimiter) Allow(key string, limit Limit) (bool, time.Duration, error) {
+
+	if limit.Disabled() {
+		return true, 0, nil
+	}
+
+	res, err := takeScript.Run(r.rdb, []string{keyPrefix + key},
+		limit.Rate,
+		limit.Burst,
+		time.Now().UnixMilli(),
+	).Result()
+	if err != nil {
+		return false, 0, err
+	}
+
+	values, ok := res.([]interface{})
+	if !ok || len(values) != 2 {
+		return false, 0, errUnexpectedReply
+	}
+
+	allowed, _ := values[0].(int64)
+	wait, _ := values[1].(int64)
+
+	return allowed == 1, time.Duration(wait) * time.Millisecond, nil
+}
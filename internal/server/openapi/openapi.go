@@ -0,0 +1,48 @@
+package openapi
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// OpenAPI 3 document of REST API, must be updated together with route.New
+//
+//go:embed openapi.json
+var document []byte
+
+// Swagger UI loads document from the same service
+const page = `<!DOCTYPE html>
+<html lang="ru">
+<head>
+  <meta charset="utf-8">
+  <title>Сервис баннеров</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({url: "/api/openapi.json", dom_id: "#swagger-ui"});
+  </script>
+</body>
+</html>
+`
+
+// Document returns OpenAPI document
+func Document() []byte {
+	return document
+}
+
+// Отдача спецификации
+func Spec(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(document)
+}
+
+// Страница Swagger UI
+func UI(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write([]byte(page))
+}
@@ -0,0 +1,95 @@
+package openapi_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/config"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/logger"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/middlewares"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/openapi"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/route"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/service"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/go-chi/chi"
+)
+
+func load(t *testing.T) *openapi3.T {
+	t.Helper()
+
+	doc, err := openapi3.NewLoader().LoadFromData(openapi.Document())
+	if err != nil {
+		t.Fatalf("load document: %v", err)
+	}
+	return doc
+}
+
+func TestDocumentIsValid(t *testing.T) {
+	if err := load(t).Validate(context.Background()); err != nil {
+		t.Fatalf("document is invalid: %v", err)
+	}
+}
+
+// Каждый маршрут route.New описан в документе и наоборот
+func TestEveryRouteIsDocumented(t *testing.T) {
+
+	doc := load(t)
+
+	log, err := logger.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Config{}
+	svc, err := service.New(log, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := make(map[string]bool)
+	err = chi.Walk(route.New(svc, middlewares.New(cfg, nil, log)),
+		func(method, path string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+			routes[method+" "+path] = true
+			if doc.Paths.Find(path) == nil || doc.Paths.Find(path).GetOperation(method) == nil {
+				t.Errorf("route %s %s is not documented", method, path)
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, item := range doc.Paths.Map() {
+		for method := range item.Operations() {
+			if !routes[strings.ToUpper(method)+" "+path] {
+				t.Errorf("documented operation %s %s has no route", method, path)
+			}
+		}
+	}
+}
+
+func TestSpecIsServed(t *testing.T) {
+
+	log, err := logger.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Config{}
+	svc, err := service.New(log, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := route.New(svc, middlewares.New(cfg, nil, log))
+
+	for _, target := range []string{"/api/openapi.json", "/api/docs"} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+		if recorder.Code != http.StatusOK {
+			t.Errorf("GET %s: status %d", target, recorder.Code)
+		}
+	}
+}
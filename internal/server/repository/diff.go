@@ -0,0 +1,211 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+)
+
+var ErrBadDiffVersion = errors.New("from must be version number, to must be version number or live")
+
+// Ключ JSON, который можно записать в пути через точку
+var plainKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Параметры сравнения: from обязателен, to - номер версии или live,
+// без to версия сравнивается с действующим содержимым. 0 в to означает live
+func (repo Repository) GetDiffQuery(querys url.Values) (int, int, error) {
+
+	val, ok := querys["from"]
+	if !ok {
+		return 0, 0, ErrBadDiffVersion
+	}
+	from, err := strconv.Atoi(val[0])
+	if err != nil || from <= 0 {
+		return 0, 0, ErrBadDiffVersion
+	}
+
+	var to int
+	if val, ok = querys["to"]; ok && val[0] != "live" {
+		if to, err = strconv.Atoi(val[0]); err != nil || to <= 0 {
+			return 0, 0, ErrBadDiffVersion
+		}
+	}
+
+	return from, to, nil
+}
+
+// Разница версии from и версии to или действующего содержимого, если to равен 0.
+// Разница с действующим содержимым показывает, что изменит откат на from, поэтому
+// live в ней - старое значение, а from - новое. false если баннера или версии нет
+func (repo Repository) DiffBanner(ctx context.Context, bannerID, from, to int) (models.BannerDiff, bool, error) {
+
+	history, err := repo.db.GetHistoryBanner(ctx, bannerID)
+	if err != nil {
+		return models.BannerDiff{}, false, err
+	}
+
+	version := func(number int) (models.BannerContent, bool) {
+		for _, v := range history {
+			if v.Version == number {
+				return models.BannerContent{Title: v.Title, Text: v.Text, Url: v.Url}, true
+			}
+		}
+		return models.BannerContent{}, false
+	}
+
+	oldContent, ok := version(from)
+	if !ok {
+		return models.BannerDiff{}, false, nil
+	}
+
+	var newContent models.BannerContent
+	if to == 0 {
+		live, ok, err := repo.db.GetBannerContent(ctx, bannerID)
+		if err != nil || !ok {
+			return models.BannerDiff{}, false, err
+		}
+		// Откат меняет действующее содержимое на from
+		oldContent, newContent = live, oldContent
+	} else if newContent, ok = version(to); !ok {
+		return models.BannerDiff{}, false, nil
+	}
+
+	diff := models.BannerDiff{
+		BannerID: uint32(bannerID),
+		From:     from,
+		To:       to,
+		Live:     to == 0,
+		Changes:  make([]models.BannerChange, 0),
+	}
+
+	diff.Changes = diffField(diff.Changes, "title", oldContent.Title, newContent.Title)
+	diff.Changes = diffField(diff.Changes, "text", oldContent.Text, newContent.Text)
+	diff.Changes = diffField(diff.Changes, "url", oldContent.Url, newContent.Url)
+
+	return diff, true, nil
+}
+
+// Разница одного поля. Если в поле с обеих сторон JSON объект или массив,
+// разница считается по путям внутри него, иначе поле заменяется целиком
+func diffField(changes []models.BannerChange, path, oldValue, newValue string) []models.BannerChange {
+
+	if oldValue == newValue {
+		return changes
+	}
+
+	oldJSON, oldOK := structured(oldValue)
+	newJSON, newOK := structured(newValue)
+	if oldOK && newOK {
+		return diffJSON(changes, path, oldJSON, newJSON)
+	}
+
+	return append(changes, change(path, models.DiffChanged, oldValue, newValue))
+}
+
+// Содержимое поля как JSON объект или массив
+func structured(value string) (any, bool) {
+
+	trimmed := bytes.TrimSpace([]byte(value))
+	if len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return nil, false
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	decoder.UseNumber()
+
+	var v any
+	if err := decoder.Decode(&v); err != nil || decoder.More() {
+		return nil, false
+	}
+
+	return v, true
+}
+
+// Рекурсивная разница JSON значений, ключи объектов обходятся по порядку
+func diffJSON(changes []models.BannerChange, path string, oldValue, newValue any) []models.BannerChange {
+
+	switch oldTyped := oldValue.(type) {
+	case map[string]any:
+		newTyped, ok := newValue.(map[string]any)
+		if !ok {
+			break
+		}
+
+		keys := make([]string, 0, len(oldTyped)+len(newTyped))
+		for key := range oldTyped {
+			keys = append(keys, key)
+		}
+		for key := range newTyped {
+			if _, ok := oldTyped[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			keyPath := path + "." + key
+			if !plainKey.MatchString(key) {
+				keyPath = path + "[" + strconv.Quote(key) + "]"
+			}
+
+			oldItem, inOld := oldTyped[key]
+			newItem, inNew := newTyped[key]
+			switch {
+			case !inOld:
+				changes = append(changes, change(keyPath, models.DiffAdded, nil, newItem))
+			case !inNew:
+				changes = append(changes, change(keyPath, models.DiffRemoved, oldItem, nil))
+			default:
+				changes = diffJSON(changes, keyPath, oldItem, newItem)
+			}
+		}
+		return changes
+
+	case []any:
+		newTyped, ok := newValue.([]any)
+		if !ok {
+			break
+		}
+
+		for i := 0; i < len(oldTyped) || i < len(newTyped); i++ {
+			itemPath := path + "[" + strconv.Itoa(i) + "]"
+			switch {
+			case i >= len(oldTyped):
+				changes = append(changes, change(itemPath, models.DiffAdded, nil, newTyped[i]))
+			case i >= len(newTyped):
+				changes = append(changes, change(itemPath, models.DiffRemoved, oldTyped[i], nil))
+			default:
+				changes = diffJSON(changes, itemPath, oldTyped[i], newTyped[i])
+			}
+		}
+		return changes
+	}
+
+	if reflect.DeepEqual(oldValue, newValue) {
+		return changes
+	}
+
+	return append(changes, change(path, models.DiffChanged, oldValue, newValue))
+}
+
+// Изменение со значениями в JSON, отсутствующая сторона передается как nil
+func change(path, op string, oldValue, newValue any) models.BannerChange {
+
+	c := models.BannerChange{Path: path, Op: op}
+	if op != models.DiffAdded {
+		c.Old, _ = json.Marshal(oldValue)
+	}
+	if op != models.DiffRemoved {
+		c.New, _ = json.Marshal(newValue)
+	}
+
+	return c
+}
@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/storage/database"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+)
+
+var (
+	ErrApprovalRequired = errors.New("content of banner changes through draft approval, create draft with POST /api/draft or pass emergency=true with emergency scope")
+	ErrEmergencyScope   = errors.New("emergency update requires token with emergency scope")
+	ErrBadDraftAction   = errors.New("unknown draft action, expected submit, approve, reject or comment")
+	ErrBadDraftStatus   = errors.New("unknown draft status, expected draft, pending, published or rejected")
+	ErrEmptyComment     = errors.New("comment is required")
+	ErrDraftContent     = errors.New("content can be changed only on submit")
+)
+
+// Параметр emergency обновления баннера в обход проверки черновиков
+func (repo Repository) GetEmergency(querys url.Values) (bool, error) {
+
+	if val, ok := querys["emergency"]; ok {
+		return strconv.ParseBool(val[0])
+	}
+
+	return false, nil
+}
+
+// Проверка изменения действующего содержимого в обход черновиков. Если включено ApprovalRequired,
+// его меняет только токен со scope emergency и только с явным флагом, change - меняется ли содержимое.
+// Возвращает контекст с отметкой срочного изменения для журнала аудита
+func (repo Repository) bypassApproval(ctx context.Context, emergency, change bool) (context.Context, error) {
+
+	if emergency {
+		if !token.HasScope(ctx, models.ScopeEmergency) {
+			return ctx, ErrEmergencyScope
+		}
+		return database.WithEmergency(ctx), nil
+	}
+
+	if repo.approval && change {
+		return ctx, ErrApprovalRequired
+	}
+
+	return ctx, nil
+}
+
+// Меняется ли действующее содержимое баннера, проверяется только при включенном ApprovalRequired
+func (repo Repository) contentChanged(ctx context.Context, bannerID int, content models.BannerContent) (bool, error) {
+
+	if !repo.approval {
+		return false, nil
+	}
+
+	live, ok, err := repo.db.GetBannerContent(ctx, bannerID)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	return live != content, nil
+}
+
+// Обновление баннера. Если включено ApprovalRequired, содержимое меняется только через черновики,
+// остальные поля обновляются как обычно
+func (repo Repository) UpdateBanner(ctx context.Context, bannerBody models.BannerBody, bannerID int, emergency bool) (bool, error) {
+
+	change, err := repo.contentChanged(ctx, bannerID, bannerBody.Content)
+	if err != nil {
+		return false, err
+	}
+
+	if ctx, err = repo.bypassApproval(ctx, emergency, change); err != nil {
+		return false, err
+	}
+
+	return repo.db.UpdateBanner(ctx, bannerBody, bannerID)
+}
+
+func (repo Repository) CreateDraft(ctx context.Context, draftRequest models.DraftRequest) (models.Draft, bool, error) {
+	return repo.db.CreateDraft(ctx, draftRequest)
+}
+
+func (repo Repository) GetDraftQuery(querys url.Values) (models.DraftQuery, error) {
+
+	var (
+		d   models.DraftQuery
+		err error
+	)
+
+	if val, ok := querys["banner_id"]; ok {
+		if d.BannerID, err = strconv.Atoi(val[0]); err != nil {
+			return models.DraftQuery{}, err
+		}
+	}
+
+	if val, ok := querys["status"]; ok {
+		switch val[0] {
+		case models.DraftStatusDraft, models.DraftStatusPending, models.DraftStatusPublished, models.DraftStatusRejected:
+			d.Status = val[0]
+		default:
+			return models.DraftQuery{}, ErrBadDraftStatus
+		}
+	}
+
+	return d, nil
+}
+
+func (repo Repository) GetDrafts(ctx context.Context, draftQuery models.DraftQuery) ([]models.Draft, error) {
+	return repo.db.GetDrafts(ctx, draftQuery)
+}
+
+func (repo Repository) GetDraft(ctx context.Context, draftID int64) (models.Draft, bool, error) {
+	return repo.db.GetDraft(ctx, draftID)
+}
+
+// Действие с черновиком, новое содержимое передается только при повторной отправке на проверку
+func (repo Repository) UpdateDraft(ctx context.Context, draftID int64, draftAction models.DraftAction) (models.Draft, bool, error) {
+
+	switch draftAction.Action {
+	case models.DraftActionSubmit, models.DraftActionApprove, models.DraftActionReject:
+	case models.DraftActionComment:
+		if draftAction.Comment == "" {
+			return models.Draft{}, false, ErrEmptyComment
+		}
+	default:
+		return models.Draft{}, false, ErrBadDraftAction
+	}
+
+	if draftAction.Content != nil && draftAction.Action != models.DraftActionSubmit {
+		return models.Draft{}, false, ErrDraftContent
+	}
+
+	return repo.db.UpdateDraft(ctx, draftID, draftAction)
+}
@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+)
+
+var (
+	ErrBadLocale     = errors.New("locale must be language tag like en or en-US")
+	ErrDefaultLocale = errors.New("content in default locale is main banner content, update it with PATCH /api/banner/{id}")
+)
+
+var localePattern = regexp.MustCompile(models.LocalePattern)
+
+// Тэг локали приводится к нижнему регистру, en_US и en-US равны en-us
+func normalizeLocale(locale string) (string, error) {
+
+	locale = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(locale)), "_", "-")
+	if !localePattern.MatchString(locale) {
+		return "", ErrBadLocale
+	}
+
+	return locale, nil
+}
+
+// Локаль основного содержимого, конфиг тестов часто собирается без нее
+func (repo Repository) defaultLocale() string {
+	if repo.locale == "" {
+		return models.DefaultLocale
+	}
+	return repo.locale
+}
+
+// Локали пользовательского баннера в порядке предпочтения: параметр lang,
+// затем Accept-Language по убыванию q и цепочка запасных локалей из конфига.
+// За региональным тэгом идет его язык: en-us, затем en
+func (repo Repository) GetLocales(querys url.Values, acceptLanguage string) ([]string, error) {
+
+	var requested []string
+
+	if val, ok := querys["lang"]; ok {
+		locale, err := normalizeLocale(val[0])
+		if err != nil {
+			return nil, err
+		}
+		requested = append(requested, locale)
+	}
+
+	requested = append(requested, parseAcceptLanguage(acceptLanguage)...)
+	requested = append(requested, repo.fallback...)
+
+	var (
+		locales = make([]string, 0, len(requested))
+		seen    = make(map[string]bool, len(requested))
+	)
+	add := func(locale string) {
+		if !seen[locale] {
+			seen[locale] = true
+			locales = append(locales, locale)
+		}
+	}
+
+	for _, locale := range requested {
+		add(locale)
+		if base, _, ok := strings.Cut(locale, "-"); ok {
+			add(base)
+		}
+	}
+
+	return locales, nil
+}
+
+// Тэги заголовка Accept-Language по убыванию q. Тэги с q=0, звездочка
+// и некорректные тэги пропускаются, заголовок с ошибкой не ломает ответ
+func parseAcceptLanguage(header string) []string {
+
+	type weighted struct {
+		locale string
+		q      float64
+	}
+
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+
+		tag, params, _ := strings.Cut(part, ";")
+
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			var err error
+			if q, err = strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil {
+				continue
+			}
+		}
+
+		locale, err := normalizeLocale(tag)
+		if err != nil || q <= 0 {
+			continue
+		}
+
+		tags = append(tags, weighted{locale: locale, q: q})
+	}
+
+	// При равном q сохраняется порядок заголовка
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	locales := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		locales = append(locales, tag.locale)
+	}
+
+	return locales
+}
+
+// Содержимое баннера в первой доступной локали и сама локаль.
+// Основное содержимое баннера считается локалью по умолчанию,
+// если ни одной локали нет, отдается оно
+func (repo Repository) Localize(banner models.UserBanner, locales []string) (models.BannerContent, string) {
+
+	for _, locale := range locales {
+		if locale == repo.defaultLocale() {
+			break
+		}
+		if content, ok := banner.Locales[locale]; ok {
+			return content, locale
+		}
+	}
+
+	return banner.Content, repo.defaultLocale()
+}
+
+// Параметр lang истории баннера, пустая строка для основного содержимого
+func (repo Repository) GetLocale(querys url.Values) (string, error) {
+
+	val, ok := querys["lang"]
+	if !ok {
+		return "", nil
+	}
+
+	locale, err := normalizeLocale(val[0])
+	if err != nil || locale == repo.defaultLocale() {
+		return "", err
+	}
+
+	return locale, nil
+}
+
+func (repo Repository) GetBannerLocales(ctx context.Context, bannerID int) ([]models.BannerLocale, bool, error) {
+	return repo.db.GetBannerLocales(ctx, bannerID)
+}
+
+// Добавление или изменение локали баннера, основное содержимое и история других локалей не меняются.
+// Черновиков у локалей нет, при ApprovalRequired локаль меняется только срочным изменением
+func (repo Repository) SetBannerLocale(ctx context.Context, bannerID int, locale models.BannerLocale, emergency bool) (models.BannerLocale, bool, error) {
+
+	var err error
+
+	if locale.Locale, err = normalizeLocale(locale.Locale); err != nil {
+		return models.BannerLocale{}, false, err
+	}
+
+	if locale.Locale == repo.defaultLocale() {
+		return models.BannerLocale{}, false, ErrDefaultLocale
+	}
+
+	if ctx, err = repo.bypassApproval(ctx, emergency, true); err != nil {
+		return models.BannerLocale{}, false, err
+	}
+
+	return repo.db.SetBannerLocale(ctx, bannerID, locale)
+}
+
+// Удаление локали, как и ее изменение, при ApprovalRequired выполняется только срочным изменением
+func (repo Repository) DeleteBannerLocale(ctx context.Context, bannerID int, locale string, emergency bool) (bool, error) {
+
+	locale, err := normalizeLocale(locale)
+	if err != nil {
+		return false, err
+	}
+
+	if ctx, err = repo.bypassApproval(ctx, emergency, true); err != nil {
+		return false, err
+	}
+
+	return repo.db.DeleteBannerLocale(ctx, bannerID, locale)
+}
+
+func (repo Repository) GetLocaleHistory(ctx context.Context, bannerID int, locale string) ([]models.BannerHistory, error) {
+	return repo.db.GetLocaleHistory(ctx, bannerID, locale)
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+)
+
+var ErrEmptyName = errors.New("name is required")
+
+// Регистрация фичи или тэга, владельцем по умолчанию становится автор запроса
+func (repo Repository) CreateRegistryEntry(ctx context.Context, kind string, entry models.RegistryEntry) (models.RegistryEntry, error) {
+
+	if entry.Name = strings.TrimSpace(entry.Name); entry.Name == "" {
+		return models.RegistryEntry{}, ErrEmptyName
+	}
+
+	if entry.Owner == "" {
+		entry.Owner = token.Actor(ctx)
+	}
+
+	return repo.db.CreateRegistryEntry(ctx, kind, entry)
+}
+
+func (repo Repository) GetRegistryEntries(ctx context.Context, kind, name string) ([]models.RegistryEntry, error) {
+	return repo.db.GetRegistryEntries(ctx, kind, name)
+}
+
+func (repo Repository) GetRegistryEntry(ctx context.Context, kind string, id int) (models.RegistryEntry, bool, error) {
+	return repo.db.GetRegistryEntry(ctx, kind, id)
+}
+
+func (repo Repository) UpdateRegistryEntry(ctx context.Context, kind string, entry models.RegistryEntry) (bool, error) {
+
+	if entry.Name = strings.TrimSpace(entry.Name); entry.Name == "" {
+		return false, ErrEmptyName
+	}
+
+	return repo.db.UpdateRegistryEntry(ctx, kind, entry)
+}
+
+// Параметр force удаления из справочника
+func (repo Repository) GetForce(querys url.Values) (bool, error) {
+
+	if val, ok := querys["force"]; ok {
+		return strconv.ParseBool(val[0])
+	}
+
+	return false, nil
+}
+
+func (repo Repository) DeleteRegistryEntry(ctx context.Context, kind string, id int, force bool) (bool, error) {
+	return repo.db.DeleteRegistryEntry(ctx, kind, id, force)
+}
+
+// Имена фичи и тэга запроса переводятся в id. false, если имя не зарегистрировано
+// или указывает не на тот id, который передан вместе с ним
+func (repo Repository) resolveNames(ctx context.Context, queryParam *models.Query) (bool, error) {
+
+	names := []struct {
+		kind string
+		name string
+		id   *int
+	}{
+		{models.RegistryFeature, queryParam.FeatureName, &queryParam.FeatureID},
+		{models.RegistryTag, queryParam.TagName, &queryParam.TagID},
+	}
+
+	for _, n := range names {
+		if n.name == "" {
+			continue
+		}
+
+		entries, err := repo.db.GetRegistryEntries(ctx, n.kind, n.name)
+		if err != nil {
+			return false, err
+		}
+		if len(entries) == 0 || (*n.id != 0 && *n.id != int(entries[0].ID)) {
+			return false, nil
+		}
+
+		*n.id = int(entries[0].ID)
+	}
+
+	return true, nil
+}
@@ -2,65 +2,141 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"hash/fnv"
+	"io"
 	"net/url"
+	"sort"
 	"strconv"
+	"time"
 
 	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/config"
 	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/stats"
 	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/storage/cache"
 	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/storage/database"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
 )
 
 // Implementation check
 var _ Repositorer = (*Repository)(nil)
 
+var ErrBadExperiment = errors.New("experiment needs at least one variant and positive weights")
+
 type Repositorer interface {
 	CreateBanner(ctx context.Context, bannerBody models.BannerBody) (int, error)
-	UpdateBanner(ctx context.Context, bannerBody models.BannerBody, bannerID int) (bool, error)
+	UpdateBanner(ctx context.Context, bannerBody models.BannerBody, bannerID int, emergency bool) (bool, error)
+	GetEmergency(querys url.Values) (bool, error)
 	GetQueryParam(querys url.Values) models.Query
 	GetBanners(ctx context.Context, queryParam models.Query) ([]models.ResponseBody, error)
+	GetBannerByID(ctx context.Context, bannerID int) (models.ResponseBody, bool, error)
+	GetBannerBody(ctx context.Context, bannerID int) (models.BannerBody, bool, error)
 	CheckQuery(queryParam models.Query) bool
-	GetBanner(ctx context.Context, featureID, tagID int) (models.BannerContent, error)
-	GetBannerFromCache(featureID, tagID int) (models.BannerContent, error)
+	GetBanner(ctx context.Context, featureID int, tagIDs []int) ([]models.UserBanner, error)
+	GetBannerFromCache(ctx context.Context, featureID int, tagIDs []int) ([]models.UserBanner, bool, error)
+	ChooseVariant(banners []models.UserBanner, subject string, featureID, tagID int) models.UserBanner
 	DeleteBanner(ctx context.Context, bannerID int) error
 	GetHistoryBanner(ctx context.Context, bannerID int) ([]models.BannerHistory, error)
-	UpdateVersion(ctx context.Context, bannerVersion models.BannerHistory) error
+	UpdateVersion(ctx context.Context, bannerVersion models.BannerHistory, emergency bool) error
+	GetDiffQuery(querys url.Values) (int, int, error)
+	DiffBanner(ctx context.Context, bannerID, from, to int) (models.BannerDiff, bool, error)
+	GetAuditQuery(querys url.Values) (models.AuditQuery, error)
+	GetAudit(ctx context.Context, auditQuery models.AuditQuery) ([]models.AuditRecord, error)
+	GetDeletedBanners(ctx context.Context) ([]models.DeletedBanner, error)
+	RestoreBanner(ctx context.Context, bannerID int) (bool, error)
+	PurgeBanners(ctx context.Context) (int, error)
+	GetExportQuery(querys url.Values) (string, bool, error)
+	ExportBanners(ctx context.Context, writer io.Writer, format string, withHistory bool) error
+	GetImportOptions(querys url.Values) (models.ImportOptions, error)
+	ImportBanners(ctx context.Context, reader io.Reader, options models.ImportOptions) (models.ImportReport, error)
+	TrackImpression(bannerID, featureID, tagID int)
+	TrackClick(ctx context.Context, bannerID, featureID, tagID int) (string, error)
+	FlushStats(ctx context.Context) error
+	GetStatsQuery(querys url.Values) (models.StatsQuery, error)
+	GetStats(ctx context.Context, statsQuery models.StatsQuery) ([]models.BannerStats, error)
+	CreateExperiment(ctx context.Context, experimentRequest models.ExperimentRequest, emergency bool) (models.Experiment, bool, error)
+	GetExperiment(ctx context.Context, featureID, tagID int) (models.Experiment, bool, error)
+	DeclareWinner(ctx context.Context, winner models.WinnerRequest, emergency bool) (bool, error)
+	RelayEvents(ctx context.Context, publish func(context.Context, models.Event) error) (int, error)
+	CreateWebhook(ctx context.Context, subscription models.WebhookSubscription) (models.WebhookSubscription, error)
+	GetWebhooks(ctx context.Context) ([]models.WebhookSubscription, error)
+	DeleteWebhook(ctx context.Context, subscriptionID int64) (bool, error)
+	GetDeliveryQuery(subscriptionID int64, querys url.Values) models.DeliveryQuery
+	GetDeliveries(ctx context.Context, deliveryQuery models.DeliveryQuery) ([]models.WebhookDelivery, error)
+	ClaimDeliveries(ctx context.Context) ([]models.WebhookDelivery, error)
+	CompleteDelivery(ctx context.Context, result models.DeliveryResult) error
+	CreateRegistryEntry(ctx context.Context, kind string, entry models.RegistryEntry) (models.RegistryEntry, error)
+	GetRegistryEntries(ctx context.Context, kind, name string) ([]models.RegistryEntry, error)
+	GetRegistryEntry(ctx context.Context, kind string, id int) (models.RegistryEntry, bool, error)
+	UpdateRegistryEntry(ctx context.Context, kind string, entry models.RegistryEntry) (bool, error)
+	GetForce(querys url.Values) (bool, error)
+	DeleteRegistryEntry(ctx context.Context, kind string, id int, force bool) (bool, error)
+	SetCacheTTL(ttl time.Duration)
+	GetLocales(querys url.Values, acceptLanguage string) ([]string, error)
+	Localize(banner models.UserBanner, locales []string) (models.BannerContent, string)
+	GetLocale(querys url.Values) (string, error)
+	GetBannerLocales(ctx context.Context, bannerID int) ([]models.BannerLocale, bool, error)
+	SetBannerLocale(ctx context.Context, bannerID int, locale models.BannerLocale, emergency bool) (models.BannerLocale, bool, error)
+	DeleteBannerLocale(ctx context.Context, bannerID int, locale string, emergency bool) (bool, error)
+	GetLocaleHistory(ctx context.Context, bannerID int, locale string) ([]models.BannerHistory, error)
+	CreateDraft(ctx context.Context, draftRequest models.DraftRequest) (models.Draft, bool, error)
+	GetDraftQuery(querys url.Values) (models.DraftQuery, error)
+	GetDrafts(ctx context.Context, draftQuery models.DraftQuery) ([]models.Draft, error)
+	GetDraft(ctx context.Context, draftID int64) (models.Draft, bool, error)
+	UpdateDraft(ctx context.Context, draftID int64, draftAction models.DraftAction) (models.Draft, bool, error)
 }
 
 // Repository layer
 type Repository struct {
-	db    database.DBaser
-	cache cache.Cacher
+	db        database.DBaser
+	cache     cache.Cacher
+	tracker   *stats.Tracker // buffer of impressions and clicks
+	retention time.Duration  // how long deleted banners can be restored
+	batch     int            // max events relayed from outbox at once
+	webhooks  int            // max webhook deliveries claimed at once
+	locale    string         // locale of main banner content
+	fallback  []string       // locales tried after requested ones
+	approval  bool           // content changes only through reviewed drafts
 }
 
 // Create new repository for service
 func New(cfg config.Config) (Repositorer, error) {
 
 	// Connect to postgreSQL database
-	postgre, err := database.Connect(cfg.DSN)
+	postgre, err := database.Connect(cfg.DSN, database.Pool{
+		MaxOpenConns:    cfg.DBMaxOpenConns,
+		MaxIdleConns:    cfg.DBMaxIdleConns,
+		ConnMaxLifetime: cfg.DBConnMaxLifetime,
+	})
 	if err != nil {
 		return nil, err
 	}
 
 	// Connect to redis database
-	cache := cache.New(cfg.RedisAddr, cfg.RedisPassword)
+	cache := cache.New(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisPoolSize, cfg.CacheTTL)
+
+	return NewWithStorage(postgre, cache, cfg), nil
+}
 
+// Create repository over passed storages, e.g. in-memory ones in tests
+func NewWithStorage(db database.DBaser, cache cache.Cacher, cfg config.Config) Repositorer {
 	return Repository{
-			db:    postgre,
-			cache: cache,
-		},
-		nil
+		db:        db,
+		cache:     cache,
+		tracker:   stats.NewTracker(),
+		retention: cfg.DeleteRetention,
+		batch:     cfg.OutboxBatch,
+		webhooks:  cfg.WebhookBatch,
+		locale:    cfg.DefaultLocale,
+		fallback:  cfg.Locales(),
+		approval:  cfg.ApprovalRequired,
+	}
 }
 
 func (repo Repository) CreateBanner(ctx context.Context, bannerBody models.BannerBody) (int, error) {
 	return repo.db.CreateBanner(ctx, bannerBody)
 }
 
-func (repo Repository) UpdateBanner(ctx context.Context, bannerBody models.BannerBody, bannerID int) (bool, error) {
-	return repo.db.UpdateBanner(ctx, bannerBody, bannerID)
-}
-
 func (repo Repository) GetQueryParam(querys url.Values) models.Query {
 
 	var d models.Query
@@ -69,8 +145,21 @@ func (repo Repository) GetQueryParam(querys url.Values) models.Query {
 		d.FeatureID, _ = strconv.Atoi(val[0])
 	}
 
+	// У пользователя может быть несколько тэгов: tag_id=1&tag_id=2
 	if val, ok := querys["tag_id"]; ok {
-		d.TagID, _ = strconv.Atoi(val[0])
+		for _, v := range val {
+			tagID, _ := strconv.Atoi(v)
+			d.TagIDs = append(d.TagIDs, tagID)
+		}
+		d.TagID = d.TagIDs[0]
+	}
+
+	if val, ok := querys["feature_name"]; ok {
+		d.FeatureName = val[0]
+	}
+
+	if val, ok := querys["tag_name"]; ok {
+		d.TagName = val[0]
 	}
 
 	if val, ok := querys["limit"]; ok {
@@ -90,6 +179,12 @@ func (repo Repository) GetQueryParam(querys url.Values) models.Query {
 
 func (repo Repository) GetBanners(ctx context.Context, queryParam models.Query) ([]models.ResponseBody, error) {
 
+	// Имена фичи и тэга заменяются их id, по неизвестному имени баннеров нет
+	resolved, err := repo.resolveNames(ctx, &queryParam)
+	if err != nil || !resolved {
+		return []models.ResponseBody{}, err
+	}
+
 	// Получим все баннеры из БД по параметрам
 	banners, err := repo.db.GetBanners(ctx, queryParam)
 	if err != nil {
@@ -101,6 +196,34 @@ func (repo Repository) GetBanners(ctx context.Context, queryParam models.Query)
 	return banners, nil
 }
 
+func (repo Repository) GetBannerByID(ctx context.Context, bannerID int) (models.ResponseBody, bool, error) {
+	return repo.db.GetBannerByID(ctx, bannerID)
+}
+
+// Текущее состояние баннера в виде тела запроса на обновление, поверх него
+// раскладывается PATCH, поэтому поля, которых нет в запросе, не меняются
+func (repo Repository) GetBannerBody(ctx context.Context, bannerID int) (models.BannerBody, bool, error) {
+
+	banner, ok, err := repo.db.GetBannerByID(ctx, bannerID)
+	if err != nil || !ok {
+		return models.BannerBody{}, false, err
+	}
+
+	body := models.BannerBody{
+		BannerID:       banner.BannerID,
+		FeatureID:      banner.FeatureID,
+		Content:        banner.Content,
+		Active:         banner.Active,
+		Priority:       banner.Priority,
+		FeatureDefault: banner.FeatureDefault,
+	}
+	if len(banner.TagID) > 0 {
+		body.TagID = banner.TagID[0]
+	}
+
+	return body, true, nil
+}
+
 func (repo Repository) CheckQuery(queryParam models.Query) bool {
 	if queryParam.FeatureID != 0 && queryParam.TagID != 0 {
 		return true
@@ -108,34 +231,106 @@ func (repo Repository) CheckQuery(queryParam models.Query) bool {
 	return false
 }
 
-func (repo Repository) GetBanner(ctx context.Context, featureID, tagID int) (models.BannerContent, error) {
+// Баннер для фичи и тэгов пользователя, выбранный по правилам resolve.
+// Если для выбранной пары идет эксперимент, возвращаются все его варианты
+func (repo Repository) GetBanner(ctx context.Context, featureID int, tagIDs []int) ([]models.UserBanner, error) {
 
-	banner, err := repo.db.GetBanner(ctx, featureID, tagID)
+	candidates, err := repo.db.GetBanner(ctx, featureID, tagIDs)
 	if err != nil {
-		return models.BannerContent{}, err
+		return nil, err
 	}
 
-	// Запишем баннер в кэш
-	repo.setBanner2Cache(repo.hashTwoFields(featureID, tagID), banner)
+	banners := repo.resolve(candidates, len(tagIDs))
 
-	return banner, nil
+	// Запишем результат выбора в кэш, отсутствие баннера не кэшируем
+	if len(banners) > 0 {
+		repo.setBanner2Cache(token.Tenant(ctx), repo.hashKey(featureID, tagIDs...), banners)
+	}
+
+	return banners, nil
 }
 
-func (repo Repository) GetBannerFromCache(featureID, tagID int) (models.BannerContent, error) {
+func (repo Repository) GetBannerFromCache(ctx context.Context, featureID int, tagIDs []int) ([]models.UserBanner, bool, error) {
 
-	// Преобразуем фичу и тэг в хэш, чтобы записать в кэш
-	return repo.cache.GetBanner(repo.hashTwoFields(featureID, tagID))
+	// Преобразуем фичу и тэги в хэш, чтобы записать в кэш, ключ кэша у каждого проекта свой
+	return repo.cache.GetBanner(token.Tenant(ctx), repo.hashKey(featureID, tagIDs...))
 }
 
-func (repo Repository) hashTwoFields(featureID, tagID int) uint64 {
+// Ключ кэша фичи и набора тэгов, порядок и повторы тэгов не важны.
+// Для одного тэга это ключ пары фича + тэг
+func (repo Repository) hashKey(featureID int, tagIDs ...int) uint64 {
+
+	tags := append([]int(nil), tagIDs...)
+	sort.Ints(tags)
+
 	h := fnv.New64a()
 	h.Write([]byte(strconv.Itoa(featureID)))
-	h.Write([]byte(strconv.Itoa(tagID)))
+	h.Write([]byte{':'}) // разделитель, чтобы пары 1 и 23, 12 и 3 не совпадали
+	for i, tagID := range tags {
+		if i > 0 && tags[i-1] == tagID {
+			continue
+		}
+		if i > 0 {
+			h.Write([]byte{','})
+		}
+		h.Write([]byte(strconv.Itoa(tagID)))
+	}
 	return h.Sum64()
 }
 
-func (repo Repository) setBanner2Cache(hashKey uint64, banner models.BannerContent) {
-	repo.cache.SetBanner2Cache(hashKey, banner)
+// Новый срок жизни кэша, уже закэшированные баннеры живут по старому
+func (repo Repository) SetCacheTTL(ttl time.Duration) {
+	repo.cache.SetTTL(ttl)
+}
+
+func (repo Repository) setBanner2Cache(tenant string, hashKey uint64, banners []models.UserBanner) {
+	repo.cache.SetBanner2Cache(tenant, hashKey, banners)
+}
+
+// Выбор варианта эксперимента по весам. Пользователь закрепляется за вариантом
+// по хэшу subject токена, без subject показываем контрольный баннер пары
+func (repo Repository) ChooseVariant(banners []models.UserBanner, subject string, featureID, tagID int) models.UserBanner {
+
+	if len(banners) == 0 {
+		return models.UserBanner{}
+	}
+
+	total := 0
+	for _, banner := range banners {
+		total += banner.Weight
+	}
+
+	if len(banners) == 1 || total <= 0 || subject == "" {
+		return repo.control(banners)
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(subject))
+	h.Write([]byte{':'})
+	h.Write([]byte(strconv.Itoa(featureID)))
+	h.Write([]byte{':'})
+	h.Write([]byte(strconv.Itoa(tagID)))
+	point := int(h.Sum64() % uint64(total))
+
+	for _, banner := range banners {
+		if point < banner.Weight {
+			return banner
+		}
+		point -= banner.Weight
+	}
+
+	return banners[len(banners)-1]
+}
+
+// Контрольный баннер - вариант с наименьшим ID, он создан раньше вариантов эксперимента
+func (repo Repository) control(banners []models.UserBanner) models.UserBanner {
+	control := banners[0]
+	for _, banner := range banners[1:] {
+		if banner.BannerID < control.BannerID {
+			control = banner
+		}
+	}
+	return control
 }
 
 func (repo Repository) DeleteBanner(ctx context.Context, bannerID int) error {
@@ -156,6 +351,219 @@ func (repo Repository) GetHistoryBanner(ctx context.Context, bannerID int) ([]mo
 	return repo.db.GetHistoryBanner(ctx, bannerID)
 }
 
-func (repo Repository) UpdateVersion(ctx context.Context, bannerVersion models.BannerHistory) error {
+// Замена актуального содержимого версией. При ApprovalRequired откат проходит через черновик
+// с содержимым версии, напрямую - только срочным изменением
+func (repo Repository) UpdateVersion(ctx context.Context, bannerVersion models.BannerHistory, emergency bool) error {
+
+	content := models.BannerContent{Title: bannerVersion.Title, Text: bannerVersion.Text, Url: bannerVersion.Url}
+	change, err := repo.contentChanged(ctx, int(bannerVersion.BannerID), content)
+	if err != nil {
+		return err
+	}
+
+	if ctx, err = repo.bypassApproval(ctx, emergency, change); err != nil {
+		return err
+	}
+
 	return repo.db.UpdateVersion(ctx, bannerVersion)
 }
+
+func (repo Repository) GetAuditQuery(querys url.Values) (models.AuditQuery, error) {
+
+	var (
+		d   models.AuditQuery
+		err error
+	)
+
+	if val, ok := querys["banner_id"]; ok {
+		if d.BannerID, err = strconv.Atoi(val[0]); err != nil {
+			return models.AuditQuery{}, err
+		}
+	}
+
+	if val, ok := querys["actor"]; ok {
+		d.Actor = val[0]
+	}
+
+	// Границы интервала передаются в RFC3339
+	if val, ok := querys["from"]; ok {
+		if d.From, err = time.Parse(time.RFC3339, val[0]); err != nil {
+			return models.AuditQuery{}, err
+		}
+	}
+
+	if val, ok := querys["to"]; ok {
+		if d.To, err = time.Parse(time.RFC3339, val[0]); err != nil {
+			return models.AuditQuery{}, err
+		}
+	}
+
+	if val, ok := querys["limit"]; ok {
+		d.Limit, _ = strconv.Atoi(val[0])
+	}
+
+	if val, ok := querys["offset"]; ok {
+		d.Offset, _ = strconv.Atoi(val[0])
+	}
+
+	return d, nil
+}
+
+func (repo Repository) GetAudit(ctx context.Context, auditQuery models.AuditQuery) ([]models.AuditRecord, error) {
+	return repo.db.GetAudit(ctx, auditQuery)
+}
+
+// Удаленные баннеры, которые еще можно восстановить
+func (repo Repository) GetDeletedBanners(ctx context.Context) ([]models.DeletedBanner, error) {
+
+	banners, err := repo.db.GetDeletedBanners(ctx, time.Now().Add(-repo.retention))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range banners {
+		banners[i].RestoreUntil = banners[i].DeletedAt.Add(repo.retention)
+	}
+
+	return banners, nil
+}
+
+func (repo Repository) RestoreBanner(ctx context.Context, bannerID int) (bool, error) {
+	return repo.db.RestoreBanner(ctx, bannerID, time.Now().Add(-repo.retention))
+}
+
+// Окончательно удаляем баннеры, у которых истекло окно восстановления
+func (repo Repository) PurgeBanners(ctx context.Context) (int, error) {
+	return repo.db.PurgeBanners(ctx, time.Now().Add(-repo.retention))
+}
+
+// Учет показа баннера, в БД показы попадают пакетно при сбросе буфера
+func (repo Repository) TrackImpression(bannerID, featureID, tagID int) {
+	repo.tracker.Impression(bannerID, featureID, tagID)
+}
+
+// Учет клика по баннеру, возвращает ссылку баннера или пустую строку, если баннер недоступен
+func (repo Repository) TrackClick(ctx context.Context, bannerID, featureID, tagID int) (string, error) {
+
+	url, err := repo.db.GetBannerURL(ctx, bannerID)
+	if err != nil || url == "" {
+		return "", err
+	}
+
+	repo.tracker.Click(bannerID, featureID, tagID)
+
+	return url, nil
+}
+
+// Сброс накопленных показов и кликов в БД, при ошибке счетчики возвращаются в буфер
+func (repo Repository) FlushStats(ctx context.Context) error {
+
+	deltas := repo.tracker.Drain()
+
+	if err := repo.db.SaveStats(ctx, deltas); err != nil {
+		repo.tracker.Restore(deltas)
+		return err
+	}
+
+	return nil
+}
+
+func (repo Repository) GetStatsQuery(querys url.Values) (models.StatsQuery, error) {
+
+	var (
+		d   models.StatsQuery
+		err error
+	)
+
+	if val, ok := querys["banner_id"]; ok {
+		if d.BannerID, err = strconv.Atoi(val[0]); err != nil {
+			return models.StatsQuery{}, err
+		}
+	}
+
+	// Границы интервала передаются в RFC3339
+	if val, ok := querys["from"]; ok {
+		if d.From, err = time.Parse(time.RFC3339, val[0]); err != nil {
+			return models.StatsQuery{}, err
+		}
+	}
+
+	if val, ok := querys["to"]; ok {
+		if d.To, err = time.Parse(time.RFC3339, val[0]); err != nil {
+			return models.StatsQuery{}, err
+		}
+	}
+
+	return d, nil
+}
+
+func (repo Repository) GetStats(ctx context.Context, statsQuery models.StatsQuery) ([]models.BannerStats, error) {
+	return repo.db.GetStats(ctx, statsQuery)
+}
+
+// Создание эксперимента. Варианты сразу показываются пользователям, поэтому
+// при ApprovalRequired эксперимент создается только срочным изменением
+func (repo Repository) CreateExperiment(ctx context.Context, experimentRequest models.ExperimentRequest, emergency bool) (models.Experiment, bool, error) {
+
+	if len(experimentRequest.Variants) == 0 || experimentRequest.ControlWeight <= 0 {
+		return models.Experiment{}, false, ErrBadExperiment
+	}
+
+	for _, variant := range experimentRequest.Variants {
+		if variant.Weight <= 0 {
+			return models.Experiment{}, false, ErrBadExperiment
+		}
+	}
+
+	ctx, err := repo.bypassApproval(ctx, emergency, true)
+	if err != nil {
+		return models.Experiment{}, false, err
+	}
+
+	experiment, ok, err := repo.db.CreateExperiment(ctx, experimentRequest)
+	if err != nil || !ok {
+		return experiment, ok, err
+	}
+
+	// Сбрасываем закэшированный баннер пары, чтобы варианты начали показываться сразу
+	repo.cache.Invalidate(token.Tenant(ctx), repo.hashKey(int(experimentRequest.FeatureID), int(experimentRequest.TagID)))
+
+	return experiment, true, nil
+}
+
+func (repo Repository) GetExperiment(ctx context.Context, featureID, tagID int) (models.Experiment, bool, error) {
+	return repo.db.GetExperiment(ctx, featureID, tagID)
+}
+
+// Завершение эксперимента. Победивший вариант становится содержимым контрольного баннера,
+// при ApprovalRequired это срочное изменение, если победил не контрольный баннер
+func (repo Repository) DeclareWinner(ctx context.Context, winner models.WinnerRequest, emergency bool) (bool, error) {
+
+	var change bool
+	if repo.approval {
+		experiment, ok, err := repo.db.GetExperiment(ctx, int(winner.FeatureID), int(winner.TagID))
+		if err != nil || !ok {
+			return false, err
+		}
+		change = experiment.ControlID != winner.BannerID
+	}
+
+	ctx, err := repo.bypassApproval(ctx, emergency, change)
+	if err != nil {
+		return false, err
+	}
+
+	ok, err := repo.db.DeclareWinner(ctx, winner)
+	if err != nil || !ok {
+		return ok, err
+	}
+
+	repo.cache.Invalidate(token.Tenant(ctx), repo.hashKey(int(winner.FeatureID), int(winner.TagID)))
+
+	return true, nil
+}
+
+// Публикация событий из outbox
+func (repo Repository) RelayEvents(ctx context.Context, publish func(context.Context, models.Event) error) (int, error) {
+	return repo.db.RelayOutbox(ctx, repo.batch, publish)
+}
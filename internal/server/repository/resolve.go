@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+)
+
+// Выбор баннера среди кандидатов хранилища. Если баннер есть у пары одного тэга
+// пользователя, берется он, если у нескольких - пара с наибольшим приоритетом
+// (при равном приоритете - более старый баннер). Если ни у одного тэга баннера нет,
+// берется баннер фичи по умолчанию. Правило записывается в каждый баннер результата
+func (repo Repository) resolve(candidates []models.UserBanner, tags int) []models.UserBanner {
+
+	var (
+		pairs    = make(map[uint32][]models.UserBanner) // tag_id -> баннеры пары
+		fallback []models.UserBanner
+	)
+
+	for _, banner := range candidates {
+		if banner.TagID == 0 {
+			fallback = append(fallback, banner)
+			continue
+		}
+		pairs[banner.TagID] = append(pairs[banner.TagID], banner)
+	}
+
+	if len(pairs) == 0 {
+		return withRule(fallback, models.RuleFeatureDefault)
+	}
+
+	var best []models.UserBanner
+	for _, banners := range pairs {
+		if best == nil ||
+			banners[0].Priority > best[0].Priority ||
+			(banners[0].Priority == best[0].Priority && repo.control(banners).BannerID < repo.control(best).BannerID) {
+			best = banners
+		}
+	}
+
+	// Один тэг запроса или единственный тэг с баннером
+	if tags <= 1 || len(pairs) == 1 {
+		return withRule(best, models.RuleExact)
+	}
+
+	return withRule(best, models.RulePriority)
+}
+
+func withRule(banners []models.UserBanner, rule string) []models.UserBanner {
+	for i := range banners {
+		banners[i].Rule = rule
+	}
+	return banners
+}
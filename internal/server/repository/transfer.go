@@ -0,0 +1,410 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+)
+
+var (
+	errUnknownFormat   = errors.New("unknown format, expected jsonl or csv")
+	errUnknownMode     = errors.New("unknown mode, expected atomic or best_effort")
+	errUnknownConflict = errors.New("unknown conflict policy, expected skip or overwrite")
+)
+
+// Колонки CSV, тэги разделяются точкой с запятой, история передается в JSON
+var csvHeader = []string{"banner_id", "feature_id", "tag_id", "title", "text", "url", "is_active"}
+
+// Необязательные при загрузке колонки, в файлах прежних выгрузок их нет
+var csvOptional = []string{"priority", "is_feature_default"}
+
+const (
+	csvHistory      = "history"
+	csvTagSeparator = ";"
+)
+
+// Максимальный размер строки JSON Lines
+const maxLineSize = 1 << 20
+
+// Параметры выгрузки: формат и нужна ли история версий
+func (repo Repository) GetExportQuery(querys url.Values) (string, bool, error) {
+
+	var withHistory bool
+
+	format, err := getFormat(querys)
+	if err != nil {
+		return "", false, err
+	}
+
+	if val, ok := querys["history"]; ok {
+		if withHistory, err = strconv.ParseBool(val[0]); err != nil {
+			return "", false, err
+		}
+	}
+
+	return format, withHistory, nil
+}
+
+// Формат выгрузки и загрузки, по умолчанию JSON Lines
+func getFormat(querys url.Values) (string, error) {
+	format := querys.Get("format")
+	switch format {
+	case "":
+		return models.FormatJSONL, nil
+	case models.FormatJSONL, models.FormatCSV:
+		return format, nil
+	default:
+		return "", errUnknownFormat
+	}
+}
+
+func (repo Repository) GetImportOptions(querys url.Values) (models.ImportOptions, error) {
+
+	var (
+		options = models.ImportOptions{
+			Mode:     models.ImportAtomic,
+			Conflict: models.ConflictSkip,
+		}
+		err error
+	)
+
+	if options.Format, err = getFormat(querys); err != nil {
+		return models.ImportOptions{}, err
+	}
+
+	if val, ok := querys["mode"]; ok {
+		options.Mode = val[0]
+	}
+	if options.Mode != models.ImportAtomic && options.Mode != models.ImportBestEffort {
+		return models.ImportOptions{}, errUnknownMode
+	}
+
+	if val, ok := querys["conflict"]; ok {
+		options.Conflict = val[0]
+	}
+	if options.Conflict != models.ConflictSkip && options.Conflict != models.ConflictOverwrite {
+		return models.ImportOptions{}, errUnknownConflict
+	}
+
+	if val, ok := querys["dry_run"]; ok {
+		if options.DryRun, err = strconv.ParseBool(val[0]); err != nil {
+			return models.ImportOptions{}, err
+		}
+	}
+
+	if options.Emergency, err = repo.GetEmergency(querys); err != nil {
+		return models.ImportOptions{}, err
+	}
+
+	return options, nil
+}
+
+// Потоковая выгрузка баннеров в writer в переданном формате
+func (repo Repository) ExportBanners(ctx context.Context, writer io.Writer, format string, withHistory bool) error {
+
+	switch format {
+	case models.FormatJSONL:
+		encoder := json.NewEncoder(writer)
+		return repo.db.ExportBanners(ctx, withHistory, func(banner models.ExportBanner) error {
+			return encoder.Encode(banner)
+		})
+
+	case models.FormatCSV:
+		csvWriter := csv.NewWriter(writer)
+
+		header := append(append([]string{}, csvHeader...), csvOptional...)
+		if withHistory {
+			header = append(header, csvHistory)
+		}
+		if err := csvWriter.Write(header); err != nil {
+			return err
+		}
+
+		err := repo.db.ExportBanners(ctx, withHistory, func(banner models.ExportBanner) error {
+			record, err := bannerToCSV(banner, withHistory)
+			if err != nil {
+				return err
+			}
+			return csvWriter.Write(record)
+		})
+		if err != nil {
+			return err
+		}
+
+		csvWriter.Flush()
+		return csvWriter.Error()
+
+	default:
+		return errUnknownFormat
+	}
+}
+
+// Загрузка баннеров из reader. Ошибки разбора строк попадают в отчет,
+// в режиме atomic при ошибках разбора изменения не фиксируются.
+// Перезапись существующих баннеров при ApprovalRequired - срочное изменение
+func (repo Repository) ImportBanners(ctx context.Context, reader io.Reader, options models.ImportOptions) (models.ImportReport, error) {
+
+	var (
+		rows []importRow
+		err  error
+	)
+
+	overwrite := options.Conflict == models.ConflictOverwrite && !options.DryRun
+	if ctx, err = repo.bypassApproval(ctx, options.Emergency, overwrite); err != nil {
+		return models.ImportReport{}, err
+	}
+
+	switch options.Format {
+	case models.FormatJSONL:
+		rows, err = readJSONL(reader)
+	case models.FormatCSV:
+		rows, err = readCSV(reader)
+	default:
+		err = errUnknownFormat
+	}
+	if err != nil {
+		return models.ImportReport{}, err
+	}
+
+	report := models.ImportReport{
+		DryRun: options.DryRun,
+		Mode:   options.Mode,
+		Rows:   make([]models.ImportRowResult, len(rows)),
+	}
+
+	// В хранилище передаем только разобранные строки
+	banners := make([]models.ExportBanner, 0, len(rows))
+	positions := make([]int, 0, len(rows))
+	parseFailed := false
+
+	for i, row := range rows {
+		if row.err != nil {
+			report.Rows[i] = models.ImportRowResult{Row: row.line, Status: models.ImportFailed, Error: row.err.Error()}
+			parseFailed = true
+			continue
+		}
+		banners = append(banners, row.banner)
+		positions = append(positions, i)
+	}
+
+	dbOptions := options
+	if parseFailed && options.Mode == models.ImportAtomic {
+		dbOptions.DryRun = true
+	}
+
+	results, committed, err := repo.db.ImportBanners(ctx, banners, dbOptions)
+	if err != nil {
+		return models.ImportReport{}, err
+	}
+
+	for i, result := range results {
+		result.Row = rows[positions[i]].line
+		report.Rows[positions[i]] = result
+	}
+
+	for _, result := range report.Rows {
+		switch result.Status {
+		case models.ImportCreated:
+			report.Created++
+		case models.ImportUpdated:
+			report.Updated++
+		case models.ImportSkipped:
+			report.Skipped++
+		case models.ImportFailed:
+			report.Failed++
+		}
+	}
+	report.Committed = committed
+
+	return report, nil
+}
+
+// Строка загрузки с номером строки во входных данных
+type importRow struct {
+	line   int
+	banner models.ExportBanner
+	err    error
+}
+
+func readJSONL(reader io.Reader) ([]importRow, error) {
+
+	rows := make([]importRow, 0)
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		row := importRow{line: line}
+		row.err = json.Unmarshal(data, &row.banner)
+		rows = append(rows, row)
+	}
+
+	return rows, scanner.Err()
+}
+
+func readCSV(reader io.Reader) ([]importRow, error) {
+
+	rows := make([]importRow, 0)
+
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+
+	header, err := csvReader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+
+	for _, name := range csvHeader {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("csv header has no column %q", name)
+		}
+	}
+
+	line := 1
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+
+		// Ошибка разбора относится к строке, ошибка чтения тела прерывает загрузку
+		var parseErr *csv.ParseError
+		if err != nil && !errors.As(err, &parseErr) {
+			return nil, err
+		}
+
+		row := importRow{line: line}
+		if err != nil {
+			row.err = err
+		} else {
+			row.banner, row.err = bannerFromCSV(record, columns)
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+func bannerToCSV(banner models.ExportBanner, withHistory bool) ([]string, error) {
+
+	tags := make([]string, 0, len(banner.TagID))
+	for _, tag := range banner.TagID {
+		tags = append(tags, strconv.FormatUint(uint64(tag), 10))
+	}
+
+	record := []string{
+		strconv.FormatUint(uint64(banner.BannerID), 10),
+		strconv.FormatUint(uint64(banner.FeatureID), 10),
+		strings.Join(tags, csvTagSeparator),
+		banner.Content.Title,
+		banner.Content.Text,
+		banner.Content.Url,
+		strconv.FormatBool(banner.Active),
+		strconv.Itoa(banner.Priority),
+		strconv.FormatBool(banner.FeatureDefault),
+	}
+
+	if withHistory {
+		history, err := json.Marshal(banner.History)
+		if err != nil {
+			return nil, err
+		}
+		record = append(record, string(history))
+	}
+
+	return record, nil
+}
+
+func bannerFromCSV(record []string, columns map[string]int) (models.ExportBanner, error) {
+
+	var banner models.ExportBanner
+
+	field := func(name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return record[i]
+	}
+
+	if val := strings.TrimSpace(field("banner_id")); val != "" {
+		id, err := strconv.ParseUint(val, 10, 32)
+		if err != nil {
+			return models.ExportBanner{}, fmt.Errorf("banner_id: %w", err)
+		}
+		banner.BannerID = uint32(id)
+	}
+
+	feature, err := strconv.ParseUint(strings.TrimSpace(field("feature_id")), 10, 32)
+	if err != nil {
+		return models.ExportBanner{}, fmt.Errorf("feature_id: %w", err)
+	}
+	banner.FeatureID = uint32(feature)
+
+	for _, val := range strings.Split(field("tag_id"), csvTagSeparator) {
+		if val = strings.TrimSpace(val); val == "" {
+			continue
+		}
+		tag, err := strconv.ParseUint(val, 10, 32)
+		if err != nil {
+			return models.ExportBanner{}, fmt.Errorf("tag_id: %w", err)
+		}
+		banner.TagID = append(banner.TagID, uint32(tag))
+	}
+
+	banner.Content = models.BannerContent{
+		Title: field("title"),
+		Text:  field("text"),
+		Url:   field("url"),
+	}
+
+	if val := strings.TrimSpace(field("is_active")); val != "" {
+		if banner.Active, err = strconv.ParseBool(val); err != nil {
+			return models.ExportBanner{}, fmt.Errorf("is_active: %w", err)
+		}
+	}
+
+	if val := strings.TrimSpace(field("priority")); val != "" {
+		if banner.Priority, err = strconv.Atoi(val); err != nil {
+			return models.ExportBanner{}, fmt.Errorf("priority: %w", err)
+		}
+	}
+
+	if val := strings.TrimSpace(field("is_feature_default")); val != "" {
+		if banner.FeatureDefault, err = strconv.ParseBool(val); err != nil {
+			return models.ExportBanner{}, fmt.Errorf("is_feature_default: %w", err)
+		}
+	}
+
+	if val := strings.TrimSpace(field(csvHistory)); val != "" && val != "null" {
+		if err = json.Unmarshal([]byte(val), &banner.History); err != nil {
+			return models.ExportBanner{}, fmt.Errorf("history: %w", err)
+		}
+	}
+
+	return banner, nil
+}
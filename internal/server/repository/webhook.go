@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/webhook"
+)
+
+var (
+	ErrBadWebhookURL   = errors.New("webhook url must be absolute http or https url")
+	ErrBadWebhookEvent = errors.New("unknown webhook event type")
+)
+
+// Типы событий, на которые можно подписаться
+var eventTypes = map[string]bool{
+	models.EventBannerCreated:         true,
+	models.EventBannerUpdated:         true,
+	models.EventBannerDeleted:         true,
+	models.EventBannerVersionSwitched: true,
+	models.EventReviewRequested:       true,
+}
+
+// Создание подписки, если секрет не передан, он генерируется и возвращается один раз
+func (repo Repository) CreateWebhook(ctx context.Context, subscription models.WebhookSubscription) (models.WebhookSubscription, error) {
+
+	target, err := url.Parse(subscription.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return models.WebhookSubscription{}, ErrBadWebhookURL
+	}
+
+	if subscription.EventTypes == nil {
+		subscription.EventTypes = make([]string, 0)
+	}
+	for _, event := range subscription.EventTypes {
+		if !eventTypes[event] {
+			return models.WebhookSubscription{}, ErrBadWebhookEvent
+		}
+	}
+
+	if subscription.Secret == "" {
+		if subscription.Secret, err = webhook.NewSecret(); err != nil {
+			return models.WebhookSubscription{}, err
+		}
+	}
+
+	return repo.db.CreateWebhook(ctx, subscription)
+}
+
+func (repo Repository) GetWebhooks(ctx context.Context) ([]models.WebhookSubscription, error) {
+	return repo.db.GetWebhooks(ctx)
+}
+
+func (repo Repository) DeleteWebhook(ctx context.Context, subscriptionID int64) (bool, error) {
+	return repo.db.DeleteWebhook(ctx, subscriptionID)
+}
+
+func (repo Repository) GetDeliveryQuery(subscriptionID int64, querys url.Values) models.DeliveryQuery {
+
+	d := models.DeliveryQuery{SubscriptionID: subscriptionID}
+
+	if val, ok := querys["status"]; ok {
+		d.Status = val[0]
+	}
+
+	if val, ok := querys["limit"]; ok {
+		d.Limit, _ = strconv.Atoi(val[0])
+	}
+
+	if val, ok := querys["offset"]; ok {
+		d.Offset, _ = strconv.Atoi(val[0])
+	}
+
+	return d
+}
+
+func (repo Repository) GetDeliveries(ctx context.Context, deliveryQuery models.DeliveryQuery) ([]models.WebhookDelivery, error) {
+	return repo.db.GetDeliveries(ctx, deliveryQuery)
+}
+
+// Доставки, которые пора отправить
+func (repo Repository) ClaimDeliveries(ctx context.Context) ([]models.WebhookDelivery, error) {
+	return repo.db.ClaimDeliveries(ctx, repo.webhooks)
+}
+
+func (repo Repository) CompleteDelivery(ctx context.Context, result models.DeliveryResult) error {
+	return repo.db.CompleteDelivery(ctx, result)
+}
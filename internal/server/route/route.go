@@ -1,7 +1,10 @@
 package route
 
 import (
+	"net/http"
+
 	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/middlewares"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/openapi"
 	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/service"
 	"github.com/go-chi/chi"
 )
@@ -11,14 +14,71 @@ func New(service service.Servicer, middleware *middlewares.Middlewares) *chi.Mux
 	// New router
 	route := chi.NewRouter()
 
+	// Authorization and rate limit of routes: by IP before authorization, by subject after it
+	user := func(h http.HandlerFunc) http.HandlerFunc {
+		return middleware.IPRateLimit(middleware.UserAuthorization(middleware.UserRateLimit(h)))
+	}
+	admin := func(h http.HandlerFunc) http.HandlerFunc {
+		return middleware.IPRateLimit(middleware.AdminAuthorization(middleware.AdminRateLimit(h)))
+	}
+
 	// Handlers
-	route.Get("/api/user_banner", middleware.UserAuthorization(service.GetUserBanner))    // Getting user banner
-	route.Post("/api/banner", middleware.AdminAuthorization(service.CreateBanner))        // Create new banner
-	route.Patch("/api/banner/{id}", middleware.AdminAuthorization(service.UpdateBanner))  // Update banner exists
-	route.Get("/api/banner", middleware.AdminAuthorization(service.GetBanners))           // Get banners
-	route.Delete("/api/banner/{id}", middleware.AdminAuthorization(service.DeleteBanner)) // Delete banner
-
-	route.Get("/api/history_banner/{id}", middleware.AdminAuthorization(service.GetHistoryBanner))
-	route.Post("/api/version_banner", middleware.AdminAuthorization(service.UpdateVersion))
+	route.Get("/api/user_banner", user(service.GetUserBanner))    // Getting user banner
+	route.Post("/api/banner", admin(service.CreateBanner))        // Create new banner
+	route.Patch("/api/banner/{id}", admin(service.UpdateBanner))  // Update banner exists
+	route.Get("/api/banner", admin(service.GetBanners))           // Get banners
+	route.Get("/api/banner/{id}", admin(service.GetBannerByID))   // Get banner by id
+	route.Delete("/api/banner/{id}", admin(service.DeleteBanner)) // Delete banner
+
+	route.Get("/api/history_banner/{id}", admin(service.GetHistoryBanner))
+	route.Post("/api/version_banner", admin(service.UpdateVersion))
+	route.Get("/api/diff_banner/{id}", admin(service.DiffBanner))
+
+	route.Get("/api/banner_locale/{id}", admin(service.GetBannerLocales))      // Content variants of banner by locale
+	route.Put("/api/banner_locale/{id}", admin(service.SetBannerLocale))       // Add or update locale of banner
+	route.Delete("/api/banner_locale/{id}", admin(service.DeleteBannerLocale)) // Delete locale of banner
+
+	route.Post("/api/draft", admin(service.CreateDraft))       // Draft of banner content change
+	route.Get("/api/draft", admin(service.GetDrafts))          // Drafts, status=pending is review queue
+	route.Get("/api/draft/{id}", admin(service.GetDraft))      // Draft with comments
+	route.Patch("/api/draft/{id}", admin(service.UpdateDraft)) // Submit, approve, reject or comment draft
+
+	route.Post("/api/token", admin(service.IssueToken)) // Issue token for role
+	route.Get("/api/audit", admin(service.GetAudit))    // Audit trail of admin mutations
+
+	route.Get("/api/deleted_banner", admin(service.GetDeletedBanners))   // Deleted banners within retention window
+	route.Post("/api/restore_banner/{id}", admin(service.RestoreBanner)) // Restore deleted banner
+
+	route.Get("/api/banner/export", admin(service.ExportBanners))  // Export banners as JSON Lines or CSV
+	route.Post("/api/banner/import", admin(service.ImportBanners)) // Import banners from JSON Lines or CSV
+
+	// Переход по клику открывается из браузера без токена, поэтому ограничиваем только частоту по IP
+	route.Get("/api/click/{id}", middleware.UserRateLimit(service.ClickBanner)) // Track click and redirect to banner url
+	route.Get("/api/banner_stats", admin(service.GetStats))                     // Impressions, clicks and CTR of banners
+
+	route.Post("/api/experiment", admin(service.CreateExperiment))     // Start A/B experiment for feature and tag
+	route.Get("/api/experiment", admin(service.GetExperiment))         // Experiment variants with stats
+	route.Post("/api/experiment/winner", admin(service.DeclareWinner)) // Collapse experiment to winner
+
+	route.Post("/api/webhook", admin(service.CreateWebhook))              // Subscribe to banner events
+	route.Get("/api/webhook", admin(service.GetWebhooks))                 // Webhook subscriptions
+	route.Delete("/api/webhook/{id}", admin(service.DeleteWebhook))       // Unsubscribe
+	route.Get("/api/webhook_delivery/{id}", admin(service.GetDeliveries)) // Delivery log of subscription
+
+	route.Post("/api/feature", admin(service.CreateFeature))        // Register feature
+	route.Get("/api/feature", admin(service.GetFeatures))           // Features, optionally by name
+	route.Get("/api/feature/{id}", admin(service.GetFeature))       // Feature by id
+	route.Patch("/api/feature/{id}", admin(service.UpdateFeature))  // Rename or describe feature
+	route.Delete("/api/feature/{id}", admin(service.DeleteFeature)) // Delete feature, force=true if banners use it
+
+	route.Post("/api/tag", admin(service.CreateTag))        // Register tag
+	route.Get("/api/tag", admin(service.GetTags))           // Tags, optionally by name
+	route.Get("/api/tag/{id}", admin(service.GetTag))       // Tag by id
+	route.Patch("/api/tag/{id}", admin(service.UpdateTag))  // Rename or describe tag
+	route.Delete("/api/tag/{id}", admin(service.DeleteTag)) // Delete tag, force=true if banners use it
+
+	// Документация API открыта без токена
+	route.Get("/api/openapi.json", openapi.Spec) // OpenAPI document
+	route.Get("/api/docs", openapi.UI)           // Swagger UI
 	return route
 }
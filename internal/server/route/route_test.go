@@ -0,0 +1,556 @@
+package route_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/config"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/logger"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/middlewares"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/ratelimit"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/openapi"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/repository"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/route"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/service"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+)
+
+// ID, для которого заглушка хранилища отвечает "не найдено"
+const missingID = 404
+
+var (
+	content = models.BannerContent{Title: "title", Text: "text", Url: "https://example.com"}
+	now     = time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+)
+
+// Заглушка хранилища: разбор параметров запроса выполняет настоящий Repository,
+// методы с данными отвечают фиксированными значениями
+type stubRepository struct {
+	repository.Repository
+}
+
+func (stubRepository) GetBannerFromCache(ctx context.Context, featureID int, tagIDs []int) ([]models.UserBanner, bool, error) {
+	return nil, false, nil
+}
+
+func (stubRepository) GetBanner(ctx context.Context, featureID int, tagIDs []int) ([]models.UserBanner, error) {
+	if featureID == missingID {
+		return nil, nil
+	}
+	if len(tagIDs) > 1 {
+		return []models.UserBanner{{BannerID: 1, Content: content, TagID: uint32(tagIDs[1]), Priority: 10, Rule: models.RulePriority}}, nil
+	}
+	return []models.UserBanner{{BannerID: 1, Content: content, TagID: uint32(tagIDs[0]), Rule: models.RuleExact}}, nil
+}
+
+func (stubRepository) TrackImpression(bannerID, featureID, tagID int) {}
+
+func (stubRepository) CreateBanner(ctx context.Context, bannerBody models.BannerBody) (int, error) {
+	return 1, nil
+}
+
+// Содержимое меняется только через черновики или в обход них с флагом emergency
+func (stubRepository) UpdateBanner(ctx context.Context, bannerBody models.BannerBody, bannerID int, emergency bool) (bool, error) {
+	if bannerBody.Content != content && !emergency {
+		return false, repository.ErrApprovalRequired
+	}
+	return bannerID != missingID, nil
+}
+
+func (stubRepository) GetBanners(ctx context.Context, queryParam models.Query) ([]models.ResponseBody, error) {
+	return []models.ResponseBody{{BannerID: 1, TagID: []uint32{1, 2}, FeatureID: 1, Content: content, Active: true, Priority: 10, FeatureDefault: true}}, nil
+}
+
+func (stubRepository) GetBannerByID(ctx context.Context, bannerID int) (models.ResponseBody, bool, error) {
+	banner := models.ResponseBody{BannerID: uint32(bannerID), TagID: []uint32{1, 2}, FeatureID: 1, Content: content, Active: true, Priority: 10}
+	return banner, bannerID != missingID, nil
+}
+
+func (stubRepository) GetBannerBody(ctx context.Context, bannerID int) (models.BannerBody, bool, error) {
+	banner := models.BannerBody{BannerID: uint32(bannerID), TagID: 1, FeatureID: 1, Content: content, Active: true, Priority: 10}
+	return banner, bannerID != missingID, nil
+}
+
+func (stubRepository) DeleteBanner(ctx context.Context, bannerID int) error {
+	return nil
+}
+
+func (stubRepository) GetHistoryBanner(ctx context.Context, bannerID int) ([]models.BannerHistory, error) {
+	return []models.BannerHistory{{BannerID: 1, Version: 1, Title: content.Title, Text: content.Text, Url: content.Url}}, nil
+}
+
+func (stubRepository) DiffBanner(ctx context.Context, bannerID, from, to int) (models.BannerDiff, bool, error) {
+	diff := models.BannerDiff{BannerID: uint32(bannerID), From: from, To: to, Live: to == 0, Changes: []models.BannerChange{
+		{Path: "title", Op: models.DiffChanged, Old: json.RawMessage(`"old"`), New: json.RawMessage(`"new"`)},
+		{Path: "text.items[1]", Op: models.DiffAdded, New: json.RawMessage(`{"label":"more"}`)},
+	}}
+	return diff, bannerID != missingID, nil
+}
+
+func (stubRepository) GetLocaleHistory(ctx context.Context, bannerID int, locale string) ([]models.BannerHistory, error) {
+	return []models.BannerHistory{{BannerID: 1, Version: 1, Title: content.Title, Text: content.Text, Url: content.Url, Locale: locale}}, nil
+}
+
+func (stubRepository) GetBannerLocales(ctx context.Context, bannerID int) ([]models.BannerLocale, bool, error) {
+	return []models.BannerLocale{{Locale: "en", Content: content, Version: 1}}, bannerID != missingID, nil
+}
+
+func (stubRepository) SetBannerLocale(ctx context.Context, bannerID int, locale models.BannerLocale, emergency bool) (models.BannerLocale, bool, error) {
+	if locale.Locale == "" {
+		return models.BannerLocale{}, false, repository.ErrBadLocale
+	}
+	if bannerID == http.StatusConflict && !emergency {
+		return models.BannerLocale{}, false, repository.ErrApprovalRequired
+	}
+	locale.Version = 1
+	return locale, bannerID != missingID, nil
+}
+
+func (stubRepository) DeleteBannerLocale(ctx context.Context, bannerID int, locale string, emergency bool) (bool, error) {
+	if bannerID == http.StatusConflict && !emergency {
+		return false, repository.ErrApprovalRequired
+	}
+	return bannerID != missingID, nil
+}
+
+func (stubRepository) UpdateVersion(ctx context.Context, bannerVersion models.BannerHistory, emergency bool) error {
+	if bannerVersion.BannerID == http.StatusConflict && !emergency {
+		return repository.ErrApprovalRequired
+	}
+	return nil
+}
+
+func (stubRepository) GetAudit(ctx context.Context, auditQuery models.AuditQuery) ([]models.AuditRecord, error) {
+	return []models.AuditRecord{{
+		ID:        1,
+		Actor:     "admin",
+		Action:    models.AuditCreate,
+		BannerID:  1,
+		Before:    []byte("null"),
+		After:     []byte(`{"banner_id":1,"tag_id":[1],"feature_id":1,"content":{"title":"title","text":"text","url":"u"},"is_active":true}`),
+		CreatedAt: now,
+	}}, nil
+}
+
+func (stubRepository) GetDeletedBanners(ctx context.Context) ([]models.DeletedBanner, error) {
+	return []models.DeletedBanner{{BannerID: 1, TagID: []uint32{1}, FeatureID: 1, Content: content, DeletedAt: now, RestoreUntil: now.Add(time.Hour)}}, nil
+}
+
+func (stubRepository) RestoreBanner(ctx context.Context, bannerID int) (bool, error) {
+	if bannerID == 409 {
+		return false, models.ErrPairTaken
+	}
+	return bannerID != missingID, nil
+}
+
+func (stubRepository) ExportBanners(ctx context.Context, writer io.Writer, format string, withHistory bool) error {
+	_, err := io.WriteString(writer, `{"banner_id":1,"tag_id":[1],"feature_id":1,"content":{"title":"t","text":"t","url":"u"},"is_active":true}`+"\n")
+	return err
+}
+
+func (stubRepository) ImportBanners(ctx context.Context, reader io.Reader, options models.ImportOptions) (models.ImportReport, error) {
+	if options.Conflict == models.ConflictOverwrite && !options.Emergency {
+		return models.ImportReport{}, repository.ErrApprovalRequired
+	}
+	return models.ImportReport{
+		Mode:      options.Mode,
+		Committed: true,
+		Created:   1,
+		Rows:      []models.ImportRowResult{{Row: 1, Status: models.ImportCreated, BannerID: 1}},
+	}, nil
+}
+
+func (stubRepository) TrackClick(ctx context.Context, bannerID, featureID, tagID int) (string, error) {
+	if bannerID == missingID {
+		return "", nil
+	}
+	return content.Url, nil
+}
+
+func (stubRepository) GetStats(ctx context.Context, statsQuery models.StatsQuery) ([]models.BannerStats, error) {
+	return []models.BannerStats{{BannerID: 1, Impressions: 10, Clicks: 1, CTR: 0.1}}, nil
+}
+
+func (stubRepository) experiment() models.Experiment {
+	return models.Experiment{
+		FeatureID: 1,
+		TagID:     1,
+		ControlID: 1,
+		Variants: []models.ExperimentVariant{
+			{BannerID: 1, Weight: 50, Content: content},
+			{BannerID: 2, Weight: 50, Content: content},
+		},
+	}
+}
+
+func (s stubRepository) CreateExperiment(ctx context.Context, experimentRequest models.ExperimentRequest, emergency bool) (models.Experiment, bool, error) {
+	if len(experimentRequest.Variants) == 0 {
+		return models.Experiment{}, false, repository.ErrBadExperiment
+	}
+	if experimentRequest.FeatureID == http.StatusConflict && !emergency {
+		return models.Experiment{}, false, repository.ErrApprovalRequired
+	}
+	return s.experiment(), true, nil
+}
+
+func (s stubRepository) GetExperiment(ctx context.Context, featureID, tagID int) (models.Experiment, bool, error) {
+	return s.experiment(), featureID != missingID, nil
+}
+
+func (stubRepository) DeclareWinner(ctx context.Context, winner models.WinnerRequest, emergency bool) (bool, error) {
+	if winner.FeatureID == http.StatusConflict && !emergency {
+		return false, repository.ErrApprovalRequired
+	}
+	return winner.FeatureID != missingID, nil
+}
+
+func (stubRepository) CreateWebhook(ctx context.Context, subscription models.WebhookSubscription) (models.WebhookSubscription, error) {
+	if subscription.URL == "" {
+		return models.WebhookSubscription{}, repository.ErrBadWebhookURL
+	}
+	subscription.ID, subscription.Secret, subscription.CreatedAt = 1, "secret", now
+	return subscription, nil
+}
+
+func (stubRepository) GetWebhooks(ctx context.Context) ([]models.WebhookSubscription, error) {
+	return []models.WebhookSubscription{{ID: 1, URL: "https://example.com/hook", EventTypes: []string{}, CreatedAt: now}}, nil
+}
+
+func (stubRepository) DeleteWebhook(ctx context.Context, subscriptionID int64) (bool, error) {
+	return subscriptionID != missingID, nil
+}
+
+func (stubRepository) GetDeliveries(ctx context.Context, deliveryQuery models.DeliveryQuery) ([]models.WebhookDelivery, error) {
+	return []models.WebhookDelivery{{
+		ID:             1,
+		SubscriptionID: deliveryQuery.SubscriptionID,
+		EventID:        1,
+		EventType:      models.EventBannerCreated,
+		Status:         models.DeliveryDelivered,
+		Attempts:       1,
+		NextAttemptAt:  now,
+		LastStatus:     http.StatusOK,
+		CreatedAt:      now,
+		DeliveredAt:    &now,
+	}}, nil
+}
+
+func (stubRepository) draft(id int64, status string) models.Draft {
+	return models.Draft{
+		DraftID:   id,
+		BannerID:  1,
+		Content:   content,
+		Status:    status,
+		Author:    "author",
+		Comments:  []models.DraftComment{{Author: "author", Action: models.DraftActionSubmit, Text: "please review", CreatedAt: now}},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+func (s stubRepository) CreateDraft(ctx context.Context, draftRequest models.DraftRequest) (models.Draft, bool, error) {
+	return s.draft(1, models.DraftStatusPending), draftRequest.BannerID != missingID, nil
+}
+
+func (s stubRepository) GetDrafts(ctx context.Context, draftQuery models.DraftQuery) ([]models.Draft, error) {
+	return []models.Draft{s.draft(1, models.DraftStatusPending)}, nil
+}
+
+func (s stubRepository) GetDraft(ctx context.Context, draftID int64) (models.Draft, bool, error) {
+	return s.draft(draftID, models.DraftStatusPending), draftID != missingID, nil
+}
+
+func (s stubRepository) UpdateDraft(ctx context.Context, draftID int64, draftAction models.DraftAction) (models.Draft, bool, error) {
+	switch {
+	case draftAction.Action == "":
+		return models.Draft{}, false, repository.ErrBadDraftAction
+	case draftID == http.StatusForbidden:
+		return models.Draft{}, false, models.ErrSelfReview
+	case draftID == http.StatusConflict:
+		return models.Draft{}, false, models.ErrDraftStatus
+	}
+	draft := s.draft(draftID, models.DraftStatusPublished)
+	draft.Reviewer = "reviewer"
+	return draft, draftID != missingID, nil
+}
+
+func (stubRepository) entry(id int) models.RegistryEntry {
+	return models.RegistryEntry{ID: uint32(id), Name: "name", Description: "description", Owner: "admin", CreatedAt: now}
+}
+
+func (s stubRepository) CreateRegistryEntry(ctx context.Context, kind string, entry models.RegistryEntry) (models.RegistryEntry, error) {
+	switch entry.Name {
+	case "":
+		return models.RegistryEntry{}, repository.ErrEmptyName
+	case "taken":
+		return models.RegistryEntry{}, models.ErrNameTaken
+	}
+	return s.entry(1), nil
+}
+
+func (s stubRepository) GetRegistryEntries(ctx context.Context, kind, name string) ([]models.RegistryEntry, error) {
+	return []models.RegistryEntry{s.entry(1)}, nil
+}
+
+func (s stubRepository) GetRegistryEntry(ctx context.Context, kind string, id int) (models.RegistryEntry, bool, error) {
+	return s.entry(id), id != missingID, nil
+}
+
+func (stubRepository) UpdateRegistryEntry(ctx context.Context, kind string, entry models.RegistryEntry) (bool, error) {
+	return entry.ID != missingID, nil
+}
+
+func (stubRepository) DeleteRegistryEntry(ctx context.Context, kind string, id int, force bool) (bool, error) {
+	if id == http.StatusConflict && !force {
+		return false, models.ErrEntryInUse
+	}
+	return id != missingID, nil
+}
+
+type testCase struct {
+	name        string
+	method      string
+	target      string
+	token       string // admin, user, bad or empty
+	contentType string
+	body        string
+	status      int
+}
+
+var cases = []testCase{
+	{"user banner", http.MethodGet, "/api/user_banner?feature_id=1&tag_id=1", "user", "", "", http.StatusOK},
+	{"user banner by admin", http.MethodGet, "/api/user_banner?feature_id=1&tag_id=1&use_last_revision=true", "admin", "", "", http.StatusOK},
+	{"user banner without tag", http.MethodGet, "/api/user_banner?feature_id=1", "user", "", "", http.StatusBadRequest},
+	{"user banner not found", http.MethodGet, "/api/user_banner?feature_id=404&tag_id=1", "user", "", "", http.StatusNotFound},
+	{"user banner of several tags", http.MethodGet, "/api/user_banner?feature_id=1&tag_id=1&tag_id=2", "user", "", "", http.StatusOK},
+	{"user banner in locale", http.MethodGet, "/api/user_banner?feature_id=1&tag_id=1&lang=en", "user", "", "", http.StatusOK},
+	{"user banner bad locale", http.MethodGet, "/api/user_banner?feature_id=1&tag_id=1&lang=1", "user", "", "", http.StatusBadRequest},
+	{"user banner bad token", http.MethodGet, "/api/user_banner?feature_id=1&tag_id=1", "bad", "", "", http.StatusUnauthorized},
+	{"create banner", http.MethodPost, "/api/banner", "admin", "application/json", `{"tag_id":1,"feature_id":1,"content":{"title":"t","text":"t","url":"u"},"is_active":true}`, http.StatusCreated},
+	{"create feature default banner", http.MethodPost, "/api/banner", "admin", "application/json", `{"tag_id":1,"feature_id":1,"content":{"title":"t","text":"t","url":"u"},"is_active":true,"priority":10,"is_feature_default":true}`, http.StatusCreated},
+	{"create banner by user", http.MethodPost, "/api/banner", "user", "application/json", `{"tag_id":1,"feature_id":1}`, http.StatusUnauthorized},
+	{"get banners", http.MethodGet, "/api/banner?feature_id=1&limit=10&offset=0", "admin", "", "", http.StatusOK},
+	{"update banner", http.MethodPatch, "/api/banner/1", "admin", "application/json", `{"tag_id":1,"feature_id":1,"is_active":false}`, http.StatusOK},
+	{"update banner active only", http.MethodPatch, "/api/banner/1", "admin", "application/json", `{"is_active":false}`, http.StatusOK},
+	{"update banner content without review", http.MethodPatch, "/api/banner/1", "admin", "application/json", `{"tag_id":1,"feature_id":1,"content":{"title":"t"}}`, http.StatusConflict},
+	{"emergency update banner content", http.MethodPatch, "/api/banner/1?emergency=true", "admin", "application/json", `{"tag_id":1,"feature_id":1,"content":{"title":"t"}}`, http.StatusOK},
+	{"update banner bad emergency", http.MethodPatch, "/api/banner/1?emergency=yes", "admin", "application/json", `{"tag_id":1,"feature_id":1}`, http.StatusBadRequest},
+	{"create draft", http.MethodPost, "/api/draft", "admin", "application/json", `{"banner_id":1,"content":{"title":"t","text":"t","url":"u"},"comment":"please review","submit":true}`, http.StatusCreated},
+	{"create draft of missing banner", http.MethodPost, "/api/draft", "admin", "application/json", `{"banner_id":404,"content":{"title":"t"}}`, http.StatusNotFound},
+	{"get drafts", http.MethodGet, "/api/draft?status=pending&banner_id=1", "admin", "", "", http.StatusOK},
+	{"get drafts bad status", http.MethodGet, "/api/draft?status=live", "admin", "", "", http.StatusBadRequest},
+	{"get draft", http.MethodGet, "/api/draft/1", "admin", "", "", http.StatusOK},
+	{"get missing draft", http.MethodGet, "/api/draft/404", "admin", "", "", http.StatusNotFound},
+	{"approve draft", http.MethodPatch, "/api/draft/1", "admin", "application/json", `{"action":"approve","comment":"ok"}`, http.StatusOK},
+	{"draft without action", http.MethodPatch, "/api/draft/1", "admin", "application/json", `{"comment":"ok"}`, http.StatusBadRequest},
+	{"approve own draft", http.MethodPatch, "/api/draft/403", "admin", "application/json", `{"action":"approve"}`, http.StatusForbidden},
+	{"approve published draft", http.MethodPatch, "/api/draft/409", "admin", "application/json", `{"action":"approve"}`, http.StatusConflict},
+	{"approve missing draft", http.MethodPatch, "/api/draft/404", "admin", "application/json", `{"action":"approve"}`, http.StatusNotFound},
+	{"update missing banner", http.MethodPatch, "/api/banner/404", "admin", "application/json", `{"tag_id":1,"feature_id":1}`, http.StatusNotFound},
+	{"get banner", http.MethodGet, "/api/banner/1", "admin", "", "", http.StatusOK},
+	{"get missing banner", http.MethodGet, "/api/banner/404", "admin", "", "", http.StatusNotFound},
+	{"get banner bad id", http.MethodGet, "/api/banner/one", "admin", "", "", http.StatusBadRequest},
+	{"delete banner", http.MethodDelete, "/api/banner/1", "admin", "", "", http.StatusNoContent},
+	{"history banner", http.MethodGet, "/api/history_banner/1", "admin", "", "", http.StatusOK},
+	{"history banner locale", http.MethodGet, "/api/history_banner/1?lang=en", "admin", "", "", http.StatusOK},
+	{"history banner bad locale", http.MethodGet, "/api/history_banner/1?lang=english!", "admin", "", "", http.StatusBadRequest},
+	{"diff banner versions", http.MethodGet, "/api/diff_banner/1?from=1&to=2", "admin", "", "", http.StatusOK},
+	{"diff banner with live", http.MethodGet, "/api/diff_banner/1?from=1&to=live", "admin", "", "", http.StatusOK},
+	{"diff banner without from", http.MethodGet, "/api/diff_banner/1", "admin", "", "", http.StatusBadRequest},
+	{"diff missing banner", http.MethodGet, "/api/diff_banner/404?from=1", "admin", "", "", http.StatusNotFound},
+	{"diff banner as user", http.MethodGet, "/api/diff_banner/1?from=1", "user", "", "", http.StatusUnauthorized},
+	{"banner locales", http.MethodGet, "/api/banner_locale/1", "admin", "", "", http.StatusOK},
+	{"locales of missing banner", http.MethodGet, "/api/banner_locale/404", "admin", "", "", http.StatusNotFound},
+	{"set banner locale", http.MethodPut, "/api/banner_locale/1", "admin", "application/json", `{"locale":"en","content":{"title":"t","text":"t","url":"u"}}`, http.StatusOK},
+	{"set banner locale without locale", http.MethodPut, "/api/banner_locale/1", "admin", "application/json", `{"content":{"title":"t"}}`, http.StatusBadRequest},
+	{"set banner locale without approval", http.MethodPut, "/api/banner_locale/409", "admin", "application/json", `{"locale":"en","content":{"title":"t"}}`, http.StatusConflict},
+	{"set banner locale in emergency", http.MethodPut, "/api/banner_locale/409?emergency=true", "admin", "application/json", `{"locale":"en","content":{"title":"t"}}`, http.StatusOK},
+	{"delete banner locale", http.MethodDelete, "/api/banner_locale/1?lang=en", "admin", "", "", http.StatusNoContent},
+	{"delete locale of missing banner", http.MethodDelete, "/api/banner_locale/404?lang=en", "admin", "", "", http.StatusNotFound},
+	{"delete banner locale without approval", http.MethodDelete, "/api/banner_locale/409?lang=en", "admin", "", "", http.StatusConflict},
+	{"delete banner locale in emergency", http.MethodDelete, "/api/banner_locale/409?lang=en&emergency=true", "admin", "", "", http.StatusNoContent},
+	{"version banner", http.MethodPost, "/api/version_banner", "admin", "application/json", `{"banner_id":1,"version":1,"title":"t","text":"t","url":"u"}`, http.StatusOK},
+	{"version banner without approval", http.MethodPost, "/api/version_banner", "admin", "application/json", `{"banner_id":409,"version":1,"title":"t","text":"t","url":"u"}`, http.StatusConflict},
+	{"version banner in emergency", http.MethodPost, "/api/version_banner?emergency=true", "admin", "application/json", `{"banner_id":409,"version":1,"title":"t","text":"t","url":"u"}`, http.StatusOK},
+	{"version banner bad emergency", http.MethodPost, "/api/version_banner?emergency=maybe", "admin", "application/json", `{"banner_id":1,"version":1,"title":"t","text":"t","url":"u"}`, http.StatusBadRequest},
+	{"get banners by names", http.MethodGet, "/api/banner?feature_name=name&tag_name=name", "admin", "", "", http.StatusOK},
+	{"create feature", http.MethodPost, "/api/feature", "admin", "application/json", `{"name":"checkout","description":"d"}`, http.StatusCreated},
+	{"create feature without name", http.MethodPost, "/api/feature", "admin", "application/json", `{"id":1}`, http.StatusBadRequest},
+	{"create tag with taken name", http.MethodPost, "/api/tag", "admin", "application/json", `{"name":"taken"}`, http.StatusConflict},
+	{"create tag by user", http.MethodPost, "/api/tag", "user", "application/json", `{"name":"segment"}`, http.StatusUnauthorized},
+	{"get features", http.MethodGet, "/api/feature?name=name", "admin", "", "", http.StatusOK},
+	{"get tags", http.MethodGet, "/api/tag", "admin", "", "", http.StatusOK},
+	{"get feature", http.MethodGet, "/api/feature/1", "admin", "", "", http.StatusOK},
+	{"get missing tag", http.MethodGet, "/api/tag/404", "admin", "", "", http.StatusNotFound},
+	{"update tag", http.MethodPatch, "/api/tag/1", "admin", "application/json", `{"name":"segment","owner":"team"}`, http.StatusOK},
+	{"update missing feature", http.MethodPatch, "/api/feature/404", "admin", "application/json", `{"name":"checkout"}`, http.StatusNotFound},
+	{"delete tag", http.MethodDelete, "/api/tag/1", "admin", "", "", http.StatusNoContent},
+	{"delete used feature", http.MethodDelete, "/api/feature/409", "admin", "", "", http.StatusConflict},
+	{"force delete used feature", http.MethodDelete, "/api/feature/409?force=true", "admin", "", "", http.StatusNoContent},
+	{"delete missing feature", http.MethodDelete, "/api/feature/404", "admin", "", "", http.StatusNotFound},
+	{"issue token", http.MethodPost, "/api/token", "admin", "application/json", `{"role":"user","subject":"42","ttl":"1h"}`, http.StatusCreated},
+	{"issue token unknown role", http.MethodPost, "/api/token", "admin", "application/json", `{"role":"root"}`, http.StatusBadRequest},
+	{"audit", http.MethodGet, "/api/audit?banner_id=1&from=2024-01-01T00:00:00Z", "admin", "", "", http.StatusOK},
+	{"audit bad interval", http.MethodGet, "/api/audit?from=yesterday", "admin", "", "", http.StatusBadRequest},
+	{"deleted banners", http.MethodGet, "/api/deleted_banner", "admin", "", "", http.StatusOK},
+	{"restore banner", http.MethodPost, "/api/restore_banner/1", "admin", "", "", http.StatusOK},
+	{"restore missing banner", http.MethodPost, "/api/restore_banner/404", "admin", "", "", http.StatusNotFound},
+	{"restore taken pair", http.MethodPost, "/api/restore_banner/409", "admin", "", "", http.StatusConflict},
+	{"export", http.MethodGet, "/api/banner/export?format=jsonl&history=true", "admin", "", "", http.StatusOK},
+	{"export unknown format", http.MethodGet, "/api/banner/export?format=xml", "admin", "", "", http.StatusBadRequest},
+	{"import", http.MethodPost, "/api/banner/import?format=csv&mode=best_effort", "admin", "text/csv", "banner_id,feature_id,tag_id,title,text,url,is_active\n", http.StatusOK},
+	{"import overwrite without approval", http.MethodPost, "/api/banner/import?format=csv&conflict=overwrite", "admin", "text/csv", "banner_id,feature_id,tag_id,title,text,url,is_active\n", http.StatusConflict},
+	{"import overwrite in emergency", http.MethodPost, "/api/banner/import?format=csv&conflict=overwrite&emergency=true", "admin", "text/csv", "banner_id,feature_id,tag_id,title,text,url,is_active\n", http.StatusOK},
+	{"click", http.MethodGet, "/api/click/1?feature_id=1&tag_id=1", "", "", "", http.StatusFound},
+	{"click missing banner", http.MethodGet, "/api/click/404", "", "", "", http.StatusNotFound},
+	{"stats", http.MethodGet, "/api/banner_stats?banner_id=1", "admin", "", "", http.StatusOK},
+	{"create experiment", http.MethodPost, "/api/experiment", "admin", "application/json", `{"feature_id":1,"tag_id":1,"control_weight":50,"variants":[{"content":{"title":"b"},"weight":50}]}`, http.StatusCreated},
+	{"create empty experiment", http.MethodPost, "/api/experiment", "admin", "application/json", `{"feature_id":1,"tag_id":1,"variants":[]}`, http.StatusBadRequest},
+	{"create experiment without approval", http.MethodPost, "/api/experiment", "admin", "application/json", `{"feature_id":409,"tag_id":1,"control_weight":50,"variants":[{"content":{"title":"b"},"weight":50}]}`, http.StatusConflict},
+	{"create experiment in emergency", http.MethodPost, "/api/experiment?emergency=true", "admin", "application/json", `{"feature_id":409,"tag_id":1,"control_weight":50,"variants":[{"content":{"title":"b"},"weight":50}]}`, http.StatusCreated},
+	{"get experiment", http.MethodGet, "/api/experiment?feature_id=1&tag_id=1", "admin", "", "", http.StatusOK},
+	{"get missing experiment", http.MethodGet, "/api/experiment?feature_id=404&tag_id=1", "admin", "", "", http.StatusNotFound},
+	{"declare winner", http.MethodPost, "/api/experiment/winner", "admin", "application/json", `{"feature_id":1,"tag_id":1,"banner_id":2}`, http.StatusOK},
+	{"declare winner without approval", http.MethodPost, "/api/experiment/winner", "admin", "application/json", `{"feature_id":409,"tag_id":1,"banner_id":2}`, http.StatusConflict},
+	{"declare winner in emergency", http.MethodPost, "/api/experiment/winner?emergency=true", "admin", "application/json", `{"feature_id":409,"tag_id":1,"banner_id":2}`, http.StatusOK},
+	{"create webhook", http.MethodPost, "/api/webhook", "admin", "application/json", `{"url":"https://example.com/hook","event_types":["banner.updated"]}`, http.StatusCreated},
+	{"create webhook without url", http.MethodPost, "/api/webhook", "admin", "application/json", `{"url":""}`, http.StatusBadRequest},
+	{"get webhooks", http.MethodGet, "/api/webhook", "admin", "", "", http.StatusOK},
+	{"delete webhook", http.MethodDelete, "/api/webhook/1", "admin", "", "", http.StatusNoContent},
+	{"delete missing webhook", http.MethodDelete, "/api/webhook/404", "admin", "", "", http.StatusNotFound},
+	{"webhook deliveries", http.MethodGet, "/api/webhook_delivery/1?status=delivered", "admin", "", "", http.StatusOK},
+	{"openapi", http.MethodGet, "/api/openapi.json", "", "", "", http.StatusOK},
+	{"docs", http.MethodGet, "/api/docs", "", "", "", http.StatusOK},
+}
+
+// Ответы настоящих обработчиков должны соответствовать документу OpenAPI
+func TestResponsesMatchDocument(t *testing.T) {
+
+	// Выгрузка и загрузка в JSON Lines и страница Swagger UI проверяются как строка
+	openapi3filter.RegisterBodyDecoder("application/x-ndjson", openapi3filter.FileBodyDecoder)
+	openapi3filter.RegisterBodyDecoder("text/html", openapi3filter.FileBodyDecoder)
+
+	doc, err := openapi3.NewLoader().LoadFromData(openapi.Document())
+	if err != nil {
+		t.Fatalf("load document: %v", err)
+	}
+	doc.Servers = nil
+
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		t.Fatalf("create router: %v", err)
+	}
+
+	log, err := logger.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Config{
+		AdminSecretKey: "admin-secret",
+		UserSecretKey:  "user-secret",
+		AdminToken:     "AdminToken",
+		UserToken:      "UserToken",
+	}
+
+	svc, err := service.New(log, cfg, stubRepository{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := route.New(svc, middlewares.New(cfg, ratelimit.NewMemory(), log))
+
+	tokens := map[string]string{"bad": "bad.token.value"}
+	for _, role := range []string{models.RoleAdmin, models.RoleUser} {
+		issued, err := token.NewIssuer(cfg).Issue(models.TokenRequest{Role: role, Subject: role})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[role] = issued.Token
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+
+			request := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+			if tc.contentType != "" {
+				request.Header.Set("Content-Type", tc.contentType)
+			}
+			if tc.token != "" {
+				request.Header.Set("Token", tokens[tc.token])
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != tc.status {
+				t.Fatalf("status = %d, want %d, body: %s", recorder.Code, tc.status, recorder.Body.String())
+			}
+
+			validate(t, router, request, tc.body, recorder)
+		})
+	}
+}
+
+// Проверка запроса и ответа по документу
+func validate(t *testing.T, router routers.Router, request *http.Request, body string, recorder *httptest.ResponseRecorder) {
+	t.Helper()
+
+	// Тело запроса уже прочитано обработчиком
+	request.Body = io.NopCloser(strings.NewReader(body))
+
+	operation, pathParams, err := router.FindRoute(request)
+	if err != nil {
+		t.Fatalf("operation is not documented: %v", err)
+	}
+
+	requestInput := &openapi3filter.RequestValidationInput{
+		Request:    request,
+		PathParams: pathParams,
+		Route:      operation,
+		Options: &openapi3filter.Options{
+			AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+			MultiError:         true,
+		},
+	}
+
+	// Некорректные запросы проверяем только по ответу
+	if recorder.Code < http.StatusBadRequest {
+		if err = openapi3filter.ValidateRequest(context.Background(), requestInput); err != nil {
+			t.Errorf("request does not match document: %v", err)
+		}
+	}
+
+	err = openapi3filter.ValidateResponse(context.Background(), &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: requestInput,
+		Status:                 recorder.Code,
+		Header:                 recorder.Header(),
+		Body:                   io.NopCloser(bytes.NewReader(recorder.Body.Bytes())),
+		Options: &openapi3filter.Options{
+			IncludeResponseStatus: true,
+			MultiError:            true,
+		},
+	})
+	if err != nil {
+		var multi openapi3.MultiError
+		if errors.As(err, &multi) {
+			for _, e := range multi {
+				t.Errorf("response does not match document: %v", e)
+			}
+			return
+		}
+		t.Errorf("response does not match document: %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package rpc
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/config"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/logger"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/pkg/bannerpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Metadata key of token, the same as header in REST
+const tokenKey = "token"
+
+// Methods available for user token, others require admin token
+var userMethods = map[string]bool{
+	bannerpb.BannerService_GetUserBanner_FullMethodName: true,
+}
+
+// Authenticator checks JWT of gRPC calls, secret keys can be swapped on config reload
+type Authenticator struct {
+	secrets atomic.Pointer[secrets]
+	log     *logger.Logger
+}
+
+type secrets struct {
+	adminSecretKey string // Admin secret key
+	userSecretKey  string // User secret key
+}
+
+func NewAuthenticator(cfg config.Config, log *logger.Logger) *Authenticator {
+
+	auth := &Authenticator{log: log}
+	auth.SetSecrets(cfg.AdminSecretKey, cfg.UserSecretKey)
+
+	return auth
+}
+
+// SetSecrets swaps secret keys, calls in flight finish with keys they started with
+func (a *Authenticator) SetSecrets(adminSecretKey, userSecretKey string) {
+	a.secrets.Store(&secrets{adminSecretKey: adminSecretKey, userSecretKey: userSecretKey})
+}
+
+func (a *Authenticator) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	claims, err := a.authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(token.WithClaims(ctx, claims), req)
+}
+
+func (a *Authenticator) authorize(ctx context.Context, method string) (*token.Claims, error) {
+
+	var tokenString string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(tokenKey); len(values) > 0 {
+			tokenString = values[0]
+		}
+	}
+
+	if len(tokenString) == 0 {
+		a.log.Log.Error("token is empty")
+		return nil, status.Error(codes.Unauthenticated, "token is empty")
+	}
+
+	secrets := a.secrets.Load()
+
+	// Admin token gives access to all methods
+	claims, ok, err := token.Parse(tokenString, secrets.adminSecretKey)
+	if err != nil {
+		a.log.Log.Error("token validation is failed: ", err)
+		return nil, status.Error(codes.PermissionDenied, "token validation is failed")
+	}
+
+	if !ok && userMethods[method] {
+		claims, ok, err = token.Parse(tokenString, secrets.userSecretKey)
+		if err != nil {
+			a.log.Log.Error("token validation is failed: ", err)
+			return nil, status.Error(codes.PermissionDenied, "token validation is failed")
+		}
+	}
+
+	if !ok {
+		a.log.Log.Info("token validation is failed")
+		return nil, status.Error(codes.Unauthenticated, "token validation is failed")
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,263 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/config"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/logger"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/repository"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/pkg/bannerpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Implementation check
+var _ bannerpb.BannerServiceServer = (*Server)(nil)
+
+// gRPC API of banners on top of the same repository as REST service
+type Server struct {
+	bannerpb.UnimplementedBannerServiceServer
+	log        *logger.Logger
+	repository repository.Repositorer
+}
+
+// Create gRPC server with registered banner service and JWT interceptor
+func New(log *logger.Logger, cfg config.Config, repository repository.Repositorer) *grpc.Server {
+	return NewWithAuthenticator(log, NewAuthenticator(cfg, log), repository)
+}
+
+// Create gRPC server with passed authenticator, e.g. to swap its secret keys on config reload
+func NewWithAuthenticator(log *logger.Logger, auth *Authenticator, repository repository.Repositorer) *grpc.Server {
+
+	server := grpc.NewServer(grpc.UnaryInterceptor(auth.unary))
+	bannerpb.RegisterBannerServiceServer(server, &Server{
+		log:        log,
+		repository: repository,
+	})
+
+	return server
+}
+
+func (s *Server) GetUserBanner(ctx context.Context, req *bannerpb.GetUserBannerRequest) (*bannerpb.GetUserBannerResponse, error) {
+
+	featureID, tagID := int(req.GetFeatureId()), int(req.GetTagId())
+
+	if featureID == 0 || tagID == 0 {
+		return nil, status.Error(codes.InvalidArgument, "feature_id and tag_id are required")
+	}
+
+	var (
+		banners []models.UserBanner
+		found   bool
+		err     error
+	)
+
+	// Получим баннер из кэша, если не нужна последняя версия
+	if !req.GetUseLastRevision() {
+		banners, found, err = s.repository.GetBannerFromCache(ctx, featureID, []int{tagID})
+		if err != nil {
+			// Кэш недоступен, идем в БД
+			s.log.Log.Error("geting banner from cache is failed: ", err)
+		}
+	}
+
+	if !found {
+		banners, err = s.repository.GetBanner(ctx, featureID, []int{tagID})
+		if err != nil {
+			s.log.Log.Error("geting banner is failed: ", err)
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	// Если для пары идет эксперимент, выбираем вариант пользователя
+	var subject string
+	if claims, ok := token.FromContext(ctx); ok {
+		subject = claims.Subject
+	}
+
+	// Баннер мог быть выбран по умолчанию для фичи, показ учитывается по выбранной паре
+	if len(banners) > 0 {
+		tagID = int(banners[0].TagID)
+	}
+	banner := s.repository.ChooseVariant(banners, subject, featureID, tagID)
+
+	if banner.BannerID == 0 {
+		return nil, status.Error(codes.NotFound, "banner not found")
+	}
+
+	s.repository.TrackImpression(int(banner.BannerID), featureID, tagID)
+
+	// Локали в запросе gRPC нет, отдается основное содержимое
+	response := &bannerpb.GetUserBannerResponse{Content: toContent(banner.Content)}
+	if len(banners) > 1 {
+		response.VariantId = banner.BannerID
+	}
+
+	return response, nil
+}
+
+func (s *Server) CreateBanner(ctx context.Context, req *bannerpb.CreateBannerRequest) (*bannerpb.CreateBannerResponse, error) {
+
+	bannerID, err := s.repository.CreateBanner(ctx, models.BannerBody{
+		TagID:     req.GetTagId(),
+		FeatureID: req.GetFeatureId(),
+		Content:   fromContent(req.GetContent()),
+		Active:    req.GetIsActive(),
+	})
+	if err != nil {
+		s.log.Log.Error("creating banner is failed: ", err)
+		if errors.Is(err, models.ErrUnknownFeature) || errors.Is(err, models.ErrUnknownTag) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &bannerpb.CreateBannerResponse{BannerId: uint32(bannerID)}, nil
+}
+
+func (s *Server) UpdateBanner(ctx context.Context, req *bannerpb.UpdateBannerRequest) (*bannerpb.UpdateBannerResponse, error) {
+
+	// Приоритета и признака баннера по умолчанию нет в запросе, они сохраняют текущие значения
+	bannerBody, ok, err := s.repository.GetBannerBody(ctx, int(req.GetBannerId()))
+	if err != nil {
+		s.log.Log.Error("getting banner is failed: ", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if !ok {
+		return nil, status.Error(codes.NotFound, "banner not found")
+	}
+
+	bannerBody.TagID = req.GetTagId()
+	bannerBody.FeatureID = req.GetFeatureId()
+	bannerBody.Content = fromContent(req.GetContent())
+	bannerBody.Active = req.GetIsActive()
+
+	ok, err = s.repository.UpdateBanner(ctx, bannerBody, int(req.GetBannerId()), false)
+	if err != nil {
+		s.log.Log.Error("updating banner is failed: ", err)
+		if errors.Is(err, repository.ErrApprovalRequired) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if !ok {
+		return nil, status.Error(codes.NotFound, "banner not found")
+	}
+
+	return &bannerpb.UpdateBannerResponse{}, nil
+}
+
+func (s *Server) ListBanners(ctx context.Context, req *bannerpb.ListBannersRequest) (*bannerpb.ListBannersResponse, error) {
+
+	banners, err := s.repository.GetBanners(ctx, models.Query{
+		FeatureID: int(req.GetFeatureId()),
+		TagID:     int(req.GetTagId()),
+		Limit:     int(req.GetLimit()),
+		Offset:    int(req.GetOffset()),
+	})
+	if err != nil {
+		s.log.Log.Error("getting banners is failed: ", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response := &bannerpb.ListBannersResponse{Banners: make([]*bannerpb.Banner, 0, len(banners))}
+	for _, banner := range banners {
+		response.Banners = append(response.Banners, &bannerpb.Banner{
+			BannerId:  banner.BannerID,
+			TagIds:    banner.TagID,
+			FeatureId: banner.FeatureID,
+			Content:   toContent(banner.Content),
+			IsActive:  banner.Active,
+		})
+	}
+
+	return response, nil
+}
+
+func (s *Server) DeleteBanner(ctx context.Context, req *bannerpb.DeleteBannerRequest) (*bannerpb.DeleteBannerResponse, error) {
+
+	if err := s.repository.DeleteBanner(ctx, int(req.GetBannerId())); err != nil {
+		s.log.Log.Error("deleting banner is failed: ", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &bannerpb.DeleteBannerResponse{}, nil
+}
+
+func (s *Server) GetHistory(ctx context.Context, req *bannerpb.GetHistoryRequest) (*bannerpb.GetHistoryResponse, error) {
+
+	history, err := s.repository.GetHistoryBanner(ctx, int(req.GetBannerId()))
+	if err != nil {
+		s.log.Log.Error("getting history banner is failed: ", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response := &bannerpb.GetHistoryResponse{Versions: make([]*bannerpb.BannerVersion, 0, len(history))}
+	for _, version := range history {
+		response.Versions = append(response.Versions, toVersion(version))
+	}
+
+	return response, nil
+}
+
+// Активация версии из истории, в отличие от REST содержимое версии берется из истории баннера
+func (s *Server) ActivateVersion(ctx context.Context, req *bannerpb.ActivateVersionRequest) (*bannerpb.ActivateVersionResponse, error) {
+
+	history, err := s.repository.GetHistoryBanner(ctx, int(req.GetBannerId()))
+	if err != nil {
+		s.log.Log.Error("getting history banner is failed: ", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	for _, version := range history {
+		if version.Version != int(req.GetVersion()) {
+			continue
+		}
+
+		if err = s.repository.UpdateVersion(ctx, version, false); err != nil {
+			s.log.Log.Error("updating version is failed: ", err)
+			if errors.Is(err, repository.ErrApprovalRequired) {
+				return nil, status.Error(codes.FailedPrecondition, err.Error())
+			}
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		return &bannerpb.ActivateVersionResponse{}, nil
+	}
+
+	return nil, status.Error(codes.NotFound, "version not found")
+}
+
+func toContent(content models.BannerContent) *bannerpb.BannerContent {
+	return &bannerpb.BannerContent{
+		Title: content.Title,
+		Text:  content.Text,
+		Url:   content.Url,
+	}
+}
+
+func fromContent(content *bannerpb.BannerContent) models.BannerContent {
+	return models.BannerContent{
+		Title: content.GetTitle(),
+		Text:  content.GetText(),
+		Url:   content.GetUrl(),
+	}
+}
+
+func toVersion(version models.BannerHistory) *bannerpb.BannerVersion {
+	return &bannerpb.BannerVersion{
+		BannerId: version.BannerID,
+		Version:  int32(version.Version),
+		Content: &bannerpb.BannerContent{
+			Title: version.Title,
+			Text:  version.Text,
+			Url:   version.Url,
+		},
+	}
+}
@@ -0,0 +1,256 @@
+package rpc_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/config"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/logger"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/repository"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/rpc"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/storage/cache"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/storage/database"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/pkg/bannerpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+var content = &bannerpb.BannerContent{Title: "first", Text: "text", Url: "https://example.com/1"}
+
+// gRPC сервер поверх in-memory хранилищ, вызовы идут через bufconn и настоящий interceptor
+type testServer struct {
+	client bannerpb.BannerServiceClient
+	repo   repository.Repositorer
+	tokens map[string]string
+}
+
+func newTestServer(t *testing.T, options ...func(cfg *config.Config)) *testServer {
+	t.Helper()
+
+	log, err := logger.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Config{
+		AdminSecretKey: "admin-secret",
+		UserSecretKey:  "user-secret",
+		AdminToken:     "AdminToken",
+		UserToken:      "UserToken",
+	}
+	for _, option := range options {
+		option(&cfg)
+	}
+
+	repo := repository.NewWithStorage(database.NewMemory(), cache.NewMemory(time.Minute), cfg)
+	for id := uint32(1); id <= 2; id++ {
+		for _, kind := range []string{models.RegistryFeature, models.RegistryTag} {
+			entry := models.RegistryEntry{ID: id, Name: fmt.Sprintf("%s %d", kind, id)}
+			if _, err = repo.CreateRegistryEntry(context.Background(), kind, entry); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = repo.CreateRegistryEntry(token.WithClaims(context.Background(), &token.Claims{Tenant: "shop"}), kind, entry); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	listener := bufconn.Listen(1 << 20)
+	server := rpc.New(log, cfg, repo)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	s := &testServer{
+		client: bannerpb.NewBannerServiceClient(conn),
+		repo:   repo,
+		tokens: map[string]string{"bad": "bad.token.value"},
+	}
+
+	issuer := token.NewIssuer(cfg)
+	for name, request := range map[string]models.TokenRequest{
+		"admin":      {Role: models.RoleAdmin, Subject: "admin"},
+		"user":       {Role: models.RoleUser, Subject: "user"},
+		"shop-admin": {Role: models.RoleAdmin, Subject: "shop-admin", Tenant: "shop"},
+		"shop-user":  {Role: models.RoleUser, Subject: "shop-user", Tenant: "shop"},
+	} {
+		issued, err := issuer.Issue(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.tokens[name] = issued.Token
+	}
+
+	// Токен с ролью admin, подписанный чужим ключом
+	forged, err := token.NewIssuer(config.Config{AdminSecretKey: "other-secret"}).Issue(models.TokenRequest{Role: models.RoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.tokens["forged"] = forged.Token
+
+	return s
+}
+
+// Контекст вызова с токеном роли, без роли - без токена
+func (s *testServer) ctx(role string) context.Context {
+	if role == "" {
+		return context.Background()
+	}
+	return metadata.AppendToOutgoingContext(context.Background(), "token", s.tokens[role])
+}
+
+func expectCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+
+	if got := status.Code(err); got != code {
+		t.Fatalf("code = %v, want %v, err: %v", got, code, err)
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	s := newTestServer(t)
+
+	create := &bannerpb.CreateBannerRequest{TagId: 1, FeatureId: 1, Content: content, IsActive: true}
+	get := &bannerpb.GetUserBannerRequest{TagId: 1, FeatureId: 1, UseLastRevision: true}
+
+	tests := []struct {
+		name string
+		call func(ctx context.Context) error
+		role string
+		code codes.Code
+	}{
+		{"create without token", func(ctx context.Context) error { _, err := s.client.CreateBanner(ctx, create); return err }, "", codes.Unauthenticated},
+		{"create with invalid token", func(ctx context.Context) error { _, err := s.client.CreateBanner(ctx, create); return err }, "bad", codes.Unauthenticated},
+		{"create with forged token", func(ctx context.Context) error { _, err := s.client.CreateBanner(ctx, create); return err }, "forged", codes.Unauthenticated},
+		{"create with user token", func(ctx context.Context) error { _, err := s.client.CreateBanner(ctx, create); return err }, "user", codes.Unauthenticated},
+		{"create with admin token", func(ctx context.Context) error { _, err := s.client.CreateBanner(ctx, create); return err }, "admin", codes.OK},
+		{"user banner without token", func(ctx context.Context) error { _, err := s.client.GetUserBanner(ctx, get); return err }, "", codes.Unauthenticated},
+		{"user banner with invalid token", func(ctx context.Context) error { _, err := s.client.GetUserBanner(ctx, get); return err }, "bad", codes.Unauthenticated},
+		{"user banner with user token", func(ctx context.Context) error { _, err := s.client.GetUserBanner(ctx, get); return err }, "user", codes.OK},
+		{"user banner with admin token", func(ctx context.Context) error { _, err := s.client.GetUserBanner(ctx, get); return err }, "admin", codes.OK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectCode(t, tt.call(s.ctx(tt.role)), tt.code)
+		})
+	}
+}
+
+// Проект берется из токена: баннеры одного проекта не видны другому
+func TestTenantFromClaims(t *testing.T) {
+	s := newTestServer(t)
+
+	shop := &bannerpb.BannerContent{Title: "shop"}
+	if _, err := s.client.CreateBanner(s.ctx("shop-admin"), &bannerpb.CreateBannerRequest{TagId: 1, FeatureId: 1, Content: shop, IsActive: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	get := &bannerpb.GetUserBannerRequest{TagId: 1, FeatureId: 1, UseLastRevision: true}
+	response, err := s.client.GetUserBanner(s.ctx("shop-user"), get)
+	if err != nil || response.GetContent().GetTitle() != "shop" {
+		t.Fatalf("shop banner = %v, %v", response, err)
+	}
+
+	_, err = s.client.GetUserBanner(s.ctx("user"), get)
+	expectCode(t, err, codes.NotFound)
+
+	// Баннер другого проекта не обновляется
+	_, err = s.client.UpdateBanner(s.ctx("admin"), &bannerpb.UpdateBannerRequest{BannerId: 1, TagId: 1, FeatureId: 1, Content: content, IsActive: true})
+	expectCode(t, err, codes.NotFound)
+}
+
+// Полей priority и is_feature_default нет в запросе gRPC, обновление их не сбрасывает
+func TestUpdateKeepsPriority(t *testing.T) {
+	s := newTestServer(t)
+
+	ctx := context.Background()
+	bannerID, err := s.repo.CreateBanner(ctx, models.BannerBody{TagID: 1, FeatureID: 1, Content: models.BannerContent{Title: "first"}, Active: true, Priority: 5, FeatureDefault: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.client.UpdateBanner(s.ctx("admin"), &bannerpb.UpdateBannerRequest{BannerId: uint32(bannerID), TagId: 1, FeatureId: 1, Content: content, IsActive: false})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	banner, ok, err := s.repo.GetBannerByID(ctx, bannerID)
+	if err != nil || !ok {
+		t.Fatalf("banner: %v, %v", ok, err)
+	}
+	if banner.Priority != 5 || !banner.FeatureDefault || banner.Active || banner.Content.Title != content.Title {
+		t.Fatalf("banner = %+v", banner)
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) { cfg.ApprovalRequired = true })
+
+	ctx := s.ctx("admin")
+	created, err := s.client.CreateBanner(ctx, &bannerpb.CreateBannerRequest{TagId: 1, FeatureId: 1, Content: content, IsActive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bannerID := created.GetBannerId()
+
+	changed := &bannerpb.BannerContent{Title: "second", Text: "text", Url: "https://example.com/1"}
+
+	tests := []struct {
+		name string
+		call func() error
+		code codes.Code
+	}{
+		{"unknown feature", func() error {
+			_, err := s.client.CreateBanner(ctx, &bannerpb.CreateBannerRequest{TagId: 1, FeatureId: 9, Content: content})
+			return err
+		}, codes.InvalidArgument},
+		{"unknown tag", func() error {
+			_, err := s.client.CreateBanner(ctx, &bannerpb.CreateBannerRequest{TagId: 9, FeatureId: 2, Content: content})
+			return err
+		}, codes.InvalidArgument},
+		{"missing banner", func() error {
+			_, err := s.client.UpdateBanner(ctx, &bannerpb.UpdateBannerRequest{BannerId: 404, TagId: 1, FeatureId: 1, Content: content})
+			return err
+		}, codes.NotFound},
+		{"content without approval", func() error {
+			_, err := s.client.UpdateBanner(ctx, &bannerpb.UpdateBannerRequest{BannerId: bannerID, TagId: 1, FeatureId: 1, Content: changed, IsActive: true})
+			return err
+		}, codes.FailedPrecondition},
+		{"fields without content change", func() error {
+			_, err := s.client.UpdateBanner(ctx, &bannerpb.UpdateBannerRequest{BannerId: bannerID, TagId: 1, FeatureId: 1, Content: content})
+			return err
+		}, codes.OK},
+		{"missing version", func() error {
+			_, err := s.client.ActivateVersion(ctx, &bannerpb.ActivateVersionRequest{BannerId: bannerID, Version: 9})
+			return err
+		}, codes.NotFound},
+		{"user banner without pair", func() error {
+			_, err := s.client.GetUserBanner(ctx, &bannerpb.GetUserBannerRequest{FeatureId: 1})
+			return err
+		}, codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectCode(t, tt.call(), tt.code)
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+)
+
+// Разница версий баннера from и to, без to - разница версии с действующим содержимым,
+// то есть то, что изменит откат на эту версию
+func (s *Service) DiffBanner(writer http.ResponseWriter, request *http.Request) {
+
+	var response models.Response
+
+	ctx := request.Context()
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	path := request.URL.Path
+	parts := strings.Split(path, "/")
+	bannerID, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil {
+		s.log.Log.Error("reading banner id from request is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	from, to, err := s.repository.GetDiffQuery(request.URL.Query())
+	if err != nil {
+		s.log.Log.Error("reading diff versions from request is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	diff, ok, err := s.repository.DiffBanner(ctx, bannerID, from, to)
+	if err != nil {
+		s.log.Log.Error("diffing banner versions is failed: ", err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Баннер или версия не найдены
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Если все ОК
+	writer.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(writer).Encode(diff); err != nil {
+		s.log.Log.Error("searilizing diff is failed: ", err)
+	}
+
+}
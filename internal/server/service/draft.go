@@ -0,0 +1,258 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/repository"
+)
+
+// Код ответа на отказ изменить содержимое в обход черновиков, false если ошибка другая
+func approvalStatus(err error) (int, bool) {
+	switch {
+	case errors.Is(err, repository.ErrApprovalRequired):
+		return http.StatusConflict, true
+	case errors.Is(err, repository.ErrEmergencyScope):
+		return http.StatusForbidden, true
+	default:
+		return 0, false
+	}
+}
+
+// Код ответа на ошибку обновления баннера
+func updateStatus(err error) int {
+	if code, ok := approvalStatus(err); ok {
+		return code
+	}
+	return http.StatusBadRequest
+}
+
+// Код ответа на ошибку действия с черновиком
+func draftStatus(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrBadDraftAction), errors.Is(err, repository.ErrEmptyComment),
+		errors.Is(err, repository.ErrDraftContent):
+		return http.StatusBadRequest
+	case errors.Is(err, models.ErrNotDraftAuthor), errors.Is(err, models.ErrSelfReview):
+		return http.StatusForbidden
+	case errors.Is(err, models.ErrDraftStatus):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Создание черновика изменения содержимого баннера
+func (s *Service) CreateDraft(writer http.ResponseWriter, request *http.Request) {
+
+	var (
+		response     models.Response
+		draftRequest models.DraftRequest
+	)
+
+	ctx := request.Context()
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	// Читаем тело запроса
+	body, err := io.ReadAll(request.Body)
+	if err != nil {
+		s.log.Log.Error("error read body request: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Десериализуем JSON
+	if err = json.Unmarshal(body, &draftRequest); err != nil {
+		s.log.Log.Error("unmarshal json is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	draft, ok, err := s.repository.CreateDraft(ctx, draftRequest)
+	if err != nil {
+		s.log.Log.Error("creating draft is failed: ", err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Баннер не найден
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	s.notifyReview(draft)
+
+	// Если все ОК
+	writer.WriteHeader(http.StatusCreated)
+	if err = json.NewEncoder(writer).Encode(draft); err != nil {
+		s.log.Log.Error("searilizing draft is failed: ", err)
+	}
+
+}
+
+// Черновики по баннеру и статусу, status=pending - очередь на проверку
+func (s *Service) GetDrafts(writer http.ResponseWriter, request *http.Request) {
+
+	var response models.Response
+
+	ctx := request.Context()
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	draftQuery, err := s.repository.GetDraftQuery(request.URL.Query())
+	if err != nil {
+		s.log.Log.Error("reading draft query is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	drafts, err := s.repository.GetDrafts(ctx, draftQuery)
+	if err != nil {
+		s.log.Log.Error("getting drafts is failed: ", err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Если все ОК
+	writer.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(writer).Encode(drafts); err != nil {
+		s.log.Log.Error("searilizing drafts is failed: ", err)
+	}
+
+}
+
+// Черновик с комментариями
+func (s *Service) GetDraft(writer http.ResponseWriter, request *http.Request) {
+
+	var response models.Response
+
+	ctx := request.Context()
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	path := request.URL.Path
+	parts := strings.Split(path, "/")
+	draftID, err := strconv.ParseInt(parts[len(parts)-1], 10, 64)
+	if err != nil {
+		s.log.Log.Error("reading draft id from request is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	draft, ok, err := s.repository.GetDraft(ctx, draftID)
+	if err != nil {
+		s.log.Log.Error("getting draft is failed: ", err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Черновик не найден
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Если все ОК
+	writer.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(writer).Encode(draft); err != nil {
+		s.log.Log.Error("searilizing draft is failed: ", err)
+	}
+
+}
+
+// Действие с черновиком: отправка на проверку, одобрение, отклонение или комментарий
+func (s *Service) UpdateDraft(writer http.ResponseWriter, request *http.Request) {
+
+	var (
+		response    models.Response
+		draftAction models.DraftAction
+	)
+
+	ctx := request.Context()
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	path := request.URL.Path
+	parts := strings.Split(path, "/")
+	draftID, err := strconv.ParseInt(parts[len(parts)-1], 10, 64)
+	if err != nil {
+		s.log.Log.Error("reading draft id from request is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Читаем тело запроса
+	body, err := io.ReadAll(request.Body)
+	if err != nil {
+		s.log.Log.Error("error read body request: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Десериализуем JSON
+	if err = json.Unmarshal(body, &draftAction); err != nil {
+		s.log.Log.Error("unmarshal json is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	draft, ok, err := s.repository.UpdateDraft(ctx, draftID, draftAction)
+	if err != nil {
+		s.log.Log.Errorf("%s of draft is failed: %v", draftAction.Action, err)
+		writer.WriteHeader(draftStatus(err))
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Черновик не найден
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if draftAction.Action == models.DraftActionSubmit {
+		s.notifyReview(draft)
+	}
+
+	// Если все ОК
+	writer.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(writer).Encode(draft); err != nil {
+		s.log.Log.Error("searilizing draft is failed: ", err)
+	}
+
+}
+
+// Черновик, ожидающий проверки, попадает в лог, подписчики получают событие banner.review_requested
+func (s *Service) notifyReview(draft models.Draft) {
+	if draft.Status == models.DraftStatusPending {
+		s.log.Log.Infof("draft %d of banner %d by %s awaits review", draft.DraftID, draft.BannerID, draft.Author)
+	}
+}
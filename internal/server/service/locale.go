@@ -0,0 +1,190 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/repository"
+)
+
+// Локали баннера с последними версиями
+func (s *Service) GetBannerLocales(writer http.ResponseWriter, request *http.Request) {
+
+	var response models.Response
+
+	ctx := request.Context()
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	path := request.URL.Path
+	parts := strings.Split(path, "/")
+	bannerID, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil {
+		s.log.Log.Error("reading banner id from request is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	locales, ok, err := s.repository.GetBannerLocales(ctx, bannerID)
+	if err != nil {
+		s.log.Log.Error("getting banner locales is failed: ", err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Баннер не найден
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Если все ОК
+	writer.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(writer).Encode(locales); err != nil {
+		s.log.Log.Error("searilizing banner locales is failed: ", err)
+	}
+
+}
+
+// Добавление или изменение локали баннера. Версия появляется только в истории этой локали
+func (s *Service) SetBannerLocale(writer http.ResponseWriter, request *http.Request) {
+
+	var (
+		response models.Response
+		locale   models.BannerLocale
+	)
+
+	ctx := request.Context()
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	path := request.URL.Path
+	parts := strings.Split(path, "/")
+	bannerID, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil {
+		s.log.Log.Error("reading banner id from request is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	emergency, err := s.repository.GetEmergency(request.URL.Query())
+	if err != nil {
+		s.log.Log.Error("reading emergency from request is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Читаем тело запроса
+	body, err := io.ReadAll(request.Body)
+	if err != nil {
+		s.log.Log.Error("error read body request: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Десериализуем JSON
+	if err = json.Unmarshal(body, &locale); err != nil {
+		s.log.Log.Error("unmarshal json is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	locale, ok, err := s.repository.SetBannerLocale(ctx, bannerID, locale, emergency)
+	if err != nil {
+		s.log.Log.Error("setting banner locale is failed: ", err)
+		if code, ok := approvalStatus(err); ok {
+			writer.WriteHeader(code)
+		} else if errors.Is(err, repository.ErrBadLocale) || errors.Is(err, repository.ErrDefaultLocale) {
+			writer.WriteHeader(http.StatusBadRequest)
+		} else {
+			writer.WriteHeader(http.StatusInternalServerError)
+		}
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Баннер не найден
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Если все ОК
+	writer.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(writer).Encode(locale); err != nil {
+		s.log.Log.Error("searilizing banner locale is failed: ", err)
+	}
+
+}
+
+// Удаление локали баннера из параметра lang, история локали сохраняется
+func (s *Service) DeleteBannerLocale(writer http.ResponseWriter, request *http.Request) {
+
+	var response models.Response
+
+	ctx := request.Context()
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	path := request.URL.Path
+	parts := strings.Split(path, "/")
+	bannerID, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil {
+		s.log.Log.Error("reading banner id from request is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	emergency, err := s.repository.GetEmergency(request.URL.Query())
+	if err != nil {
+		s.log.Log.Error("reading emergency from request is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	ok, err := s.repository.DeleteBannerLocale(ctx, bannerID, request.URL.Query().Get("lang"), emergency)
+	if err != nil {
+		s.log.Log.Error("deleting banner locale is failed: ", err)
+		if code, ok := approvalStatus(err); ok {
+			writer.WriteHeader(code)
+		} else if errors.Is(err, repository.ErrBadLocale) {
+			writer.WriteHeader(http.StatusBadRequest)
+		} else {
+			writer.WriteHeader(http.StatusInternalServerError)
+		}
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Баннер или локаль не найдены
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Если все ОК
+	writer.WriteHeader(http.StatusNoContent)
+}
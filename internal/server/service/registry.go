@@ -0,0 +1,300 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/repository"
+)
+
+// Справочник фич
+func (s *Service) CreateFeature(writer http.ResponseWriter, request *http.Request) {
+	s.createEntry(models.RegistryFeature, writer, request)
+}
+
+func (s *Service) GetFeatures(writer http.ResponseWriter, request *http.Request) {
+	s.getEntries(models.RegistryFeature, writer, request)
+}
+
+func (s *Service) GetFeature(writer http.ResponseWriter, request *http.Request) {
+	s.getEntry(models.RegistryFeature, writer, request)
+}
+
+func (s *Service) UpdateFeature(writer http.ResponseWriter, request *http.Request) {
+	s.updateEntry(models.RegistryFeature, writer, request)
+}
+
+func (s *Service) DeleteFeature(writer http.ResponseWriter, request *http.Request) {
+	s.deleteEntry(models.RegistryFeature, writer, request)
+}
+
+// Справочник тэгов
+func (s *Service) CreateTag(writer http.ResponseWriter, request *http.Request) {
+	s.createEntry(models.RegistryTag, writer, request)
+}
+
+func (s *Service) GetTags(writer http.ResponseWriter, request *http.Request) {
+	s.getEntries(models.RegistryTag, writer, request)
+}
+
+func (s *Service) GetTag(writer http.ResponseWriter, request *http.Request) {
+	s.getEntry(models.RegistryTag, writer, request)
+}
+
+func (s *Service) UpdateTag(writer http.ResponseWriter, request *http.Request) {
+	s.updateEntry(models.RegistryTag, writer, request)
+}
+
+func (s *Service) DeleteTag(writer http.ResponseWriter, request *http.Request) {
+	s.deleteEntry(models.RegistryTag, writer, request)
+}
+
+// Код ответа на ошибку справочника
+func registryStatus(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrEmptyName):
+		return http.StatusBadRequest
+	case errors.Is(err, models.ErrEntryExists), errors.Is(err, models.ErrNameTaken), errors.Is(err, models.ErrEntryInUse):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Регистрация фичи или тэга
+func (s *Service) createEntry(kind string, writer http.ResponseWriter, request *http.Request) {
+
+	var (
+		response models.Response
+		entry    models.RegistryEntry
+	)
+
+	ctx := request.Context()
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	// Читаем тело запроса
+	body, err := io.ReadAll(request.Body)
+	if err != nil {
+		s.log.Log.Error("error read body request: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Десериализуем JSON
+	if err = json.Unmarshal(body, &entry); err != nil {
+		s.log.Log.Error("unmarshal json is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	entry, err = s.repository.CreateRegistryEntry(ctx, kind, entry)
+	if err != nil {
+		s.log.Log.Errorf("creating %s is failed: %v", kind, err)
+		writer.WriteHeader(registryStatus(err))
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Если все ОК
+	writer.WriteHeader(http.StatusCreated)
+	if err = json.NewEncoder(writer).Encode(entry); err != nil {
+		s.log.Log.Errorf("searilizing %s is failed: %v", kind, err)
+	}
+
+}
+
+// Все фичи или тэги, параметр name оставляет запись с этим именем
+func (s *Service) getEntries(kind string, writer http.ResponseWriter, request *http.Request) {
+
+	var response models.Response
+
+	ctx := request.Context()
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	entries, err := s.repository.GetRegistryEntries(ctx, kind, request.URL.Query().Get("name"))
+	if err != nil {
+		s.log.Log.Errorf("getting %s registry is failed: %v", kind, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Если все ОК
+	writer.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(writer).Encode(entries); err != nil {
+		s.log.Log.Errorf("searilizing %s registry is failed: %v", kind, err)
+	}
+
+}
+
+func (s *Service) getEntry(kind string, writer http.ResponseWriter, request *http.Request) {
+
+	var response models.Response
+
+	ctx := request.Context()
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	id, err := entryID(request)
+	if err != nil {
+		s.log.Log.Errorf("reading %s id from request is failed: %v", kind, err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	entry, ok, err := s.repository.GetRegistryEntry(ctx, kind, id)
+	if err != nil {
+		s.log.Log.Errorf("getting %s is failed: %v", kind, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Запись не найдена
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Если все ОК
+	writer.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(writer).Encode(entry); err != nil {
+		s.log.Log.Errorf("searilizing %s is failed: %v", kind, err)
+	}
+
+}
+
+// Изменение имени, описания и владельца
+func (s *Service) updateEntry(kind string, writer http.ResponseWriter, request *http.Request) {
+
+	var (
+		response models.Response
+		entry    models.RegistryEntry
+	)
+
+	ctx := request.Context()
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	id, err := entryID(request)
+	if err != nil {
+		s.log.Log.Errorf("reading %s id from request is failed: %v", kind, err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Читаем тело запроса
+	body, err := io.ReadAll(request.Body)
+	if err != nil {
+		s.log.Log.Error("error read body request: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Десериализуем JSON
+	if err = json.Unmarshal(body, &entry); err != nil {
+		s.log.Log.Error("unmarshal json is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+	entry.ID = uint32(id)
+
+	ok, err := s.repository.UpdateRegistryEntry(ctx, kind, entry)
+	if err == nil && ok {
+		entry, ok, err = s.repository.GetRegistryEntry(ctx, kind, id)
+	}
+	if err != nil {
+		s.log.Log.Errorf("updating %s is failed: %v", kind, err)
+		writer.WriteHeader(registryStatus(err))
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Запись не найдена
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Если все ОК
+	writer.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(writer).Encode(entry); err != nil {
+		s.log.Log.Errorf("searilizing %s is failed: %v", kind, err)
+	}
+
+}
+
+// Удаление из справочника, пока на запись ссылаются баннеры, только с force=true
+func (s *Service) deleteEntry(kind string, writer http.ResponseWriter, request *http.Request) {
+
+	var response models.Response
+
+	ctx := request.Context()
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	id, err := entryID(request)
+	if err != nil {
+		s.log.Log.Errorf("reading %s id from request is failed: %v", kind, err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	force, err := s.repository.GetForce(request.URL.Query())
+	if err != nil {
+		s.log.Log.Error("reading force from request is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	ok, err := s.repository.DeleteRegistryEntry(ctx, kind, id, force)
+	if err != nil {
+		s.log.Log.Errorf("deleting %s is failed: %v", kind, err)
+		writer.WriteHeader(registryStatus(err))
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Запись не найдена
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Если все ОК
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// ID из последнего сегмента пути, как и у баннеров
+func entryID(request *http.Request) (int, error) {
+	parts := strings.Split(request.URL.Path, "/")
+	return strconv.Atoi(parts[len(parts)-1])
+}
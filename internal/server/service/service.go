@@ -12,6 +12,7 @@ import (
 	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/logger"
 	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
 	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/repository"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
 	"github.com/go-chi/chi"
 )
 
@@ -23,29 +24,63 @@ type Servicer interface {
 	CreateBanner(writer http.ResponseWriter, request *http.Request)
 	UpdateBanner(writer http.ResponseWriter, request *http.Request)
 	GetBanners(writer http.ResponseWriter, request *http.Request)
+	GetBannerByID(writer http.ResponseWriter, request *http.Request)
 	DeleteBanner(writer http.ResponseWriter, request *http.Request)
 	GetHistoryBanner(writer http.ResponseWriter, request *http.Request)
+	DiffBanner(writer http.ResponseWriter, request *http.Request)
 	UpdateVersion(writer http.ResponseWriter, request *http.Request)
+	IssueToken(writer http.ResponseWriter, request *http.Request)
+	GetAudit(writer http.ResponseWriter, request *http.Request)
+	GetDeletedBanners(writer http.ResponseWriter, request *http.Request)
+	RestoreBanner(writer http.ResponseWriter, request *http.Request)
+	ExportBanners(writer http.ResponseWriter, request *http.Request)
+	ImportBanners(writer http.ResponseWriter, request *http.Request)
+	ClickBanner(writer http.ResponseWriter, request *http.Request)
+	GetStats(writer http.ResponseWriter, request *http.Request)
+	CreateExperiment(writer http.ResponseWriter, request *http.Request)
+	GetExperiment(writer http.ResponseWriter, request *http.Request)
+	DeclareWinner(writer http.ResponseWriter, request *http.Request)
+	CreateWebhook(writer http.ResponseWriter, request *http.Request)
+	GetWebhooks(writer http.ResponseWriter, request *http.Request)
+	DeleteWebhook(writer http.ResponseWriter, request *http.Request)
+	GetDeliveries(writer http.ResponseWriter, request *http.Request)
+	CreateFeature(writer http.ResponseWriter, request *http.Request)
+	GetFeatures(writer http.ResponseWriter, request *http.Request)
+	GetFeature(writer http.ResponseWriter, request *http.Request)
+	UpdateFeature(writer http.ResponseWriter, request *http.Request)
+	DeleteFeature(writer http.ResponseWriter, request *http.Request)
+	CreateTag(writer http.ResponseWriter, request *http.Request)
+	GetTags(writer http.ResponseWriter, request *http.Request)
+	GetTag(writer http.ResponseWriter, request *http.Request)
+	UpdateTag(writer http.ResponseWriter, request *http.Request)
+	DeleteTag(writer http.ResponseWriter, request *http.Request)
+	GetBannerLocales(writer http.ResponseWriter, request *http.Request)
+	SetBannerLocale(writer http.ResponseWriter, request *http.Request)
+	DeleteBannerLocale(writer http.ResponseWriter, request *http.Request)
+	CreateDraft(writer http.ResponseWriter, request *http.Request)
+	GetDrafts(writer http.ResponseWriter, request *http.Request)
+	GetDraft(writer http.ResponseWriter, request *http.Request)
+	UpdateDraft(writer http.ResponseWriter, request *http.Request)
 }
 
 type Service struct {
 	log        *logger.Logger
 	repository repository.Repositorer
+	issuer     *token.Issuer
 	Route      *chi.Mux
 }
 
-func New(log *logger.Logger, cfg config.Config) (Servicer, error) {
-
-	// Create a new repository
-	repository, err := repository.New(cfg)
-	if err != nil {
-		return &Service{}, err
-	}
+func New(log *logger.Logger, cfg config.Config, repository repository.Repositorer) (Servicer, error) {
+	return NewWithIssuer(log, repository, token.NewIssuer(cfg)), nil
+}
 
+// Create service over injected dependencies, e.g. in-memory repository in tests
+func NewWithIssuer(log *logger.Logger, repository repository.Repositorer, issuer *token.Issuer) Servicer {
 	return &Service{
 		log:        log,
 		repository: repository,
-	}, nil
+		issuer:     issuer,
+	}
 }
 
 func (s *Service) GetUserBanner(writer http.ResponseWriter, request *http.Request) {
@@ -68,44 +103,84 @@ func (s *Service) GetUserBanner(writer http.ResponseWriter, request *http.Reques
 		return
 	}
 
+	// Локали ответа в порядке предпочтения
+	locales, err := s.repository.GetLocales(request.URL.Query(), request.Header.Get("Accept-Language"))
+	if err != nil {
+		s.log.Log.Error("reading locale from request is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	var (
+		banners []models.UserBanner
+		found   bool
+	)
+
+	// Получим баннер из кэша, если не нужна последняя версия
+	cacheStatus := models.CacheBypass
+	if !queryParam.Last {
+		banners, found, err = s.repository.GetBannerFromCache(ctx, queryParam.FeatureID, queryParam.TagIDs)
+		if err != nil {
+			// Кэш недоступен, идем в БД
+			s.log.Log.Error("geting banner from cache is failed: ", err)
+		}
+		cacheStatus = models.CacheMiss
+		if found {
+			cacheStatus = models.CacheHit
+		}
+	}
+	writer.Header().Set("X-Cache", cacheStatus)
+
 	// Получим баннер из БД
-	if queryParam.Last {
-		banner, err := s.repository.GetBanner(ctx, queryParam.FeatureID, queryParam.TagID)
+	if !found {
+		banners, err = s.repository.GetBanner(ctx, queryParam.FeatureID, queryParam.TagIDs)
 		if err != nil {
-			if banner == (models.BannerContent{}) {
-				s.log.Log.Error("banner not found")
-				writer.WriteHeader(http.StatusNotFound)
-				response.Err = errors.New("banner not found")
-				json.NewEncoder(writer).Encode(response)
-				return
-			}
 			s.log.Log.Error("geting banner is failed: ", err)
 			writer.WriteHeader(http.StatusInternalServerError)
 			response.Err = err
 			json.NewEncoder(writer).Encode(response)
 			return
 		}
+	}
 
-		// Если все ОК
-		writer.WriteHeader(http.StatusOK)
-		if err = json.NewEncoder(writer).Encode(banner); err != nil {
-			s.log.Log.Error("searilizing banners is failed: ", err)
-		}
-		return
+	// Если для выбранной пары идет эксперимент, выбираем вариант пользователя
+	var (
+		subject string
+		tagID   int
+	)
+	if claims, ok := token.FromContext(ctx); ok {
+		subject = claims.Subject
 	}
+	if len(banners) > 0 {
+		tagID = int(banners[0].TagID)
+	}
+	banner := s.repository.ChooseVariant(banners, subject, queryParam.FeatureID, tagID)
 
-	// Получим баннер из кэша
-	banner, err := s.repository.GetBannerFromCache(queryParam.FeatureID, queryParam.TagID)
-	if err != nil {
-		s.log.Log.Error("geting banner is failed: ", err)
-		writer.WriteHeader(http.StatusInternalServerError)
-		response.Err = err
+	if banner.BannerID == 0 {
+		s.log.Log.Error("banner not found")
+		writer.WriteHeader(http.StatusNotFound)
+		response.Err = errors.New("banner not found")
 		json.NewEncoder(writer).Encode(response)
 		return
 	}
 
+	// Учтем показ баннера по тэгу, пара которого выбрана
+	s.repository.TrackImpression(int(banner.BannerID), queryParam.FeatureID, tagID)
+
+	if len(banners) > 1 {
+		writer.Header().Set("X-Banner-Variant", strconv.Itoa(int(banner.BannerID)))
+	}
+	writer.Header().Set("X-Banner-Rule", banner.Rule)
+
+	// Содержимое на языке пользователя, ответ зависит от Accept-Language
+	content, locale := s.repository.Localize(banner, locales)
+	writer.Header().Set("Content-Language", locale)
+	writer.Header().Add("Vary", "Accept-Language")
+
 	writer.WriteHeader(http.StatusOK)
-	if err = json.NewEncoder(writer).Encode(banner); err != nil {
+	if err = json.NewEncoder(writer).Encode(content); err != nil {
 		s.log.Log.Error("searilizing banners is failed: ", err)
 	}
 
@@ -146,7 +221,11 @@ func (s *Service) CreateBanner(writer http.ResponseWriter, request *http.Request
 	// Создаем баннер
 	if bannerID, err = s.repository.CreateBanner(ctx, bannerBody); err != nil {
 		s.log.Log.Error("creating banner is failed: ", err)
-		writer.WriteHeader(http.StatusInternalServerError)
+		if errors.Is(err, models.ErrUnknownFeature) || errors.Is(err, models.ErrUnknownTag) {
+			writer.WriteHeader(http.StatusBadRequest)
+		} else {
+			writer.WriteHeader(http.StatusInternalServerError)
+		}
 		response.Err = err
 		json.NewEncoder(writer).Encode(response)
 		return
@@ -184,6 +263,15 @@ func (s *Service) UpdateBanner(writer http.ResponseWriter, request *http.Request
 		return
 	}
 
+	emergency, err := s.repository.GetEmergency(request.URL.Query())
+	if err != nil {
+		s.log.Log.Error("reading emergency from request is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
 	// Читаем тело запроса
 	body, err := io.ReadAll(request.Body)
 	if err != nil {
@@ -194,6 +282,21 @@ func (s *Service) UpdateBanner(writer http.ResponseWriter, request *http.Request
 		return
 	}
 
+	// Поля, которых нет в запросе, сохраняют текущие значения
+	if bannerBody, ok, err = s.repository.GetBannerBody(ctx, bannerID); err != nil {
+		s.log.Log.Error("getting banner is failed: ", err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Баннер не найден
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
 	// Десериализуем JSON
 	if err = json.Unmarshal(body, &bannerBody); err != nil {
 		s.log.Log.Error("unmarshal json is failed: ", err)
@@ -203,10 +306,10 @@ func (s *Service) UpdateBanner(writer http.ResponseWriter, request *http.Request
 		return
 	}
 
-	// Обновляем баннер
-	if ok, err = s.repository.UpdateBanner(ctx, bannerBody, bannerID); err != nil {
+	// Обновляем баннер, emergency=true меняет содержимое в обход проверки черновиков
+	if ok, err = s.repository.UpdateBanner(ctx, bannerBody, bannerID, emergency); err != nil {
 		s.log.Log.Error("updating banner is failed: ", err)
-		writer.WriteHeader(http.StatusBadRequest)
+		writer.WriteHeader(updateStatus(err))
 		response.Err = err
 		json.NewEncoder(writer).Encode(response)
 		return
@@ -253,6 +356,49 @@ func (s *Service) GetBanners(writer http.ResponseWriter, request *http.Request)
 
 }
 
+// Баннер со всеми тэгами
+func (s *Service) GetBannerByID(writer http.ResponseWriter, request *http.Request) {
+
+	var response models.Response
+
+	ctx := request.Context()
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	path := request.URL.Path
+	parts := strings.Split(path, "/")
+	bannerID, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil {
+		s.log.Log.Error("reading banner id from request is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	banner, ok, err := s.repository.GetBannerByID(ctx, bannerID)
+	if err != nil {
+		s.log.Log.Error("getting banner is failed: ", err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Баннер не найден
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Если все ОК
+	writer.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(writer).Encode(banner); err != nil {
+		s.log.Log.Error("searilizing banner is failed: ", err)
+	}
+
+}
+
 // Удаление баннера
 func (s *Service) DeleteBanner(writer http.ResponseWriter, request *http.Request) {
 
@@ -286,7 +432,7 @@ func (s *Service) DeleteBanner(writer http.ResponseWriter, request *http.Request
 	writer.WriteHeader(http.StatusNoContent)
 }
 
-// Просмотр всей истории баннера
+// Просмотр всей истории баннера, с параметром lang - истории одной локали
 func (s *Service) GetHistoryBanner(writer http.ResponseWriter, request *http.Request) {
 
 	var (
@@ -309,8 +455,22 @@ func (s *Service) GetHistoryBanner(writer http.ResponseWriter, request *http.Req
 		return
 	}
 
-	// вернем всю историю баннера
-	if bannerHistory, err = s.repository.GetHistoryBanner(ctx, bannerID); err != nil {
+	locale, err := s.repository.GetLocale(request.URL.Query())
+	if err != nil {
+		s.log.Log.Error("reading locale from request is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// вернем всю историю баннера или его локали
+	if locale != "" {
+		bannerHistory, err = s.repository.GetLocaleHistory(ctx, bannerID, locale)
+	} else {
+		bannerHistory, err = s.repository.GetHistoryBanner(ctx, bannerID)
+	}
+	if err != nil {
 		s.log.Log.Error("getting history banner is failed: ", err)
 		writer.WriteHeader(http.StatusInternalServerError)
 		response.Err = err
@@ -338,6 +498,15 @@ func (s *Service) UpdateVersion(writer http.ResponseWriter, request *http.Reques
 
 	writer.Header().Set("Content-Type", "application/json")
 
+	emergency, err := s.repository.GetEmergency(request.URL.Query())
+	if err != nil {
+		s.log.Log.Error("reading emergency from request is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
 	// Читаем тело запроса
 	body, err := io.ReadAll(request.Body)
 	if err != nil {
@@ -358,12 +527,692 @@ func (s *Service) UpdateVersion(writer http.ResponseWriter, request *http.Reques
 	}
 
 	// Обновляем версию баннера
-	if err = s.repository.UpdateVersion(ctx, bannerVersion); err != nil {
+	if err = s.repository.UpdateVersion(ctx, bannerVersion, emergency); err != nil {
 		s.log.Log.Error("updating version is failed: ", err)
+		if code, ok := approvalStatus(err); ok {
+			writer.WriteHeader(code)
+		} else {
+			writer.WriteHeader(http.StatusInternalServerError)
+		}
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+}
+
+// Выпуск подписанного токена для роли
+func (s *Service) IssueToken(writer http.ResponseWriter, request *http.Request) {
+
+	var (
+		response      models.Response
+		tokenRequest  models.TokenRequest
+		tokenResponse models.TokenResponse
+	)
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	// Читаем тело запроса
+	body, err := io.ReadAll(request.Body)
+	if err != nil {
+		s.log.Log.Error("error read body request: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Десериализуем JSON
+	if err = json.Unmarshal(body, &tokenRequest); err != nil {
+		s.log.Log.Error("unmarshal json is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Администратор выпускает токены только своего проекта
+	tenant := token.Tenant(request.Context())
+	if tokenRequest.Tenant == "" {
+		tokenRequest.Tenant = tenant
+	}
+	if tokenRequest.Tenant != tenant {
+		s.log.Log.Errorf("issuing token of tenant %s by admin of %s", tokenRequest.Tenant, tenant)
+		writer.WriteHeader(http.StatusForbidden)
+		response.Err = token.ErrForeignTenant
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Токен не шире токена администратора: только его scopes, admin токен - только на его subject
+	if tokenRequest, err = token.Delegate(request.Context(), tokenRequest); err != nil {
+		s.log.Log.Error("issuing token is refused: ", err)
+		writer.WriteHeader(http.StatusForbidden)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Выпускаем токен
+	if tokenResponse, err = s.issuer.Issue(tokenRequest); err != nil {
+		s.log.Log.Error("issuing token is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Если все ОК
+	writer.WriteHeader(http.StatusCreated)
+	if err = json.NewEncoder(writer).Encode(tokenResponse); err != nil {
+		s.log.Log.Error("searilizing token is failed: ", err)
+	}
+
+}
+
+// Просмотр журнала аудита по баннеру, автору или интервалу времени
+func (s *Service) GetAudit(writer http.ResponseWriter, request *http.Request) {
+
+	var response models.Response
+
+	ctx := request.Context()
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	// Получим параметры запроса
+	auditQuery, err := s.repository.GetAuditQuery(request.URL.Query())
+	if err != nil {
+		s.log.Log.Error("reading audit query is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	records, err := s.repository.GetAudit(ctx, auditQuery)
+	if err != nil {
+		s.log.Log.Error("getting audit is failed: ", err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Если все ОК
+	writer.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(writer).Encode(records); err != nil {
+		s.log.Log.Error("searilizing audit is failed: ", err)
+	}
+
+}
+
+// Просмотр удаленных баннеров, которые еще можно восстановить
+func (s *Service) GetDeletedBanners(writer http.ResponseWriter, request *http.Request) {
+
+	var response models.Response
+
+	ctx := request.Context()
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	banners, err := s.repository.GetDeletedBanners(ctx)
+	if err != nil {
+		s.log.Log.Error("getting deleted banners is failed: ", err)
 		writer.WriteHeader(http.StatusInternalServerError)
 		response.Err = err
 		json.NewEncoder(writer).Encode(response)
 		return
 	}
 
+	// Если все ОК
+	writer.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(writer).Encode(banners); err != nil {
+		s.log.Log.Error("searilizing banners is failed: ", err)
+	}
+
+}
+
+// Восстановление удаленного баннера
+func (s *Service) RestoreBanner(writer http.ResponseWriter, request *http.Request) {
+
+	var response models.Response
+
+	ctx := request.Context()
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	path := request.URL.Path
+	parts := strings.Split(path, "/")
+	bannerID, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil {
+		s.log.Log.Error("reading banner id from request is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	ok, err := s.repository.RestoreBanner(ctx, bannerID)
+	if err != nil {
+		s.log.Log.Error("restoring banner is failed: ", err)
+		// Пара фича + тэг уже занята другим баннером
+		if errors.Is(err, models.ErrPairTaken) {
+			writer.WriteHeader(http.StatusConflict)
+		} else {
+			writer.WriteHeader(http.StatusInternalServerError)
+		}
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Баннер не найден или окно восстановления истекло
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Если все ОК
+	writer.WriteHeader(http.StatusOK)
+	response.BannerID = bannerID
+	json.NewEncoder(writer).Encode(response)
+
+}
+
+// Потоковая выгрузка всех баннеров в JSON Lines или CSV
+func (s *Service) ExportBanners(writer http.ResponseWriter, request *http.Request) {
+
+	var response models.Response
+
+	ctx := request.Context()
+
+	// Получим параметры запроса
+	format, withHistory, err := s.repository.GetExportQuery(request.URL.Query())
+	if err != nil {
+		s.log.Log.Error("reading export query is failed: ", err)
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	if format == models.FormatCSV {
+		writer.Header().Set("Content-Type", "text/csv")
+	} else {
+		writer.Header().Set("Content-Type", "application/x-ndjson")
+	}
+
+	// Ответ уже начат, поэтому ошибку выгрузки можно только залогировать
+	writer.WriteHeader(http.StatusOK)
+	if err = s.repository.ExportBanners(ctx, writer, format, withHistory); err != nil {
+		s.log.Log.Error("exporting banners is failed: ", err)
+	}
+
+}
+
+// Загрузка баннеров из JSON Lines или CSV
+func (s *Service) ImportBanners(writer http.ResponseWriter, request *http.Request) {
+
+	var response models.Response
+
+	ctx := request.Context()
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	// Получим параметры запроса
+	options, err := s.repository.GetImportOptions(request.URL.Query())
+	if err != nil {
+		s.log.Log.Error("reading import options is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Загрузка читается в память целиком, размер тела ограничиваем
+	body := http.MaxBytesReader(writer, request.Body, models.MaxImportBytes)
+
+	report, err := s.repository.ImportBanners(ctx, body, options)
+	if err != nil {
+		s.log.Log.Error("importing banners is failed: ", err)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writer.WriteHeader(http.StatusRequestEntityTooLarge)
+		} else {
+			writer.WriteHeader(updateStatus(err))
+		}
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// В режиме atomic загрузка с ошибками не применяется
+	if report.Failed > 0 && options.Mode == models.ImportAtomic && !options.DryRun {
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+	} else {
+		writer.WriteHeader(http.StatusOK)
+	}
+
+	if err = json.NewEncoder(writer).Encode(report); err != nil {
+		s.log.Log.Error("searilizing import report is failed: ", err)
+	}
+
+}
+
+// Учет клика по баннеру и переход по его ссылке
+func (s *Service) ClickBanner(writer http.ResponseWriter, request *http.Request) {
+
+	var response models.Response
+
+	ctx := request.Context()
+
+	path := request.URL.Path
+	parts := strings.Split(path, "/")
+	bannerID, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil {
+		s.log.Log.Error("reading banner id from request is failed: ", err)
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Фича и тэг необязательны, по ним детализируется статистика
+	queryParam := s.repository.GetQueryParam(request.URL.Query())
+
+	url, err := s.repository.TrackClick(ctx, bannerID, queryParam.FeatureID, queryParam.TagID)
+	if err != nil {
+		s.log.Log.Error("tracking click is failed: ", err)
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Баннер не найден или не активен
+	if url == "" {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(writer, request, url, http.StatusFound)
+}
+
+// Показы, клики и CTR баннеров за интервал времени
+func (s *Service) GetStats(writer http.ResponseWriter, request *http.Request) {
+
+	var response models.Response
+
+	ctx := request.Context()
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	// Получим параметры запроса
+	statsQuery, err := s.repository.GetStatsQuery(request.URL.Query())
+	if err != nil {
+		s.log.Log.Error("reading stats query is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	stats, err := s.repository.GetStats(ctx, statsQuery)
+	if err != nil {
+		s.log.Log.Error("getting stats is failed: ", err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Если все ОК
+	writer.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(writer).Encode(stats); err != nil {
+		s.log.Log.Error("searilizing stats is failed: ", err)
+	}
+
+}
+
+// Создание эксперимента с вариантами баннера для пары фича + тэг
+func (s *Service) CreateExperiment(writer http.ResponseWriter, request *http.Request) {
+
+	var (
+		response          models.Response
+		experimentRequest models.ExperimentRequest
+	)
+
+	ctx := request.Context()
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	emergency, err := s.repository.GetEmergency(request.URL.Query())
+	if err != nil {
+		s.log.Log.Error("reading emergency from request is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Читаем тело запроса
+	body, err := io.ReadAll(request.Body)
+	if err != nil {
+		s.log.Log.Error("error read body request: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Десериализуем JSON
+	if err = json.Unmarshal(body, &experimentRequest); err != nil {
+		s.log.Log.Error("unmarshal json is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	experiment, ok, err := s.repository.CreateExperiment(ctx, experimentRequest, emergency)
+	if err != nil {
+		s.log.Log.Error("creating experiment is failed: ", err)
+		switch {
+		case errors.Is(err, models.ErrExperimentExists), errors.Is(err, repository.ErrApprovalRequired):
+			writer.WriteHeader(http.StatusConflict)
+		case errors.Is(err, repository.ErrEmergencyScope):
+			writer.WriteHeader(http.StatusForbidden)
+		case errors.Is(err, repository.ErrBadExperiment):
+			writer.WriteHeader(http.StatusBadRequest)
+		default:
+			writer.WriteHeader(http.StatusInternalServerError)
+		}
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// У пары нет баннера
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Если все ОК
+	writer.WriteHeader(http.StatusCreated)
+	if err = json.NewEncoder(writer).Encode(experiment); err != nil {
+		s.log.Log.Error("searilizing experiment is failed: ", err)
+	}
+
+}
+
+// Просмотр эксперимента с показами и кликами вариантов
+func (s *Service) GetExperiment(writer http.ResponseWriter, request *http.Request) {
+
+	var response models.Response
+
+	ctx := request.Context()
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	// Получим параметры запроса
+	queryParam := s.repository.GetQueryParam(request.URL.Query())
+
+	// Необходимо проверить, что переданные данные в запросе не пустые
+	if ok := s.repository.CheckQuery(queryParam); !ok {
+		s.log.Log.Error("error read body request")
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = errors.New("error read body request")
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	experiment, ok, err := s.repository.GetExperiment(ctx, queryParam.FeatureID, queryParam.TagID)
+	if err != nil {
+		s.log.Log.Error("getting experiment is failed: ", err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Эксперимент не найден
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Если все ОК
+	writer.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(writer).Encode(experiment); err != nil {
+		s.log.Log.Error("searilizing experiment is failed: ", err)
+	}
+
+}
+
+// Выбор победителя эксперимента, пара снова обслуживается одним баннером
+func (s *Service) DeclareWinner(writer http.ResponseWriter, request *http.Request) {
+
+	var (
+		response models.Response
+		winner   models.WinnerRequest
+	)
+
+	ctx := request.Context()
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	emergency, err := s.repository.GetEmergency(request.URL.Query())
+	if err != nil {
+		s.log.Log.Error("reading emergency from request is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Читаем тело запроса
+	body, err := io.ReadAll(request.Body)
+	if err != nil {
+		s.log.Log.Error("error read body request: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Десериализуем JSON
+	if err = json.Unmarshal(body, &winner); err != nil {
+		s.log.Log.Error("unmarshal json is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	ok, err := s.repository.DeclareWinner(ctx, winner, emergency)
+	if err != nil {
+		s.log.Log.Error("declaring winner is failed: ", err)
+		if code, ok := approvalStatus(err); ok {
+			writer.WriteHeader(code)
+		} else if errors.Is(err, models.ErrNotVariant) {
+			writer.WriteHeader(http.StatusBadRequest)
+		} else {
+			writer.WriteHeader(http.StatusInternalServerError)
+		}
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Эксперимент не найден
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Если все ОК
+	writer.WriteHeader(http.StatusOK)
+	response.BannerID = int(winner.BannerID)
+	json.NewEncoder(writer).Encode(response)
+
+}
+
+// Подписка на события баннеров
+func (s *Service) CreateWebhook(writer http.ResponseWriter, request *http.Request) {
+
+	var (
+		response     models.Response
+		subscription models.WebhookSubscription
+	)
+
+	ctx := request.Context()
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	// Читаем тело запроса
+	body, err := io.ReadAll(request.Body)
+	if err != nil {
+		s.log.Log.Error("error read body request: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Десериализуем JSON
+	if err = json.Unmarshal(body, &subscription); err != nil {
+		s.log.Log.Error("unmarshal json is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	subscription, err = s.repository.CreateWebhook(ctx, subscription)
+	if err != nil {
+		s.log.Log.Error("creating webhook is failed: ", err)
+		if errors.Is(err, repository.ErrBadWebhookURL) || errors.Is(err, repository.ErrBadWebhookEvent) {
+			writer.WriteHeader(http.StatusBadRequest)
+		} else {
+			writer.WriteHeader(http.StatusInternalServerError)
+		}
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Если все ОК, секрет возвращается только при создании
+	writer.WriteHeader(http.StatusCreated)
+	if err = json.NewEncoder(writer).Encode(subscription); err != nil {
+		s.log.Log.Error("searilizing webhook is failed: ", err)
+	}
+
+}
+
+// Просмотр подписок на события
+func (s *Service) GetWebhooks(writer http.ResponseWriter, request *http.Request) {
+
+	var response models.Response
+
+	ctx := request.Context()
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	subscriptions, err := s.repository.GetWebhooks(ctx)
+	if err != nil {
+		s.log.Log.Error("getting webhooks is failed: ", err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Если все ОК
+	writer.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(writer).Encode(subscriptions); err != nil {
+		s.log.Log.Error("searilizing webhooks is failed: ", err)
+	}
+
+}
+
+// Удаление подписки
+func (s *Service) DeleteWebhook(writer http.ResponseWriter, request *http.Request) {
+
+	var response models.Response
+
+	ctx := request.Context()
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	path := request.URL.Path
+	parts := strings.Split(path, "/")
+	subscriptionID, err := strconv.ParseInt(parts[len(parts)-1], 10, 64)
+	if err != nil {
+		s.log.Log.Error("reading webhook id from request is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	ok, err := s.repository.DeleteWebhook(ctx, subscriptionID)
+	if err != nil {
+		s.log.Log.Error("deleting webhook is failed: ", err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Подписка не найдена
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Если все ОК
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// Журнал доставок подписки
+func (s *Service) GetDeliveries(writer http.ResponseWriter, request *http.Request) {
+
+	var response models.Response
+
+	ctx := request.Context()
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	path := request.URL.Path
+	parts := strings.Split(path, "/")
+	subscriptionID, err := strconv.ParseInt(parts[len(parts)-1], 10, 64)
+	if err != nil {
+		s.log.Log.Error("reading webhook id from request is failed: ", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	deliveryQuery := s.repository.GetDeliveryQuery(subscriptionID, request.URL.Query())
+
+	deliveries, err := s.repository.GetDeliveries(ctx, deliveryQuery)
+	if err != nil {
+		s.log.Log.Error("getting webhook deliveries is failed: ", err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		response.Err = err
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+
+	// Если все ОК
+	writer.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(writer).Encode(deliveries); err != nil {
+		s.log.Log.Error("searilizing webhook deliveries is failed: ", err)
+	}
+
 }
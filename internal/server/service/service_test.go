@@ -0,0 +1,1079 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/config"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/logger"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/middlewares"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/ratelimit"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/repository"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/route"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/server/service"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/storage/cache"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/storage/database"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+)
+
+const banner1 = `{"tag_id":1,"feature_id":1,"content":{"title":"first","text":"text","url":"https://example.com/1"},"is_active":true}`
+
+// Сервис поверх in-memory хранилищ, запросы идут через настоящий роутер и middlewares
+type testServer struct {
+	t          *testing.T
+	handler    http.Handler
+	repository repository.Repositorer
+	tokens     map[string]string
+	cfg        config.Config
+}
+
+// Настройки конфигурации можно поменять до создания сервиса
+func newTestServer(t *testing.T, options ...func(cfg *config.Config)) *testServer {
+	t.Helper()
+
+	log, err := logger.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Config{
+		AdminSecretKey:  "admin-secret",
+		UserSecretKey:   "user-secret",
+		AdminToken:      "AdminToken",
+		UserToken:       "UserToken",
+		DeleteRetention: time.Hour,
+		OutboxBatch:     100,
+		WebhookBatch:    100,
+		DefaultLocale:   "ru",
+		LocaleFallback:  "en",
+	}
+	for _, option := range options {
+		option(&cfg)
+	}
+
+	repo := repository.NewWithStorage(database.NewMemory(), cache.NewMemory(time.Minute), cfg)
+	issuer := token.NewIssuer(cfg)
+	svc := service.NewWithIssuer(log, repo, issuer)
+
+	s := &testServer{
+		t:          t,
+		handler:    route.New(svc, middlewares.New(cfg, ratelimit.NewMemory(), log)),
+		repository: repo,
+		tokens:     map[string]string{"bad": "bad.token.value"},
+		cfg:        cfg,
+	}
+
+	// Фичи и тэги, на которые ссылаются баннеры тестов
+	for id := uint32(1); id <= 3; id++ {
+		for _, kind := range []string{models.RegistryFeature, models.RegistryTag} {
+			entry := models.RegistryEntry{ID: id, Name: fmt.Sprintf("%s %d", kind, id)}
+			if _, err := repo.CreateRegistryEntry(context.Background(), kind, entry); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for _, role := range []string{models.RoleAdmin, models.RoleUser} {
+		issued, err := issuer.Issue(models.TokenRequest{Role: role, Subject: role})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.tokens[role] = issued.Token
+	}
+
+	return s
+}
+
+// Запрос от имени роли: admin, user, bad или без токена
+func (s *testServer) do(method, target, role, body string) *httptest.ResponseRecorder {
+	s.t.Helper()
+
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if role != "" {
+		request.Header.Set("Token", s.tokens[role])
+	}
+
+	recorder := httptest.NewRecorder()
+	s.handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// Запрос с проверкой статуса ответа
+func (s *testServer) expect(method, target, role, body string, status int) *httptest.ResponseRecorder {
+	s.t.Helper()
+
+	recorder := s.do(method, target, role, body)
+	if recorder.Code != status {
+		s.t.Fatalf("%s %s: status = %d, want %d, body: %s", method, target, recorder.Code, status, recorder.Body.String())
+	}
+	return recorder
+}
+
+func decode[T any](t *testing.T, recorder *httptest.ResponseRecorder) T {
+	t.Helper()
+
+	var v T
+	if err := json.Unmarshal(recorder.Body.Bytes(), &v); err != nil {
+		t.Fatalf("decode %q: %v", recorder.Body.String(), err)
+	}
+	return v
+}
+
+func (s *testServer) createBanner(body string) int {
+	s.t.Helper()
+
+	var response struct {
+		BannerID int `json:"banner_id"`
+	}
+	recorder := s.expect(http.MethodPost, "/api/banner", "admin", body, http.StatusCreated)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		s.t.Fatal(err)
+	}
+	return response.BannerID
+}
+
+func TestAuthorization(t *testing.T) {
+	s := newTestServer(t)
+
+	s.expect(http.MethodGet, "/api/user_banner?feature_id=1&tag_id=1", "", "", http.StatusBadRequest)
+	s.expect(http.MethodGet, "/api/user_banner?feature_id=1&tag_id=1", "bad", "", http.StatusUnauthorized)
+	s.expect(http.MethodPost, "/api/banner", "user", banner1, http.StatusUnauthorized)
+	s.expect(http.MethodGet, "/api/banner", "user", "", http.StatusUnauthorized)
+
+	// Выпущенный токен пользователя открывает пользовательский эндпойнт
+	recorder := s.expect(http.MethodPost, "/api/token", "admin", `{"role":"user","subject":"42","ttl":"1h"}`, http.StatusCreated)
+	issued := decode[models.TokenResponse](t, recorder)
+	s.tokens["issued"] = issued.Token
+
+	s.createBanner(banner1)
+	s.expect(http.MethodGet, "/api/user_banner?feature_id=1&tag_id=1", "issued", "", http.StatusOK)
+
+	s.expect(http.MethodPost, "/api/token", "admin", `{"role":"root"}`, http.StatusBadRequest)
+}
+
+// Лимит по IP действует до проверки токена, лимит по subject после нее
+func TestRateLimit(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.IPRateLimit, cfg.IPRateBurst = 0.001, 4
+		cfg.UserRateLimit, cfg.UserRateBurst = 0.001, 1
+	})
+
+	target := "/api/user_banner?feature_id=1&tag_id=1"
+	s.expect(http.MethodGet, target, "user", "", http.StatusNotFound)
+	s.expect(http.MethodGet, target, "user", "", http.StatusTooManyRequests)
+	s.expect(http.MethodGet, target, "bad", "", http.StatusUnauthorized)
+	s.expect(http.MethodGet, target, "", "", http.StatusBadRequest)
+
+	// Запросы с неверным токеном и без него исчерпали лимит IP
+	recorder := s.expect(http.MethodGet, target, "bad", "", http.StatusTooManyRequests)
+	if recorder.Header().Get("Retry-After") == "" {
+		t.Fatal("Retry-After is empty")
+	}
+	s.expect(http.MethodGet, target, "", "", http.StatusTooManyRequests)
+	s.expect(http.MethodGet, "/api/banner", "admin", "", http.StatusTooManyRequests)
+
+	// Другой IP не затронут
+	request := httptest.NewRequest(http.MethodGet, target, nil)
+	request.RemoteAddr = "198.51.100.7:4321"
+	request.Header.Set("Token", s.tokens["bad"])
+	recorder = httptest.NewRecorder()
+	s.handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("other ip: status = %d", recorder.Code)
+	}
+}
+
+func TestTokenDelegation(t *testing.T) {
+	s := newTestServer(t)
+
+	issued, err := token.NewIssuer(s.cfg).Issue(models.TokenRequest{Role: models.RoleAdmin, Subject: "oncall", Scopes: []string{models.ScopeEmergency}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.tokens["oncall"] = issued.Token
+
+	// Администратор не выпускает токен от имени другого администратора и не выдает себе emergency
+	s.expect(http.MethodPost, "/api/token", "admin", `{"role":"admin","subject":"someone-else"}`, http.StatusForbidden)
+	s.expect(http.MethodPost, "/api/token", "admin", `{"role":"admin","scopes":["emergency"]}`, http.StatusForbidden)
+	s.expect(http.MethodPost, "/api/token", "admin", `{"role":"user","subject":"42","scopes":["emergency"]}`, http.StatusForbidden)
+
+	// Свой admin токен выпускается на subject администратора, свои scopes передаются
+	tests := []struct {
+		role, body, subject string
+		scopes              []string
+	}{
+		{"admin", `{"role":"admin","ttl":"1h"}`, "admin", nil},
+		{"oncall", `{"role":"admin","subject":"oncall","scopes":["emergency"]}`, "oncall", []string{models.ScopeEmergency}},
+		{"admin", `{"role":"user","subject":"42"}`, "42", nil},
+	}
+	for _, tt := range tests {
+		recorder := s.expect(http.MethodPost, "/api/token", tt.role, tt.body, http.StatusCreated)
+		secretKey := s.cfg.AdminSecretKey
+		if !strings.Contains(tt.body, `"role":"admin"`) {
+			secretKey = s.cfg.UserSecretKey
+		}
+		claims, ok, err := token.Parse(decode[models.TokenResponse](t, recorder).Token, secretKey)
+		if err != nil || !ok || claims.Subject != tt.subject || fmt.Sprint(claims.Scopes) != fmt.Sprint(tt.scopes) {
+			t.Fatalf("%s: claims = %+v, ok = %v, err = %v", tt.body, claims, ok, err)
+		}
+	}
+}
+
+func TestUserBanner(t *testing.T) {
+	s := newTestServer(t)
+
+	s.createBanner(banner1)
+
+	recorder := s.expect(http.MethodGet, "/api/user_banner?feature_id=1&tag_id=1", "user", "", http.StatusOK)
+	if content := decode[models.BannerContent](t, recorder); content.Title != "first" {
+		t.Fatalf("content = %+v", content)
+	}
+
+	s.expect(http.MethodGet, "/api/user_banner?feature_id=1", "user", "", http.StatusBadRequest)
+	s.expect(http.MethodGet, "/api/user_banner?feature_id=1&tag_id=2", "user", "", http.StatusNotFound)
+	s.expect(http.MethodGet, "/api/user_banner?feature_id=2&tag_id=1", "user", "", http.StatusNotFound)
+
+	// Выключенный баннер: из кэша он еще отдается до истечения ttl, последняя версия уже не найдена
+	s.expect(http.MethodPatch, "/api/banner/1", "admin",
+		`{"tag_id":1,"feature_id":1,"content":{"title":"first","text":"text","url":"https://example.com/1"},"is_active":false}`, http.StatusOK)
+	s.expect(http.MethodGet, "/api/user_banner?feature_id=1&tag_id=1", "user", "", http.StatusOK)
+	s.expect(http.MethodGet, "/api/user_banner?feature_id=1&tag_id=1&use_last_revision=true", "user", "", http.StatusNotFound)
+}
+
+func TestCreateBanner(t *testing.T) {
+	s := newTestServer(t)
+
+	if id := s.createBanner(banner1); id != 1 {
+		t.Fatalf("banner id = %d, want 1", id)
+	}
+
+	// Пара фича + тэг уникальна
+	s.expect(http.MethodPost, "/api/banner", "admin", banner1, http.StatusInternalServerError)
+
+	if id := s.createBanner(`{"tag_id":2,"feature_id":1,"content":{"title":"second"},"is_active":true}`); id != 3 {
+		t.Fatalf("banner id = %d, want 3", id)
+	}
+
+	s.expect(http.MethodPost, "/api/banner", "admin", `{"tag_id":`, http.StatusInternalServerError)
+}
+
+func TestGetBanners(t *testing.T) {
+	s := newTestServer(t)
+
+	s.createBanner(banner1)
+	s.createBanner(`{"tag_id":2,"feature_id":2,"content":{"title":"second"},"is_active":true}`)
+
+	recorder := s.expect(http.MethodGet, "/api/banner?feature_id=2", "admin", "", http.StatusOK)
+	banners := decode[[]models.ResponseBody](t, recorder)
+	if len(banners) != 1 || banners[0].BannerID != 2 {
+		t.Fatalf("banners = %+v", banners)
+	}
+
+	recorder = s.expect(http.MethodGet, "/api/banner?feature_id=2&tag_id=1", "admin", "", http.StatusOK)
+	if banners = decode[[]models.ResponseBody](t, recorder); len(banners) != 2 {
+		t.Fatalf("banners = %+v", banners)
+	}
+}
+
+func TestUpdateBannerAndVersions(t *testing.T) {
+	s := newTestServer(t)
+
+	s.createBanner(banner1)
+
+	updated := `{"tag_id":1,"feature_id":1,"content":{"title":"second","text":"text","url":"https://example.com/1"},"is_active":true}`
+	s.expect(http.MethodPatch, "/api/banner/1", "admin", updated, http.StatusOK)
+
+	// Содержимое не изменилось, новая версия не создается
+	s.expect(http.MethodPatch, "/api/banner/1", "admin", updated, http.StatusOK)
+
+	recorder := s.expect(http.MethodGet, "/api/history_banner/1", "admin", "", http.StatusOK)
+	history := decode[[]models.BannerHistory](t, recorder)
+	if len(history) != 2 || history[0].Title != "first" || history[1].Title != "second" {
+		t.Fatalf("history = %+v", history)
+	}
+
+	s.expect(http.MethodPatch, "/api/banner/2", "admin", updated, http.StatusNotFound)
+	s.expect(http.MethodPatch, "/api/banner/one", "admin", updated, http.StatusBadRequest)
+
+	// Фича баннера не меняется
+	s.expect(http.MethodPatch, "/api/banner/1", "admin", `{"tag_id":1,"feature_id":2}`, http.StatusBadRequest)
+
+	// Поля, которых нет в запросе, сохраняют прежние значения
+	s.expect(http.MethodPatch, "/api/banner/1", "admin", `{"is_active":false,"priority":7}`, http.StatusOK)
+	s.expect(http.MethodPatch, "/api/banner/1", "admin", `{"content":{"text":"text"}}`, http.StatusOK)
+
+	recorder = s.expect(http.MethodGet, "/api/banner/1", "admin", "", http.StatusOK)
+	banner := decode[models.ResponseBody](t, recorder)
+	if banner.Active || banner.Priority != 7 || banner.Content.Title != "second" || banner.FeatureID != 1 || len(banner.TagID) != 1 {
+		t.Fatalf("banner = %+v", banner)
+	}
+	if history = decode[[]models.BannerHistory](t, s.expect(http.MethodGet, "/api/history_banner/1", "admin", "", http.StatusOK)); len(history) != 2 {
+		t.Fatalf("history after partial update = %+v", history)
+	}
+	s.expect(http.MethodPatch, "/api/banner/1", "admin", `{"is_active":true}`, http.StatusOK)
+	s.expect(http.MethodGet, "/api/banner/2", "admin", "", http.StatusNotFound)
+
+	// Возврат к первой версии
+	version, _ := json.Marshal(history[0])
+	s.expect(http.MethodPost, "/api/version_banner", "admin", string(version), http.StatusOK)
+
+	recorder = s.expect(http.MethodGet, "/api/user_banner?feature_id=1&tag_id=1&use_last_revision=true", "user", "", http.StatusOK)
+	if content := decode[models.BannerContent](t, recorder); content.Title != "first" {
+		t.Fatalf("content = %+v", content)
+	}
+}
+
+func TestDeleteAndRestore(t *testing.T) {
+	s := newTestServer(t)
+
+	s.createBanner(banner1)
+
+	s.expect(http.MethodDelete, "/api/banner/1", "admin", "", http.StatusNoContent)
+	s.expect(http.MethodDelete, "/api/banner/one", "admin", "", http.StatusBadRequest)
+	s.expect(http.MethodGet, "/api/user_banner?feature_id=1&tag_id=1&use_last_revision=true", "user", "", http.StatusNotFound)
+	s.expect(http.MethodGet, "/api/banner/1", "admin", "", http.StatusNotFound)
+
+	recorder := s.expect(http.MethodGet, "/api/deleted_banner", "admin", "", http.StatusOK)
+	deleted := decode[[]models.DeletedBanner](t, recorder)
+	if len(deleted) != 1 || deleted[0].BannerID != 1 || len(deleted[0].TagID) != 1 {
+		t.Fatalf("deleted = %+v", deleted)
+	}
+
+	// Освобожденную пару занимает новый баннер, восстановить старый нельзя
+	s.createBanner(banner1)
+	s.expect(http.MethodPost, "/api/restore_banner/1", "admin", "", http.StatusConflict)
+
+	s.expect(http.MethodDelete, "/api/banner/2", "admin", "", http.StatusNoContent)
+	s.expect(http.MethodPost, "/api/restore_banner/1", "admin", "", http.StatusOK)
+	s.expect(http.MethodPost, "/api/restore_banner/1", "admin", "", http.StatusNotFound)
+	s.expect(http.MethodPost, "/api/restore_banner/404", "admin", "", http.StatusNotFound)
+
+	s.expect(http.MethodGet, "/api/user_banner?feature_id=1&tag_id=1&use_last_revision=true", "user", "", http.StatusOK)
+}
+
+func TestAudit(t *testing.T) {
+	s := newTestServer(t)
+
+	s.createBanner(banner1)
+	s.expect(http.MethodDelete, "/api/banner/1", "admin", "", http.StatusNoContent)
+
+	recorder := s.expect(http.MethodGet, "/api/audit?banner_id=1", "admin", "", http.StatusOK)
+	records := decode[[]models.AuditRecord](t, recorder)
+	if len(records) != 2 || records[0].Action != models.AuditCreate || records[1].Action != models.AuditDelete {
+		t.Fatalf("records = %+v", records)
+	}
+	if records[0].Actor != models.RoleAdmin {
+		t.Fatalf("actor = %q, want %q", records[0].Actor, models.RoleAdmin)
+	}
+
+	s.expect(http.MethodGet, "/api/audit?from=yesterday", "admin", "", http.StatusBadRequest)
+
+	// Изменения попадают в outbox
+	published := 0
+	if _, err := s.repository.RelayEvents(context.Background(), func(ctx context.Context, event models.Event) error {
+		published++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if published != 2 {
+		t.Fatalf("published = %d, want 2", published)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	s := newTestServer(t)
+
+	s.createBanner(banner1)
+	s.createBanner(`{"tag_id":2,"feature_id":2,"content":{"title":"second"},"is_active":true}`)
+
+	export := s.expect(http.MethodGet, "/api/banner/export?format=jsonl&history=true", "admin", "", http.StatusOK)
+	if lines := strings.Count(export.Body.String(), "\n"); lines != 2 {
+		t.Fatalf("exported %d lines, want 2", lines)
+	}
+	s.expect(http.MethodGet, "/api/banner/export?format=xml", "admin", "", http.StatusBadRequest)
+
+	// Загрузка в пустой сервис
+	target := newTestServer(t)
+	recorder := target.expect(http.MethodPost, "/api/banner/import?format=jsonl", "admin", export.Body.String(), http.StatusOK)
+	if report := decode[models.ImportReport](t, recorder); report.Created != 2 || !report.Committed {
+		t.Fatalf("report = %+v", report)
+	}
+
+	// Повторная загрузка пропускает занятые пары
+	recorder = target.expect(http.MethodPost, "/api/banner/import?format=jsonl", "admin", export.Body.String(), http.StatusOK)
+	if report := decode[models.ImportReport](t, recorder); report.Skipped != 2 {
+		t.Fatalf("report = %+v", report)
+	}
+
+	// Строка без тэгов откатывает атомарную загрузку целиком
+	csv := "banner_id,feature_id,tag_id,title,text,url,is_active\n" +
+		"0,3,3,third,,,true\n" +
+		"0,4,,fourth,,,true\n"
+	target.expect(http.MethodPost, "/api/banner/import?format=csv", "admin", csv, http.StatusUnprocessableEntity)
+	target.expect(http.MethodGet, "/api/user_banner?feature_id=3&tag_id=3&use_last_revision=true", "user", "", http.StatusNotFound)
+
+	recorder = target.expect(http.MethodPost, "/api/banner/import?format=csv&mode=best_effort", "admin", csv, http.StatusOK)
+	if report := decode[models.ImportReport](t, recorder); report.Created != 1 || report.Failed != 1 {
+		t.Fatalf("report = %+v", report)
+	}
+	target.expect(http.MethodGet, "/api/user_banner?feature_id=3&tag_id=3&use_last_revision=true", "user", "", http.StatusOK)
+
+	target.expect(http.MethodPost, "/api/banner/import?mode=all", "admin", "", http.StatusBadRequest)
+
+	// Ошибка чтения тела прерывает загрузку, а не превращается в строки с ошибкой
+	header := "banner_id,feature_id,tag_id,title,text,url,is_active\n"
+	request := httptest.NewRequest(http.MethodPost, "/api/banner/import?format=csv",
+		io.MultiReader(strings.NewReader(header), iotest.ErrReader(errors.New("connection reset"))))
+	request.Header.Set("Token", target.tokens["admin"])
+	recorder = httptest.NewRecorder()
+	target.handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("broken body: status = %d, body: %s", recorder.Code, recorder.Body.String())
+	}
+
+	// Размер загрузки ограничен
+	large := header + "0,5,5," + strings.Repeat("a", int(models.MaxImportBytes)) + ",,,true\n"
+	target.expect(http.MethodPost, "/api/banner/import?format=csv", "admin", large, http.StatusRequestEntityTooLarge)
+}
+
+// Приоритет и признак баннера по умолчанию переносятся в обоих форматах
+func TestExportImportKeepsPriority(t *testing.T) {
+	s := newTestServer(t)
+
+	s.createBanner(`{"tag_id":1,"feature_id":1,"content":{"title":"first"},"is_active":true,"priority":3,"is_feature_default":true}`)
+	s.createBanner(`{"tag_id":2,"feature_id":2,"content":{"title":"second"},"is_active":true,"priority":1}`)
+
+	for _, format := range []string{models.FormatJSONL, models.FormatCSV} {
+		export := s.expect(http.MethodGet, "/api/banner/export?format="+format, "admin", "", http.StatusOK)
+
+		target := newTestServer(t)
+		recorder := target.expect(http.MethodPost, "/api/banner/import?format="+format, "admin", export.Body.String(), http.StatusOK)
+		if report := decode[models.ImportReport](t, recorder); report.Created != 2 || !report.Committed {
+			t.Fatalf("%s: report = %+v", format, report)
+		}
+
+		for id, want := range map[int]struct {
+			priority  int
+			isDefault bool
+		}{1: {3, true}, 2: {1, false}} {
+			banner, ok, err := target.repository.GetBannerByID(context.Background(), id)
+			if err != nil || !ok {
+				t.Fatalf("%s: banner %d: %v, %v", format, id, ok, err)
+			}
+			if banner.Priority != want.priority || banner.FeatureDefault != want.isDefault {
+				t.Fatalf("%s: banner = %+v", format, banner)
+			}
+		}
+	}
+}
+
+func TestClickAndStats(t *testing.T) {
+	s := newTestServer(t)
+
+	s.createBanner(banner1)
+	s.expect(http.MethodGet, "/api/user_banner?feature_id=1&tag_id=1", "user", "", http.StatusOK)
+
+	recorder := s.expect(http.MethodGet, "/api/click/1?feature_id=1&tag_id=1", "", "", http.StatusFound)
+	if location := recorder.Header().Get("Location"); location != "https://example.com/1" {
+		t.Fatalf("location = %q", location)
+	}
+	s.expect(http.MethodGet, "/api/click/2", "", "", http.StatusNotFound)
+
+	if err := s.repository.FlushStats(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder = s.expect(http.MethodGet, "/api/banner_stats?banner_id=1", "admin", "", http.StatusOK)
+	stats := decode[[]models.BannerStats](t, recorder)
+	if len(stats) != 1 || stats[0].Impressions != 1 || stats[0].Clicks != 1 || stats[0].CTR != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestExperiment(t *testing.T) {
+	s := newTestServer(t)
+
+	s.createBanner(banner1)
+
+	experiment := `{"feature_id":1,"tag_id":1,"control_weight":50,"variants":[{"content":{"title":"variant"},"weight":50}]}`
+	s.expect(http.MethodPost, "/api/experiment", "admin", experiment, http.StatusCreated)
+	s.expect(http.MethodPost, "/api/experiment", "admin", experiment, http.StatusConflict)
+	s.expect(http.MethodPost, "/api/experiment", "admin", `{"feature_id":1,"tag_id":1,"variants":[]}`, http.StatusBadRequest)
+	s.expect(http.MethodPost, "/api/experiment", "admin",
+		`{"feature_id":2,"tag_id":2,"control_weight":50,"variants":[{"content":{"title":"variant"},"weight":50}]}`, http.StatusNotFound)
+
+	recorder := s.expect(http.MethodGet, "/api/experiment?feature_id=1&tag_id=1", "admin", "", http.StatusOK)
+	if got := decode[models.Experiment](t, recorder); len(got.Variants) != 2 || got.ControlID != 1 {
+		t.Fatalf("experiment = %+v", got)
+	}
+
+	// Пользователю показывается один из вариантов
+	recorder = s.expect(http.MethodGet, "/api/user_banner?feature_id=1&tag_id=1", "user", "", http.StatusOK)
+	if variant := recorder.Header().Get("X-Banner-Variant"); variant != "1" && variant != "2" {
+		t.Fatalf("variant = %q", variant)
+	}
+
+	s.expect(http.MethodPost, "/api/experiment/winner", "admin", `{"feature_id":1,"tag_id":1,"banner_id":3}`, http.StatusBadRequest)
+	s.expect(http.MethodPost, "/api/experiment/winner", "admin", `{"feature_id":1,"tag_id":1,"banner_id":2}`, http.StatusOK)
+	s.expect(http.MethodPost, "/api/experiment/winner", "admin", `{"feature_id":1,"tag_id":1,"banner_id":2}`, http.StatusNotFound)
+	s.expect(http.MethodGet, "/api/experiment?feature_id=1&tag_id=1", "admin", "", http.StatusNotFound)
+
+	// Содержимое победителя стало новой версией контрольного баннера
+	recorder = s.expect(http.MethodGet, "/api/user_banner?feature_id=1&tag_id=1", "user", "", http.StatusOK)
+	if content := decode[models.BannerContent](t, recorder); content.Title != "variant" {
+		t.Fatalf("content = %+v", content)
+	}
+	if variant := recorder.Header().Get("X-Banner-Variant"); variant != "" {
+		t.Fatalf("variant = %q after winner", variant)
+	}
+
+	// Удаление контрольного баннера завершает эксперимент, новый баннер пары показывается без вариантов
+	s.expect(http.MethodPost, "/api/experiment", "admin", experiment, http.StatusCreated)
+	s.expect(http.MethodDelete, "/api/banner/1", "admin", "", http.StatusNoContent)
+	s.expect(http.MethodGet, "/api/experiment?feature_id=1&tag_id=1", "admin", "", http.StatusNotFound)
+
+	s.createBanner(`{"tag_id":1,"feature_id":1,"content":{"title":"new"},"is_active":true}`)
+	recorder = s.expect(http.MethodGet, "/api/user_banner?feature_id=1&tag_id=1&use_last_revision=true", "user", "", http.StatusOK)
+	if content := decode[models.BannerContent](t, recorder); content.Title != "new" || recorder.Header().Get("X-Banner-Variant") != "" {
+		t.Fatalf("content = %+v, variant = %q", content, recorder.Header().Get("X-Banner-Variant"))
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	s := newTestServer(t)
+
+	recorder := s.expect(http.MethodPost, "/api/webhook", "admin", `{"url":"https://example.com/hook","event_types":["banner.created"]}`, http.StatusCreated)
+	subscription := decode[models.WebhookSubscription](t, recorder)
+	if subscription.ID != 1 || subscription.Secret == "" {
+		t.Fatalf("subscription = %+v", subscription)
+	}
+
+	s.expect(http.MethodPost, "/api/webhook", "admin", `{"url":"ftp://example.com"}`, http.StatusBadRequest)
+	s.expect(http.MethodPost, "/api/webhook", "admin", `{"url":"https://example.com","event_types":["banner.exploded"]}`, http.StatusBadRequest)
+
+	recorder = s.expect(http.MethodGet, "/api/webhook", "admin", "", http.StatusOK)
+	if subscriptions := decode[[]models.WebhookSubscription](t, recorder); len(subscriptions) != 1 || subscriptions[0].Secret != "" {
+		t.Fatalf("subscriptions = %+v", subscriptions)
+	}
+
+	// Доставка ставится только для событий подписки
+	s.createBanner(banner1)
+	s.expect(http.MethodDelete, "/api/banner/1", "admin", "", http.StatusNoContent)
+
+	recorder = s.expect(http.MethodGet, "/api/webhook_delivery/1?status=pending", "admin", "", http.StatusOK)
+	deliveries := decode[[]models.WebhookDelivery](t, recorder)
+	if len(deliveries) != 1 || deliveries[0].EventType != models.EventBannerCreated {
+		t.Fatalf("deliveries = %+v", deliveries)
+	}
+
+	s.expect(http.MethodDelete, "/api/webhook/1", "admin", "", http.StatusNoContent)
+	s.expect(http.MethodDelete, "/api/webhook/1", "admin", "", http.StatusNotFound)
+	s.expect(http.MethodDelete, "/api/webhook/one", "admin", "", http.StatusBadRequest)
+}
+
+func TestRegistry(t *testing.T) {
+	s := newTestServer(t)
+
+	// Без id фича получает следующий за наибольшим, владелец по умолчанию автор запроса
+	recorder := s.expect(http.MethodPost, "/api/feature", "admin", `{"name":"checkout","description":"new checkout"}`, http.StatusCreated)
+	feature := decode[models.RegistryEntry](t, recorder)
+	if feature.ID != 4 || feature.Owner != models.RoleAdmin || feature.CreatedAt.IsZero() {
+		t.Fatalf("feature = %+v", feature)
+	}
+
+	s.expect(http.MethodPost, "/api/feature", "admin", `{"id":4,"name":"other"}`, http.StatusConflict)
+	s.expect(http.MethodPost, "/api/feature", "admin", `{"name":"checkout"}`, http.StatusConflict)
+	s.expect(http.MethodPost, "/api/tag", "admin", `{"name":"  "}`, http.StatusBadRequest)
+
+	recorder = s.expect(http.MethodPatch, "/api/feature/4", "admin", `{"name":"checkout v2","owner":"payments"}`, http.StatusOK)
+	if feature = decode[models.RegistryEntry](t, recorder); feature.Name != "checkout v2" || feature.Owner != "payments" {
+		t.Fatalf("updated feature = %+v", feature)
+	}
+	s.expect(http.MethodPatch, "/api/feature/4", "admin", `{"name":"feature 1"}`, http.StatusConflict)
+	s.expect(http.MethodPatch, "/api/tag/40", "admin", `{"name":"tag 40"}`, http.StatusNotFound)
+
+	recorder = s.expect(http.MethodGet, "/api/feature?name=checkout%20v2", "admin", "", http.StatusOK)
+	if entries := decode[[]models.RegistryEntry](t, recorder); len(entries) != 1 || entries[0].ID != 4 {
+		t.Fatalf("features by name = %+v", entries)
+	}
+	s.expect(http.MethodGet, "/api/tag/3", "admin", "", http.StatusOK)
+	s.expect(http.MethodGet, "/api/tag/40", "admin", "", http.StatusNotFound)
+
+	// Баннер только на зарегистрированные фичу и тэги
+	s.expect(http.MethodPost, "/api/banner", "admin", `{"tag_id":1,"feature_id":40,"content":{"title":"t"},"is_active":true}`, http.StatusBadRequest)
+	s.expect(http.MethodPost, "/api/banner", "admin", `{"tag_id":40,"feature_id":1,"content":{"title":"t"},"is_active":true}`, http.StatusBadRequest)
+	s.createBanner(`{"tag_id":2,"feature_id":4,"content":{"title":"checkout"},"is_active":true}`)
+	s.createBanner(banner1)
+
+	recorder = s.expect(http.MethodGet, "/api/banner?feature_name=checkout%20v2", "admin", "", http.StatusOK)
+	if banners := decode[[]models.ResponseBody](t, recorder); len(banners) != 1 || banners[0].FeatureID != 4 {
+		t.Fatalf("banners by feature name = %+v", banners)
+	}
+	recorder = s.expect(http.MethodGet, "/api/banner?tag_name=tag%201", "admin", "", http.StatusOK)
+	if banners := decode[[]models.ResponseBody](t, recorder); len(banners) != 1 || banners[0].BannerID != 2 {
+		t.Fatalf("banners by tag name = %+v", banners)
+	}
+	recorder = s.expect(http.MethodGet, "/api/banner?tag_name=unknown", "admin", "", http.StatusOK)
+	if banners := decode[[]models.ResponseBody](t, recorder); len(banners) != 0 {
+		t.Fatalf("banners by unknown tag = %+v", banners)
+	}
+
+	// Пока на фичу ссылаются баннеры, удалить ее можно только принудительно
+	s.expect(http.MethodDelete, "/api/feature/4", "admin", "", http.StatusConflict)
+	s.expect(http.MethodGet, "/api/feature/4", "admin", "", http.StatusOK)
+	s.expect(http.MethodDelete, "/api/feature/4?force=yes", "admin", "", http.StatusBadRequest)
+	s.expect(http.MethodDelete, "/api/feature/4?force=true", "admin", "", http.StatusNoContent)
+	s.expect(http.MethodDelete, "/api/feature/4", "admin", "", http.StatusNotFound)
+	s.expect(http.MethodDelete, "/api/tag/3", "admin", "", http.StatusNoContent)
+}
+
+func TestTenants(t *testing.T) {
+	s := newTestServer(t)
+
+	// Токены проекта shop
+	issuer := token.NewIssuer(s.cfg)
+	for role, name := range map[string]string{models.RoleAdmin: "shop-admin", models.RoleUser: "shop-user"} {
+		issued, err := issuer.Issue(models.TokenRequest{Role: role, Subject: name, Tenant: "shop"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.tokens[name] = issued.Token
+	}
+
+	s.createBanner(banner1)
+
+	// Справочники у проекта свои, та же пара фича + тэг в другом проекте свободна
+	shopBanner := `{"tag_id":1,"feature_id":1,"content":{"title":"shop"},"is_active":true}`
+	s.expect(http.MethodPost, "/api/banner", "shop-admin", shopBanner, http.StatusBadRequest)
+	s.expect(http.MethodPost, "/api/feature", "shop-admin", `{"id":1,"name":"feature 1"}`, http.StatusCreated)
+	s.expect(http.MethodPost, "/api/tag", "shop-admin", `{"id":1,"name":"tag 1"}`, http.StatusCreated)
+	s.expect(http.MethodPost, "/api/banner", "shop-admin", shopBanner, http.StatusCreated)
+
+	// Пользователь видит баннер своего проекта, в том числе из кэша
+	for _, user := range []string{"user", "shop-user", "user", "shop-user"} {
+		want := "first"
+		if user == "shop-user" {
+			want = "shop"
+		}
+		recorder := s.expect(http.MethodGet, "/api/user_banner?feature_id=1&tag_id=1", user, "", http.StatusOK)
+		if content := decode[models.BannerContent](t, recorder); content.Title != want {
+			t.Fatalf("%s content = %+v, want %q", user, content, want)
+		}
+	}
+
+	// Баннеры и аудит другого проекта не видны и не меняются
+	recorder := s.expect(http.MethodGet, "/api/banner?feature_id=1", "shop-admin", "", http.StatusOK)
+	if banners := decode[[]models.ResponseBody](t, recorder); len(banners) != 1 || banners[0].BannerID != 2 {
+		t.Fatalf("shop banners = %+v", banners)
+	}
+	s.expect(http.MethodPatch, "/api/banner/1", "shop-admin", shopBanner, http.StatusNotFound)
+
+	recorder = s.expect(http.MethodGet, "/api/audit", "shop-admin", "", http.StatusOK)
+	if records := decode[[]models.AuditRecord](t, recorder); len(records) != 1 || records[0].BannerID != 2 {
+		t.Fatalf("shop audit = %+v", records)
+	}
+
+	// Администратор выпускает токены только своего проекта
+	s.expect(http.MethodPost, "/api/token", "shop-admin", `{"role":"user","tenant":"default"}`, http.StatusForbidden)
+	s.expect(http.MethodPost, "/api/token", "admin", `{"role":"user","tenant":"Shop!"}`, http.StatusForbidden)
+	recorder = s.expect(http.MethodPost, "/api/token", "shop-admin", `{"role":"user"}`, http.StatusCreated)
+	s.tokens["issued"] = decode[models.TokenResponse](t, recorder).Token
+
+	recorder = s.expect(http.MethodGet, "/api/user_banner?feature_id=1&tag_id=1", "issued", "", http.StatusOK)
+	if content := decode[models.BannerContent](t, recorder); content.Title != "shop" {
+		t.Fatalf("issued token content = %+v", content)
+	}
+}
+
+func TestBannerRules(t *testing.T) {
+	s := newTestServer(t)
+
+	s.createBanner(banner1)
+	s.createBanner(`{"tag_id":2,"feature_id":1,"content":{"title":"second"},"is_active":true,"priority":5}`)
+	s.createBanner(`{"tag_id":3,"feature_id":1,"content":{"title":"fallback"},"is_active":true,"is_feature_default":true}`)
+
+	tests := []struct {
+		query, title, rule string
+	}{
+		{"tag_id=1", "first", models.RuleExact},
+		{"tag_id=1&tag_id=4", "first", models.RuleExact},
+		{"tag_id=1&tag_id=2", "second", models.RulePriority},
+		{"tag_id=2&tag_id=1", "second", models.RulePriority},
+		{"tag_id=4", "fallback", models.RuleFeatureDefault},
+	}
+	for _, tt := range tests {
+		recorder := s.expect(http.MethodGet, "/api/user_banner?feature_id=1&"+tt.query, "user", "", http.StatusOK)
+		content := decode[models.BannerContent](t, recorder)
+		if content.Title != tt.title || recorder.Header().Get("X-Banner-Rule") != tt.rule {
+			t.Fatalf("%s: content %+v, rule %q", tt.query, content, recorder.Header().Get("X-Banner-Rule"))
+		}
+	}
+
+	// Результат выбора кэшируется для набора тэгов независимо от их порядка
+	recorder := s.expect(http.MethodGet, "/api/user_banner?feature_id=1&tag_id=2&tag_id=1&tag_id=2", "user", "", http.StatusOK)
+	if recorder.Header().Get("X-Cache") != models.CacheHit || recorder.Header().Get("X-Banner-Rule") != models.RulePriority {
+		t.Fatalf("X-Cache %q, rule %q", recorder.Header().Get("X-Cache"), recorder.Header().Get("X-Banner-Rule"))
+	}
+
+	// При равном приоритете побеждает более старый баннер
+	s.expect(http.MethodPatch, "/api/banner/2", "admin", `{"priority":0}`, http.StatusOK)
+	recorder = s.expect(http.MethodGet, "/api/user_banner?feature_id=1&tag_id=2&tag_id=1&use_last_revision=true", "user", "", http.StatusOK)
+	if content := decode[models.BannerContent](t, recorder); content.Title != "first" {
+		t.Fatalf("content of equal priorities = %+v", content)
+	}
+
+	// Без баннера по умолчанию у фичи остается 404
+	s.expect(http.MethodGet, "/api/user_banner?feature_id=2&tag_id=1", "user", "", http.StatusNotFound)
+
+	recorder = s.expect(http.MethodGet, "/api/banner?feature_id=1", "admin", "", http.StatusOK)
+	for _, banner := range decode[[]models.ResponseBody](t, recorder) {
+		if banner.FeatureDefault != (banner.BannerID == 3) {
+			t.Fatalf("banner = %+v", banner)
+		}
+	}
+}
+
+func TestLocales(t *testing.T) {
+	s := newTestServer(t)
+
+	s.createBanner(banner1)
+
+	s.expect(http.MethodPut, "/api/banner_locale/1", "admin", `{"locale":"en","content":{"title":"english"}}`, http.StatusOK)
+	s.expect(http.MethodPut, "/api/banner_locale/1", "admin", `{"locale":"DE","content":{"title":"deutsch"}}`, http.StatusOK)
+
+	// Повтор без изменений не создает версию, изменение создает версию только у локали
+	s.expect(http.MethodPut, "/api/banner_locale/1", "admin", `{"locale":"en","content":{"title":"english"}}`, http.StatusOK)
+	recorder := s.expect(http.MethodPut, "/api/banner_locale/1", "admin", `{"locale":"en","content":{"title":"english v2"}}`, http.StatusOK)
+	if locale := decode[models.BannerLocale](t, recorder); locale.Version != 2 {
+		t.Fatalf("locale = %+v", locale)
+	}
+
+	recorder = s.expect(http.MethodGet, "/api/history_banner/1", "admin", "", http.StatusOK)
+	if history := decode[[]models.BannerHistory](t, recorder); len(history) != 1 {
+		t.Fatalf("main history = %+v", history)
+	}
+	recorder = s.expect(http.MethodGet, "/api/history_banner/1?lang=en", "admin", "", http.StatusOK)
+	if history := decode[[]models.BannerHistory](t, recorder); len(history) != 2 || history[1].Title != "english v2" || history[1].Locale != "en" {
+		t.Fatalf("en history = %+v", history)
+	}
+
+	recorder = s.expect(http.MethodGet, "/api/banner_locale/1", "admin", "", http.StatusOK)
+	if locales := decode[[]models.BannerLocale](t, recorder); len(locales) != 2 || locales[0].Locale != "de" || locales[1].Version != 2 {
+		t.Fatalf("locales = %+v", locales)
+	}
+
+	// Основное содержимое относится к локали по умолчанию и меняется через PATCH
+	s.expect(http.MethodPut, "/api/banner_locale/1", "admin", `{"locale":"ru","content":{"title":"русский"}}`, http.StatusBadRequest)
+	s.expect(http.MethodPut, "/api/banner_locale/1", "admin", `{"locale":"english","content":{}}`, http.StatusBadRequest)
+	s.expect(http.MethodPut, "/api/banner_locale/2", "admin", `{"locale":"en","content":{}}`, http.StatusNotFound)
+
+	tests := []struct {
+		lang, acceptLanguage, title, locale string
+	}{
+		{"de", "en", "deutsch", "de"},
+		{"", "fr, de;q=0.5, en;q=0.8", "english v2", "en"},
+		{"", "de-AT", "deutsch", "de"},
+		{"", "de;q=0, fr", "english v2", "en"}, // fr нет, дальше цепочка из конфига
+		{"", "ru, en", "first", "ru"},
+		{"", "*", "english v2", "en"},
+	}
+	for _, tt := range tests {
+		target := "/api/user_banner?feature_id=1&tag_id=1&use_last_revision=true"
+		if tt.lang != "" {
+			target += "&lang=" + tt.lang
+		}
+
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		request.Header.Set("Token", s.tokens["user"])
+		request.Header.Set("Accept-Language", tt.acceptLanguage)
+
+		recorder := httptest.NewRecorder()
+		s.handler.ServeHTTP(recorder, request)
+
+		content := decode[models.BannerContent](t, recorder)
+		if recorder.Code != http.StatusOK || content.Title != tt.title || recorder.Header().Get("Content-Language") != tt.locale {
+			t.Fatalf("lang %q, Accept-Language %q: status %d, content %+v, Content-Language %q",
+				tt.lang, tt.acceptLanguage, recorder.Code, content, recorder.Header().Get("Content-Language"))
+		}
+		if recorder.Header().Get("Vary") != "Accept-Language" {
+			t.Fatalf("Vary = %q", recorder.Header().Get("Vary"))
+		}
+	}
+
+	// Локаль из кэша тоже выбирается по запросу
+	s.expect(http.MethodGet, "/api/user_banner?feature_id=1&tag_id=1&lang=de", "user", "", http.StatusOK)
+	recorder = s.expect(http.MethodGet, "/api/user_banner?feature_id=1&tag_id=1&lang=en", "user", "", http.StatusOK)
+	if content := decode[models.BannerContent](t, recorder); recorder.Header().Get("X-Cache") != models.CacheHit || content.Title != "english v2" {
+		t.Fatalf("cached content = %+v, X-Cache %q", content, recorder.Header().Get("X-Cache"))
+	}
+
+	// Удаление локали сохраняет ее историю
+	s.expect(http.MethodDelete, "/api/banner_locale/1?lang=de", "admin", "", http.StatusNoContent)
+	s.expect(http.MethodDelete, "/api/banner_locale/1?lang=de", "admin", "", http.StatusNotFound)
+	recorder = s.expect(http.MethodGet, "/api/history_banner/1?lang=de", "admin", "", http.StatusOK)
+	if history := decode[[]models.BannerHistory](t, recorder); len(history) != 1 {
+		t.Fatalf("de history = %+v", history)
+	}
+
+	recorder = s.expect(http.MethodGet, "/api/audit?banner_id=1", "admin", "", http.StatusOK)
+	actions := make(map[string]int)
+	for _, record := range decode[[]models.AuditRecord](t, recorder) {
+		actions[record.Action]++
+	}
+	if actions[models.AuditLocaleUpdate] != 4 || actions[models.AuditLocaleDelete] != 1 {
+		t.Fatalf("audit actions = %v", actions)
+	}
+}
+
+func TestDrafts(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) { cfg.ApprovalRequired = true })
+
+	// Второй администратор проверяет черновики, дежурный может менять содержимое в обход проверки
+	issuer := token.NewIssuer(s.cfg)
+	for name, scopes := range map[string][]string{"reviewer": nil, "oncall": {models.ScopeEmergency}} {
+		issued, err := issuer.Issue(models.TokenRequest{Role: models.RoleAdmin, Subject: name, Scopes: scopes})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.tokens[name] = issued.Token
+	}
+
+	s.createBanner(banner1)
+
+	// Содержимое напрямую не меняется, остальные поля меняются
+	second := `{"tag_id":1,"feature_id":1,"content":{"title":"second","text":"text","url":"https://example.com/1"},"is_active":true}`
+	s.expect(http.MethodPatch, "/api/banner/1", "admin", second, http.StatusConflict)
+	s.expect(http.MethodPatch, "/api/banner/1", "admin", strings.Replace(banner1, `"is_active":true`, `"is_active":true,"priority":5`, 1), http.StatusOK)
+
+	recorder := s.expect(http.MethodPost, "/api/draft", "admin", `{"banner_id":1,"content":{"title":"second","text":"text","url":"https://example.com/1"},"comment":"new copy"}`, http.StatusCreated)
+	draft := decode[models.Draft](t, recorder)
+	if draft.Status != models.DraftStatusDraft || draft.Author != models.RoleAdmin || len(draft.Comments) != 1 {
+		t.Fatalf("draft = %+v", draft)
+	}
+	s.expect(http.MethodPost, "/api/draft", "admin", `{"banner_id":2,"content":{}}`, http.StatusNotFound)
+
+	target := fmt.Sprintf("/api/draft/%d", draft.DraftID)
+
+	// Одобрить можно только отправленный на проверку черновик, отправляет только автор
+	s.expect(http.MethodPatch, target, "reviewer", `{"action":"approve"}`, http.StatusConflict)
+	s.expect(http.MethodPatch, target, "reviewer", `{"action":"submit"}`, http.StatusForbidden)
+	s.expect(http.MethodPatch, target, "admin", `{"action":"publish"}`, http.StatusBadRequest)
+	s.expect(http.MethodPatch, target, "admin", `{"action":"comment"}`, http.StatusBadRequest)
+	s.expect(http.MethodPatch, target, "admin", `{"action":"submit"}`, http.StatusOK)
+
+	// Свой черновик автор не одобряет
+	s.expect(http.MethodPatch, target, "admin", `{"action":"approve"}`, http.StatusForbidden)
+	recorder = s.expect(http.MethodPatch, target, "reviewer", `{"action":"reject","comment":"typo"}`, http.StatusOK)
+	if draft = decode[models.Draft](t, recorder); draft.Status != models.DraftStatusRejected || draft.Reviewer != "reviewer" {
+		t.Fatalf("rejected draft = %+v", draft)
+	}
+
+	// Исправленный черновик отправляется снова
+	s.expect(http.MethodPatch, target, "admin", `{"action":"submit","content":{"title":"third","text":"text","url":"https://example.com/1"}}`, http.StatusOK)
+	recorder = s.expect(http.MethodGet, "/api/draft?status=pending", "reviewer", "", http.StatusOK)
+	if pending := decode[[]models.Draft](t, recorder); len(pending) != 1 || pending[0].Content.Title != "third" {
+		t.Fatalf("pending = %+v", pending)
+	}
+
+	recorder = s.expect(http.MethodPatch, target, "reviewer", `{"action":"approve","comment":"ok"}`, http.StatusOK)
+	if draft = decode[models.Draft](t, recorder); draft.Status != models.DraftStatusPublished || len(draft.Comments) != 3 {
+		t.Fatalf("published draft = %+v", draft)
+	}
+	s.expect(http.MethodPatch, target, "reviewer", `{"action":"approve"}`, http.StatusConflict)
+	s.expect(http.MethodPatch, target, "admin", `{"action":"comment","comment":"thanks"}`, http.StatusOK)
+
+	recorder = s.expect(http.MethodGet, "/api/user_banner?feature_id=1&tag_id=1", "user", "", http.StatusOK)
+	if content := decode[models.BannerContent](t, recorder); content.Title != "third" {
+		t.Fatalf("content = %+v", content)
+	}
+
+	// В обход проверки содержимое меняет только токен со scope emergency
+	s.expect(http.MethodPatch, "/api/banner/1?emergency=true", "admin", second, http.StatusForbidden)
+	s.expect(http.MethodPatch, "/api/banner/1?emergency=true", "oncall", second, http.StatusOK)
+
+	recorder = s.expect(http.MethodGet, "/api/audit?banner_id=1", "admin", "", http.StatusOK)
+	records := decode[[]models.AuditRecord](t, recorder)
+	if len(records) != 4 ||
+		records[2].Action != models.AuditPublish || records[2].Actor != "reviewer" ||
+		records[3].Action != models.AuditEmergency || records[3].Actor != "oncall" {
+		t.Fatalf("records = %+v", records)
+	}
+
+	recorder = s.expect(http.MethodGet, "/api/history_banner/1", "admin", "", http.StatusOK)
+	if history := decode[[]models.BannerHistory](t, recorder); len(history) != 3 {
+		t.Fatalf("history = %+v", history)
+	}
+
+	// Каждая отправка на проверку публикует событие
+	requested := 0
+	if _, err := s.repository.RelayEvents(context.Background(), func(ctx context.Context, event models.Event) error {
+		if event.Type == models.EventReviewRequested {
+			requested++
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if requested != 2 {
+		t.Fatalf("review requested = %d, want 2", requested)
+	}
+
+	s.expect(http.MethodGet, "/api/draft/404", "admin", "", http.StatusNotFound)
+	s.expect(http.MethodGet, "/api/draft?status=live", "admin", "", http.StatusBadRequest)
+}
+
+func TestApprovalBypass(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) { cfg.ApprovalRequired = true })
+
+	issued, err := token.NewIssuer(s.cfg).Issue(models.TokenRequest{Role: models.RoleAdmin, Subject: "oncall", Scopes: []string{models.ScopeEmergency}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.tokens["oncall"] = issued.Token
+
+	s.createBanner(banner1)
+	second := `{"tag_id":1,"feature_id":1,"content":{"title":"second","text":"text","url":"https://example.com/1"},"is_active":true}`
+	s.expect(http.MethodPatch, "/api/banner/1?emergency=true", "oncall", second, http.StatusOK)
+
+	live := func(title string) {
+		t.Helper()
+		recorder := s.expect(http.MethodGet, "/api/user_banner?feature_id=1&tag_id=1&use_last_revision=true", "user", "", http.StatusOK)
+		if content := decode[models.BannerContent](t, recorder); content.Title != title {
+			t.Fatalf("content = %+v, want title %q", content, title)
+		}
+	}
+
+	// Откат на версию с другим содержимым, версия с действующим содержимым ничего не меняет
+	first := `{"banner_id":1,"version":1,"title":"first","text":"text","url":"https://example.com/1"}`
+	s.expect(http.MethodPost, "/api/version_banner", "admin", first, http.StatusConflict)
+	s.expect(http.MethodPost, "/api/version_banner", "admin", strings.Replace(first, `"first"`, `"second"`, 1), http.StatusOK)
+	s.expect(http.MethodPost, "/api/version_banner?emergency=true", "admin", first, http.StatusForbidden)
+	s.expect(http.MethodPost, "/api/version_banner?emergency=true", "oncall", first, http.StatusOK)
+	live("first")
+
+	// Локали
+	locale := `{"locale":"de","content":{"title":"deutsch"}}`
+	s.expect(http.MethodPut, "/api/banner_locale/1", "admin", locale, http.StatusConflict)
+	s.expect(http.MethodPut, "/api/banner_locale/1?emergency=true", "oncall", locale, http.StatusOK)
+	s.expect(http.MethodDelete, "/api/banner_locale/1?lang=de", "admin", "", http.StatusConflict)
+	s.expect(http.MethodDelete, "/api/banner_locale/1?lang=de&emergency=yes", "oncall", "", http.StatusBadRequest)
+	s.expect(http.MethodDelete, "/api/banner_locale/1?lang=de&emergency=true", "admin", "", http.StatusForbidden)
+	s.expect(http.MethodDelete, "/api/banner_locale/1?lang=de&emergency=true", "oncall", "", http.StatusNoContent)
+
+	// Перезапись при загрузке, пробная загрузка ничего не меняет
+	csv := "banner_id,feature_id,tag_id,title,text,url,is_active\n1,1,1,imported,text,https://example.com/1,true\n"
+	s.expect(http.MethodPost, "/api/banner/import?format=csv&conflict=overwrite", "admin", csv, http.StatusConflict)
+	s.expect(http.MethodPost, "/api/banner/import?format=csv&conflict=overwrite&dry_run=true", "admin", csv, http.StatusOK)
+	s.expect(http.MethodPost, "/api/banner/import?format=csv", "admin", csv, http.StatusOK)
+	live("first")
+	s.expect(http.MethodPost, "/api/banner/import?format=csv&conflict=overwrite&emergency=true", "oncall", csv, http.StatusOK)
+	live("imported")
+
+	// Эксперимент показывает варианты сразу, победа варианта меняет контрольный баннер
+	experiment := `{"feature_id":1,"tag_id":1,"control_weight":50,"variants":[{"content":{"title":"variant"},"weight":50}]}`
+	s.expect(http.MethodPost, "/api/experiment", "admin", experiment, http.StatusConflict)
+	s.expect(http.MethodPost, "/api/experiment?emergency=true", "oncall", experiment, http.StatusCreated)
+	s.expect(http.MethodPost, "/api/experiment/winner", "admin", `{"feature_id":1,"tag_id":1,"banner_id":2}`, http.StatusConflict)
+	s.expect(http.MethodPost, "/api/experiment/winner?emergency=true", "oncall", `{"feature_id":1,"tag_id":1,"banner_id":2}`, http.StatusOK)
+	live("variant")
+
+	// Победа контрольного баннера содержимое не меняет
+	s.expect(http.MethodPost, "/api/experiment?emergency=true", "oncall", experiment, http.StatusCreated)
+	s.expect(http.MethodPost, "/api/experiment/winner", "admin", `{"feature_id":1,"tag_id":1,"banner_id":1}`, http.StatusOK)
+	s.expect(http.MethodPost, "/api/experiment/winner", "admin", `{"feature_id":1,"tag_id":1,"banner_id":1}`, http.StatusNotFound)
+}
+
+func TestDiffBanner(t *testing.T) {
+	s := newTestServer(t)
+
+	s.createBanner(banner1)
+
+	// Во второй версии текст - JSON, в третьей он меняется внутри
+	updated := func(title, text string) string {
+		body, _ := json.Marshal(models.BannerBody{TagID: 1, FeatureID: 1, Active: true,
+			Content: models.BannerContent{Title: title, Text: text, Url: "https://example.com/1"}})
+		return string(body)
+	}
+	s.expect(http.MethodPatch, "/api/banner/1", "admin", updated("second", `{"items":[{"label":"a"}],"color":"red"}`), http.StatusOK)
+	s.expect(http.MethodPatch, "/api/banner/1", "admin", updated("second", `{"items":[{"label":"b"},{"label":"c"}],"size":2}`), http.StatusOK)
+
+	recorder := s.expect(http.MethodGet, "/api/diff_banner/1?from=1&to=2", "admin", "", http.StatusOK)
+	diff := decode[models.BannerDiff](t, recorder)
+	if diff.Live || len(diff.Changes) != 2 || diff.Changes[0].Path != "title" || diff.Changes[1].Path != "text" ||
+		string(diff.Changes[0].Old) != `"first"` || diff.Changes[1].Op != models.DiffChanged {
+		t.Fatalf("diff 1..2 = %+v", diff)
+	}
+
+	recorder = s.expect(http.MethodGet, "/api/diff_banner/1?from=2&to=3", "admin", "", http.StatusOK)
+	got := make([]string, 0)
+	for _, change := range decode[models.BannerDiff](t, recorder).Changes {
+		got = append(got, change.Op+" "+change.Path+" "+string(change.Old)+" "+string(change.New))
+	}
+	want := []string{
+		`removed text.color "red" `,
+		`changed text.items[0].label "a" "b"`,
+		`added text.items[1]  {"label":"c"}`,
+		`added text.size  2`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("diff 2..3 = %q", got)
+	}
+
+	// Разница с действующим содержимым - то, что изменит откат
+	recorder = s.expect(http.MethodGet, "/api/diff_banner/1?from=3", "admin", "", http.StatusOK)
+	if diff = decode[models.BannerDiff](t, recorder); !diff.Live || diff.To != 0 || len(diff.Changes) != 0 {
+		t.Fatalf("diff 3..live = %+v", diff)
+	}
+	recorder = s.expect(http.MethodGet, "/api/diff_banner/1?from=1&to=live", "admin", "", http.StatusOK)
+	if diff = decode[models.BannerDiff](t, recorder); len(diff.Changes) != 2 ||
+		string(diff.Changes[0].Old) != `"second"` || string(diff.Changes[0].New) != `"first"` {
+		t.Fatalf("diff 1..live = %+v", diff)
+	}
+
+	// Откат на from приводит действующее содержимое к new
+	recorder = s.expect(http.MethodGet, "/api/diff_banner/1?from=2", "admin", "", http.StatusOK)
+	got = got[:0]
+	for _, change := range decode[models.BannerDiff](t, recorder).Changes {
+		got = append(got, change.Op+" "+change.Path+" "+string(change.Old)+" "+string(change.New))
+	}
+	want = []string{
+		`added text.color  "red"`,
+		`changed text.items[0].label "b" "a"`,
+		`removed text.items[1] {"label":"c"} `,
+		`removed text.size 2 `,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("diff 2..live = %q", got)
+	}
+
+	s.expect(http.MethodGet, "/api/diff_banner/1", "admin", "", http.StatusBadRequest)
+	s.expect(http.MethodGet, "/api/diff_banner/1?from=1&to=last", "admin", "", http.StatusBadRequest)
+	s.expect(http.MethodGet, "/api/diff_banner/1?from=9", "admin", "", http.StatusNotFound)
+	s.expect(http.MethodGet, "/api/diff_banner/2?from=1", "admin", "", http.StatusNotFound)
+
+	// У удаленного баннера нет действующего содержимого, версии сравниваются
+	s.expect(http.MethodDelete, "/api/banner/1", "admin", "", http.StatusNoContent)
+	s.expect(http.MethodGet, "/api/diff_banner/1?from=1", "admin", "", http.StatusNotFound)
+	s.expect(http.MethodGet, "/api/diff_banner/1?from=1&to=3", "admin", "", http.StatusOK)
+}
+
+func TestDocumentation(t *testing.T) {
+	s := newTestServer(t)
+
+	s.expect(http.MethodGet, "/api/openapi.json", "", "", http.StatusOK)
+	s.expect(http.MethodGet, "/api/docs", "", "", http.StatusOK)
+}
@@ -0,0 +1,78 @@
+package stats
+
+import (
+	"sync"
+	"time"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+)
+
+// Tracker buffers impressions and clicks in memory until they are flushed
+type Tracker struct {
+	mu       sync.Mutex
+	counters map[models.StatsKey]*models.StatsDelta
+	now      func() time.Time
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		counters: make(map[models.StatsKey]*models.StatsDelta),
+		now:      time.Now,
+	}
+}
+
+func (t *Tracker) Impression(bannerID, featureID, tagID int) {
+	t.add(bannerID, featureID, tagID, 1, 0)
+}
+
+func (t *Tracker) Click(bannerID, featureID, tagID int) {
+	t.add(bannerID, featureID, tagID, 0, 1)
+}
+
+func (t *Tracker) add(bannerID, featureID, tagID int, impressions, clicks int64) {
+
+	key := models.StatsKey{
+		BannerID:  bannerID,
+		FeatureID: featureID,
+		TagID:     tagID,
+		Bucket:    t.now().UTC().Truncate(models.StatsBucket),
+	}
+
+	t.addKey(models.StatsDelta{StatsKey: key, Impressions: impressions, Clicks: clicks})
+}
+
+// Drain takes all buffered counters, buffer starts from scratch
+func (t *Tracker) Drain() []models.StatsDelta {
+
+	t.mu.Lock()
+	counters := t.counters
+	t.counters = make(map[models.StatsKey]*models.StatsDelta, len(counters))
+	t.mu.Unlock()
+
+	deltas := make([]models.StatsDelta, 0, len(counters))
+	for _, delta := range counters {
+		deltas = append(deltas, *delta)
+	}
+	return deltas
+}
+
+// Restore returns counters which failed to flush back to buffer
+func (t *Tracker) Restore(deltas []models.StatsDelta) {
+	for _, delta := range deltas {
+		t.addKey(delta)
+	}
+}
+
+func (t *Tracker) addKey(delta models.StatsDelta) {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current, ok := t.counters[delta.StatsKey]
+	if !ok {
+		current = &models.StatsDelta{StatsKey: delta.StatsKey}
+		t.counters[delta.StatsKey] = current
+	}
+	current.Impressions += delta.Impressions
+	current.Clicks += delta.Clicks
+}
@@ -1,7 +1,10 @@
 package cache
 
 import (
+	"encoding/json"
 	"strconv"
+	"sync/atomic"
+	"time"
 
 	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
 	"github.com/go-redis/redis"
@@ -10,46 +13,89 @@ import (
 // Implementation check
 var _ Cacher = cache{}
 
+// Пары фича + тэг разных проектов (tenant) кэшируются под разными ключами
 type Cacher interface {
-	GetBanner(FThash uint64) (models.BannerContent, error)
-	SetBanner2Cache(hashKey uint64, banner models.BannerContent)
+	GetBanner(tenant string, FThash uint64) ([]models.UserBanner, bool, error)
+	SetBanner2Cache(tenant string, hashKey uint64, banners []models.UserBanner)
 	DeleteBanner(bannerID int)
+	Invalidate(tenant string, hashKey uint64)
+	SetTTL(ttl time.Duration)
+	Close() error
 }
 
 type cache struct {
 	rdb *redis.Client
+	ttl *atomic.Int64 // lifetime of cached banner, can be changed at runtime
 }
 
-func New(addr, password string) Cacher {
+func New(addr, password string, poolSize int, ttl time.Duration) Cacher {
+	c := &cache{rdb: NewClient(addr, password, poolSize), ttl: &atomic.Int64{}}
+	c.SetTTL(ttl)
+	return c
+}
 
-	rdb := redis.NewClient(&redis.Options{
+// Create new redis client, it is also used by components sharing redis with cache.
+// Zero pool size means 10 connections per CPU
+func NewClient(addr, password string, poolSize int) *redis.Client {
+	return redis.NewClient(&redis.Options{
 		Addr:     addr,
 		Password: password,
 		DB:       0,
+		PoolSize: poolSize,
 	})
+}
 
-	return &cache{rdb: rdb}
+// Ключ баннера пары с префиксом проекта
+func key(tenant string, hashKey uint64) string {
+	return tenant + ":" + strconv.FormatUint(hashKey, 10)
 }
 
-func (c cache) GetBanner(FThash uint64) (models.BannerContent, error) {
+// Получение баннера из кэша, второе значение false если баннера в кэше нет.
+// Для пары с экспериментом в кэше лежат все варианты
+func (c cache) GetBanner(tenant string, FThash uint64) ([]models.UserBanner, bool, error) {
 
-	var banner models.BannerContent
+	var banners []models.UserBanner
 
-	// Получение структуры из хэша Redis
-	val, err := c.rdb.HGetAll(strconv.Itoa(int(FThash))).Result()
+	val, err := c.rdb.Get(key(tenant, FThash)).Bytes()
 	if err != nil {
-		return models.BannerContent{}, err
+		if err == redis.Nil {
+			return nil, false, nil
+		}
+		return nil, false, err
 	}
 
-	banner.Title = val["Title"]
-	banner.Text = val["Text"]
-	banner.Url = val["Url"]
+	// Испорченную запись считаем промахом
+	if err = json.Unmarshal(val, &banners); err != nil || len(banners) == 0 {
+		return nil, false, nil
+	}
 
-	return banner, nil
+	return banners, true, nil
 }
-func (c cache) SetBanner2Cache(hashKey uint64, banner models.BannerContent) {
-	_ = c.rdb.HSet(strconv.Itoa(int(hashKey)), "key", banner).Err()
+
+func (c cache) SetBanner2Cache(tenant string, hashKey uint64, banners []models.UserBanner) {
+
+	val, err := json.Marshal(banners)
+	if err != nil {
+		return
+	}
+
+	_ = c.rdb.Set(key(tenant, hashKey), val, time.Duration(c.ttl.Load())).Err()
 }
 
 func (c cache) DeleteBanner(bannerID int) {
 }
+
+// Новый срок жизни действует для баннеров, записанных после вызова
+func (c cache) SetTTL(ttl time.Duration) {
+	c.ttl.Store(int64(ttl))
+}
+
+// Удаление закэшированного баннера пары фича + тэг
+func (c cache) Invalidate(tenant string, hashKey uint64) {
+	_ = c.rdb.Del(key(tenant, hashKey)).Err()
+}
+
+// Close closes connections to redis
+func (c cache) Close() error {
+	return c.rdb.Close()
+}
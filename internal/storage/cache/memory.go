@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+)
+
+// Implementation check
+var _ Cacher = (*memory)(nil)
+
+type memoryEntry struct {
+	banners   []models.UserBanner
+	expiresAt time.Time
+}
+
+// In-memory Cacher with the same expiration semantics as redis cache, used in tests
+type memory struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry // keyed like redis cache
+	ttl     time.Duration          // lifetime of cached banner
+}
+
+func NewMemory(ttl time.Duration) Cacher {
+	return &memory{entries: make(map[string]memoryEntry), ttl: ttl}
+}
+
+func (m *memory) GetBanner(tenant string, FThash uint64) ([]models.UserBanner, bool, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[key(tenant, FThash)]
+	if !ok || len(entry.banners) == 0 {
+		return nil, false, nil
+	}
+
+	// Истекшую запись считаем промахом
+	if !entry.expiresAt.IsZero() && !time.Now().Before(entry.expiresAt) {
+		delete(m.entries, key(tenant, FThash))
+		return nil, false, nil
+	}
+
+	return append([]models.UserBanner(nil), entry.banners...), true, nil
+}
+
+func (m *memory) SetBanner2Cache(tenant string, hashKey uint64, banners []models.UserBanner) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Нулевой ttl, как и в redis, означает запись без срока жизни
+	entry := memoryEntry{banners: append([]models.UserBanner(nil), banners...)}
+	if m.ttl > 0 {
+		entry.expiresAt = time.Now().Add(m.ttl)
+	}
+
+	m.entries[key(tenant, hashKey)] = entry
+}
+
+func (m *memory) DeleteBanner(bannerID int) {
+}
+
+func (m *memory) Invalidate(tenant string, hashKey uint64) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key(tenant, hashKey))
+}
+
+func (m *memory) SetTTL(ttl time.Duration) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.ttl = ttl
+}
+
+func (m *memory) Close() error {
+	return nil
+}
@@ -0,0 +1,97 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/storage/cache"
+)
+
+const cacheTTL = time.Second
+
+var banners = []models.UserBanner{
+	{BannerID: 1, Content: first, Weight: 70, Locales: map[string]models.BannerContent{"en": second}},
+	{BannerID: 2, Content: second, Weight: 30},
+}
+
+// Сценарии выполняются и на Redis, и на in-memory реализации
+func forEachCacher(t *testing.T, scenario func(t *testing.T, c cache.Cacher)) {
+
+	t.Run("redis", func(t *testing.T) {
+		c, _ := newRedis(t, cacheTTL)
+		scenario(t, c)
+	})
+
+	t.Run("memory", func(t *testing.T) {
+		scenario(t, cache.NewMemory(cacheTTL))
+	})
+}
+
+func TestCacheSetGetInvalidate(t *testing.T) {
+	forEachCacher(t, func(t *testing.T, c cache.Cacher) {
+
+		if _, found, err := c.GetBanner(models.DefaultTenant, 1); err != nil || found {
+			t.Fatalf("empty cache: %v, %v", found, err)
+		}
+
+		c.SetBanner2Cache(models.DefaultTenant, 1, banners)
+
+		got, found, err := c.GetBanner(models.DefaultTenant, 1)
+		if err != nil || !found {
+			t.Fatalf("cached banners: %v, %v", found, err)
+		}
+		if !reflect.DeepEqual(got, banners) {
+			t.Fatalf("banners = %+v", got)
+		}
+
+		// Ключи не пересекаются ни между парами, ни между проектами
+		if _, found, _ = c.GetBanner(models.DefaultTenant, 2); found {
+			t.Fatal("banners are found by other key")
+		}
+		if _, found, _ = c.GetBanner("other", 1); found {
+			t.Fatal("banners are found by other tenant")
+		}
+
+		c.Invalidate(models.DefaultTenant, 1)
+		if _, found, err = c.GetBanner(models.DefaultTenant, 1); err != nil || found {
+			t.Fatalf("invalidated banners: %v, %v", found, err)
+		}
+
+		// Пустой список не считается попаданием
+		c.SetBanner2Cache(models.DefaultTenant, 3, nil)
+		if _, found, err = c.GetBanner(models.DefaultTenant, 3); err != nil || found {
+			t.Fatalf("empty banners: %v, %v", found, err)
+		}
+	})
+}
+
+func TestCacheExpiration(t *testing.T) {
+	forEachCacher(t, func(t *testing.T, c cache.Cacher) {
+
+		c.SetBanner2Cache(models.DefaultTenant, 1, banners)
+		time.Sleep(cacheTTL + 200*time.Millisecond)
+
+		if _, found, err := c.GetBanner(models.DefaultTenant, 1); err != nil || found {
+			t.Fatalf("expired banners: %v, %v", found, err)
+		}
+	})
+}
+
+// Испорченная запись считается промахом
+func TestCacheCorruptedEntry(t *testing.T) {
+
+	c, client := newRedis(t, cacheTTL)
+
+	if err := client.Set(models.DefaultTenant+":"+strconv.FormatUint(1, 10), "{not json", cacheTTL).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, found, err := c.GetBanner(models.DefaultTenant, 1); err != nil || found {
+		t.Fatalf("corrupted entry: %v, %v", found, err)
+	}
+}
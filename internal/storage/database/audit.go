@@ -0,0 +1,197 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+)
+
+// Снимок баннера со всеми тэгами внутри транзакции, nil если баннера нет,
+// он удален или принадлежит другому проекту
+func snapshot(ctx context.Context, tx *sql.Tx, bannerID int) (*models.ResponseBody, error) {
+
+	var banner models.ResponseBody
+
+	row := tx.QueryRowContext(ctx, `SELECT banner_id, title, text, url, is_active, priority,
+									EXISTS (SELECT 1 FROM feature_default WHERE feature_default.banner_id = actual_banner.banner_id)
+									FROM actual_banner
+									WHERE banner_id = $1
+									AND tenant = $2
+									AND deleted_at IS NULL`, bannerID, token.Tenant(ctx))
+	err := row.Scan(&banner.BannerID, &banner.Content.Title, &banner.Content.Text, &banner.Content.Url, &banner.Active,
+		&banner.Priority, &banner.FeatureDefault)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT feature_id, tag_id
+									FROM tag_feature
+									WHERE banner_id = $1
+									ORDER BY tag_id`, bannerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	banner.TagID = make([]uint32, 0)
+	for rows.Next() {
+		var tag uint32
+		if err = rows.Scan(&banner.FeatureID, &tag); err != nil {
+			return nil, err
+		}
+		banner.TagID = append(banner.TagID, tag)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Локали попадают в снимок, чтобы их изменения были видны в аудите и событиях
+	if rows, err = tx.QueryContext(ctx, bannerLocalesQuery, []int64{int64(bannerID)}); err != nil {
+		return nil, err
+	}
+	locales, err := scanLocales(rows)
+	if err != nil {
+		return nil, err
+	}
+	banner.Locales = locales[banner.BannerID]
+
+	return &banner, nil
+}
+
+// Запись изменения баннера в журнал аудита и в outbox событий в той же транзакции,
+// что и само изменение. Автор изменения берется из токена запроса.
+func recordChange(ctx context.Context, tx *sql.Tx, action string, bannerID int, before, after *models.ResponseBody) error {
+
+	beforeJSON, err := marshalSnapshot(before)
+	if err != nil {
+		return err
+	}
+
+	afterJSON, err := marshalSnapshot(after)
+	if err != nil {
+		return err
+	}
+
+	actor := token.Actor(ctx)
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO audit_log
+								(tenant, actor, action, banner_id, before, after)
+								VALUES($1, $2, $3, $4, $5, $6)`,
+		token.Tenant(ctx),
+		actor,
+		action,
+		bannerID,
+		beforeJSON,
+		afterJSON,
+	)
+	if err != nil {
+		return err
+	}
+
+	return writeOutbox(ctx, tx, eventType(action), bannerID, actor, before, after)
+}
+
+func marshalSnapshot(banner *models.ResponseBody) (interface{}, error) {
+	if banner == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(banner)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+// Выборка журнала аудита по баннеру, автору и интервалу времени
+func (d dbase) GetAudit(ctx context.Context, auditQuery models.AuditQuery) ([]models.AuditRecord, error) {
+
+	var (
+		conditions []string
+		args       []interface{}
+	)
+
+	records := make([]models.AuditRecord, 0)
+
+	// Собираем условия только по переданным параметрам
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.Replace(condition, "?", "$"+strconv.Itoa(len(args)), 1))
+	}
+
+	// Журнал только своего проекта
+	addCondition("tenant = ?", token.Tenant(ctx))
+
+	if auditQuery.BannerID != 0 {
+		addCondition("banner_id = ?", auditQuery.BannerID)
+	}
+	if auditQuery.Actor != "" {
+		addCondition("actor = ?", auditQuery.Actor)
+	}
+	if !auditQuery.From.IsZero() {
+		addCondition("created_at >= ?", auditQuery.From)
+	}
+	if !auditQuery.To.IsZero() {
+		addCondition("created_at < ?", auditQuery.To)
+	}
+
+	query := `SELECT id, actor, action, banner_id, before, after, created_at
+				FROM audit_log
+				WHERE ` + strings.Join(conditions, " AND ")
+	query += " ORDER BY id"
+
+	if auditQuery.Limit > 0 {
+		args = append(args, auditQuery.Limit)
+		query += " LIMIT $" + strconv.Itoa(len(args))
+	}
+	if auditQuery.Offset > 0 {
+		args = append(args, auditQuery.Offset)
+		query += " OFFSET $" + strconv.Itoa(len(args))
+	}
+
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var (
+			record        models.AuditRecord
+			before, after []byte
+		)
+
+		err = rows.Scan(&record.ID, &record.Actor, &record.Action, &record.BannerID, &before, &after, &record.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if before != nil {
+			record.Before = before
+		}
+		if after != nil {
+			record.After = after
+		}
+
+		records = append(records, record)
+	}
+
+	// проверяем на ошибки
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
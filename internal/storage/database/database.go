@@ -4,22 +4,68 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"time"
 
 	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
 // Implementation check
 var _ DBaser = (*dbase)(nil)
 
+var errFeatureMismatch = errors.New("feature not comparable")
+
 type DBaser interface {
 	CreateBanner(ctx context.Context, bannerBody models.BannerBody) (int, error)
 	UpdateBanner(ctx context.Context, bannerBody models.BannerBody, bannerID int) (bool, error)
 	GetBanners(ctx context.Context, queryParam models.Query) ([]models.ResponseBody, error)
-	GetBanner(ctx context.Context, featureID, tagID int) (models.BannerContent, error)
+	GetBannerByID(ctx context.Context, bannerID int) (models.ResponseBody, bool, error)
+	GetBanner(ctx context.Context, featureID int, tagIDs []int) ([]models.UserBanner, error)
 	DeleteBanner(ctx context.Context, bannerID int) error
 	GetHistoryBanner(ctx context.Context, bannerID int) ([]models.BannerHistory, error)
 	UpdateVersion(ctx context.Context, bannerVersion models.BannerHistory) error
+	GetAudit(ctx context.Context, auditQuery models.AuditQuery) ([]models.AuditRecord, error)
+	GetDeletedBanners(ctx context.Context, deletedAfter time.Time) ([]models.DeletedBanner, error)
+	RestoreBanner(ctx context.Context, bannerID int, deletedAfter time.Time) (bool, error)
+	PurgeBanners(ctx context.Context, deletedBefore time.Time) (int, error)
+	ExportBanners(ctx context.Context, withHistory bool, fn func(models.ExportBanner) error) error
+	ImportBanners(ctx context.Context, banners []models.ExportBanner, options models.ImportOptions) ([]models.ImportRowResult, bool, error)
+	SaveStats(ctx context.Context, deltas []models.StatsDelta) error
+	GetStats(ctx context.Context, statsQuery models.StatsQuery) ([]models.BannerStats, error)
+	GetBannerURL(ctx context.Context, bannerID int) (string, error)
+	CreateExperiment(ctx context.Context, experimentRequest models.ExperimentRequest) (models.Experiment, bool, error)
+	GetExperiment(ctx context.Context, featureID, tagID int) (models.Experiment, bool, error)
+	DeclareWinner(ctx context.Context, winner models.WinnerRequest) (bool, error)
+	RelayOutbox(ctx context.Context, limit int, publish func(context.Context, models.Event) error) (int, error)
+	CreateWebhook(ctx context.Context, subscription models.WebhookSubscription) (models.WebhookSubscription, error)
+	GetWebhooks(ctx context.Context) ([]models.WebhookSubscription, error)
+	DeleteWebhook(ctx context.Context, subscriptionID int64) (bool, error)
+	GetDeliveries(ctx context.Context, deliveryQuery models.DeliveryQuery) ([]models.WebhookDelivery, error)
+	ClaimDeliveries(ctx context.Context, limit int) ([]models.WebhookDelivery, error)
+	CompleteDelivery(ctx context.Context, result models.DeliveryResult) error
+	CreateRegistryEntry(ctx context.Context, kind string, entry models.RegistryEntry) (models.RegistryEntry, error)
+	GetRegistryEntries(ctx context.Context, kind, name string) ([]models.RegistryEntry, error)
+	GetRegistryEntry(ctx context.Context, kind string, id int) (models.RegistryEntry, bool, error)
+	UpdateRegistryEntry(ctx context.Context, kind string, entry models.RegistryEntry) (bool, error)
+	DeleteRegistryEntry(ctx context.Context, kind string, id int, force bool) (bool, error)
+	GetBannerLocales(ctx context.Context, bannerID int) ([]models.BannerLocale, bool, error)
+	SetBannerLocale(ctx context.Context, bannerID int, locale models.BannerLocale) (models.BannerLocale, bool, error)
+	DeleteBannerLocale(ctx context.Context, bannerID int, locale string) (bool, error)
+	GetLocaleHistory(ctx context.Context, bannerID int, locale string) ([]models.BannerHistory, error)
+	GetBannerContent(ctx context.Context, bannerID int) (models.BannerContent, bool, error)
+	CreateDraft(ctx context.Context, draftRequest models.DraftRequest) (models.Draft, bool, error)
+	GetDrafts(ctx context.Context, draftQuery models.DraftQuery) ([]models.Draft, error)
+	GetDraft(ctx context.Context, draftID int64) (models.Draft, bool, error)
+	UpdateDraft(ctx context.Context, draftID int64, draftAction models.DraftAction) (models.Draft, bool, error)
+	Close() error
+}
+
+// Connection pool settings, zero values keep database/sql behaviour
+type Pool struct {
+	MaxOpenConns    int           // 0 means unlimited
+	MaxIdleConns    int           // 0 means idle connections are closed
+	ConnMaxLifetime time.Duration // 0 means connections are reused forever
 }
 
 // Database layer
@@ -27,13 +73,17 @@ type dbase struct {
 	db *sql.DB
 }
 
-func Connect(dsn string) (DBaser, error) {
+func Connect(dsn string, pool Pool) (DBaser, error) {
 
 	db, err := sql.Open("pgx", dsn)
 	if err != nil {
 		return nil, err
 	}
 
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	db.SetMaxIdleConns(pool.MaxIdleConns)
+	db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+
 	// Create table for actual_banner
 	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS actual_banner
 					(banner_id BIGSERIAL PRIMARY KEY,
@@ -67,6 +117,130 @@ func Connect(dsn string) (DBaser, error) {
 		return nil, err
 	}
 
+	// Mark of soft deleted banners
+	_, err = db.Exec(`ALTER TABLE actual_banner
+					ADD COLUMN IF NOT EXISTS deleted_at timestamptz`)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create table for tag and feature of soft deleted banners
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS deleted_tag_feature
+					(feature_id bigint NOT NULL,
+					tag_id bigint NOT NULL,
+					banner_id bigint NOT NULL,
+					PRIMARY KEY (banner_id, feature_id, tag_id))`)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create table for variants of experiments, control banner is variant too
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS experiment_variant
+					(feature_id bigint NOT NULL,
+					tag_id bigint NOT NULL,
+					banner_id bigint NOT NULL,
+					weight int NOT NULL CHECK (weight > 0),
+					PRIMARY KEY (feature_id, tag_id, banner_id))`)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create transactional outbox of banner events
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS outbox
+					(id BIGSERIAL PRIMARY KEY,
+					event_type text NOT NULL,
+					banner_id bigint NOT NULL,
+					payload jsonb NOT NULL,
+					created_at timestamptz NOT NULL DEFAULT now(),
+					published_at timestamptz);
+					CREATE INDEX IF NOT EXISTS outbox_unpublished_idx ON outbox (id) WHERE published_at IS NULL`)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create tables for webhook subscriptions and their deliveries
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS webhook_subscription
+					(id BIGSERIAL PRIMARY KEY,
+					url text NOT NULL,
+					secret text NOT NULL,
+					feature_id bigint,
+					tag_id bigint,
+					event_types jsonb NOT NULL DEFAULT '[]',
+					created_at timestamptz NOT NULL DEFAULT now());
+					CREATE TABLE IF NOT EXISTS webhook_delivery
+					(id BIGSERIAL PRIMARY KEY,
+					subscription_id bigint NOT NULL,
+					event_id bigint NOT NULL,
+					event_type text NOT NULL,
+					payload jsonb NOT NULL,
+					status text NOT NULL DEFAULT 'pending',
+					attempts int NOT NULL DEFAULT 0,
+					next_attempt_at timestamptz NOT NULL DEFAULT now(),
+					last_status int NOT NULL DEFAULT 0,
+					last_error text NOT NULL DEFAULT '',
+					created_at timestamptz NOT NULL DEFAULT now(),
+					delivered_at timestamptz);
+					CREATE INDEX IF NOT EXISTS webhook_delivery_pending_idx
+					ON webhook_delivery (next_attempt_at) WHERE status = 'pending';
+					CREATE INDEX IF NOT EXISTS webhook_delivery_subscription_idx
+					ON webhook_delivery (subscription_id)`)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create table for impressions and clicks counters
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS banner_stats
+					(banner_id bigint NOT NULL,
+					feature_id bigint NOT NULL,
+					tag_id bigint NOT NULL,
+					bucket timestamptz NOT NULL,
+					impressions bigint NOT NULL DEFAULT 0,
+					clicks bigint NOT NULL DEFAULT 0,
+					PRIMARY KEY (banner_id, feature_id, tag_id, bucket))`)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create append-only table for audit of admin mutations
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS audit_log
+					(id BIGSERIAL PRIMARY KEY,
+					actor text NOT NULL,
+					action text NOT NULL,
+					banner_id bigint NOT NULL,
+					before jsonb,
+					after jsonb,
+					created_at timestamptz NOT NULL DEFAULT now());
+					CREATE INDEX IF NOT EXISTS audit_log_banner_id_idx ON audit_log (banner_id);
+					CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log (actor);
+					CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at)`)
+	if err != nil {
+		return nil, err
+	}
+
+	// Add tenant to tables of banners, their pairs, experiments, subscriptions and journals
+	if err = createTenants(db); err != nil {
+		return nil, err
+	}
+
+	// Create registries of features and tags
+	if err = createRegistries(db); err != nil {
+		return nil, err
+	}
+
+	// Create tables for localized content of banners
+	if err = createLocales(db); err != nil {
+		return nil, err
+	}
+
+	// Add priority of banners and default banners of features
+	if err = createPriorities(db); err != nil {
+		return nil, err
+	}
+
+	if err = createDrafts(db); err != nil {
+		return nil, err
+	}
+
 	return dbase{
 		db: db,
 	}, nil
@@ -84,6 +258,8 @@ func (d dbase) CreateBanner(ctx context.Context, bannerBody models.BannerBody) (
 
 	var id int
 
+	tenant := token.Tenant(ctx)
+
 	tx, err := d.db.Begin()
 	if err != nil {
 		return 0, err
@@ -91,6 +267,11 @@ func (d dbase) CreateBanner(ctx context.Context, bannerBody models.BannerBody) (
 
 	defer tx.Rollback()
 
+	// Фича и тэг должны быть зарегистрированы
+	if err = checkRegistered(ctx, tx, bannerBody.FeatureID, []uint32{bannerBody.TagID}); err != nil {
+		return 0, err
+	}
+
 	// 1. Делаем обновления таблицы actual_banner
 	// Не смотрим поле is_active т.к. оно не является телом баннера, а является лишь свойством
 	// stmt, err := tx.Prepare("INSERT INTO actual_banner (title, text, url) VALUES ($1, $2, $3)")
@@ -109,19 +290,40 @@ func (d dbase) CreateBanner(ctx context.Context, bannerBody models.BannerBody) (
 	// 	return 0, err
 	// }
 
-	err = tx.QueryRowContext(ctx, `INSERT INTO actual_banner (title, text, url) 
-									VALUES ($1, $2, $3) RETURNING banner_id`,
+	err = tx.QueryRowContext(ctx, `INSERT INTO actual_banner (tenant, title, text, url, priority) 
+									VALUES ($1, $2, $3, $4, $5) RETURNING banner_id`,
+		tenant,
 		bannerBody.Content.Title,
 		bannerBody.Content.Text,
-		bannerBody.Content.Url).Scan(&id)
+		bannerBody.Content.Url,
+		bannerBody.Priority).Scan(&id)
 	if err != nil {
 		return 0, err
 	}
 
-	// 2. Делаем обновления таблицы tag_feature
+	// 2. Делаем обновления таблицы tag_feature, пара уникальна внутри проекта
+	var taken bool
+	row := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1
+									FROM tag_feature
+									WHERE tenant = $1
+									AND feature_id = $2
+									AND tag_id = $3)`,
+		tenant,
+		bannerBody.FeatureID,
+		bannerBody.TagID,
+	)
+	if err = row.Scan(&taken); err != nil {
+		return 0, err
+	}
+
+	if taken {
+		return 0, models.ErrPairTaken
+	}
+
 	_, err = tx.ExecContext(ctx, `INSERT INTO tag_feature 
-								(feature_id, tag_id, banner_id) 
-								VALUES($1, $2, $3)`,
+								(tenant, feature_id, tag_id, banner_id) 
+								VALUES($1, $2, $3, $4)`,
+		tenant,
 		bannerBody.FeatureID,
 		bannerBody.TagID,
 		id,
@@ -130,6 +332,12 @@ func (d dbase) CreateBanner(ctx context.Context, bannerBody models.BannerBody) (
 		return 0, err
 	}
 
+	if bannerBody.FeatureDefault {
+		if err = setFeatureDefault(ctx, tx, bannerBody.FeatureID, id, true); err != nil {
+			return 0, err
+		}
+	}
+
 	// 3. Делаем первую запись в таблицу history_banner
 	_, err = tx.ExecContext(ctx, `INSERT INTO history_banner
 								(banner_id, version, title, text, url)
@@ -144,6 +352,16 @@ func (d dbase) CreateBanner(ctx context.Context, bannerBody models.BannerBody) (
 		return 0, err
 	}
 
+	// 4. Запишем создание в журнал аудита
+	after, err := snapshot(ctx, tx, id)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = recordChange(ctx, tx, models.AuditCreate, id, nil, after); err != nil {
+		return 0, err
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return 0, err
@@ -178,10 +396,12 @@ func (d dbase) UpdateBanner(ctx context.Context, bannerBody models.BannerBody, b
 
 	defer tx.Rollback()
 
-	// Делаем проверку существования баннера
+	// Делаем проверку существования баннера в проекте
 	row := tx.QueryRowContext(ctx, `SELECT banner_id
 									FROM actual_banner
-									WHERE banner_id = $1`, bannerID)
+									WHERE banner_id = $1
+									AND tenant = $2
+									AND deleted_at IS NULL`, bannerID, token.Tenant(ctx))
 	if err = row.Scan(&existsID); err != nil {
 		if sql.ErrNoRows == err {
 			return false, nil
@@ -189,18 +409,26 @@ func (d dbase) UpdateBanner(ctx context.Context, bannerBody models.BannerBody, b
 		return false, err
 	}
 
+	// Состояние баннера до изменения для журнала аудита
+	before, err := snapshot(ctx, tx, bannerID)
+	if err != nil {
+		return false, err
+	}
+
 	// 1. Делаем обновления таблицы actual_banner
 	_, err = tx.ExecContext(ctx, `UPDATE actual_banner
 								SET title = $1,
 								text = $2,
 								url = $3,
-								is_active = $4
+								is_active = $4,
+								priority = $6
 								WHERE banner_id = $5`,
 		bannerBody.Content.Title,
 		bannerBody.Content.Text,
 		bannerBody.Content.Url,
 		bannerBody.Active,
 		bannerID,
+		bannerBody.Priority,
 	)
 
 	// Надо проверить обработку ошибки, когда записи не обновились, потому что нечего обновлять
@@ -221,7 +449,11 @@ func (d dbase) UpdateBanner(ctx context.Context, bannerBody models.BannerBody, b
 	// Проверяем, что фича у обновляемого баннера совпадает с фичами, которые есть у баннера
 	// чтобы не нарушить условия хранения баннеров
 	if feature != int(bannerBody.FeatureID) {
-		return false, errors.New("feature not comparable")
+		return false, errFeatureMismatch
+	}
+
+	if err = setFeatureDefault(ctx, tx, bannerBody.FeatureID, bannerID, bannerBody.FeatureDefault); err != nil {
+		return false, err
 	}
 
 	row = tx.QueryRowContext(ctx, `SELECT feature_id, tag_id
@@ -234,8 +466,9 @@ func (d dbase) UpdateBanner(ctx context.Context, bannerBody models.BannerBody, b
 	// Проверяем, что записи с tag и feature нет еще
 	if tag != int(bannerBody.TagID) && feature != int(bannerBody.FeatureID) {
 		_, err = tx.ExecContext(ctx, `INSERT INTO tag_feature
-									(tag_id, feature_id, banner_id)
-									VALUES($1, $2, $3)`,
+									(tenant, tag_id, feature_id, banner_id)
+									VALUES($1, $2, $3, $4)`,
+			token.Tenant(ctx),
 			bannerBody.FeatureID,
 			bannerBody.TagID,
 			bannerID,
@@ -275,6 +508,16 @@ func (d dbase) UpdateBanner(ctx context.Context, bannerBody models.BannerBody, b
 
 	}
 
+	// 4. Запишем изменение в журнал аудита
+	after, err := snapshot(ctx, tx, bannerID)
+	if err != nil {
+		return false, err
+	}
+
+	if err = recordChange(ctx, tx, updateAction(ctx), bannerID, before, after); err != nil {
+		return false, err
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return false, err
@@ -301,14 +544,20 @@ func (d dbase) GetBanners(ctx context.Context, queryParam models.Query) ([]model
 										actual_banner.title,
 										actual_banner.text,
 										actual_banner.url,
-										actual_banner.is_active
+										actual_banner.is_active,
+										actual_banner.priority,
+										EXISTS (SELECT 1
+											FROM feature_default
+											WHERE feature_default.banner_id = actual_banner.banner_id)
 										FROM actual_banner
 										INNER JOIN tag_feature
 										ON actual_banner.banner_id = tag_feature.banner_id
-										WHERE tag_feature.tag_id = $1
-										OR tag_feature.feature_id = $2`,
+										WHERE tag_feature.tenant = $3
+										AND (tag_feature.tag_id = $1
+										OR tag_feature.feature_id = $2)`,
 		queryParam.TagID,
 		queryParam.FeatureID,
+		token.Tenant(ctx),
 	)
 	if err != nil {
 		return nil, err
@@ -318,7 +567,8 @@ func (d dbase) GetBanners(ctx context.Context, queryParam models.Query) ([]model
 
 		var banner models.ResponseBody
 
-		err = rows.Scan(&banner.BannerID, &banner.Content.Title, &banner.Content.Text, &banner.Content.Url, &banner.Active)
+		err = rows.Scan(&banner.BannerID, &banner.Content.Title, &banner.Content.Text, &banner.Content.Url, &banner.Active,
+			&banner.Priority, &banner.FeatureDefault)
 		if err != nil {
 			return []models.ResponseBody{}, err
 		}
@@ -335,32 +585,134 @@ func (d dbase) GetBanners(ctx context.Context, queryParam models.Query) ([]model
 	return banners, nil
 }
 
-// Просто получение баннера по фиче и тэгу
-func (d dbase) GetBanner(ctx context.Context, featureID, tagID int) (models.BannerContent, error) {
-	var banner models.BannerContent
+// Баннер проекта со всеми тэгами, false если баннера нет или он удален
+func (d dbase) GetBannerByID(ctx context.Context, bannerID int) (models.ResponseBody, bool, error) {
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return models.ResponseBody{}, false, err
+	}
+
+	defer tx.Rollback()
+
+	banner, err := snapshot(ctx, tx, bannerID)
+	if err != nil || banner == nil {
+		return models.ResponseBody{}, false, err
+	}
+
+	// Локали отдаются отдельным методом
+	banner.Locales = nil
+
+	return *banner, true, nil
+}
+
+// Баннеры-кандидаты для фичи и тэгов пользователя: баннеры каждой пары с тэгом
+// и приоритетом пары (если для пары идет эксперимент - все его варианты с весами)
+// и баннер фичи по умолчанию с нулевым тэгом. Правило выбора применяет репозиторий
+func (d dbase) GetBanner(ctx context.Context, featureID int, tagIDs []int) ([]models.UserBanner, error) {
+
+	banners := make([]models.UserBanner, 0)
 
-	row := d.db.QueryRowContext(ctx, `SELECT actual_banner.title,
+	tags := make([]int64, 0, len(tagIDs))
+	for _, tagID := range tagIDs {
+		tags = append(tags, int64(tagID))
+	}
+
+	rows, err := d.db.QueryContext(ctx, `SELECT actual_banner.banner_id,
+								actual_banner.title,
 								actual_banner.text,
-								actual_banner.url
-								FROM actual_banner
-								INNER JOIN tag_feature
-								ON actual_banner.banner_id = tag_feature.banner_id
-								WHERE tag_feature.tag_id = $1
+								actual_banner.url,
+								COALESCE(experiment_variant.weight, 0),
+								tag_feature.tag_id,
+								control.priority
+								FROM tag_feature
+								INNER JOIN actual_banner control
+								ON control.banner_id = tag_feature.banner_id
+								LEFT JOIN experiment_variant
+								ON experiment_variant.tenant = tag_feature.tenant
+								AND experiment_variant.feature_id = tag_feature.feature_id
+								AND experiment_variant.tag_id = tag_feature.tag_id
+								INNER JOIN actual_banner
+								ON actual_banner.banner_id = COALESCE(experiment_variant.banner_id, tag_feature.banner_id)
+								WHERE tag_feature.tenant = $3
+								AND tag_feature.tag_id = ANY($1)
 								AND tag_feature.feature_id = $2
-								AND actual_banner.is_active = true`,
-		tagID,
+								AND control.is_active = true
+								AND control.deleted_at IS NULL
+								AND actual_banner.is_active = true
+								AND actual_banner.deleted_at IS NULL
+								UNION ALL
+								SELECT actual_banner.banner_id,
+								actual_banner.title,
+								actual_banner.text,
+								actual_banner.url,
+								0,
+								0,
+								actual_banner.priority
+								FROM feature_default
+								INNER JOIN actual_banner
+								ON actual_banner.banner_id = feature_default.banner_id
+								WHERE feature_default.tenant = $3
+								AND feature_default.feature_id = $2
+								AND actual_banner.is_active = true
+								AND actual_banner.deleted_at IS NULL
+								ORDER BY 6, 1`,
+		tags,
 		featureID,
+		token.Tenant(ctx),
 	)
-	if err := row.Scan(&banner.Title, &banner.Text, &banner.Url); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return models.BannerContent{}, nil // Условимся, что если не нашли баннер, то ничего не возвращаем
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var banner models.UserBanner
+
+		err = rows.Scan(&banner.BannerID, &banner.Content.Title, &banner.Content.Text, &banner.Content.Url, &banner.Weight,
+			&banner.TagID, &banner.Priority)
+		if err != nil {
+			return nil, err
 		}
-		return models.BannerContent{}, err
+
+		banners = append(banners, banner)
 	}
-	return banner, nil
+
+	// проверяем на ошибки, если не нашли баннер, то возвращаем пустой список
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(banners) == 0 {
+		return banners, nil
+	}
+
+	// Локали отдаются вместе с баннером, язык выбирается при ответе пользователю
+	ids := make([]int64, 0, len(banners))
+	for _, banner := range banners {
+		ids = append(ids, int64(banner.BannerID))
+	}
+
+	localeRows, err := d.db.QueryContext(ctx, bannerLocalesQuery, ids)
+	if err != nil {
+		return nil, err
+	}
+	locales, err := scanLocales(localeRows)
+	if err != nil {
+		return nil, err
+	}
+	for i := range banners {
+		banners[i].Locales = locales[banners[i].BannerID]
+	}
+
+	return banners, nil
 }
 
-// Просто удаление из БД баннера
+// Мягкое удаление баннера: баннер помечается удаленным, а его пары фича + тэг
+// переносятся в deleted_tag_feature, чтобы их можно было занять новыми баннерами.
+// История сохраняется до окончательной очистки
 func (d dbase) DeleteBanner(ctx context.Context, bannerID int) error {
 	tx, err := d.db.Begin()
 	if err != nil {
@@ -369,8 +721,55 @@ func (d dbase) DeleteBanner(ctx context.Context, bannerID int) error {
 
 	defer tx.Rollback()
 
-	// Удаляем из всех таблиц
-	_, err = tx.ExecContext(ctx, `DELETE FROM tag_feature
+	// Состояние баннера до удаления для журнала аудита
+	before, err := snapshot(ctx, tx, bannerID)
+	if err != nil {
+		return err
+	}
+
+	// Удаленный или несуществующий баннер повторно не удаляем
+	if before == nil {
+		return nil
+	}
+
+	// Эксперименты пар баннера заканчиваются вместе с ним, иначе варианты показывались бы
+	// вместо нового баннера пары
+	rows, err := tx.QueryContext(ctx, `SELECT DISTINCT experiment_variant.feature_id,
+									experiment_variant.tag_id
+									FROM experiment_variant
+									INNER JOIN tag_feature
+									ON tag_feature.tenant = experiment_variant.tenant
+									AND tag_feature.feature_id = experiment_variant.feature_id
+									AND tag_feature.tag_id = experiment_variant.tag_id
+									WHERE tag_feature.banner_id = $1`,
+		bannerID,
+	)
+	if err != nil {
+		return err
+	}
+
+	experiments := make([][2]uint32, 0)
+	for rows.Next() {
+		var featureID, tagID uint32
+		if err = rows.Scan(&featureID, &tagID); err != nil {
+			rows.Close()
+			return err
+		}
+		experiments = append(experiments, [2]uint32{featureID, tagID})
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, experiment := range experiments {
+		if err = dropExperiment(ctx, tx, experiment[0], experiment[1], bannerID); err != nil {
+			return err
+		}
+	}
+
+	// Удаленный вариант больше не участвует в эксперименте
+	_, err = tx.ExecContext(ctx, `DELETE FROM experiment_variant
 									WHERE banner_id = $1`,
 		bannerID,
 	)
@@ -378,7 +777,20 @@ func (d dbase) DeleteBanner(ctx context.Context, bannerID int) error {
 		return err
 	}
 
-	_, err = tx.ExecContext(ctx, `DELETE FROM actual_banner
+	// Освобождаем пары фича + тэг
+	_, err = tx.ExecContext(ctx, `INSERT INTO deleted_tag_feature
+									(tenant, feature_id, tag_id, banner_id)
+									SELECT tenant, feature_id, tag_id, banner_id
+									FROM tag_feature
+									WHERE banner_id = $1
+									ON CONFLICT DO NOTHING`,
+		bannerID,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tag_feature
 									WHERE banner_id = $1`,
 		bannerID,
 	)
@@ -386,7 +798,8 @@ func (d dbase) DeleteBanner(ctx context.Context, bannerID int) error {
 		return err
 	}
 
-	_, err = tx.ExecContext(ctx, `DELETE FROM history_banner
+	_, err = tx.ExecContext(ctx, `UPDATE actual_banner
+									SET deleted_at = now()
 									WHERE banner_id = $1`,
 		bannerID,
 	)
@@ -394,6 +807,10 @@ func (d dbase) DeleteBanner(ctx context.Context, bannerID int) error {
 		return err
 	}
 
+	if err = recordChange(ctx, tx, models.AuditDelete, bannerID, before, nil); err != nil {
+		return err
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return err
@@ -407,8 +824,13 @@ func (d dbase) GetHistoryBanner(ctx context.Context, bannerID int) ([]models.Ban
 
 	rows, err := d.db.QueryContext(ctx, `SELECT banner_id, version, title, text, url
 											FROM history_banner
-											WHERE banner_id = $1`,
+											WHERE banner_id = $1
+											AND EXISTS (SELECT 1
+												FROM actual_banner
+												WHERE actual_banner.banner_id = history_banner.banner_id
+												AND actual_banner.tenant = $2)`,
 		bannerID,
+		token.Tenant(ctx),
 	)
 	if err != nil {
 		return nil, err
@@ -437,18 +859,53 @@ func (d dbase) GetHistoryBanner(ctx context.Context, bannerID int) ([]models.Ban
 }
 
 func (d dbase) UpdateVersion(ctx context.Context, bannerVersion models.BannerHistory) error {
-	_, err := d.db.ExecContext(ctx, `UPDATE actual_banner
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	bannerID := int(bannerVersion.BannerID)
+
+	// Состояние баннера до замены версии для журнала аудита
+	before, err := snapshot(ctx, tx, bannerID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE actual_banner
 									SET title = $1,
 									text = $2,
-									url = $3,
-									WHERE banner_id = $4`,
+									url = $3
+									WHERE banner_id = $4
+									AND tenant = $5
+									AND deleted_at IS NULL`,
 		bannerVersion.Title,
 		bannerVersion.Text,
 		bannerVersion.Url,
 		bannerVersion.BannerID,
+		token.Tenant(ctx),
 	)
 	if err != nil {
 		return err
 	}
-	return nil
+
+	after, err := snapshot(ctx, tx, bannerID)
+	if err != nil {
+		return err
+	}
+
+	if after != nil {
+		if err = recordChange(ctx, tx, models.AuditVersionSwitch, bannerID, before, after); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Close waits for queries in progress and closes connections of pool
+func (d dbase) Close() error {
+	return d.db.Close()
 }
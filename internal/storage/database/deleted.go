@@ -0,0 +1,261 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+)
+
+// Удаленные баннеры проекта, которые были удалены не раньше deletedAfter
+func (d dbase) GetDeletedBanners(ctx context.Context, deletedAfter time.Time) ([]models.DeletedBanner, error) {
+
+	banners := make([]models.DeletedBanner, 0)
+
+	rows, err := d.db.QueryContext(ctx, `SELECT actual_banner.banner_id,
+										actual_banner.title,
+										actual_banner.text,
+										actual_banner.url,
+										actual_banner.is_active,
+										actual_banner.deleted_at,
+										deleted_tag_feature.feature_id,
+										deleted_tag_feature.tag_id
+										FROM actual_banner
+										LEFT JOIN deleted_tag_feature
+										ON actual_banner.banner_id = deleted_tag_feature.banner_id
+										WHERE actual_banner.tenant = $2
+										AND actual_banner.deleted_at IS NOT NULL
+										AND actual_banner.deleted_at >= $1
+										ORDER BY actual_banner.banner_id, deleted_tag_feature.tag_id`,
+		deletedAfter,
+		token.Tenant(ctx),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var (
+			banner       models.DeletedBanner
+			feature, tag sql.NullInt64
+		)
+
+		err = rows.Scan(&banner.BannerID, &banner.Content.Title, &banner.Content.Text, &banner.Content.Url,
+			&banner.Active, &banner.DeletedAt, &feature, &tag)
+		if err != nil {
+			return nil, err
+		}
+
+		// Строки одного баннера идут подряд, собираем его тэги
+		if len(banners) == 0 || banners[len(banners)-1].BannerID != banner.BannerID {
+			banner.TagID = make([]uint32, 0)
+			banners = append(banners, banner)
+		}
+
+		last := &banners[len(banners)-1]
+		if feature.Valid && tag.Valid {
+			last.FeatureID = uint32(feature.Int64)
+			last.TagID = append(last.TagID, uint32(tag.Int64))
+		}
+	}
+
+	// проверяем на ошибки
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return banners, nil
+}
+
+// Восстановление удаленного баннера, если он удален не раньше deletedAfter
+// и его пары фича + тэг еще никем в проекте не заняты
+func (d dbase) RestoreBanner(ctx context.Context, bannerID int, deletedAfter time.Time) (bool, error) {
+
+	var taken bool
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	defer tx.Rollback()
+
+	// Баннер должен быть удален и находиться в окне восстановления
+	res, err := tx.ExecContext(ctx, `UPDATE actual_banner
+									SET deleted_at = NULL
+									WHERE banner_id = $1
+									AND tenant = $3
+									AND deleted_at IS NOT NULL
+									AND deleted_at >= $2`,
+		bannerID,
+		deletedAfter,
+		token.Tenant(ctx),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	// Проверяем, что пары фича + тэг свободны
+	row := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1
+									FROM deleted_tag_feature
+									INNER JOIN tag_feature
+									ON deleted_tag_feature.tenant = tag_feature.tenant
+									AND deleted_tag_feature.feature_id = tag_feature.feature_id
+									AND deleted_tag_feature.tag_id = tag_feature.tag_id
+									WHERE deleted_tag_feature.banner_id = $1)`, bannerID)
+	if err = row.Scan(&taken); err != nil {
+		return false, err
+	}
+
+	if taken {
+		return false, models.ErrPairTaken
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO tag_feature
+									(tenant, feature_id, tag_id, banner_id)
+									SELECT tenant, feature_id, tag_id, banner_id
+									FROM deleted_tag_feature
+									WHERE banner_id = $1`,
+		bannerID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM deleted_tag_feature
+									WHERE banner_id = $1`,
+		bannerID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	after, err := snapshot(ctx, tx, bannerID)
+	if err != nil {
+		return false, err
+	}
+
+	if err = recordChange(ctx, tx, models.AuditRestore, bannerID, nil, after); err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Окончательное удаление баннеров всех проектов, удаленных раньше deletedBefore
+func (d dbase) PurgeBanners(ctx context.Context, deletedBefore time.Time) (int, error) {
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `DELETE FROM actual_banner
+									WHERE deleted_at IS NOT NULL
+									AND deleted_at < $1
+									RETURNING banner_id`,
+		deletedBefore,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	// Удаляем из остальных таблиц
+	_, err = tx.ExecContext(ctx, `DELETE FROM deleted_tag_feature
+									WHERE banner_id = ANY($1)`,
+		ids,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM history_banner
+									WHERE banner_id = ANY($1)`,
+		ids,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM banner_locale
+									WHERE banner_id = ANY($1)`,
+		ids,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM history_banner_locale
+									WHERE banner_id = ANY($1)`,
+		ids,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM feature_default
+									WHERE banner_id = ANY($1)`,
+		ids,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM banner_draft_comment
+									WHERE draft_id IN (SELECT draft_id
+										FROM banner_draft
+										WHERE banner_id = ANY($1))`,
+		ids,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM banner_draft
+									WHERE banner_id = ANY($1)`,
+		ids,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return len(ids), nil
+}
@@ -0,0 +1,454 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+)
+
+// Черновики изменений содержимого баннеров и комментарии к ним.
+// Черновики удаленного баннера не видны, пока он не восстановлен
+func createDrafts(db *sql.DB) error {
+
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS banner_draft
+					(draft_id BIGSERIAL PRIMARY KEY,
+					tenant text NOT NULL,
+					banner_id bigint NOT NULL,
+					title text NOT NULL,
+					text text NOT NULL,
+					url text NOT NULL,
+					status text NOT NULL,
+					author text NOT NULL,
+					reviewer text NOT NULL DEFAULT '',
+					created_at timestamptz NOT NULL DEFAULT now(),
+					updated_at timestamptz NOT NULL DEFAULT now());
+					CREATE INDEX IF NOT EXISTS banner_draft_tenant_status_idx ON banner_draft (tenant, status);
+					CREATE INDEX IF NOT EXISTS banner_draft_banner_id_idx ON banner_draft (banner_id);
+					CREATE TABLE IF NOT EXISTS banner_draft_comment
+					(id BIGSERIAL PRIMARY KEY,
+					draft_id bigint NOT NULL,
+					author text NOT NULL,
+					action text NOT NULL,
+					text text NOT NULL,
+					created_at timestamptz NOT NULL DEFAULT now());
+					CREATE INDEX IF NOT EXISTS banner_draft_comment_draft_id_idx ON banner_draft_comment (draft_id)`)
+
+	return err
+}
+
+type emergencyKey struct{}
+
+// WithEmergency отмечает изменения баннеров в обход проверки черновиков,
+// журнал аудита записывает их действием emergency_update
+func WithEmergency(ctx context.Context) context.Context {
+	return context.WithValue(ctx, emergencyKey{}, true)
+}
+
+// Действие аудита для обновления баннера
+func updateAction(ctx context.Context) string {
+	if emergency, _ := ctx.Value(emergencyKey{}).(bool); emergency {
+		return models.AuditEmergency
+	}
+	return models.AuditUpdate
+}
+
+// Статус черновика после действия автора запроса. Отправляет на проверку только автор,
+// одобряет и отклоняет только другой администратор, комментировать можно всегда
+func nextDraftStatus(draft models.Draft, action, actor string) (string, error) {
+	switch action {
+	case models.DraftActionSubmit:
+		if draft.Status != models.DraftStatusDraft && draft.Status != models.DraftStatusRejected {
+			return "", models.ErrDraftStatus
+		}
+		if actor != draft.Author {
+			return "", models.ErrNotDraftAuthor
+		}
+		return models.DraftStatusPending, nil
+	case models.DraftActionApprove, models.DraftActionReject:
+		if draft.Status != models.DraftStatusPending {
+			return "", models.ErrDraftStatus
+		}
+		if actor == draft.Author {
+			return "", models.ErrSelfReview
+		}
+		if action == models.DraftActionApprove {
+			return models.DraftStatusPublished, nil
+		}
+		return models.DraftStatusRejected, nil
+	default:
+		return draft.Status, nil
+	}
+}
+
+// Содержимое действующего баннера проекта, false если баннера нет
+func (d dbase) GetBannerContent(ctx context.Context, bannerID int) (models.BannerContent, bool, error) {
+
+	var content models.BannerContent
+
+	err := d.db.QueryRowContext(ctx, `SELECT title, text, url
+									FROM actual_banner
+									WHERE banner_id = $1
+									AND tenant = $2
+									AND deleted_at IS NULL`,
+		bannerID,
+		token.Tenant(ctx),
+	).Scan(&content.Title, &content.Text, &content.Url)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.BannerContent{}, false, nil
+		}
+		return models.BannerContent{}, false, err
+	}
+
+	return content, true, nil
+}
+
+// Создание черновика, сразу отправленного на проверку, если передан submit.
+// false если баннера нет
+func (d dbase) CreateDraft(ctx context.Context, draftRequest models.DraftRequest) (models.Draft, bool, error) {
+
+	var draftID int64
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return models.Draft{}, false, err
+	}
+
+	defer tx.Rollback()
+
+	live, err := snapshot(ctx, tx, int(draftRequest.BannerID))
+	if err != nil || live == nil {
+		return models.Draft{}, false, err
+	}
+
+	status, action := models.DraftStatusDraft, models.DraftActionComment
+	if draftRequest.Submit {
+		status, action = models.DraftStatusPending, models.DraftActionSubmit
+	}
+
+	err = tx.QueryRowContext(ctx, `INSERT INTO banner_draft
+									(tenant, banner_id, title, text, url, status, author)
+									VALUES($1, $2, $3, $4, $5, $6, $7)
+									RETURNING draft_id`,
+		token.Tenant(ctx),
+		draftRequest.BannerID,
+		draftRequest.Content.Title,
+		draftRequest.Content.Text,
+		draftRequest.Content.Url,
+		status,
+		token.Actor(ctx),
+	).Scan(&draftID)
+	if err != nil {
+		return models.Draft{}, false, err
+	}
+
+	if err = addDraftComment(ctx, tx, draftID, action, draftRequest.Comment); err != nil {
+		return models.Draft{}, false, err
+	}
+
+	if draftRequest.Submit {
+		if err = requestReview(ctx, tx, live, draftRequest.Content); err != nil {
+			return models.Draft{}, false, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.Draft{}, false, err
+	}
+
+	return d.GetDraft(ctx, draftID)
+}
+
+// Черновики проекта по баннеру и статусу
+func (d dbase) GetDrafts(ctx context.Context, draftQuery models.DraftQuery) ([]models.Draft, error) {
+	return d.queryDrafts(ctx, draftQuery, 0)
+}
+
+// Черновик проекта по ID, false если его нет
+func (d dbase) GetDraft(ctx context.Context, draftID int64) (models.Draft, bool, error) {
+
+	drafts, err := d.queryDrafts(ctx, models.DraftQuery{}, draftID)
+	if err != nil || len(drafts) == 0 {
+		return models.Draft{}, false, err
+	}
+
+	return drafts[0], true, nil
+}
+
+func (d dbase) queryDrafts(ctx context.Context, draftQuery models.DraftQuery, draftID int64) ([]models.Draft, error) {
+
+	var (
+		conditions []string
+		args       []interface{}
+	)
+
+	drafts := make([]models.Draft, 0)
+
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.Replace(condition, "?", "$"+strconv.Itoa(len(args)), 1))
+	}
+
+	addCondition("banner_draft.tenant = ?", token.Tenant(ctx))
+
+	if draftID != 0 {
+		addCondition("banner_draft.draft_id = ?", draftID)
+	}
+	if draftQuery.BannerID != 0 {
+		addCondition("banner_draft.banner_id = ?", draftQuery.BannerID)
+	}
+	if draftQuery.Status != "" {
+		addCondition("banner_draft.status = ?", draftQuery.Status)
+	}
+
+	rows, err := d.db.QueryContext(ctx, `SELECT banner_draft.draft_id,
+										banner_draft.banner_id,
+										banner_draft.title,
+										banner_draft.text,
+										banner_draft.url,
+										banner_draft.status,
+										banner_draft.author,
+										banner_draft.reviewer,
+										banner_draft.created_at,
+										banner_draft.updated_at
+										FROM banner_draft
+										INNER JOIN actual_banner
+										ON actual_banner.banner_id = banner_draft.banner_id
+										AND actual_banner.deleted_at IS NULL
+										WHERE `+strings.Join(conditions, " AND ")+`
+										ORDER BY banner_draft.draft_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0)
+	index := make(map[int64]int)
+	for rows.Next() {
+		var draft models.Draft
+		err = rows.Scan(&draft.DraftID, &draft.BannerID, &draft.Content.Title, &draft.Content.Text, &draft.Content.Url,
+			&draft.Status, &draft.Author, &draft.Reviewer, &draft.CreatedAt, &draft.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		draft.Comments = make([]models.DraftComment, 0)
+		index[draft.DraftID] = len(drafts)
+		ids = append(ids, draft.DraftID)
+		drafts = append(drafts, draft)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		return drafts, nil
+	}
+
+	comments, err := d.db.QueryContext(ctx, `SELECT draft_id, author, action, text, created_at
+											FROM banner_draft_comment
+											WHERE draft_id = ANY($1)
+											ORDER BY id`, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer comments.Close()
+
+	for comments.Next() {
+		var (
+			id      int64
+			comment models.DraftComment
+		)
+		if err = comments.Scan(&id, &comment.Author, &comment.Action, &comment.Text, &comment.CreatedAt); err != nil {
+			return nil, err
+		}
+		draft := &drafts[index[id]]
+		draft.Comments = append(draft.Comments, comment)
+	}
+
+	return drafts, comments.Err()
+}
+
+// Действие с черновиком. Одобренный черновик сразу становится актуальным содержимым баннера
+// и записывается в журнал аудита действием publish. false если черновика нет
+func (d dbase) UpdateDraft(ctx context.Context, draftID int64, draftAction models.DraftAction) (models.Draft, bool, error) {
+
+	var draft models.Draft
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return models.Draft{}, false, err
+	}
+
+	defer tx.Rollback()
+
+	// Действия с одним черновиком выполняются по очереди, иначе его одобрят дважды
+	row := tx.QueryRowContext(ctx, `SELECT banner_draft.banner_id,
+									banner_draft.title,
+									banner_draft.text,
+									banner_draft.url,
+									banner_draft.status,
+									banner_draft.author,
+									banner_draft.reviewer
+									FROM banner_draft
+									INNER JOIN actual_banner
+									ON actual_banner.banner_id = banner_draft.banner_id
+									AND actual_banner.deleted_at IS NULL
+									WHERE banner_draft.draft_id = $1
+									AND banner_draft.tenant = $2
+									FOR UPDATE OF banner_draft`, draftID, token.Tenant(ctx))
+	err = row.Scan(&draft.BannerID, &draft.Content.Title, &draft.Content.Text, &draft.Content.Url,
+		&draft.Status, &draft.Author, &draft.Reviewer)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.Draft{}, false, nil
+		}
+		return models.Draft{}, false, err
+	}
+
+	actor := token.Actor(ctx)
+
+	status, err := nextDraftStatus(draft, draftAction.Action, actor)
+	if err != nil {
+		return models.Draft{}, false, err
+	}
+
+	switch draftAction.Action {
+	case models.DraftActionSubmit:
+		draft.Reviewer = ""
+		if draftAction.Content != nil {
+			draft.Content = *draftAction.Content
+		}
+	case models.DraftActionApprove, models.DraftActionReject:
+		draft.Reviewer = actor
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE banner_draft
+								SET title = $1,
+								text = $2,
+								url = $3,
+								status = $4,
+								reviewer = $5,
+								updated_at = now()
+								WHERE draft_id = $6`,
+		draft.Content.Title,
+		draft.Content.Text,
+		draft.Content.Url,
+		status,
+		draft.Reviewer,
+		draftID,
+	)
+	if err != nil {
+		return models.Draft{}, false, err
+	}
+
+	if err = addDraftComment(ctx, tx, draftID, draftAction.Action, draftAction.Comment); err != nil {
+		return models.Draft{}, false, err
+	}
+
+	switch draftAction.Action {
+	case models.DraftActionSubmit:
+		live, err := snapshot(ctx, tx, int(draft.BannerID))
+		if err != nil {
+			return models.Draft{}, false, err
+		}
+		if err = requestReview(ctx, tx, live, draft.Content); err != nil {
+			return models.Draft{}, false, err
+		}
+	case models.DraftActionApprove:
+		if err = publishDraft(ctx, tx, int(draft.BannerID), draft.Content); err != nil {
+			return models.Draft{}, false, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.Draft{}, false, err
+	}
+
+	return d.GetDraft(ctx, draftID)
+}
+
+// Комментарий к черновику, пустой не сохраняется
+func addDraftComment(ctx context.Context, tx *sql.Tx, draftID int64, action, text string) error {
+
+	if text == "" {
+		return nil
+	}
+
+	_, err := tx.ExecContext(ctx, `INSERT INTO banner_draft_comment
+								(draft_id, author, action, text)
+								VALUES($1, $2, $3, $4)`,
+		draftID,
+		token.Actor(ctx),
+		action,
+		text,
+	)
+
+	return err
+}
+
+// Событие о черновике, ожидающем проверки: до - действующий баннер, после - баннер с содержимым черновика
+func requestReview(ctx context.Context, tx *sql.Tx, live *models.ResponseBody, content models.BannerContent) error {
+
+	proposed := *live
+	proposed.Content = content
+
+	return writeOutbox(ctx, tx, models.EventReviewRequested, int(live.BannerID), token.Actor(ctx), live, &proposed)
+}
+
+// Содержимое одобренного черновика становится актуальным, новая версия появляется,
+// как и при обновлении баннера, только если содержимое отличается от последней версии
+func publishDraft(ctx context.Context, tx *sql.Tx, bannerID int, content models.BannerContent) error {
+
+	var last models.BannerHistory
+
+	_, err := tx.ExecContext(ctx, `SELECT 1 FROM actual_banner WHERE banner_id = $1 FOR UPDATE`, bannerID)
+	if err != nil {
+		return err
+	}
+
+	before, err := snapshot(ctx, tx, bannerID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE actual_banner
+								SET title = $1,
+								text = $2,
+								url = $3
+								WHERE banner_id = $4`,
+		content.Title,
+		content.Text,
+		content.Url,
+		bannerID,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, `SELECT title, text, url, version
+									FROM history_banner
+									WHERE banner_id = $1
+									ORDER BY version DESC
+									LIMIT 1`, bannerID).Scan(&last.Title, &last.Text, &last.Url, &last.Version)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if last.Title != content.Title || last.Text != content.Text || last.Url != content.Url {
+		if err = insertVersion(ctx, tx, bannerID, last.Version+1, content); err != nil {
+			return err
+		}
+	}
+
+	after, err := snapshot(ctx, tx, bannerID)
+	if err != nil {
+		return err
+	}
+
+	return recordChange(ctx, tx, models.AuditPublish, bannerID, before, after)
+}
@@ -0,0 +1,392 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+)
+
+// Создание эксперимента для пары фича + тэг. Варианты создаются отдельными баннерами
+// без пар фича + тэг, контрольным вариантом становится текущий баннер пары.
+// Второе значение false, если у пары нет баннера
+func (d dbase) CreateExperiment(ctx context.Context, experimentRequest models.ExperimentRequest) (models.Experiment, bool, error) {
+
+	var (
+		controlID int
+		exists    bool
+	)
+
+	tenant := token.Tenant(ctx)
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return models.Experiment{}, false, err
+	}
+
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx, `SELECT banner_id
+									FROM tag_feature
+									WHERE tenant = $3
+									AND feature_id = $1
+									AND tag_id = $2
+									FOR UPDATE`,
+		experimentRequest.FeatureID,
+		experimentRequest.TagID,
+		tenant,
+	)
+	if err = row.Scan(&controlID); err != nil {
+		if err == sql.ErrNoRows {
+			return models.Experiment{}, false, nil
+		}
+		return models.Experiment{}, false, err
+	}
+
+	row = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1
+									FROM experiment_variant
+									WHERE tenant = $3
+									AND feature_id = $1
+									AND tag_id = $2)`,
+		experimentRequest.FeatureID,
+		experimentRequest.TagID,
+		tenant,
+	)
+	if err = row.Scan(&exists); err != nil {
+		return models.Experiment{}, false, err
+	}
+
+	if exists {
+		return models.Experiment{}, false, models.ErrExperimentExists
+	}
+
+	// Контрольный вариант
+	if err = insertVariant(ctx, tx, experimentRequest.FeatureID, experimentRequest.TagID, controlID, experimentRequest.ControlWeight); err != nil {
+		return models.Experiment{}, false, err
+	}
+
+	for _, variant := range experimentRequest.Variants {
+
+		var id int
+
+		err = tx.QueryRowContext(ctx, `INSERT INTO actual_banner (tenant, title, text, url)
+										VALUES ($1, $2, $3, $4) RETURNING banner_id`,
+			tenant,
+			variant.Content.Title,
+			variant.Content.Text,
+			variant.Content.Url).Scan(&id)
+		if err != nil {
+			return models.Experiment{}, false, err
+		}
+
+		if err = insertVersion(ctx, tx, id, 1, variant.Content); err != nil {
+			return models.Experiment{}, false, err
+		}
+
+		if err = insertVariant(ctx, tx, experimentRequest.FeatureID, experimentRequest.TagID, id, variant.Weight); err != nil {
+			return models.Experiment{}, false, err
+		}
+
+		after, err := snapshot(ctx, tx, id)
+		if err != nil {
+			return models.Experiment{}, false, err
+		}
+
+		if err = recordChange(ctx, tx, models.AuditCreate, id, nil, after); err != nil {
+			return models.Experiment{}, false, err
+		}
+	}
+
+	experiment, err := getExperiment(ctx, tx, int(experimentRequest.FeatureID), int(experimentRequest.TagID))
+	if err != nil {
+		return models.Experiment{}, false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.Experiment{}, false, err
+	}
+
+	return experiment, true, nil
+}
+
+// Эксперимент пары фича + тэг со статистикой вариантов, второе значение false если эксперимента нет
+func (d dbase) GetExperiment(ctx context.Context, featureID, tagID int) (models.Experiment, bool, error) {
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return models.Experiment{}, false, err
+	}
+
+	defer tx.Rollback()
+
+	experiment, err := getExperiment(ctx, tx, featureID, tagID)
+	if err != nil {
+		return models.Experiment{}, false, err
+	}
+
+	return experiment, len(experiment.Variants) > 0, nil
+}
+
+// Выбор победителя: содержимое победителя становится новой версией контрольного баннера,
+// остальные варианты удаляются. Второе значение false, если эксперимента нет
+func (d dbase) DeclareWinner(ctx context.Context, winner models.WinnerRequest) (bool, error) {
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	defer tx.Rollback()
+
+	experiment, err := getExperiment(ctx, tx, int(winner.FeatureID), int(winner.TagID))
+	if err != nil {
+		return false, err
+	}
+
+	if len(experiment.Variants) == 0 {
+		return false, nil
+	}
+
+	var found *models.ExperimentVariant
+	for i := range experiment.Variants {
+		if experiment.Variants[i].BannerID == winner.BannerID {
+			found = &experiment.Variants[i]
+		}
+	}
+
+	if found == nil {
+		return false, models.ErrNotVariant
+	}
+
+	controlID := int(experiment.ControlID)
+
+	before, err := snapshot(ctx, tx, controlID)
+	if err != nil {
+		return false, err
+	}
+
+	// Переносим содержимое победителя в контрольный баннер
+	if found.BannerID != experiment.ControlID {
+
+		var version int
+
+		_, err = tx.ExecContext(ctx, `UPDATE actual_banner
+									SET title = $1,
+									text = $2,
+									url = $3
+									WHERE banner_id = $4`,
+			found.Content.Title,
+			found.Content.Text,
+			found.Content.Url,
+			controlID,
+		)
+		if err != nil {
+			return false, err
+		}
+
+		row := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0)
+										FROM history_banner
+										WHERE banner_id = $1`, controlID)
+		if err = row.Scan(&version); err != nil {
+			return false, err
+		}
+
+		if err = insertVersion(ctx, tx, controlID, version+1, found.Content); err != nil {
+			return false, err
+		}
+	}
+
+	// Удаляем эксперимент и баннеры вариантов
+	if err = dropExperiment(ctx, tx, winner.FeatureID, winner.TagID, controlID); err != nil {
+		return false, err
+	}
+
+	after, err := snapshot(ctx, tx, controlID)
+	if err != nil {
+		return false, err
+	}
+
+	if err = recordChange(ctx, tx, models.AuditExperiment, controlID, before, after); err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Удаление эксперимента пары и баннеров его вариантов, контрольный баннер остается
+func dropExperiment(ctx context.Context, tx *sql.Tx, featureID, tagID uint32, controlID int) error {
+
+	rows, err := tx.QueryContext(ctx, `SELECT banner_id
+									FROM experiment_variant
+									WHERE tenant = $3
+									AND feature_id = $1
+									AND tag_id = $2
+									AND banner_id <> $4`,
+		featureID,
+		tagID,
+		token.Tenant(ctx),
+		controlID,
+	)
+	if err != nil {
+		return err
+	}
+
+	variantIDs := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		variantIDs = append(variantIDs, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM experiment_variant
+									WHERE tenant = $3
+									AND feature_id = $1
+									AND tag_id = $2`,
+		featureID,
+		tagID,
+		token.Tenant(ctx),
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range variantIDs {
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM actual_banner
+										WHERE banner_id = $1`, id)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM history_banner
+										WHERE banner_id = $1`, id)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM banner_locale
+										WHERE banner_id = $1`, id)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM history_banner_locale
+										WHERE banner_id = $1`, id)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM banner_draft_comment
+										WHERE draft_id IN (SELECT draft_id
+											FROM banner_draft
+											WHERE banner_id = $1)`, id)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM banner_draft
+										WHERE banner_id = $1`, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func insertVariant(ctx context.Context, tx *sql.Tx, featureID, tagID uint32, bannerID, weight int) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO experiment_variant
+								(tenant, feature_id, tag_id, banner_id, weight)
+								VALUES($1, $2, $3, $4, $5)`,
+		token.Tenant(ctx),
+		featureID,
+		tagID,
+		bannerID,
+		weight,
+	)
+	return err
+}
+
+// Варианты эксперимента с показами и кликами по паре фича + тэг проекта
+func getExperiment(ctx context.Context, tx *sql.Tx, featureID, tagID int) (models.Experiment, error) {
+
+	tenant := token.Tenant(ctx)
+
+	experiment := models.Experiment{
+		FeatureID: uint32(featureID),
+		TagID:     uint32(tagID),
+		Variants:  make([]models.ExperimentVariant, 0),
+	}
+
+	row := tx.QueryRowContext(ctx, `SELECT banner_id
+									FROM tag_feature
+									WHERE tenant = $3
+									AND feature_id = $1
+									AND tag_id = $2`, featureID, tagID, tenant)
+	if err := row.Scan(&experiment.ControlID); err != nil {
+		if err == sql.ErrNoRows {
+			return experiment, nil
+		}
+		return models.Experiment{}, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT experiment_variant.banner_id,
+									experiment_variant.weight,
+									actual_banner.title,
+									actual_banner.text,
+									actual_banner.url,
+									COALESCE(SUM(banner_stats.impressions), 0),
+									COALESCE(SUM(banner_stats.clicks), 0)
+									FROM experiment_variant
+									INNER JOIN actual_banner
+									ON actual_banner.banner_id = experiment_variant.banner_id
+									LEFT JOIN banner_stats
+									ON banner_stats.banner_id = experiment_variant.banner_id
+									AND banner_stats.feature_id = experiment_variant.feature_id
+									AND banner_stats.tag_id = experiment_variant.tag_id
+									WHERE experiment_variant.tenant = $3
+									AND experiment_variant.feature_id = $1
+									AND experiment_variant.tag_id = $2
+									GROUP BY experiment_variant.banner_id, experiment_variant.weight,
+									actual_banner.title, actual_banner.text, actual_banner.url
+									ORDER BY experiment_variant.banner_id`,
+		featureID,
+		tagID,
+		tenant,
+	)
+	if err != nil {
+		return models.Experiment{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var variant models.ExperimentVariant
+
+		err = rows.Scan(&variant.BannerID, &variant.Weight, &variant.Content.Title, &variant.Content.Text,
+			&variant.Content.Url, &variant.Impressions, &variant.Clicks)
+		if err != nil {
+			return models.Experiment{}, err
+		}
+
+		experiment.Variants = append(experiment.Variants, variant)
+	}
+
+	if err = rows.Err(); err != nil {
+		return models.Experiment{}, err
+	}
+
+	return experiment, nil
+}
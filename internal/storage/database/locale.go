@@ -0,0 +1,281 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+)
+
+// Содержимое баннеров на других языках и история каждой локали.
+// Проект локали определяется баннером, как и у истории
+func createLocales(db *sql.DB) error {
+
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS banner_locale
+					(banner_id bigint NOT NULL,
+					locale text NOT NULL,
+					title text NOT NULL,
+					text text NOT NULL,
+					url text NOT NULL,
+					version int NOT NULL,
+					PRIMARY KEY (banner_id, locale));
+					CREATE TABLE IF NOT EXISTS history_banner_locale
+					(banner_id bigint NOT NULL,
+					locale text NOT NULL,
+					version int NOT NULL,
+					title text NOT NULL,
+					text text NOT NULL,
+					url text NOT NULL,
+					PRIMARY KEY (banner_id, locale, version))`)
+
+	return err
+}
+
+// Локали баннеров по запросу bannerLocalesQuery, ключ внешней карты - ID баннера
+func scanLocales(rows *sql.Rows) (map[uint32]map[string]models.BannerContent, error) {
+
+	defer rows.Close()
+
+	locales := make(map[uint32]map[string]models.BannerContent)
+	for rows.Next() {
+		var (
+			bannerID uint32
+			locale   string
+			content  models.BannerContent
+		)
+		if err := rows.Scan(&bannerID, &locale, &content.Title, &content.Text, &content.Url); err != nil {
+			return nil, err
+		}
+		if locales[bannerID] == nil {
+			locales[bannerID] = make(map[string]models.BannerContent)
+		}
+		locales[bannerID][locale] = content
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return locales, nil
+}
+
+const bannerLocalesQuery = `SELECT banner_id, locale, title, text, url
+							FROM banner_locale
+							WHERE banner_id = ANY($1)`
+
+// Локали действующего баннера проекта, false если баннера нет
+func (d dbase) GetBannerLocales(ctx context.Context, bannerID int) ([]models.BannerLocale, bool, error) {
+
+	var exists bool
+
+	err := d.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1
+									FROM actual_banner
+									WHERE banner_id = $1
+									AND tenant = $2
+									AND deleted_at IS NULL)`,
+		bannerID,
+		token.Tenant(ctx),
+	).Scan(&exists)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+
+	rows, err := d.db.QueryContext(ctx, `SELECT locale, title, text, url, version
+										FROM banner_locale
+										WHERE banner_id = $1
+										ORDER BY locale`, bannerID)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	locales := make([]models.BannerLocale, 0)
+	for rows.Next() {
+		var locale models.BannerLocale
+		if err = rows.Scan(&locale.Locale, &locale.Content.Title, &locale.Content.Text, &locale.Content.Url, &locale.Version); err != nil {
+			return nil, false, err
+		}
+		locales = append(locales, locale)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	return locales, true, nil
+}
+
+// Добавление или изменение локали баннера. Новая версия появляется только в истории
+// этой локали и только если содержимое изменилось, false если баннера нет
+func (d dbase) SetBannerLocale(ctx context.Context, bannerID int, locale models.BannerLocale) (models.BannerLocale, bool, error) {
+
+	var last models.BannerHistory
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return models.BannerLocale{}, false, err
+	}
+
+	defer tx.Rollback()
+
+	// Изменения локалей одного баннера выполняются по очереди, иначе две получат одну версию
+	_, err = tx.ExecContext(ctx, `SELECT 1 FROM actual_banner WHERE banner_id = $1 FOR UPDATE`, bannerID)
+	if err != nil {
+		return models.BannerLocale{}, false, err
+	}
+
+	before, err := snapshot(ctx, tx, bannerID)
+	if err != nil || before == nil {
+		return models.BannerLocale{}, false, err
+	}
+
+	err = tx.QueryRowContext(ctx, `SELECT version, title, text, url
+									FROM history_banner_locale
+									WHERE banner_id = $1
+									AND locale = $2
+									ORDER BY version DESC
+									LIMIT 1`,
+		bannerID,
+		locale.Locale,
+	).Scan(&last.Version, &last.Title, &last.Text, &last.Url)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return models.BannerLocale{}, false, err
+	}
+
+	locale.Version = last.Version
+	if last.Version == 0 || (models.BannerContent{Title: last.Title, Text: last.Text, Url: last.Url}) != locale.Content {
+		locale.Version++
+		_, err = tx.ExecContext(ctx, `INSERT INTO history_banner_locale
+									(banner_id, locale, version, title, text, url)
+									VALUES($1, $2, $3, $4, $5, $6)`,
+			bannerID,
+			locale.Locale,
+			locale.Version,
+			locale.Content.Title,
+			locale.Content.Text,
+			locale.Content.Url,
+		)
+		if err != nil {
+			return models.BannerLocale{}, false, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO banner_locale
+								(banner_id, locale, title, text, url, version)
+								VALUES($1, $2, $3, $4, $5, $6)
+								ON CONFLICT (banner_id, locale) DO UPDATE
+								SET title = EXCLUDED.title,
+								text = EXCLUDED.text,
+								url = EXCLUDED.url,
+								version = EXCLUDED.version`,
+		bannerID,
+		locale.Locale,
+		locale.Content.Title,
+		locale.Content.Text,
+		locale.Content.Url,
+		locale.Version,
+	)
+	if err != nil {
+		return models.BannerLocale{}, false, err
+	}
+
+	after, err := snapshot(ctx, tx, bannerID)
+	if err != nil {
+		return models.BannerLocale{}, false, err
+	}
+
+	if err = recordChange(ctx, tx, models.AuditLocaleUpdate, bannerID, before, after); err != nil {
+		return models.BannerLocale{}, false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.BannerLocale{}, false, err
+	}
+
+	return locale, true, nil
+}
+
+// Удаление локали баннера, ее история сохраняется. false если баннера или локали нет
+func (d dbase) DeleteBannerLocale(ctx context.Context, bannerID int, locale string) (bool, error) {
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	defer tx.Rollback()
+
+	before, err := snapshot(ctx, tx, bannerID)
+	if err != nil || before == nil {
+		return false, err
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM banner_locale
+									WHERE banner_id = $1
+									AND locale = $2`,
+		bannerID,
+		locale,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	after, err := snapshot(ctx, tx, bannerID)
+	if err != nil {
+		return false, err
+	}
+
+	if err = recordChange(ctx, tx, models.AuditLocaleDelete, bannerID, before, after); err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// История одной локали баннера проекта
+func (d dbase) GetLocaleHistory(ctx context.Context, bannerID int, locale string) ([]models.BannerHistory, error) {
+
+	history := make([]models.BannerHistory, 0)
+
+	rows, err := d.db.QueryContext(ctx, `SELECT banner_id, version, title, text, url, locale
+										FROM history_banner_locale
+										WHERE banner_id = $1
+										AND locale = $2
+										AND EXISTS (SELECT 1
+											FROM actual_banner
+											WHERE actual_banner.banner_id = history_banner_locale.banner_id
+											AND actual_banner.tenant = $3)
+										ORDER BY version`,
+		bannerID,
+		locale,
+		token.Tenant(ctx),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version models.BannerHistory
+		if err = rows.Scan(&version.BannerID, &version.Version, &version.Title, &version.Text, &version.Url, &version.Locale); err != nil {
+			return nil, err
+		}
+		history = append(history, version)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
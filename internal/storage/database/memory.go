@@ -0,0 +1,2038 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+)
+
+// Implementation check
+var _ DBaser = (*memory)(nil)
+
+var (
+	errDuplicateVersion = errors.New("banner version already exists")
+	errBadWeight        = errors.New("variant weight must be positive")
+)
+
+// Пара фича + тэг проекта
+type pair struct {
+	tenant  string
+	feature int
+	tag     int
+}
+
+// Фича проекта
+type featureKey struct {
+	tenant  string
+	feature int
+}
+
+// Строка actual_banner
+type memoryBanner struct {
+	tenant    string
+	content   models.BannerContent
+	active    bool
+	priority  int
+	deletedAt *time.Time
+	locales   map[string]models.BannerLocale // строки banner_locale
+}
+
+// Строка audit_log
+type memoryAudit struct {
+	tenant string
+	record models.AuditRecord
+}
+
+// Строка webhook_subscription
+type memoryWebhook struct {
+	tenant       string
+	subscription models.WebhookSubscription
+}
+
+// Строка banner_draft вместе с комментариями
+type memoryDraft struct {
+	tenant string
+	draft  models.Draft
+}
+
+// Строка outbox
+type memoryEvent struct {
+	event     models.Event
+	published bool
+}
+
+// Состояние хранилища, каждое изменение выполняется над копией состояния,
+// которая подменяет текущее только при успехе, как транзакция в dbase
+type memoryState struct {
+	banners       map[int]*memoryBanner
+	history       map[int][]models.BannerHistory
+	localeHistory map[int][]models.BannerHistory // все локали баннера
+	pairs         map[pair]int
+	deletedPairs  map[int][]pair
+	variants      map[pair]map[int]int // banner_id -> weight
+	defaults      map[featureKey]int   // строки feature_default
+	stats         map[models.StatsKey]models.StatsDelta
+	audit         []memoryAudit
+	outbox        []memoryEvent
+	webhooks      []memoryWebhook
+	deliveries    []models.WebhookDelivery
+	registries    map[string]map[string]map[int]models.RegistryEntry // kind -> tenant -> id -> entry
+	drafts        map[int64]memoryDraft
+
+	lastBanner, lastAudit, lastEvent, lastWebhook, lastDelivery, lastDraft int64
+}
+
+// In-memory DBaser with the same semantics as PostgreSQL implementation, used in tests
+type memory struct {
+	mu    sync.Mutex
+	state *memoryState
+}
+
+func NewMemory() DBaser {
+	return &memory{state: &memoryState{
+		banners:       make(map[int]*memoryBanner),
+		history:       make(map[int][]models.BannerHistory),
+		localeHistory: make(map[int][]models.BannerHistory),
+		pairs:         make(map[pair]int),
+		deletedPairs:  make(map[int][]pair),
+		variants:      make(map[pair]map[int]int),
+		defaults:      make(map[featureKey]int),
+		stats:         make(map[models.StatsKey]models.StatsDelta),
+		registries: map[string]map[string]map[int]models.RegistryEntry{
+			models.RegistryFeature: make(map[string]map[int]models.RegistryEntry),
+			models.RegistryTag:     make(map[string]map[int]models.RegistryEntry),
+		},
+		drafts: make(map[int64]memoryDraft),
+	}}
+}
+
+// Изменение копии состояния, копия применяется если fn вернула nil
+func (m *memory) tx(fn func(st *memoryState) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st := m.state.clone()
+	if err := fn(st); err != nil {
+		m.state.keepSequences(st)
+		return err
+	}
+
+	m.state = st
+	return nil
+}
+
+// Как и последовательности PostgreSQL, счетчики id не откатываются вместе с транзакцией
+func (st *memoryState) keepSequences(rolledBack *memoryState) {
+	st.lastBanner = rolledBack.lastBanner
+	st.lastAudit = rolledBack.lastAudit
+	st.lastEvent = rolledBack.lastEvent
+	st.lastWebhook = rolledBack.lastWebhook
+	st.lastDelivery = rolledBack.lastDelivery
+	st.lastDraft = rolledBack.lastDraft
+}
+
+// Чтение текущего состояния
+func (m *memory) read(fn func(st *memoryState)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fn(m.state)
+}
+
+func (st *memoryState) clone() *memoryState {
+
+	c := &memoryState{
+		banners:       make(map[int]*memoryBanner, len(st.banners)),
+		history:       make(map[int][]models.BannerHistory, len(st.history)),
+		localeHistory: make(map[int][]models.BannerHistory, len(st.localeHistory)),
+		pairs:         make(map[pair]int, len(st.pairs)),
+		deletedPairs:  make(map[int][]pair, len(st.deletedPairs)),
+		variants:      make(map[pair]map[int]int, len(st.variants)),
+		defaults:      make(map[featureKey]int, len(st.defaults)),
+		stats:         make(map[models.StatsKey]models.StatsDelta, len(st.stats)),
+		audit:         append([]memoryAudit(nil), st.audit...),
+		outbox:        append([]memoryEvent(nil), st.outbox...),
+		webhooks:      append([]memoryWebhook(nil), st.webhooks...),
+		deliveries:    append([]models.WebhookDelivery(nil), st.deliveries...),
+		registries:    make(map[string]map[string]map[int]models.RegistryEntry, len(st.registries)),
+		drafts:        make(map[int64]memoryDraft, len(st.drafts)),
+		lastBanner:    st.lastBanner,
+		lastAudit:     st.lastAudit,
+		lastEvent:     st.lastEvent,
+		lastWebhook:   st.lastWebhook,
+		lastDelivery:  st.lastDelivery,
+		lastDraft:     st.lastDraft,
+	}
+
+	for id, banner := range st.banners {
+		b := *banner
+		b.locales = make(map[string]models.BannerLocale, len(banner.locales))
+		for locale, content := range banner.locales {
+			b.locales[locale] = content
+		}
+		c.banners[id] = &b
+	}
+	for id, versions := range st.history {
+		c.history[id] = append([]models.BannerHistory(nil), versions...)
+	}
+	for id, versions := range st.localeHistory {
+		c.localeHistory[id] = append([]models.BannerHistory(nil), versions...)
+	}
+	for p, id := range st.pairs {
+		c.pairs[p] = id
+	}
+	for id, pairs := range st.deletedPairs {
+		c.deletedPairs[id] = append([]pair(nil), pairs...)
+	}
+	for p, variants := range st.variants {
+		c.variants[p] = make(map[int]int, len(variants))
+		for id, weight := range variants {
+			c.variants[p][id] = weight
+		}
+	}
+	for key, id := range st.defaults {
+		c.defaults[key] = id
+	}
+	for key, delta := range st.stats {
+		c.stats[key] = delta
+	}
+	for id, d := range st.drafts {
+		d.draft.Comments = append([]models.DraftComment(nil), d.draft.Comments...)
+		c.drafts[id] = d
+	}
+	for kind, tenants := range st.registries {
+		c.registries[kind] = make(map[string]map[int]models.RegistryEntry, len(tenants))
+		for tenant, entries := range tenants {
+			c.registries[kind][tenant] = make(map[int]models.RegistryEntry, len(entries))
+			for id, entry := range entries {
+				c.registries[kind][tenant][id] = entry
+			}
+		}
+	}
+
+	return c
+}
+
+// Пары баннера, упорядоченные по тэгу
+func (st *memoryState) bannerPairs(bannerID int) []pair {
+	pairs := make([]pair, 0)
+	for p, id := range st.pairs {
+		if id == bannerID {
+			pairs = append(pairs, p)
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].tag < pairs[j].tag })
+	return pairs
+}
+
+// Баннер есть и принадлежит проекту, удаленный в том числе
+func (st *memoryState) owned(tenant string, bannerID int) bool {
+	banner, ok := st.banners[bannerID]
+	return ok && banner.tenant == tenant
+}
+
+// Аналог snapshot: баннер со всеми тэгами, nil если баннера нет или он удален
+func (st *memoryState) snapshot(bannerID int) *models.ResponseBody {
+
+	banner, ok := st.banners[bannerID]
+	if !ok || banner.deletedAt != nil {
+		return nil
+	}
+
+	snapshot := &models.ResponseBody{
+		BannerID:       uint32(bannerID),
+		TagID:          make([]uint32, 0),
+		Content:        banner.content,
+		Active:         banner.active,
+		Priority:       banner.priority,
+		FeatureDefault: st.featureDefault(bannerID),
+	}
+	for _, p := range st.bannerPairs(bannerID) {
+		snapshot.FeatureID = uint32(p.feature)
+		snapshot.TagID = append(snapshot.TagID, uint32(p.tag))
+	}
+	snapshot.Locales = banner.localeContents()
+
+	return snapshot
+}
+
+// Баннер является баннером своей фичи по умолчанию
+func (st *memoryState) featureDefault(bannerID int) bool {
+	for _, id := range st.defaults {
+		if id == bannerID {
+			return true
+		}
+	}
+	return false
+}
+
+// Аналог setFeatureDefault
+func (st *memoryState) setFeatureDefault(tenant string, featureID uint32, bannerID int, isDefault bool) {
+
+	// Баннер мог сменить фичу, прежняя отметка снимается в любом случае
+	for key, id := range st.defaults {
+		if id == bannerID {
+			delete(st.defaults, key)
+		}
+	}
+
+	if isDefault {
+		st.defaults[featureKey{tenant, int(featureID)}] = bannerID
+	}
+}
+
+// Содержимое локалей баннера, nil если их нет
+func (b *memoryBanner) localeContents() map[string]models.BannerContent {
+	if len(b.locales) == 0 {
+		return nil
+	}
+	contents := make(map[string]models.BannerContent, len(b.locales))
+	for locale, l := range b.locales {
+		contents[locale] = l.Content
+	}
+	return contents
+}
+
+func (st *memoryState) insertBanner(tenant string, content models.BannerContent, active bool) int {
+	st.lastBanner++
+	id := int(st.lastBanner)
+	st.banners[id] = &memoryBanner{tenant: tenant, content: content, active: active}
+	return id
+}
+
+// Последняя версия баннера, нулевая если истории нет
+func (st *memoryState) lastVersion(bannerID int) models.BannerHistory {
+	var last models.BannerHistory
+	for _, version := range st.history[bannerID] {
+		if version.Version > last.Version {
+			last = version
+		}
+	}
+	return last
+}
+
+func (st *memoryState) insertVersion(bannerID, version int, content models.BannerContent) error {
+	for _, v := range st.history[bannerID] {
+		if v.Version == version {
+			return errDuplicateVersion
+		}
+	}
+
+	st.history[bannerID] = append(st.history[bannerID], models.BannerHistory{
+		BannerID: uint32(bannerID),
+		Version:  version,
+		Title:    content.Title,
+		Text:     content.Text,
+		Url:      content.Url,
+	})
+	return nil
+}
+
+// Аналог recordChange: журнал аудита, outbox и доставки вебхуков
+func (st *memoryState) recordChange(ctx context.Context, action string, bannerID int, before, after *models.ResponseBody) error {
+
+	beforeJSON, err := rawSnapshot(before)
+	if err != nil {
+		return err
+	}
+
+	afterJSON, err := rawSnapshot(after)
+	if err != nil {
+		return err
+	}
+
+	actor := token.Actor(ctx)
+	tenant := token.Tenant(ctx)
+	now := time.Now()
+
+	st.lastAudit++
+	st.audit = append(st.audit, memoryAudit{tenant: tenant, record: models.AuditRecord{
+		ID:        st.lastAudit,
+		Actor:     actor,
+		Action:    action,
+		BannerID:  uint32(bannerID),
+		Before:    beforeJSON,
+		After:     afterJSON,
+		CreatedAt: now,
+	}})
+
+	return st.writeOutbox(eventType(action), tenant, bannerID, actor, before, after, now)
+}
+
+// Аналог writeOutbox
+func (st *memoryState) writeOutbox(eventType, tenant string, bannerID int, actor string, before, after *models.ResponseBody, now time.Time) error {
+
+	st.lastEvent++
+	event := models.Event{
+		ID:        st.lastEvent,
+		Type:      eventType,
+		CreatedAt: now,
+		EventPayload: models.EventPayload{
+			Tenant:   tenant,
+			BannerID: uint32(bannerID),
+			Actor:    actor,
+			Before:   before,
+			After:    after,
+		},
+	}
+	st.outbox = append(st.outbox, memoryEvent{event: event})
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	st.enqueueDeliveries(event, payload, before, after, now)
+	return nil
+}
+
+func rawSnapshot(banner *models.ResponseBody) (json.RawMessage, error) {
+	if banner == nil {
+		return nil, nil
+	}
+	return json.Marshal(banner)
+}
+
+// Аналог enqueueDeliveries
+func (st *memoryState) enqueueDeliveries(event models.Event, payload []byte, before, after *models.ResponseBody, now time.Time) {
+
+	features := make(map[uint32]bool)
+	tags := make(map[uint32]bool)
+	for _, banner := range []*models.ResponseBody{before, after} {
+		if banner == nil {
+			continue
+		}
+		features[banner.FeatureID] = true
+		for _, tag := range banner.TagID {
+			tags[tag] = true
+		}
+	}
+
+	for _, webhook := range st.webhooks {
+
+		subscription := webhook.subscription
+		if webhook.tenant != event.Tenant {
+			continue
+		}
+		if len(subscription.EventTypes) > 0 && !contains(subscription.EventTypes, event.Type) {
+			continue
+		}
+		if subscription.FeatureID != nil && !features[*subscription.FeatureID] {
+			continue
+		}
+		if subscription.TagID != nil && !tags[*subscription.TagID] {
+			continue
+		}
+
+		st.lastDelivery++
+		st.deliveries = append(st.deliveries, models.WebhookDelivery{
+			ID:             st.lastDelivery,
+			SubscriptionID: subscription.ID,
+			EventID:        event.ID,
+			EventType:      event.Type,
+			Status:         models.DeliveryPending,
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+			Payload:        payload,
+		})
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *memory) CreateBanner(ctx context.Context, bannerBody models.BannerBody) (int, error) {
+
+	var id int
+
+	tenant := token.Tenant(ctx)
+
+	err := m.tx(func(st *memoryState) error {
+
+		if err := st.checkRegistered(tenant, bannerBody.FeatureID, []uint32{bannerBody.TagID}); err != nil {
+			return err
+		}
+
+		// Как и в dbase, is_active при создании не учитывается, баннер создается активным
+		id = st.insertBanner(tenant, bannerBody.Content, true)
+		st.banners[id].priority = bannerBody.Priority
+
+		p := pair{tenant, int(bannerBody.FeatureID), int(bannerBody.TagID)}
+		if _, taken := st.pairs[p]; taken {
+			return models.ErrPairTaken
+		}
+		st.pairs[p] = id
+
+		if bannerBody.FeatureDefault {
+			st.setFeatureDefault(tenant, bannerBody.FeatureID, id, true)
+		}
+
+		if err := st.insertVersion(id, 1, bannerBody.Content); err != nil {
+			return err
+		}
+
+		return st.recordChange(ctx, models.AuditCreate, id, nil, st.snapshot(id))
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (m *memory) UpdateBanner(ctx context.Context, bannerBody models.BannerBody, bannerID int) (bool, error) {
+
+	var found bool
+
+	err := m.tx(func(st *memoryState) error {
+
+		banner, ok := st.banners[bannerID]
+		if !ok || banner.deletedAt != nil || banner.tenant != token.Tenant(ctx) {
+			return nil
+		}
+
+		before := st.snapshot(bannerID)
+
+		// Фича баннера не меняется, новые пары, как и в dbase, не добавляются
+		pairs := st.bannerPairs(bannerID)
+		if len(pairs) == 0 {
+			return sql.ErrNoRows
+		}
+		if pairs[0].feature != int(bannerBody.FeatureID) {
+			return errFeatureMismatch
+		}
+
+		banner.content = bannerBody.Content
+		banner.active = bannerBody.Active
+		banner.priority = bannerBody.Priority
+		st.setFeatureDefault(banner.tenant, bannerBody.FeatureID, bannerID, bannerBody.FeatureDefault)
+
+		// Новая версия, только если изменилось содержимое
+		last := st.lastVersion(bannerID)
+		if last.Title != bannerBody.Content.Title ||
+			last.Text != bannerBody.Content.Text ||
+			last.Url != bannerBody.Content.Url {
+			if err := st.insertVersion(bannerID, last.Version+1, bannerBody.Content); err != nil {
+				return err
+			}
+		}
+
+		found = true
+		return st.recordChange(ctx, updateAction(ctx), bannerID, before, st.snapshot(bannerID))
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return found, nil
+}
+
+// Как и в dbase, баннер подходит, если у него есть пара с тэгом или с фичей запроса
+func (m *memory) GetBanners(ctx context.Context, queryParam models.Query) ([]models.ResponseBody, error) {
+
+	banners := make([]models.ResponseBody, 0)
+
+	tenant := token.Tenant(ctx)
+
+	m.read(func(st *memoryState) {
+
+		ids := make(map[int]bool)
+		for p, id := range st.pairs {
+			if p.tenant == tenant && (p.tag == queryParam.TagID || p.feature == queryParam.FeatureID) {
+				ids[id] = true
+			}
+		}
+
+		for _, id := range sortedIDs(ids) {
+			banner := st.banners[id]
+			banners = append(banners, models.ResponseBody{
+				BannerID:       uint32(id),
+				FeatureID:      uint32(queryParam.FeatureID),
+				Content:        banner.content,
+				Active:         banner.active,
+				Priority:       banner.priority,
+				FeatureDefault: st.featureDefault(id),
+			})
+		}
+	})
+
+	return banners, nil
+}
+
+func (m *memory) GetBannerByID(ctx context.Context, bannerID int) (models.ResponseBody, bool, error) {
+
+	var (
+		banner models.ResponseBody
+		found  bool
+	)
+
+	m.read(func(st *memoryState) {
+		snapshot := st.snapshot(bannerID)
+		if snapshot == nil || !st.owned(token.Tenant(ctx), bannerID) {
+			return
+		}
+		banner, found = *snapshot, true
+		banner.Locales = nil
+	})
+
+	return banner, found, nil
+}
+
+func (m *memory) GetBanner(ctx context.Context, featureID int, tagIDs []int) ([]models.UserBanner, error) {
+
+	banners := make([]models.UserBanner, 0)
+
+	tenant := token.Tenant(ctx)
+
+	m.read(func(st *memoryState) {
+
+		tags := append([]int(nil), tagIDs...)
+		sort.Ints(tags)
+
+		for i, tagID := range tags {
+			if i > 0 && tags[i-1] == tagID {
+				continue
+			}
+
+			p := pair{tenant, featureID, tagID}
+			controlID, ok := st.pairs[p]
+			if !ok || !st.banners[controlID].active {
+				continue
+			}
+			control := st.banners[controlID]
+
+			// Без эксперимента отдаем баннер пары
+			variants := st.variants[p]
+			if len(variants) == 0 {
+				banners = append(banners, models.UserBanner{
+					BannerID: uint32(controlID),
+					Content:  control.content,
+					Locales:  control.localeContents(),
+					TagID:    uint32(tagID),
+					Priority: control.priority,
+				})
+				continue
+			}
+
+			for _, id := range sortedKeys(variants) {
+				banner, ok := st.banners[id]
+				if !ok || !banner.active || banner.deletedAt != nil {
+					continue
+				}
+				banners = append(banners, models.UserBanner{
+					BannerID: uint32(id),
+					Content:  banner.content,
+					Weight:   variants[id],
+					Locales:  banner.localeContents(),
+					TagID:    uint32(tagID),
+					Priority: control.priority,
+				})
+			}
+		}
+
+		// Баннер фичи по умолчанию с нулевым тэгом, как и в dbase, идет первым
+		if id, ok := st.defaults[featureKey{tenant, featureID}]; ok {
+			banner := st.banners[id]
+			if banner.active && banner.deletedAt == nil {
+				banners = append([]models.UserBanner{{
+					BannerID: uint32(id),
+					Content:  banner.content,
+					Locales:  banner.localeContents(),
+					Priority: banner.priority,
+				}}, banners...)
+			}
+		}
+	})
+
+	return banners, nil
+}
+
+func (m *memory) DeleteBanner(ctx context.Context, bannerID int) error {
+	return m.tx(func(st *memoryState) error {
+
+		before := st.snapshot(bannerID)
+		if before == nil || !st.owned(token.Tenant(ctx), bannerID) {
+			return nil
+		}
+
+		for _, p := range st.bannerPairs(bannerID) {
+			st.dropExperiment(p, bannerID)
+			st.deletedPairs[bannerID] = append(st.deletedPairs[bannerID], p)
+			delete(st.pairs, p)
+		}
+
+		// Удаленный вариант больше не участвует в эксперименте
+		for _, variants := range st.variants {
+			delete(variants, bannerID)
+		}
+
+		now := time.Now()
+		st.banners[bannerID].deletedAt = &now
+
+		return st.recordChange(ctx, models.AuditDelete, bannerID, before, nil)
+	})
+}
+
+func (m *memory) GetHistoryBanner(ctx context.Context, bannerID int) ([]models.BannerHistory, error) {
+
+	history := make([]models.BannerHistory, 0)
+
+	m.read(func(st *memoryState) {
+		if st.owned(token.Tenant(ctx), bannerID) {
+			history = append(history, st.history[bannerID]...)
+		}
+	})
+
+	sort.Slice(history, func(i, j int) bool { return history[i].Version < history[j].Version })
+
+	return history, nil
+}
+
+func (m *memory) UpdateVersion(ctx context.Context, bannerVersion models.BannerHistory) error {
+	return m.tx(func(st *memoryState) error {
+
+		bannerID := int(bannerVersion.BannerID)
+
+		before := st.snapshot(bannerID)
+		if before == nil || !st.owned(token.Tenant(ctx), bannerID) {
+			return nil
+		}
+
+		st.banners[bannerID].content = models.BannerContent{
+			Title: bannerVersion.Title,
+			Text:  bannerVersion.Text,
+			Url:   bannerVersion.Url,
+		}
+
+		return st.recordChange(ctx, models.AuditVersionSwitch, bannerID, before, st.snapshot(bannerID))
+	})
+}
+
+func (m *memory) GetAudit(ctx context.Context, auditQuery models.AuditQuery) ([]models.AuditRecord, error) {
+
+	records := make([]models.AuditRecord, 0)
+
+	tenant := token.Tenant(ctx)
+
+	m.read(func(st *memoryState) {
+		for _, audit := range st.audit {
+			record := audit.record
+			if audit.tenant != tenant {
+				continue
+			}
+			if auditQuery.BannerID != 0 && int(record.BannerID) != auditQuery.BannerID {
+				continue
+			}
+			if auditQuery.Actor != "" && record.Actor != auditQuery.Actor {
+				continue
+			}
+			if !auditQuery.From.IsZero() && record.CreatedAt.Before(auditQuery.From) {
+				continue
+			}
+			if !auditQuery.To.IsZero() && !record.CreatedAt.Before(auditQuery.To) {
+				continue
+			}
+			records = append(records, record)
+		}
+	})
+
+	return page(records, auditQuery.Limit, auditQuery.Offset), nil
+}
+
+func (m *memory) GetDeletedBanners(ctx context.Context, deletedAfter time.Time) ([]models.DeletedBanner, error) {
+
+	banners := make([]models.DeletedBanner, 0)
+
+	tenant := token.Tenant(ctx)
+
+	m.read(func(st *memoryState) {
+
+		ids := make(map[int]bool)
+		for id, banner := range st.banners {
+			if banner.tenant == tenant && banner.deletedAt != nil && !banner.deletedAt.Before(deletedAfter) {
+				ids[id] = true
+			}
+		}
+
+		for _, id := range sortedIDs(ids) {
+			banner := st.banners[id]
+			deleted := models.DeletedBanner{
+				BannerID:  uint32(id),
+				TagID:     make([]uint32, 0),
+				Content:   banner.content,
+				Active:    banner.active,
+				DeletedAt: *banner.deletedAt,
+			}
+
+			pairs := append([]pair(nil), st.deletedPairs[id]...)
+			sort.Slice(pairs, func(i, j int) bool { return pairs[i].tag < pairs[j].tag })
+			for _, p := range pairs {
+				deleted.FeatureID = uint32(p.feature)
+				deleted.TagID = append(deleted.TagID, uint32(p.tag))
+			}
+
+			banners = append(banners, deleted)
+		}
+	})
+
+	return banners, nil
+}
+
+func (m *memory) RestoreBanner(ctx context.Context, bannerID int, deletedAfter time.Time) (bool, error) {
+
+	var restored bool
+
+	err := m.tx(func(st *memoryState) error {
+
+		banner, ok := st.banners[bannerID]
+		if !ok || banner.tenant != token.Tenant(ctx) || banner.deletedAt == nil || banner.deletedAt.Before(deletedAfter) {
+			return nil
+		}
+
+		for _, p := range st.deletedPairs[bannerID] {
+			if _, taken := st.pairs[p]; taken {
+				return models.ErrPairTaken
+			}
+		}
+
+		banner.deletedAt = nil
+		for _, p := range st.deletedPairs[bannerID] {
+			st.pairs[p] = bannerID
+		}
+		delete(st.deletedPairs, bannerID)
+
+		restored = true
+		return st.recordChange(ctx, models.AuditRestore, bannerID, nil, st.snapshot(bannerID))
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return restored, nil
+}
+
+func (m *memory) PurgeBanners(ctx context.Context, deletedBefore time.Time) (int, error) {
+
+	var purged int
+
+	err := m.tx(func(st *memoryState) error {
+		for id, banner := range st.banners {
+			if banner.deletedAt == nil || !banner.deletedAt.Before(deletedBefore) {
+				continue
+			}
+			delete(st.banners, id)
+			delete(st.deletedPairs, id)
+			delete(st.history, id)
+			delete(st.localeHistory, id)
+			st.setFeatureDefault(banner.tenant, 0, id, false)
+			st.deleteDrafts(id)
+			purged++
+		}
+		return nil
+	})
+
+	return purged, err
+}
+
+func (m *memory) ExportBanners(ctx context.Context, withHistory bool, fn func(models.ExportBanner) error) error {
+
+	// Выгружаем копию, чтобы fn могла обращаться к хранилищу
+	banners := make([]models.ExportBanner, 0)
+
+	tenant := token.Tenant(ctx)
+
+	m.read(func(st *memoryState) {
+
+		ids := make(map[int]bool)
+		for p, id := range st.pairs {
+			if p.tenant == tenant && st.banners[id].deletedAt == nil {
+				ids[id] = true
+			}
+		}
+
+		for _, id := range sortedIDs(ids) {
+			snapshot := st.snapshot(id)
+			banner := models.ExportBanner{
+				BannerID:       snapshot.BannerID,
+				TagID:          snapshot.TagID,
+				FeatureID:      snapshot.FeatureID,
+				Content:        snapshot.Content,
+				Active:         snapshot.Active,
+				Priority:       snapshot.Priority,
+				FeatureDefault: snapshot.FeatureDefault,
+			}
+
+			if withHistory {
+				banner.History = append(make([]models.BannerHistory, 0), st.history[id]...)
+				sort.Slice(banner.History, func(i, j int) bool { return banner.History[i].Version < banner.History[j].Version })
+			}
+
+			banners = append(banners, banner)
+		}
+	})
+
+	for _, banner := range banners {
+		if err := fn(banner); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *memory) ImportBanners(ctx context.Context, banners []models.ExportBanner, options models.ImportOptions) ([]models.ImportRowResult, bool, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	results := make([]models.ImportRowResult, len(banners))
+	failed := false
+
+	st := m.state.clone()
+
+	for i, banner := range banners {
+
+		// Точка сохранения строки
+		row := st.clone()
+
+		result, err := row.importBanner(ctx, banner, options.Conflict)
+		if err != nil {
+			result = models.ImportRowResult{Status: models.ImportFailed, Error: err.Error()}
+			failed = true
+			st.keepSequences(row)
+		} else {
+			st = row
+		}
+
+		results[i] = result
+	}
+
+	if options.DryRun || (failed && options.Mode == models.ImportAtomic) {
+		m.state.keepSequences(st)
+		return results, false, nil
+	}
+
+	m.state = st
+	return results, true, nil
+}
+
+// Аналог importBanner
+func (st *memoryState) importBanner(ctx context.Context, banner models.ExportBanner, conflict string) (models.ImportRowResult, error) {
+
+	if len(banner.TagID) == 0 {
+		return models.ImportRowResult{}, errEmptyTags
+	}
+
+	tenant := token.Tenant(ctx)
+
+	if err := st.checkRegistered(tenant, banner.FeatureID, banner.TagID); err != nil {
+		return models.ImportRowResult{}, err
+	}
+
+	owners := make(map[int]bool)
+	for _, tag := range banner.TagID {
+		if id, ok := st.pairs[pair{tenant, int(banner.FeatureID), int(tag)}]; ok {
+			owners[id] = true
+		}
+	}
+
+	switch {
+	case len(owners) == 0:
+		id, err := st.insertImported(ctx, banner)
+		if err != nil {
+			return models.ImportRowResult{}, err
+		}
+		return models.ImportRowResult{Status: models.ImportCreated, BannerID: uint32(id)}, nil
+
+	case len(owners) > 1:
+		return models.ImportRowResult{}, errSeveralBanners
+	}
+
+	owner := sortedIDs(owners)[0]
+
+	if conflict == models.ConflictOverwrite {
+		if err := st.overwriteImported(ctx, owner, banner); err != nil {
+			return models.ImportRowResult{}, err
+		}
+		return models.ImportRowResult{Status: models.ImportUpdated, BannerID: uint32(owner)}, nil
+	}
+
+	return models.ImportRowResult{Status: models.ImportSkipped, BannerID: uint32(owner)}, nil
+}
+
+func (st *memoryState) insertImported(ctx context.Context, banner models.ExportBanner) (int, error) {
+
+	tenant := token.Tenant(ctx)
+
+	id := st.insertBanner(tenant, banner.Content, banner.Active)
+	st.banners[id].priority = banner.Priority
+	st.insertPairs(id, banner.FeatureID, banner.TagID)
+
+	if banner.FeatureDefault {
+		st.setFeatureDefault(tenant, banner.FeatureID, id, true)
+	}
+
+	for _, h := range banner.History {
+		content := models.BannerContent{Title: h.Title, Text: h.Text, Url: h.Url}
+		if err := st.insertVersion(id, h.Version, content); err != nil {
+			return 0, err
+		}
+	}
+
+	last := st.lastVersion(id)
+	lastContent := models.BannerContent{Title: last.Title, Text: last.Text, Url: last.Url}
+	if last.Version == 0 || lastContent != banner.Content {
+		if err := st.insertVersion(id, last.Version+1, banner.Content); err != nil {
+			return 0, err
+		}
+	}
+
+	return id, st.recordChange(ctx, models.AuditCreate, id, nil, st.snapshot(id))
+}
+
+func (st *memoryState) overwriteImported(ctx context.Context, bannerID int, banner models.ExportBanner) error {
+
+	before := st.snapshot(bannerID)
+
+	st.banners[bannerID].content = banner.Content
+	st.banners[bannerID].active = banner.Active
+	st.banners[bannerID].priority = banner.Priority
+	st.insertPairs(bannerID, banner.FeatureID, banner.TagID)
+	st.setFeatureDefault(st.banners[bannerID].tenant, banner.FeatureID, bannerID, banner.FeatureDefault)
+
+	last := st.lastVersion(bannerID)
+	if (models.BannerContent{Title: last.Title, Text: last.Text, Url: last.Url}) != banner.Content {
+		if err := st.insertVersion(bannerID, last.Version+1, banner.Content); err != nil {
+			return err
+		}
+	}
+
+	return st.recordChange(ctx, models.AuditUpdate, bannerID, before, st.snapshot(bannerID))
+}
+
+// Занятые пары проекта баннера пропускаются, как ON CONFLICT DO NOTHING
+func (st *memoryState) insertPairs(bannerID int, featureID uint32, tags []uint32) {
+	for _, tag := range tags {
+		p := pair{st.banners[bannerID].tenant, int(featureID), int(tag)}
+		if _, taken := st.pairs[p]; !taken {
+			st.pairs[p] = bannerID
+		}
+	}
+}
+
+func (m *memory) SaveStats(ctx context.Context, deltas []models.StatsDelta) error {
+	return m.tx(func(st *memoryState) error {
+		for _, delta := range deltas {
+			saved := st.stats[delta.StatsKey]
+			saved.StatsKey = delta.StatsKey
+			saved.Impressions += delta.Impressions
+			saved.Clicks += delta.Clicks
+			st.stats[delta.StatsKey] = saved
+		}
+		return nil
+	})
+}
+
+func (m *memory) GetStats(ctx context.Context, statsQuery models.StatsQuery) ([]models.BannerStats, error) {
+
+	totals := make(map[int]*models.BannerStats)
+
+	tenant := token.Tenant(ctx)
+
+	m.read(func(st *memoryState) {
+		for key, delta := range st.stats {
+			if !st.owned(tenant, key.BannerID) {
+				continue
+			}
+			if statsQuery.BannerID != 0 && key.BannerID != statsQuery.BannerID {
+				continue
+			}
+			if !statsQuery.From.IsZero() && key.Bucket.Before(statsQuery.From) {
+				continue
+			}
+			if !statsQuery.To.IsZero() && !key.Bucket.Before(statsQuery.To) {
+				continue
+			}
+
+			total, ok := totals[key.BannerID]
+			if !ok {
+				total = &models.BannerStats{BannerID: uint32(key.BannerID)}
+				totals[key.BannerID] = total
+			}
+			total.Impressions += delta.Impressions
+			total.Clicks += delta.Clicks
+		}
+	})
+
+	stats := make([]models.BannerStats, 0, len(totals))
+	for _, id := range sortedKeys(totals) {
+		total := *totals[id]
+		if total.Impressions > 0 {
+			total.CTR = float64(total.Clicks) / float64(total.Impressions)
+		}
+		stats = append(stats, total)
+	}
+
+	return stats, nil
+}
+
+func (m *memory) GetBannerURL(ctx context.Context, bannerID int) (string, error) {
+
+	var url string
+
+	m.read(func(st *memoryState) {
+		if banner, ok := st.banners[bannerID]; ok && banner.active && banner.deletedAt == nil {
+			url = banner.content.Url
+		}
+	})
+
+	return url, nil
+}
+
+func (m *memory) CreateExperiment(ctx context.Context, experimentRequest models.ExperimentRequest) (models.Experiment, bool, error) {
+
+	var (
+		experiment models.Experiment
+		found      bool
+	)
+
+	err := m.tx(func(st *memoryState) error {
+
+		p := pair{token.Tenant(ctx), int(experimentRequest.FeatureID), int(experimentRequest.TagID)}
+		controlID, ok := st.pairs[p]
+		if !ok {
+			return nil
+		}
+
+		if len(st.variants[p]) > 0 {
+			return models.ErrExperimentExists
+		}
+
+		variants := make(map[int]int)
+		if experimentRequest.ControlWeight <= 0 {
+			return errBadWeight
+		}
+		variants[controlID] = experimentRequest.ControlWeight
+
+		for _, variant := range experimentRequest.Variants {
+
+			if variant.Weight <= 0 {
+				return errBadWeight
+			}
+
+			id := st.insertBanner(p.tenant, variant.Content, true)
+			if err := st.insertVersion(id, 1, variant.Content); err != nil {
+				return err
+			}
+			variants[id] = variant.Weight
+
+			if err := st.recordChange(ctx, models.AuditCreate, id, nil, st.snapshot(id)); err != nil {
+				return err
+			}
+		}
+
+		st.variants[p] = variants
+		experiment, found = st.getExperiment(p), true
+		return nil
+	})
+	if err != nil {
+		return models.Experiment{}, false, err
+	}
+
+	return experiment, found, nil
+}
+
+func (m *memory) GetExperiment(ctx context.Context, featureID, tagID int) (models.Experiment, bool, error) {
+
+	var experiment models.Experiment
+
+	m.read(func(st *memoryState) {
+		experiment = st.getExperiment(pair{token.Tenant(ctx), featureID, tagID})
+	})
+
+	return experiment, len(experiment.Variants) > 0, nil
+}
+
+func (m *memory) DeclareWinner(ctx context.Context, winner models.WinnerRequest) (bool, error) {
+
+	var found bool
+
+	err := m.tx(func(st *memoryState) error {
+
+		p := pair{token.Tenant(ctx), int(winner.FeatureID), int(winner.TagID)}
+		experiment := st.getExperiment(p)
+		if len(experiment.Variants) == 0 {
+			return nil
+		}
+
+		var variant *models.ExperimentVariant
+		for i := range experiment.Variants {
+			if experiment.Variants[i].BannerID == winner.BannerID {
+				variant = &experiment.Variants[i]
+			}
+		}
+
+		if variant == nil {
+			return models.ErrNotVariant
+		}
+
+		controlID := int(experiment.ControlID)
+		before := st.snapshot(controlID)
+
+		// Переносим содержимое победителя в контрольный баннер
+		if variant.BannerID != experiment.ControlID {
+			st.banners[controlID].content = variant.Content
+			if err := st.insertVersion(controlID, st.lastVersion(controlID).Version+1, variant.Content); err != nil {
+				return err
+			}
+		}
+
+		st.dropExperiment(p, controlID)
+
+		found = true
+		return st.recordChange(ctx, models.AuditExperiment, controlID, before, st.snapshot(controlID))
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return found, nil
+}
+
+// Аналог dropExperiment
+func (st *memoryState) dropExperiment(p pair, controlID int) {
+	for id := range st.variants[p] {
+		if id == controlID {
+			continue
+		}
+		delete(st.banners, id)
+		delete(st.history, id)
+		delete(st.localeHistory, id)
+		st.deleteDrafts(id)
+	}
+	delete(st.variants, p)
+}
+
+// Аналог getExperiment
+func (st *memoryState) getExperiment(p pair) models.Experiment {
+
+	experiment := models.Experiment{
+		FeatureID: uint32(p.feature),
+		TagID:     uint32(p.tag),
+		Variants:  make([]models.ExperimentVariant, 0),
+	}
+
+	controlID, ok := st.pairs[p]
+	if !ok {
+		return experiment
+	}
+	experiment.ControlID = uint32(controlID)
+
+	variants := st.variants[p]
+	for _, id := range sortedKeys(variants) {
+
+		banner, ok := st.banners[id]
+		if !ok {
+			continue
+		}
+
+		variant := models.ExperimentVariant{
+			BannerID: uint32(id),
+			Weight:   variants[id],
+			Content:  banner.content,
+		}
+		for key, delta := range st.stats {
+			if key.BannerID == id && key.FeatureID == p.feature && key.TagID == p.tag {
+				variant.Impressions += delta.Impressions
+				variant.Clicks += delta.Clicks
+			}
+		}
+
+		experiment.Variants = append(experiment.Variants, variant)
+	}
+
+	return experiment
+}
+
+func (m *memory) RelayOutbox(ctx context.Context, limit int, publish func(context.Context, models.Event) error) (int, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var (
+		published  int
+		publishErr error
+	)
+
+	for i := range m.state.outbox {
+		if published == limit {
+			break
+		}
+		if m.state.outbox[i].published {
+			continue
+		}
+		if publishErr = publish(ctx, m.state.outbox[i].event); publishErr != nil {
+			break
+		}
+		m.state.outbox[i].published = true
+		published++
+	}
+
+	return published, publishErr
+}
+
+func (m *memory) CreateWebhook(ctx context.Context, subscription models.WebhookSubscription) (models.WebhookSubscription, error) {
+
+	err := m.tx(func(st *memoryState) error {
+		st.lastWebhook++
+		subscription.ID = st.lastWebhook
+		subscription.CreatedAt = time.Now()
+		if subscription.EventTypes == nil {
+			subscription.EventTypes = make([]string, 0)
+		}
+		st.webhooks = append(st.webhooks, memoryWebhook{tenant: token.Tenant(ctx), subscription: subscription})
+		return nil
+	})
+	if err != nil {
+		return models.WebhookSubscription{}, err
+	}
+
+	return subscription, nil
+}
+
+func (m *memory) GetWebhooks(ctx context.Context) ([]models.WebhookSubscription, error) {
+
+	subscriptions := make([]models.WebhookSubscription, 0)
+
+	tenant := token.Tenant(ctx)
+
+	m.read(func(st *memoryState) {
+		for _, webhook := range st.webhooks {
+			if webhook.tenant != tenant {
+				continue
+			}
+			subscription := webhook.subscription
+			subscription.Secret = ""
+			subscriptions = append(subscriptions, subscription)
+		}
+	})
+
+	return subscriptions, nil
+}
+
+func (m *memory) DeleteWebhook(ctx context.Context, subscriptionID int64) (bool, error) {
+
+	var found bool
+
+	tenant := token.Tenant(ctx)
+
+	err := m.tx(func(st *memoryState) error {
+
+		webhooks := st.webhooks[:0]
+		for _, webhook := range st.webhooks {
+			if webhook.subscription.ID == subscriptionID && webhook.tenant == tenant {
+				found = true
+				continue
+			}
+			webhooks = append(webhooks, webhook)
+		}
+		st.webhooks = webhooks
+
+		if !found {
+			return nil
+		}
+
+		deliveries := st.deliveries[:0]
+		for _, delivery := range st.deliveries {
+			if delivery.SubscriptionID != subscriptionID {
+				deliveries = append(deliveries, delivery)
+			}
+		}
+		st.deliveries = deliveries
+
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return found, nil
+}
+
+func (m *memory) GetDeliveries(ctx context.Context, deliveryQuery models.DeliveryQuery) ([]models.WebhookDelivery, error) {
+
+	deliveries := make([]models.WebhookDelivery, 0)
+
+	tenant := token.Tenant(ctx)
+
+	m.read(func(st *memoryState) {
+
+		// Доставки только по подписке проекта
+		owned := false
+		for _, webhook := range st.webhooks {
+			if webhook.subscription.ID == deliveryQuery.SubscriptionID && webhook.tenant == tenant {
+				owned = true
+			}
+		}
+		if !owned {
+			return
+		}
+
+		for i := len(st.deliveries) - 1; i >= 0; i-- {
+			delivery := st.deliveries[i]
+			if delivery.SubscriptionID != deliveryQuery.SubscriptionID {
+				continue
+			}
+			if deliveryQuery.Status != "" && delivery.Status != deliveryQuery.Status {
+				continue
+			}
+			delivery.URL, delivery.Secret, delivery.Payload = "", "", nil
+			deliveries = append(deliveries, delivery)
+		}
+	})
+
+	return page(deliveries, deliveryQuery.Limit, deliveryQuery.Offset), nil
+}
+
+func (m *memory) ClaimDeliveries(ctx context.Context, limit int) ([]models.WebhookDelivery, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deliveries := make([]models.WebhookDelivery, 0)
+	now := time.Now()
+
+	for i := range m.state.deliveries {
+
+		if len(deliveries) == limit {
+			break
+		}
+
+		delivery := &m.state.deliveries[i]
+		if delivery.Status != models.DeliveryPending || delivery.NextAttemptAt.After(now) {
+			continue
+		}
+
+		var subscription *models.WebhookSubscription
+		for j := range m.state.webhooks {
+			if m.state.webhooks[j].subscription.ID == delivery.SubscriptionID {
+				subscription = &m.state.webhooks[j].subscription
+			}
+		}
+		if subscription == nil {
+			continue
+		}
+
+		// Аренда доставки
+		delivery.NextAttemptAt = now.Add(models.WebhookLease)
+		delivery.Attempts++
+
+		claimed := *delivery
+		claimed.URL, claimed.Secret = subscription.URL, subscription.Secret
+		deliveries = append(deliveries, claimed)
+	}
+
+	return deliveries, nil
+}
+
+func (m *memory) CompleteDelivery(ctx context.Context, result models.DeliveryResult) error {
+	return m.tx(func(st *memoryState) error {
+		for i := range st.deliveries {
+			delivery := &st.deliveries[i]
+			if delivery.ID != result.ID {
+				continue
+			}
+
+			delivery.Status = result.Status
+			delivery.LastStatus = result.LastStatus
+			delivery.LastError = result.LastError
+			delivery.NextAttemptAt = result.NextAttemptAt
+			delivery.DeliveredAt = nil
+			if result.Status == models.DeliveryDelivered {
+				now := time.Now()
+				delivery.DeliveredAt = &now
+			}
+		}
+		return nil
+	})
+}
+
+func (m *memory) CreateRegistryEntry(ctx context.Context, kind string, entry models.RegistryEntry) (models.RegistryEntry, error) {
+
+	err := m.tx(func(st *memoryState) error {
+
+		entries, err := st.registry(kind, token.Tenant(ctx))
+		if err != nil {
+			return err
+		}
+
+		if entry.ID == 0 {
+			for id := range entries {
+				if id > int(entry.ID) {
+					entry.ID = uint32(id)
+				}
+			}
+			entry.ID++
+		}
+
+		if _, taken := entries[int(entry.ID)]; taken {
+			return models.ErrEntryExists
+		}
+		for _, other := range entries {
+			if other.Name == entry.Name {
+				return models.ErrNameTaken
+			}
+		}
+
+		entry.CreatedAt = time.Now()
+		entries[int(entry.ID)] = entry
+		return nil
+	})
+	if err != nil {
+		return models.RegistryEntry{}, err
+	}
+
+	return entry, nil
+}
+
+func (m *memory) GetRegistryEntries(ctx context.Context, kind, name string) ([]models.RegistryEntry, error) {
+
+	var (
+		entries = make([]models.RegistryEntry, 0)
+		err     error
+	)
+
+	m.read(func(st *memoryState) {
+
+		tenants, ok := st.registries[kind]
+		if !ok {
+			err = errUnknownRegistry
+			return
+		}
+
+		registry := tenants[token.Tenant(ctx)]
+		for _, id := range sortedKeys(registry) {
+			if name == "" || registry[id].Name == name {
+				entries = append(entries, registry[id])
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (m *memory) GetRegistryEntry(ctx context.Context, kind string, id int) (models.RegistryEntry, bool, error) {
+
+	var (
+		entry models.RegistryEntry
+		found bool
+		err   error
+	)
+
+	m.read(func(st *memoryState) {
+		tenants, ok := st.registries[kind]
+		if !ok {
+			err = errUnknownRegistry
+			return
+		}
+		entry, found = tenants[token.Tenant(ctx)][id]
+	})
+
+	return entry, found, err
+}
+
+func (m *memory) UpdateRegistryEntry(ctx context.Context, kind string, entry models.RegistryEntry) (bool, error) {
+
+	var found bool
+
+	err := m.tx(func(st *memoryState) error {
+
+		entries, err := st.registry(kind, token.Tenant(ctx))
+		if err != nil {
+			return err
+		}
+
+		current, ok := entries[int(entry.ID)]
+		if !ok {
+			return nil
+		}
+		for id, other := range entries {
+			if id != int(entry.ID) && other.Name == entry.Name {
+				return models.ErrNameTaken
+			}
+		}
+
+		current.Name, current.Description, current.Owner = entry.Name, entry.Description, entry.Owner
+		entries[int(entry.ID)] = current
+		found = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return found, nil
+}
+
+func (m *memory) DeleteRegistryEntry(ctx context.Context, kind string, id int, force bool) (bool, error) {
+
+	var found bool
+
+	tenant := token.Tenant(ctx)
+
+	err := m.tx(func(st *memoryState) error {
+
+		entries, err := st.registry(kind, tenant)
+		if err != nil {
+			return err
+		}
+		if _, found = entries[id]; !found {
+			return nil
+		}
+
+		if !force {
+			for p := range st.pairs {
+				if p.tenant != tenant {
+					continue
+				}
+				if (kind == models.RegistryFeature && p.feature == id) || (kind == models.RegistryTag && p.tag == id) {
+					found = false
+					return fmt.Errorf("%s %d %w", kind, id, models.ErrEntryInUse)
+				}
+			}
+		}
+
+		delete(entries, id)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return found, nil
+}
+
+// Справочник проекта, пустой справочник создается при первом изменении
+func (st *memoryState) registry(kind, tenant string) (map[int]models.RegistryEntry, error) {
+
+	tenants, ok := st.registries[kind]
+	if !ok {
+		return nil, errUnknownRegistry
+	}
+
+	if tenants[tenant] == nil {
+		tenants[tenant] = make(map[int]models.RegistryEntry)
+	}
+
+	return tenants[tenant], nil
+}
+
+func (m *memory) GetBannerLocales(ctx context.Context, bannerID int) ([]models.BannerLocale, bool, error) {
+
+	var (
+		locales = make([]models.BannerLocale, 0)
+		found   bool
+	)
+
+	m.read(func(st *memoryState) {
+		if st.snapshot(bannerID) == nil || !st.owned(token.Tenant(ctx), bannerID) {
+			return
+		}
+		found = true
+
+		banner := st.banners[bannerID]
+		for _, locale := range sortedLocales(banner.locales) {
+			locales = append(locales, banner.locales[locale])
+		}
+	})
+
+	if !found {
+		return nil, false, nil
+	}
+
+	return locales, true, nil
+}
+
+func (m *memory) SetBannerLocale(ctx context.Context, bannerID int, locale models.BannerLocale) (models.BannerLocale, bool, error) {
+
+	var found bool
+
+	err := m.tx(func(st *memoryState) error {
+
+		before := st.snapshot(bannerID)
+		if before == nil || !st.owned(token.Tenant(ctx), bannerID) {
+			return nil
+		}
+
+		var last models.BannerHistory
+		for _, version := range st.localeHistory[bannerID] {
+			if version.Locale == locale.Locale && version.Version > last.Version {
+				last = version
+			}
+		}
+
+		locale.Version = last.Version
+		if last.Version == 0 || (models.BannerContent{Title: last.Title, Text: last.Text, Url: last.Url}) != locale.Content {
+			locale.Version++
+			st.localeHistory[bannerID] = append(st.localeHistory[bannerID], models.BannerHistory{
+				BannerID: uint32(bannerID),
+				Version:  locale.Version,
+				Title:    locale.Content.Title,
+				Text:     locale.Content.Text,
+				Url:      locale.Content.Url,
+				Locale:   locale.Locale,
+			})
+		}
+
+		banner := st.banners[bannerID]
+		if banner.locales == nil {
+			banner.locales = make(map[string]models.BannerLocale)
+		}
+		banner.locales[locale.Locale] = locale
+
+		found = true
+		return st.recordChange(ctx, models.AuditLocaleUpdate, bannerID, before, st.snapshot(bannerID))
+	})
+	if err != nil || !found {
+		return models.BannerLocale{}, false, err
+	}
+
+	return locale, true, nil
+}
+
+func (m *memory) DeleteBannerLocale(ctx context.Context, bannerID int, locale string) (bool, error) {
+
+	var found bool
+
+	err := m.tx(func(st *memoryState) error {
+
+		before := st.snapshot(bannerID)
+		if before == nil || !st.owned(token.Tenant(ctx), bannerID) {
+			return nil
+		}
+
+		banner := st.banners[bannerID]
+		if _, found = banner.locales[locale]; !found {
+			return nil
+		}
+		delete(banner.locales, locale)
+
+		return st.recordChange(ctx, models.AuditLocaleDelete, bannerID, before, st.snapshot(bannerID))
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return found, nil
+}
+
+func (m *memory) GetLocaleHistory(ctx context.Context, bannerID int, locale string) ([]models.BannerHistory, error) {
+
+	history := make([]models.BannerHistory, 0)
+
+	m.read(func(st *memoryState) {
+		if !st.owned(token.Tenant(ctx), bannerID) {
+			return
+		}
+		for _, version := range st.localeHistory[bannerID] {
+			if version.Locale == locale {
+				history = append(history, version)
+			}
+		}
+	})
+
+	sort.Slice(history, func(i, j int) bool { return history[i].Version < history[j].Version })
+
+	return history, nil
+}
+
+func (m *memory) GetBannerContent(ctx context.Context, bannerID int) (models.BannerContent, bool, error) {
+
+	var (
+		content models.BannerContent
+		found   bool
+	)
+
+	m.read(func(st *memoryState) {
+		if st.snapshot(bannerID) == nil || !st.owned(token.Tenant(ctx), bannerID) {
+			return
+		}
+		content, found = st.banners[bannerID].content, true
+	})
+
+	return content, found, nil
+}
+
+func (m *memory) CreateDraft(ctx context.Context, draftRequest models.DraftRequest) (models.Draft, bool, error) {
+
+	var draft models.Draft
+
+	err := m.tx(func(st *memoryState) error {
+
+		tenant := token.Tenant(ctx)
+		bannerID := int(draftRequest.BannerID)
+
+		live := st.snapshot(bannerID)
+		if live == nil || !st.owned(tenant, bannerID) {
+			return nil
+		}
+
+		status, action := models.DraftStatusDraft, models.DraftActionComment
+		if draftRequest.Submit {
+			status, action = models.DraftStatusPending, models.DraftActionSubmit
+		}
+
+		now := time.Now()
+		st.lastDraft++
+		draft = models.Draft{
+			DraftID:   st.lastDraft,
+			BannerID:  draftRequest.BannerID,
+			Content:   draftRequest.Content,
+			Status:    status,
+			Author:    token.Actor(ctx),
+			Comments:  make([]models.DraftComment, 0),
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		draft.Comments = addComment(ctx, draft.Comments, action, draftRequest.Comment, now)
+		st.drafts[draft.DraftID] = memoryDraft{tenant: tenant, draft: draft}
+
+		if draftRequest.Submit {
+			return st.requestReview(ctx, live, draft.Content, now)
+		}
+		return nil
+	})
+	if err != nil || draft.DraftID == 0 {
+		return models.Draft{}, false, err
+	}
+
+	return draft, true, nil
+}
+
+func (m *memory) GetDrafts(ctx context.Context, draftQuery models.DraftQuery) ([]models.Draft, error) {
+
+	drafts := make([]models.Draft, 0)
+
+	m.read(func(st *memoryState) {
+		for _, id := range sortedDraftIDs(st.drafts) {
+			draft, ok := st.visibleDraft(token.Tenant(ctx), id)
+			if !ok {
+				continue
+			}
+			if draftQuery.BannerID != 0 && int(draft.BannerID) != draftQuery.BannerID {
+				continue
+			}
+			if draftQuery.Status != "" && draft.Status != draftQuery.Status {
+				continue
+			}
+			drafts = append(drafts, draft)
+		}
+	})
+
+	return drafts, nil
+}
+
+func (m *memory) GetDraft(ctx context.Context, draftID int64) (models.Draft, bool, error) {
+
+	var (
+		draft models.Draft
+		found bool
+	)
+
+	m.read(func(st *memoryState) {
+		draft, found = st.visibleDraft(token.Tenant(ctx), draftID)
+	})
+
+	return draft, found, nil
+}
+
+func (m *memory) UpdateDraft(ctx context.Context, draftID int64, draftAction models.DraftAction) (models.Draft, bool, error) {
+
+	var (
+		draft models.Draft
+		found bool
+	)
+
+	err := m.tx(func(st *memoryState) error {
+
+		var ok bool
+		if draft, ok = st.visibleDraft(token.Tenant(ctx), draftID); !ok {
+			return nil
+		}
+
+		actor := token.Actor(ctx)
+
+		status, err := nextDraftStatus(draft, draftAction.Action, actor)
+		if err != nil {
+			return err
+		}
+
+		switch draftAction.Action {
+		case models.DraftActionSubmit:
+			draft.Reviewer = ""
+			if draftAction.Content != nil {
+				draft.Content = *draftAction.Content
+			}
+		case models.DraftActionApprove, models.DraftActionReject:
+			draft.Reviewer = actor
+		}
+
+		now := time.Now()
+		draft.Status = status
+		draft.UpdatedAt = now
+		draft.Comments = addComment(ctx, draft.Comments, draftAction.Action, draftAction.Comment, now)
+		st.drafts[draftID] = memoryDraft{tenant: st.drafts[draftID].tenant, draft: draft}
+
+		found = true
+
+		bannerID := int(draft.BannerID)
+		switch draftAction.Action {
+		case models.DraftActionSubmit:
+			return st.requestReview(ctx, st.snapshot(bannerID), draft.Content, now)
+		case models.DraftActionApprove:
+			before := st.snapshot(bannerID)
+			st.banners[bannerID].content = draft.Content
+			if last := st.lastVersion(bannerID); last.Title != draft.Content.Title ||
+				last.Text != draft.Content.Text ||
+				last.Url != draft.Content.Url {
+				if err = st.insertVersion(bannerID, last.Version+1, draft.Content); err != nil {
+					return err
+				}
+			}
+			return st.recordChange(ctx, models.AuditPublish, bannerID, before, st.snapshot(bannerID))
+		}
+		return nil
+	})
+	if err != nil || !found {
+		return models.Draft{}, false, err
+	}
+
+	draft.Comments = append([]models.DraftComment(nil), draft.Comments...)
+	return draft, true, nil
+}
+
+// Как и в dbase, черновик виден, пока баннер проекта не удален
+func (st *memoryState) visibleDraft(tenant string, draftID int64) (models.Draft, bool) {
+
+	d, ok := st.drafts[draftID]
+	if !ok || d.tenant != tenant || st.snapshot(int(d.draft.BannerID)) == nil {
+		return models.Draft{}, false
+	}
+
+	draft := d.draft
+	draft.Comments = append(make([]models.DraftComment, 0, len(d.draft.Comments)), d.draft.Comments...)
+	return draft, true
+}
+
+// Аналог requestReview
+func (st *memoryState) requestReview(ctx context.Context, live *models.ResponseBody, content models.BannerContent, now time.Time) error {
+
+	proposed := *live
+	proposed.Content = content
+
+	return st.writeOutbox(models.EventReviewRequested, token.Tenant(ctx), int(live.BannerID), token.Actor(ctx), live, &proposed, now)
+}
+
+// Черновики баннера удаляются вместе с ним
+func (st *memoryState) deleteDrafts(bannerID int) {
+	for id, d := range st.drafts {
+		if int(d.draft.BannerID) == bannerID {
+			delete(st.drafts, id)
+		}
+	}
+}
+
+// Аналог addDraftComment
+func addComment(ctx context.Context, comments []models.DraftComment, action, text string, now time.Time) []models.DraftComment {
+	if text == "" {
+		return comments
+	}
+	return append(comments, models.DraftComment{
+		Author:    token.Actor(ctx),
+		Action:    action,
+		Text:      text,
+		CreatedAt: now,
+	})
+}
+
+func sortedDraftIDs(drafts map[int64]memoryDraft) []int64 {
+	ids := make([]int64, 0, len(drafts))
+	for id := range drafts {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Аналог checkRegistered
+func (st *memoryState) checkRegistered(tenant string, featureID uint32, tags []uint32) error {
+
+	if _, ok := st.registries[models.RegistryFeature][tenant][int(featureID)]; !ok {
+		return fmt.Errorf("%w: %d", models.ErrUnknownFeature, featureID)
+	}
+
+	for _, tag := range tags {
+		if _, ok := st.registries[models.RegistryTag][tenant][int(tag)]; !ok {
+			return fmt.Errorf("%w: %d", models.ErrUnknownTag, tag)
+		}
+	}
+
+	return nil
+}
+
+// Страница выборки, как LIMIT и OFFSET
+func page[T any](rows []T, limit, offset int) []T {
+	if offset > 0 {
+		if offset >= len(rows) {
+			return rows[:0]
+		}
+		rows = rows[offset:]
+	}
+	if limit > 0 && limit < len(rows) {
+		rows = rows[:limit]
+	}
+	return rows
+}
+
+func sortedLocales(locales map[string]models.BannerLocale) []string {
+	keys := make([]string, 0, len(locales))
+	for key := range locales {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedIDs(ids map[int]bool) []int {
+	return sortedKeys(ids)
+}
+
+func sortedKeys[V any](values map[int]V) []int {
+	keys := make([]int, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+func (m *memory) Close() error {
+	return nil
+}
@@ -0,0 +1,145 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+)
+
+// Тип события по действию администратора
+func eventType(action string) string {
+	switch action {
+	case models.AuditCreate:
+		return models.EventBannerCreated
+	case models.AuditDelete:
+		return models.EventBannerDeleted
+	case models.AuditVersionSwitch:
+		return models.EventBannerVersionSwitched
+	default:
+		return models.EventBannerUpdated
+	}
+}
+
+// Запись события в outbox, публикует его фоновый relay.
+// Там же событие ставится в очередь доставки подписчикам вебхуков
+func writeOutbox(ctx context.Context, tx *sql.Tx, event string, bannerID int, actor string, before, after *models.ResponseBody) error {
+
+	tenant := token.Tenant(ctx)
+
+	outboxEvent := models.Event{
+		Type: event,
+		EventPayload: models.EventPayload{
+			Tenant:   tenant,
+			BannerID: uint32(bannerID),
+			Actor:    actor,
+			Before:   before,
+			After:    after,
+		},
+	}
+
+	payload, err := json.Marshal(outboxEvent.EventPayload)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, `INSERT INTO outbox
+								(tenant, event_type, banner_id, payload)
+								VALUES($1, $2, $3, $4)
+								RETURNING id, created_at`,
+		tenant,
+		event,
+		bannerID,
+		string(payload),
+	).Scan(&outboxEvent.ID, &outboxEvent.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	webhookPayload, err := json.Marshal(outboxEvent)
+	if err != nil {
+		return err
+	}
+
+	return enqueueDeliveries(ctx, tx, outboxEvent.ID, event, webhookPayload, before, after)
+}
+
+// Публикация неопубликованных событий пачкой не больше limit.
+// Строки блокируются, поэтому несколько инстансов не публикуют одно событие одновременно.
+// Публикация останавливается на первой ошибке, опубликованные до нее события отмечаются.
+func (d dbase) RelayOutbox(ctx context.Context, limit int, publish func(context.Context, models.Event) error) (int, error) {
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, tenant, event_type, payload, created_at
+									FROM outbox
+									WHERE published_at IS NULL
+									ORDER BY id
+									LIMIT $1
+									FOR UPDATE SKIP LOCKED`, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	events := make([]models.Event, 0)
+	for rows.Next() {
+
+		var (
+			event   models.Event
+			payload []byte
+		)
+
+		var tenant string
+
+		if err = rows.Scan(&event.ID, &tenant, &event.Type, &payload, &event.CreatedAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		if err = json.Unmarshal(payload, &event.EventPayload); err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		// В событиях, записанных до появления проектов, проекта нет в payload
+		event.Tenant = tenant
+
+		events = append(events, event)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	published := make([]int64, 0, len(events))
+	var publishErr error
+	for _, event := range events {
+		if publishErr = publish(ctx, event); publishErr != nil {
+			break
+		}
+		published = append(published, event.ID)
+	}
+
+	if len(published) > 0 {
+		_, err = tx.ExecContext(ctx, `UPDATE outbox
+									SET published_at = now()
+									WHERE id = ANY($1)`, published)
+		if err != nil {
+			return 0, err
+		}
+
+		if err = tx.Commit(); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(published), publishErr
+}
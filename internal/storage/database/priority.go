@@ -0,0 +1,47 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+)
+
+// Приоритет баннера и баннеры фич по умолчанию. У фичи проекта не больше
+// одного баннера по умолчанию, строка сохраняется при мягком удалении баннера
+func createPriorities(db *sql.DB) error {
+
+	_, err := db.Exec(`ALTER TABLE actual_banner
+					ADD COLUMN IF NOT EXISTS priority int NOT NULL DEFAULT 0;
+					CREATE TABLE IF NOT EXISTS feature_default
+					(tenant text NOT NULL,
+					feature_id bigint NOT NULL,
+					banner_id bigint NOT NULL,
+					PRIMARY KEY (tenant, feature_id));
+					CREATE INDEX IF NOT EXISTS feature_default_banner_id_idx ON feature_default (banner_id)`)
+
+	return err
+}
+
+// Баннер становится баннером фичи по умолчанию вместо прежнего или перестает им быть
+func setFeatureDefault(ctx context.Context, tx *sql.Tx, featureID uint32, bannerID int, isDefault bool) error {
+
+	// Баннер мог сменить фичу, прежняя отметка снимается в любом случае
+	_, err := tx.ExecContext(ctx, `DELETE FROM feature_default
+									WHERE banner_id = $1`, bannerID)
+	if err != nil || !isDefault {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO feature_default
+									(tenant, feature_id, banner_id)
+									VALUES($1, $2, $3)
+									ON CONFLICT (tenant, feature_id) DO UPDATE
+									SET banner_id = EXCLUDED.banner_id`,
+		token.Tenant(ctx),
+		featureID,
+		bannerID,
+	)
+
+	return err
+}
@@ -0,0 +1,323 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+)
+
+var errUnknownRegistry = errors.New("unknown registry, expected feature or tag")
+
+// Таблица справочника и колонка tag_feature, которая на него ссылается
+func registryTable(kind string) (string, string, error) {
+	switch kind {
+	case models.RegistryFeature:
+		return "feature", "feature_id", nil
+	case models.RegistryTag:
+		return "tag", "tag_id", nil
+	default:
+		return "", "", errUnknownRegistry
+	}
+}
+
+// Справочники фич и тэгов, у каждого проекта свои. Уже существующие id баннеров
+// регистрируются один раз, пока справочник проекта пуст, чтобы их можно было использовать и дальше
+func createRegistries(db *sql.DB) error {
+
+	for _, kind := range []string{models.RegistryFeature, models.RegistryTag} {
+
+		table, column, err := registryTable(kind)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Exec(`CREATE TABLE IF NOT EXISTS ` + table + `
+						(tenant text NOT NULL DEFAULT '` + models.DefaultTenant + `',
+						id bigint NOT NULL,
+						name text NOT NULL,
+						description text NOT NULL DEFAULT '',
+						owner text NOT NULL DEFAULT '',
+						created_at timestamptz NOT NULL DEFAULT now())`)
+		if err != nil {
+			return err
+		}
+
+		// id и имя уникальны внутри проекта
+		_, err = db.Exec(`ALTER TABLE ` + table + `
+						ADD COLUMN IF NOT EXISTS tenant text NOT NULL DEFAULT '` + models.DefaultTenant + `';
+						ALTER TABLE ` + table + ` DROP CONSTRAINT IF EXISTS ` + table + `_pkey;
+						ALTER TABLE ` + table + ` DROP CONSTRAINT IF EXISTS ` + table + `_name_key;
+						CREATE UNIQUE INDEX IF NOT EXISTS ` + table + `_tenant_id_idx ON ` + table + ` (tenant, id);
+						CREATE UNIQUE INDEX IF NOT EXISTS ` + table + `_tenant_name_idx ON ` + table + ` (tenant, name)`)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Exec(`INSERT INTO ` + table + ` (tenant, id, name)
+						SELECT DISTINCT tenant, ` + column + `, '` + kind + ` ' || ` + column + `
+						FROM (SELECT tenant, ` + column + ` FROM tag_feature
+							UNION SELECT tenant, ` + column + ` FROM deleted_tag_feature) AS used
+						WHERE NOT EXISTS (SELECT 1 FROM ` + table + ` WHERE ` + table + `.tenant = used.tenant)
+						ON CONFLICT DO NOTHING`)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Регистрация фичи или тэга, без id берется следующий за наибольшим
+func (d dbase) CreateRegistryEntry(ctx context.Context, kind string, entry models.RegistryEntry) (models.RegistryEntry, error) {
+
+	table, _, err := registryTable(kind)
+	if err != nil {
+		return models.RegistryEntry{}, err
+	}
+
+	tenant := token.Tenant(ctx)
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return models.RegistryEntry{}, err
+	}
+
+	defer tx.Rollback()
+
+	// Регистрации выполняются по очереди, иначе две могут получить один id
+	if _, err = tx.ExecContext(ctx, `LOCK TABLE `+table+` IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return models.RegistryEntry{}, err
+	}
+
+	if entry.ID == 0 {
+		if err = tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(id), 0) + 1 FROM `+table+` WHERE tenant = $1`, tenant).Scan(&entry.ID); err != nil {
+			return models.RegistryEntry{}, err
+		}
+	}
+
+	var idTaken, nameTaken bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM `+table+` WHERE tenant = $3 AND id = $1),
+									EXISTS (SELECT 1 FROM `+table+` WHERE tenant = $3 AND name = $2)`,
+		entry.ID, entry.Name, tenant).Scan(&idTaken, &nameTaken)
+	if err != nil {
+		return models.RegistryEntry{}, err
+	}
+	if idTaken {
+		return models.RegistryEntry{}, models.ErrEntryExists
+	}
+	if nameTaken {
+		return models.RegistryEntry{}, models.ErrNameTaken
+	}
+
+	err = tx.QueryRowContext(ctx, `INSERT INTO `+table+`
+									(tenant, id, name, description, owner)
+									VALUES($1, $2, $3, $4, $5)
+									RETURNING created_at`,
+		tenant,
+		entry.ID,
+		entry.Name,
+		entry.Description,
+		entry.Owner,
+	).Scan(&entry.CreatedAt)
+	if err != nil {
+		return models.RegistryEntry{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.RegistryEntry{}, err
+	}
+
+	return entry, nil
+}
+
+// Фичи или тэги справочника, с непустым name только запись с этим именем
+func (d dbase) GetRegistryEntries(ctx context.Context, kind, name string) ([]models.RegistryEntry, error) {
+
+	entries := make([]models.RegistryEntry, 0)
+
+	table, _, err := registryTable(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := d.db.QueryContext(ctx, `SELECT id, name, description, owner, created_at
+										FROM `+table+`
+										WHERE tenant = $2
+										AND ($1 = '' OR name = $1)
+										ORDER BY id`, name, token.Tenant(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry models.RegistryEntry
+		if err = rows.Scan(&entry.ID, &entry.Name, &entry.Description, &entry.Owner, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	// проверяем на ошибки
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (d dbase) GetRegistryEntry(ctx context.Context, kind string, id int) (models.RegistryEntry, bool, error) {
+
+	var entry models.RegistryEntry
+
+	table, _, err := registryTable(kind)
+	if err != nil {
+		return models.RegistryEntry{}, false, err
+	}
+
+	err = d.db.QueryRowContext(ctx, `SELECT id, name, description, owner, created_at
+									FROM `+table+`
+									WHERE tenant = $2
+									AND id = $1`, id, token.Tenant(ctx)).
+		Scan(&entry.ID, &entry.Name, &entry.Description, &entry.Owner, &entry.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.RegistryEntry{}, false, nil
+	}
+	if err != nil {
+		return models.RegistryEntry{}, false, err
+	}
+
+	return entry, true, nil
+}
+
+// Изменение имени, описания и владельца, false если записи нет
+func (d dbase) UpdateRegistryEntry(ctx context.Context, kind string, entry models.RegistryEntry) (bool, error) {
+
+	table, _, err := registryTable(kind)
+	if err != nil {
+		return false, err
+	}
+
+	tenant := token.Tenant(ctx)
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	defer tx.Rollback()
+
+	var nameTaken bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM `+table+` WHERE tenant = $3 AND name = $1 AND id <> $2)`,
+		entry.Name, entry.ID, tenant).Scan(&nameTaken)
+	if err != nil {
+		return false, err
+	}
+	if nameTaken {
+		return false, models.ErrNameTaken
+	}
+
+	res, err := tx.ExecContext(ctx, `UPDATE `+table+`
+									SET name = $1,
+									description = $2,
+									owner = $3
+									WHERE id = $4
+									AND tenant = $5`,
+		entry.Name,
+		entry.Description,
+		entry.Owner,
+		entry.ID,
+		tenant,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Удаление из справочника, пока на запись ссылаются баннеры, только с force.
+// Баннеры при этом не меняются. false если записи нет
+func (d dbase) DeleteRegistryEntry(ctx context.Context, kind string, id int, force bool) (bool, error) {
+
+	table, column, err := registryTable(kind)
+	if err != nil {
+		return false, err
+	}
+
+	tenant := token.Tenant(ctx)
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	defer tx.Rollback()
+
+	// Сначала удаляем: строка блокируется до конца транзакции и новые баннеры на нее не сошлются
+	res, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE tenant = $2 AND id = $1`, id, tenant)
+	if err != nil {
+		return false, err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	if !force {
+		var used bool
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM tag_feature WHERE tenant = $2 AND `+column+` = $1)`, id, tenant).Scan(&used)
+		if err != nil {
+			return false, err
+		}
+		if used {
+			return false, fmt.Errorf("%s %d %w", kind, id, models.ErrEntryInUse)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Фича и тэги баннера должны быть в справочниках проекта. Строки справочников блокируются
+// до конца транзакции, чтобы их не удалили раньше, чем баннер будет создан
+func checkRegistered(ctx context.Context, tx *sql.Tx, featureID uint32, tags []uint32) error {
+
+	if err := lockEntry(ctx, tx, "feature", featureID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %d", models.ErrUnknownFeature, featureID)
+		}
+		return err
+	}
+
+	for _, tag := range tags {
+		if err := lockEntry(ctx, tx, "tag", tag); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("%w: %d", models.ErrUnknownTag, tag)
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func lockEntry(ctx context.Context, tx *sql.Tx, table string, id uint32) error {
+	return tx.QueryRowContext(ctx, `SELECT id FROM `+table+` WHERE tenant = $2 AND id = $1 FOR SHARE`,
+		id, token.Tenant(ctx)).Scan(&id)
+}
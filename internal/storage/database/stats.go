@@ -0,0 +1,135 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+)
+
+// Пакетная запись накопленных показов и кликов
+func (d dbase) SaveStats(ctx context.Context, deltas []models.StatsDelta) error {
+
+	if len(deltas) == 0 {
+		return nil
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO banner_stats
+								(banner_id, feature_id, tag_id, bucket, impressions, clicks)
+								VALUES($1, $2, $3, $4, $5, $6)
+								ON CONFLICT (banner_id, feature_id, tag_id, bucket) DO UPDATE
+								SET impressions = banner_stats.impressions + EXCLUDED.impressions,
+								clicks = banner_stats.clicks + EXCLUDED.clicks`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, delta := range deltas {
+		_, err = stmt.ExecContext(ctx,
+			delta.BannerID,
+			delta.FeatureID,
+			delta.TagID,
+			delta.Bucket,
+			delta.Impressions,
+			delta.Clicks,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Показы, клики и CTR по баннерам проекта за интервал времени
+func (d dbase) GetStats(ctx context.Context, statsQuery models.StatsQuery) ([]models.BannerStats, error) {
+
+	var (
+		conditions []string
+		args       []interface{}
+	)
+
+	stats := make([]models.BannerStats, 0)
+
+	args = append(args, token.Tenant(ctx))
+	conditions = append(conditions, "banner_id IN (SELECT banner_id FROM actual_banner WHERE tenant = $1)")
+
+	if statsQuery.BannerID != 0 {
+		args = append(args, statsQuery.BannerID)
+		conditions = append(conditions, "banner_id = $"+strconv.Itoa(len(args)))
+	}
+	if !statsQuery.From.IsZero() {
+		args = append(args, statsQuery.From)
+		conditions = append(conditions, "bucket >= $"+strconv.Itoa(len(args)))
+	}
+	if !statsQuery.To.IsZero() {
+		args = append(args, statsQuery.To)
+		conditions = append(conditions, "bucket < $"+strconv.Itoa(len(args)))
+	}
+
+	query := `SELECT banner_id, SUM(impressions), SUM(clicks)
+				FROM banner_stats
+				WHERE ` + strings.Join(conditions, " AND ")
+	query += " GROUP BY banner_id ORDER BY banner_id"
+
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var banner models.BannerStats
+
+		if err = rows.Scan(&banner.BannerID, &banner.Impressions, &banner.Clicks); err != nil {
+			return nil, err
+		}
+
+		if banner.Impressions > 0 {
+			banner.CTR = float64(banner.Clicks) / float64(banner.Impressions)
+		}
+
+		stats = append(stats, banner)
+	}
+
+	// проверяем на ошибки
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// Ссылка действующего баннера для перехода по клику. Переход выполняется без токена,
+// ID баннеров уникальны во всех проектах
+func (d dbase) GetBannerURL(ctx context.Context, bannerID int) (string, error) {
+
+	var url string
+
+	row := d.db.QueryRowContext(ctx, `SELECT url
+									FROM actual_banner
+									WHERE banner_id = $1
+									AND is_active = true
+									AND deleted_at IS NULL`, bannerID)
+	if err := row.Scan(&url); err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return url, nil
+}
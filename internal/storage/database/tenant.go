@@ -0,0 +1,41 @@
+package database
+
+import (
+	"database/sql"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+)
+
+// Таблицы, строки которых принадлежат проекту (tenant). История, статистика и доставки
+// вебхуков принадлежат проекту через баннер или подписку
+var tenantTables = []string{
+	"actual_banner",
+	"tag_feature",
+	"deleted_tag_feature",
+	"experiment_variant",
+	"webhook_subscription",
+	"audit_log",
+	"outbox",
+}
+
+// Колонка проекта в таблицах. Строки, созданные до появления проектов,
+// относятся к проекту по умолчанию
+func createTenants(db *sql.DB) error {
+
+	for _, table := range tenantTables {
+		_, err := db.Exec(`ALTER TABLE ` + table + `
+						ADD COLUMN IF NOT EXISTS tenant text NOT NULL DEFAULT '` + models.DefaultTenant + `'`)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Пара фича + тэг уникальна внутри проекта, а не во всем сервисе
+	_, err := db.Exec(`ALTER TABLE tag_feature DROP CONSTRAINT IF EXISTS tag_feature_pkey;
+					CREATE UNIQUE INDEX IF NOT EXISTS tag_feature_tenant_pair_idx
+					ON tag_feature (tenant, feature_id, tag_id);
+					CREATE INDEX IF NOT EXISTS actual_banner_tenant_idx ON actual_banner (tenant);
+					CREATE INDEX IF NOT EXISTS audit_log_tenant_idx ON audit_log (tenant)`)
+
+	return err
+}
@@ -0,0 +1,434 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+)
+
+var (
+	errEmptyTags      = errors.New("tag_id is empty")
+	errSeveralBanners = errors.New("feature and tag pairs belong to several banners")
+)
+
+// Потоковая выгрузка всех действующих баннеров проекта, для каждого баннера вызывается fn.
+// История читается вторым курсором в том же порядке и сливается с баннерами.
+func (d dbase) ExportBanners(ctx context.Context, withHistory bool, fn func(models.ExportBanner) error) error {
+
+	tenant := token.Tenant(ctx)
+
+	rows, err := d.db.QueryContext(ctx, `SELECT actual_banner.banner_id,
+										actual_banner.title,
+										actual_banner.text,
+										actual_banner.url,
+										actual_banner.is_active,
+										actual_banner.priority,
+										EXISTS(SELECT 1 FROM feature_default
+											WHERE feature_default.banner_id = actual_banner.banner_id),
+										tag_feature.feature_id,
+										tag_feature.tag_id
+										FROM actual_banner
+										INNER JOIN tag_feature
+										ON actual_banner.banner_id = tag_feature.banner_id
+										WHERE actual_banner.tenant = $1
+										AND actual_banner.deleted_at IS NULL
+										ORDER BY actual_banner.banner_id, tag_feature.tag_id`, tenant)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var history *historyCursor
+	if withHistory {
+		history, err = d.newHistoryCursor(ctx, tenant)
+		if err != nil {
+			return err
+		}
+		defer history.close()
+	}
+
+	var (
+		current models.ExportBanner
+		started bool
+	)
+
+	// Отдаем собранный баннер
+	emit := func() error {
+		if history != nil {
+			if current.History, err = history.next(current.BannerID); err != nil {
+				return err
+			}
+		}
+		return fn(current)
+	}
+
+	for rows.Next() {
+
+		var (
+			banner models.ExportBanner
+			tag    uint32
+		)
+
+		err = rows.Scan(&banner.BannerID, &banner.Content.Title, &banner.Content.Text, &banner.Content.Url,
+			&banner.Active, &banner.Priority, &banner.FeatureDefault, &banner.FeatureID, &tag)
+		if err != nil {
+			return err
+		}
+
+		// Строки одного баннера идут подряд
+		if !started || current.BannerID != banner.BannerID {
+			if started {
+				if err = emit(); err != nil {
+					return err
+				}
+			}
+			current, started = banner, true
+		}
+
+		current.TagID = append(current.TagID, tag)
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if started {
+		return emit()
+	}
+
+	return nil
+}
+
+// Курсор по истории всех баннеров проекта, упорядоченной по ID и версии
+type historyCursor struct {
+	rows    *sql.Rows
+	pending *models.BannerHistory
+	done    bool
+}
+
+func (d dbase) newHistoryCursor(ctx context.Context, tenant string) (*historyCursor, error) {
+	rows, err := d.db.QueryContext(ctx, `SELECT banner_id, version, title, text, url
+										FROM history_banner
+										WHERE banner_id IN (SELECT banner_id
+											FROM actual_banner
+											WHERE tenant = $1)
+										ORDER BY banner_id, version`, tenant)
+	if err != nil {
+		return nil, err
+	}
+	return &historyCursor{rows: rows}, nil
+}
+
+// Версии баннера bannerID, версии баннеров с меньшим ID пропускаются
+func (c *historyCursor) next(bannerID uint32) ([]models.BannerHistory, error) {
+
+	versions := make([]models.BannerHistory, 0)
+
+	for {
+		if c.pending == nil {
+			if c.done || !c.rows.Next() {
+				c.done = true
+				return versions, c.rows.Err()
+			}
+
+			var version models.BannerHistory
+			err := c.rows.Scan(&version.BannerID, &version.Version, &version.Title, &version.Text, &version.Url)
+			if err != nil {
+				return nil, err
+			}
+			c.pending = &version
+		}
+
+		switch {
+		case c.pending.BannerID < bannerID:
+			c.pending = nil
+		case c.pending.BannerID == bannerID:
+			versions = append(versions, *c.pending)
+			c.pending = nil
+		default:
+			return versions, nil
+		}
+	}
+}
+
+func (c *historyCursor) close() {
+	c.rows.Close()
+}
+
+// Загрузка баннеров в одной транзакции, каждая строка выполняется в своей точке сохранения.
+// В режиме atomic любая ошибка откатывает всю загрузку, в режиме best_effort
+// откатывается только строка с ошибкой. В режиме dry run транзакция всегда откатывается.
+func (d dbase) ImportBanners(ctx context.Context, banners []models.ExportBanner, options models.ImportOptions) ([]models.ImportRowResult, bool, error) {
+
+	results := make([]models.ImportRowResult, len(banners))
+	failed := false
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+
+	defer tx.Rollback()
+
+	for i, banner := range banners {
+
+		if _, err = tx.ExecContext(ctx, `SAVEPOINT import_row`); err != nil {
+			return nil, false, err
+		}
+
+		result, err := importBanner(ctx, tx, banner, options.Conflict)
+		if err != nil {
+			if _, err := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT import_row`); err != nil {
+				return nil, false, err
+			}
+			result = models.ImportRowResult{Status: models.ImportFailed, Error: err.Error()}
+			failed = true
+		} else {
+			if _, err = tx.ExecContext(ctx, `RELEASE SAVEPOINT import_row`); err != nil {
+				return nil, false, err
+			}
+		}
+
+		results[i] = result
+	}
+
+	if options.DryRun || (failed && options.Mode == models.ImportAtomic) {
+		return results, false, nil
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, false, err
+	}
+
+	return results, true, nil
+}
+
+// Загрузка одного баннера: создание нового или обработка конфликта по парам фича + тэг
+func importBanner(ctx context.Context, tx *sql.Tx, banner models.ExportBanner, conflict string) (models.ImportRowResult, error) {
+
+	if len(banner.TagID) == 0 {
+		return models.ImportRowResult{}, errEmptyTags
+	}
+
+	if err := checkRegistered(ctx, tx, banner.FeatureID, banner.TagID); err != nil {
+		return models.ImportRowResult{}, err
+	}
+
+	// Баннеры проекта, которые уже занимают пары фича + тэг строки
+	rows, err := tx.QueryContext(ctx, `SELECT DISTINCT banner_id
+									FROM tag_feature
+									WHERE tenant = $3
+									AND feature_id = $1
+									AND tag_id = ANY($2)`,
+		banner.FeatureID,
+		tagsArg(banner.TagID),
+		token.Tenant(ctx),
+	)
+	if err != nil {
+		return models.ImportRowResult{}, err
+	}
+
+	owners := make([]int, 0)
+	for rows.Next() {
+		var owner int
+		if err = rows.Scan(&owner); err != nil {
+			rows.Close()
+			return models.ImportRowResult{}, err
+		}
+		owners = append(owners, owner)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return models.ImportRowResult{}, err
+	}
+
+	switch {
+	case len(owners) == 0:
+		id, err := insertImported(ctx, tx, banner)
+		if err != nil {
+			return models.ImportRowResult{}, err
+		}
+		return models.ImportRowResult{Status: models.ImportCreated, BannerID: uint32(id)}, nil
+
+	case len(owners) > 1:
+		return models.ImportRowResult{}, errSeveralBanners
+
+	case conflict == models.ConflictOverwrite:
+		if err = overwriteImported(ctx, tx, owners[0], banner); err != nil {
+			return models.ImportRowResult{}, err
+		}
+		return models.ImportRowResult{Status: models.ImportUpdated, BannerID: uint32(owners[0])}, nil
+
+	default:
+		return models.ImportRowResult{Status: models.ImportSkipped, BannerID: uint32(owners[0])}, nil
+	}
+}
+
+// Создание нового баннера из загрузки вместе с историей, если она передана
+func insertImported(ctx context.Context, tx *sql.Tx, banner models.ExportBanner) (int, error) {
+
+	var id int
+
+	err := tx.QueryRowContext(ctx, `INSERT INTO actual_banner (tenant, title, text, url, is_active, priority)
+									VALUES ($1, $2, $3, $4, $5, $6) RETURNING banner_id`,
+		token.Tenant(ctx),
+		banner.Content.Title,
+		banner.Content.Text,
+		banner.Content.Url,
+		banner.Active,
+		banner.Priority).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = insertPairs(ctx, tx, id, banner.FeatureID, banner.TagID); err != nil {
+		return 0, err
+	}
+
+	if banner.FeatureDefault {
+		if err = setFeatureDefault(ctx, tx, banner.FeatureID, id, true); err != nil {
+			return 0, err
+		}
+	}
+
+	// Переносим историю, последней версией должно стать актуальное содержимое
+	version := 0
+	last := models.BannerContent{}
+	for _, h := range banner.History {
+		_, err = tx.ExecContext(ctx, `INSERT INTO history_banner
+									(banner_id, version, title, text, url)
+									VALUES($1, $2, $3, $4, $5)`,
+			id, h.Version, h.Title, h.Text, h.Url,
+		)
+		if err != nil {
+			return 0, err
+		}
+		if h.Version > version {
+			version = h.Version
+			last = models.BannerContent{Title: h.Title, Text: h.Text, Url: h.Url}
+		}
+	}
+
+	if version == 0 || last != banner.Content {
+		if err = insertVersion(ctx, tx, id, version+1, banner.Content); err != nil {
+			return 0, err
+		}
+	}
+
+	after, err := snapshot(ctx, tx, id)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = recordChange(ctx, tx, models.AuditCreate, id, nil, after); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// Перезапись существующего баннера содержимым из загрузки
+func overwriteImported(ctx context.Context, tx *sql.Tx, bannerID int, banner models.ExportBanner) error {
+
+	var (
+		last    models.BannerContent
+		version int
+	)
+
+	before, err := snapshot(ctx, tx, bannerID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE actual_banner
+								SET title = $1,
+								text = $2,
+								url = $3,
+								is_active = $4,
+								priority = $5
+								WHERE banner_id = $6`,
+		banner.Content.Title,
+		banner.Content.Text,
+		banner.Content.Url,
+		banner.Active,
+		banner.Priority,
+		bannerID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err = insertPairs(ctx, tx, bannerID, banner.FeatureID, banner.TagID); err != nil {
+		return err
+	}
+
+	if err = setFeatureDefault(ctx, tx, banner.FeatureID, bannerID, banner.FeatureDefault); err != nil {
+		return err
+	}
+
+	// Новая версия только если изменилось содержимое
+	row := tx.QueryRowContext(ctx, `SELECT title, text, url, version
+									FROM history_banner
+									WHERE banner_id = $1 ORDER BY version DESC
+									LIMIT 1`, bannerID)
+	if err = row.Scan(&last.Title, &last.Text, &last.Url, &version); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if last != banner.Content {
+		if err = insertVersion(ctx, tx, bannerID, version+1, banner.Content); err != nil {
+			return err
+		}
+	}
+
+	after, err := snapshot(ctx, tx, bannerID)
+	if err != nil {
+		return err
+	}
+
+	return recordChange(ctx, tx, models.AuditUpdate, bannerID, before, after)
+}
+
+// Добавление пар фича + тэг баннеру, уже существующие пары баннера пропускаются
+func insertPairs(ctx context.Context, tx *sql.Tx, bannerID int, featureID uint32, tags []uint32) error {
+	for _, tag := range tags {
+		_, err := tx.ExecContext(ctx, `INSERT INTO tag_feature
+									(tenant, feature_id, tag_id, banner_id)
+									VALUES($1, $2, $3, $4)
+									ON CONFLICT DO NOTHING`,
+			token.Tenant(ctx),
+			featureID,
+			tag,
+			bannerID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertVersion(ctx context.Context, tx *sql.Tx, bannerID, version int, content models.BannerContent) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO history_banner
+								(banner_id, version, title, text, url)
+								VALUES($1, $2, $3, $4, $5)`,
+		bannerID,
+		version,
+		content.Title,
+		content.Text,
+		content.Url,
+	)
+	return err
+}
+
+func tagsArg(tags []uint32) []int64 {
+	arg := make([]int64, 0, len(tags))
+	for _, tag := range tags {
+		arg = append(arg, int64(tag))
+	}
+	return arg
+}
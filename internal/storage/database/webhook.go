@@ -0,0 +1,304 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+)
+
+// Создание подписки на события баннеров
+func (d dbase) CreateWebhook(ctx context.Context, subscription models.WebhookSubscription) (models.WebhookSubscription, error) {
+
+	eventTypes, err := json.Marshal(subscription.EventTypes)
+	if err != nil {
+		return models.WebhookSubscription{}, err
+	}
+
+	err = d.db.QueryRowContext(ctx, `INSERT INTO webhook_subscription
+									(tenant, url, secret, feature_id, tag_id, event_types)
+									VALUES($1, $2, $3, $4, $5, $6)
+									RETURNING id, created_at`,
+		token.Tenant(ctx),
+		subscription.URL,
+		subscription.Secret,
+		nullableID(subscription.FeatureID),
+		nullableID(subscription.TagID),
+		string(eventTypes),
+	).Scan(&subscription.ID, &subscription.CreatedAt)
+	if err != nil {
+		return models.WebhookSubscription{}, err
+	}
+
+	return subscription, nil
+}
+
+// Все подписки проекта без секретов
+func (d dbase) GetWebhooks(ctx context.Context) ([]models.WebhookSubscription, error) {
+
+	subscriptions := make([]models.WebhookSubscription, 0)
+
+	rows, err := d.db.QueryContext(ctx, `SELECT id, url, feature_id, tag_id, event_types, created_at
+										FROM webhook_subscription
+										WHERE tenant = $1
+										ORDER BY id`, token.Tenant(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var (
+			subscription models.WebhookSubscription
+			feature, tag sql.NullInt64
+			eventTypes   []byte
+		)
+
+		err = rows.Scan(&subscription.ID, &subscription.URL, &feature, &tag, &eventTypes, &subscription.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = json.Unmarshal(eventTypes, &subscription.EventTypes); err != nil {
+			return nil, err
+		}
+		subscription.FeatureID = idFromNull(feature)
+		subscription.TagID = idFromNull(tag)
+
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	// проверяем на ошибки
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return subscriptions, nil
+}
+
+// Удаление подписки вместе с журналом доставок, false если подписки нет
+func (d dbase) DeleteWebhook(ctx context.Context, subscriptionID int64) (bool, error) {
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM webhook_subscription
+									WHERE id = $1
+									AND tenant = $2`, subscriptionID, token.Tenant(ctx))
+	if err != nil {
+		return false, err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM webhook_delivery
+									WHERE subscription_id = $1`, subscriptionID)
+	if err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Журнал доставок подписки проекта
+func (d dbase) GetDeliveries(ctx context.Context, deliveryQuery models.DeliveryQuery) ([]models.WebhookDelivery, error) {
+
+	deliveries := make([]models.WebhookDelivery, 0)
+
+	args := []interface{}{deliveryQuery.SubscriptionID, token.Tenant(ctx)}
+	query := `SELECT id, subscription_id, event_id, event_type, status, attempts,
+				next_attempt_at, last_status, last_error, created_at, delivered_at
+				FROM webhook_delivery
+				WHERE subscription_id = $1
+				AND EXISTS (SELECT 1
+					FROM webhook_subscription
+					WHERE webhook_subscription.id = webhook_delivery.subscription_id
+					AND webhook_subscription.tenant = $2)`
+
+	if deliveryQuery.Status != "" {
+		args = append(args, deliveryQuery.Status)
+		query += " AND status = $" + strconv.Itoa(len(args))
+	}
+	query += " ORDER BY id DESC"
+
+	if deliveryQuery.Limit > 0 {
+		args = append(args, deliveryQuery.Limit)
+		query += " LIMIT $" + strconv.Itoa(len(args))
+	}
+	if deliveryQuery.Offset > 0 {
+		args = append(args, deliveryQuery.Offset)
+		query += " OFFSET $" + strconv.Itoa(len(args))
+	}
+
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var (
+			delivery    models.WebhookDelivery
+			deliveredAt sql.NullTime
+		)
+
+		err = rows.Scan(&delivery.ID, &delivery.SubscriptionID, &delivery.EventID, &delivery.EventType,
+			&delivery.Status, &delivery.Attempts, &delivery.NextAttemptAt, &delivery.LastStatus,
+			&delivery.LastError, &delivery.CreatedAt, &deliveredAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if deliveredAt.Valid {
+			delivery.DeliveredAt = &deliveredAt.Time
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	// проверяем на ошибки
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// Захват доставок, время следующей попытки которых наступило. Захваченные доставки
+// скрываются от других воркеров на время аренды, так что после падения воркера
+// они будут отправлены повторно
+func (d dbase) ClaimDeliveries(ctx context.Context, limit int) ([]models.WebhookDelivery, error) {
+
+	deliveries := make([]models.WebhookDelivery, 0)
+
+	rows, err := d.db.QueryContext(ctx, `UPDATE webhook_delivery
+										SET next_attempt_at = now() + $2 * interval '1 millisecond',
+										attempts = webhook_delivery.attempts + 1
+										FROM webhook_subscription
+										WHERE webhook_subscription.id = webhook_delivery.subscription_id
+										AND webhook_delivery.id IN (SELECT id
+											FROM webhook_delivery
+											WHERE status = 'pending'
+											AND next_attempt_at <= now()
+											ORDER BY id
+											LIMIT $1
+											FOR UPDATE SKIP LOCKED)
+										RETURNING webhook_delivery.id,
+										webhook_delivery.subscription_id,
+										webhook_delivery.event_id,
+										webhook_delivery.event_type,
+										webhook_delivery.attempts,
+										webhook_delivery.payload,
+										webhook_subscription.url,
+										webhook_subscription.secret`,
+		limit,
+		models.WebhookLease.Milliseconds(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var delivery models.WebhookDelivery
+
+		err = rows.Scan(&delivery.ID, &delivery.SubscriptionID, &delivery.EventID, &delivery.EventType,
+			&delivery.Attempts, &delivery.Payload, &delivery.URL, &delivery.Secret)
+		if err != nil {
+			return nil, err
+		}
+
+		delivery.Status = models.DeliveryPending
+		deliveries = append(deliveries, delivery)
+	}
+
+	// проверяем на ошибки
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// Сохранение результата попытки доставки
+func (d dbase) CompleteDelivery(ctx context.Context, result models.DeliveryResult) error {
+	_, err := d.db.ExecContext(ctx, `UPDATE webhook_delivery
+									SET status = $1,
+									last_status = $2,
+									last_error = $3,
+									next_attempt_at = $4,
+									delivered_at = CASE WHEN $1 = 'delivered' THEN now() END
+									WHERE id = $5`,
+		result.Status,
+		result.LastStatus,
+		result.LastError,
+		result.NextAttemptAt,
+		result.ID,
+	)
+	return err
+}
+
+// Постановка доставок события подходящим подписчикам проекта в той же транзакции, что и событие
+func enqueueDeliveries(ctx context.Context, tx *sql.Tx, eventID int64, event string, payload []byte, before, after *models.ResponseBody) error {
+
+	features := make([]int64, 0, 2)
+	tags := make([]int64, 0)
+	for _, banner := range []*models.ResponseBody{before, after} {
+		if banner == nil {
+			continue
+		}
+		features = append(features, int64(banner.FeatureID))
+		tags = append(tags, tagsArg(banner.TagID)...)
+	}
+
+	_, err := tx.ExecContext(ctx, `INSERT INTO webhook_delivery
+								(subscription_id, event_id, event_type, payload)
+								SELECT id, $1, $2, $3
+								FROM webhook_subscription
+								WHERE tenant = $6
+								AND (event_types = '[]'::jsonb OR event_types ? $2)
+								AND (feature_id IS NULL OR feature_id = ANY($4))
+								AND (tag_id IS NULL OR tag_id = ANY($5))`,
+		eventID,
+		event,
+		string(payload),
+		features,
+		tags,
+		token.Tenant(ctx),
+	)
+	return err
+}
+
+func nullableID(id *uint32) interface{} {
+	if id == nil {
+		return nil
+	}
+	return int64(*id)
+}
+
+func idFromNull(id sql.NullInt64) *uint32 {
+	if !id.Valid {
+		return nil
+	}
+	value := uint32(id.Int64)
+	return &value
+}
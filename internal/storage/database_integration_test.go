@@ -0,0 +1,1061 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/storage/database"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/token"
+	"github.com/golang-jwt/jwt"
+)
+
+var (
+	first  = models.BannerContent{Title: "first", Text: "text", Url: "https://example.com/1"}
+	second = models.BannerContent{Title: "second", Text: "text", Url: "https://example.com/2"}
+)
+
+// Контекст запроса администратора, из него берется автор изменений
+func adminContext() context.Context {
+	return token.WithClaims(context.Background(), &token.Claims{Role: models.RoleAdmin})
+}
+
+// Сценарии выполняются и на PostgreSQL, и на in-memory реализации,
+// так что тесты обработчиков поверх database.NewMemory опираются на то же поведение
+func forEachDBaser(t *testing.T, scenario func(t *testing.T, db database.DBaser)) {
+
+	t.Run("postgres", func(t *testing.T) {
+		db, _ := newPostgres(t)
+		register(t, db)
+		scenario(t, db)
+	})
+
+	t.Run("memory", func(t *testing.T) {
+		db := database.NewMemory()
+		register(t, db)
+		scenario(t, db)
+	})
+}
+
+// Регистрирует фичи и тэги 1..7, на которые ссылаются баннеры сценариев
+func register(t *testing.T, db database.DBaser) {
+	t.Helper()
+
+	for id := uint32(1); id <= 7; id++ {
+		for _, kind := range []string{models.RegistryFeature, models.RegistryTag} {
+			entry := models.RegistryEntry{ID: id, Name: fmt.Sprintf("%s %d", kind, id)}
+			if _, err := db.CreateRegistryEntry(context.Background(), kind, entry); err != nil {
+				t.Fatalf("register %s %d: %v", kind, id, err)
+			}
+		}
+	}
+}
+
+func mustCreate(t *testing.T, db database.DBaser, featureID, tagID uint32, content models.BannerContent) int {
+	t.Helper()
+
+	id, err := db.CreateBanner(adminContext(), models.BannerBody{FeatureID: featureID, TagID: tagID, Content: content, Active: true})
+	if err != nil {
+		t.Fatalf("create banner %d/%d: %v", featureID, tagID, err)
+	}
+	return id
+}
+
+func userBanner(t *testing.T, db database.DBaser, featureID, tagID int) []models.UserBanner {
+	t.Helper()
+
+	banners, err := db.GetBanner(context.Background(), featureID, []int{tagID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return banners
+}
+
+func history(t *testing.T, db database.DBaser, bannerID int) []models.BannerHistory {
+	t.Helper()
+
+	versions, err := db.GetHistoryBanner(context.Background(), bannerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	return versions
+}
+
+func TestCreateAndGetBanner(t *testing.T) {
+	forEachDBaser(t, func(t *testing.T, db database.DBaser) {
+
+		id := mustCreate(t, db, 1, 1, first)
+		mustCreate(t, db, 1, 2, second)
+		mustCreate(t, db, 2, 3, second)
+
+		banners := userBanner(t, db, 1, 1)
+		if len(banners) != 1 || int(banners[0].BannerID) != id || banners[0].Content != first {
+			t.Fatalf("banners = %+v", banners)
+		}
+
+		if banners = userBanner(t, db, 2, 1); len(banners) != 0 {
+			t.Fatalf("banners of missing pair = %+v", banners)
+		}
+
+		// Баннеры с тэгом 1 или фичей 2
+		list, err := db.GetBanners(context.Background(), models.Query{FeatureID: 2, TagID: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 2 {
+			t.Fatalf("banners = %+v", list)
+		}
+
+		versions := history(t, db, id)
+		if len(versions) != 1 || versions[0].Version != 1 || versions[0].Title != first.Title {
+			t.Fatalf("history = %+v", versions)
+		}
+	})
+}
+
+func TestDuplicatePairIsRejected(t *testing.T) {
+	forEachDBaser(t, func(t *testing.T, db database.DBaser) {
+
+		mustCreate(t, db, 1, 1, first)
+
+		if _, err := db.CreateBanner(adminContext(), models.BannerBody{FeatureID: 1, TagID: 1, Content: second}); !errors.Is(err, models.ErrPairTaken) {
+			t.Fatalf("duplicate pair: %v", err)
+		}
+
+		// Отвергнутый баннер не оставил следов
+		banners := userBanner(t, db, 1, 1)
+		if len(banners) != 1 || banners[0].Content != first {
+			t.Fatalf("banners = %+v", banners)
+		}
+
+		records, err := db.GetAudit(context.Background(), models.AuditQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 1 {
+			t.Fatalf("audit = %+v", records)
+		}
+	})
+}
+
+// Транзакция откатывает баннер без пары целиком
+func TestDuplicatePairRollsBack(t *testing.T) {
+
+	db, raw := newPostgres(t)
+	register(t, db)
+
+	mustCreate(t, db, 1, 1, first)
+	if _, err := db.CreateBanner(adminContext(), models.BannerBody{FeatureID: 1, TagID: 1, Content: second}); err == nil {
+		t.Fatal("duplicate pair is created")
+	}
+
+	if n := count(t, raw, `SELECT count(*) FROM actual_banner`); n != 1 {
+		t.Fatalf("actual_banner rows = %d, want 1", n)
+	}
+	if n := count(t, raw, `SELECT count(*) FROM history_banner`); n != 1 {
+		t.Fatalf("history_banner rows = %d, want 1", n)
+	}
+	if n := count(t, raw, `SELECT count(*) FROM outbox`); n != 1 {
+		t.Fatalf("outbox rows = %d, want 1", n)
+	}
+}
+
+func TestConcurrentCreateOnSamePair(t *testing.T) {
+
+	const writers = 16
+
+	scenario := func(t *testing.T, db database.DBaser) {
+
+		register(t, db)
+
+		var (
+			wg      sync.WaitGroup
+			mu      sync.Mutex
+			created []int
+		)
+
+		start := make(chan struct{})
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				<-start
+
+				content := models.BannerContent{Title: "writer", Text: "text", Url: "https://example.com"}
+				id, err := db.CreateBanner(adminContext(), models.BannerBody{FeatureID: 7, TagID: 7, Content: content, Active: true})
+				if err != nil {
+					return
+				}
+
+				mu.Lock()
+				created = append(created, id)
+				mu.Unlock()
+			}(i)
+		}
+
+		close(start)
+		wg.Wait()
+
+		if len(created) != 1 {
+			t.Fatalf("created %d banners on one pair, want 1: %v", len(created), created)
+		}
+
+		banners := userBanner(t, db, 7, 7)
+		if len(banners) != 1 || int(banners[0].BannerID) != created[0] {
+			t.Fatalf("banners = %+v, created = %v", banners, created)
+		}
+	}
+
+	t.Run("postgres", func(t *testing.T) {
+		db, raw := newPostgres(t)
+		scenario(t, db)
+
+		if n := count(t, raw, `SELECT count(*) FROM actual_banner`); n != 1 {
+			t.Fatalf("actual_banner rows = %d, want 1", n)
+		}
+		if n := count(t, raw, `SELECT count(*) FROM tag_feature WHERE feature_id = 7 AND tag_id = 7`); n != 1 {
+			t.Fatalf("tag_feature rows = %d, want 1", n)
+		}
+	})
+
+	t.Run("memory", func(t *testing.T) {
+		scenario(t, database.NewMemory())
+	})
+}
+
+func TestUpdateBannerVersions(t *testing.T) {
+	forEachDBaser(t, func(t *testing.T, db database.DBaser) {
+
+		ctx := adminContext()
+		id := mustCreate(t, db, 1, 1, first)
+
+		ok, err := db.UpdateBanner(ctx, models.BannerBody{FeatureID: 1, TagID: 1, Content: second, Active: true}, id)
+		if err != nil || !ok {
+			t.Fatalf("update: %v, %v", ok, err)
+		}
+
+		// Меняется только активность, версия не создается
+		ok, err = db.UpdateBanner(ctx, models.BannerBody{FeatureID: 1, TagID: 1, Content: second, Active: false}, id)
+		if err != nil || !ok {
+			t.Fatalf("update: %v, %v", ok, err)
+		}
+
+		versions := history(t, db, id)
+		if len(versions) != 2 || versions[1].Version != 2 || versions[1].Title != second.Title {
+			t.Fatalf("history = %+v", versions)
+		}
+
+		// Выключенный баннер пользователю не показывается
+		if banners := userBanner(t, db, 1, 1); len(banners) != 0 {
+			t.Fatalf("inactive banner is shown: %+v", banners)
+		}
+
+		if ok, err = db.UpdateBanner(ctx, models.BannerBody{FeatureID: 1, TagID: 1}, id+100); err != nil || ok {
+			t.Fatalf("update of missing banner: %v, %v", ok, err)
+		}
+
+		// Фича баннера не меняется, изменение откатывается
+		if _, err = db.UpdateBanner(ctx, models.BannerBody{FeatureID: 2, TagID: 1, Content: first, Active: true}, id); err == nil {
+			t.Fatal("feature of banner is changed")
+		}
+		if versions = history(t, db, id); len(versions) != 2 {
+			t.Fatalf("history after failed update = %+v", versions)
+		}
+
+		// Возврат к первой версии
+		if err = db.UpdateVersion(ctx, versions[0]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = db.UpdateBanner(ctx, models.BannerBody{FeatureID: 1, TagID: 1, Content: first, Active: true}, id); err != nil {
+			t.Fatal(err)
+		}
+
+		banners := userBanner(t, db, 1, 1)
+		if len(banners) != 1 || banners[0].Content != first {
+			t.Fatalf("banners = %+v", banners)
+		}
+	})
+}
+
+func TestDeleteRestorePurge(t *testing.T) {
+	forEachDBaser(t, func(t *testing.T, db database.DBaser) {
+
+		ctx := adminContext()
+		id := mustCreate(t, db, 1, 1, first)
+		before := time.Now().Add(-time.Minute)
+
+		if err := db.DeleteBanner(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+		if banners := userBanner(t, db, 1, 1); len(banners) != 0 {
+			t.Fatalf("deleted banner is shown: %+v", banners)
+		}
+
+		deleted, err := db.GetDeletedBanners(context.Background(), before)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deleted) != 1 || int(deleted[0].BannerID) != id || deleted[0].FeatureID != 1 || len(deleted[0].TagID) != 1 {
+			t.Fatalf("deleted = %+v", deleted)
+		}
+
+		// Освобожденная пара занимается новым баннером
+		other := mustCreate(t, db, 1, 1, second)
+		if _, err = db.RestoreBanner(ctx, id, before); !errors.Is(err, models.ErrPairTaken) {
+			t.Fatalf("restore on taken pair: %v", err)
+		}
+
+		if err = db.DeleteBanner(ctx, other); err != nil {
+			t.Fatal(err)
+		}
+
+		ok, err := db.RestoreBanner(ctx, id, before)
+		if err != nil || !ok {
+			t.Fatalf("restore: %v, %v", ok, err)
+		}
+		if ok, err = db.RestoreBanner(ctx, id, before); err != nil || ok {
+			t.Fatalf("second restore: %v, %v", ok, err)
+		}
+
+		banners := userBanner(t, db, 1, 1)
+		if len(banners) != 1 || int(banners[0].BannerID) != id {
+			t.Fatalf("banners = %+v", banners)
+		}
+
+		// Окно восстановления удаленного баннера еще не истекло
+		n, err := db.PurgeBanners(context.Background(), before)
+		if err != nil || n != 0 {
+			t.Fatalf("purge: %d, %v", n, err)
+		}
+
+		if n, err = db.PurgeBanners(context.Background(), time.Now().Add(time.Minute)); err != nil || n != 1 {
+			t.Fatalf("purge: %d, %v", n, err)
+		}
+		if ok, err = db.RestoreBanner(ctx, other, before); err != nil || ok {
+			t.Fatalf("restore of purged banner: %v, %v", ok, err)
+		}
+		if versions := history(t, db, other); len(versions) != 0 {
+			t.Fatalf("history of purged banner = %+v", versions)
+		}
+	})
+}
+
+func TestAuditAndOutbox(t *testing.T) {
+	forEachDBaser(t, func(t *testing.T, db database.DBaser) {
+
+		ctx := token.WithClaims(context.Background(), &token.Claims{Role: models.RoleAdmin, StandardClaims: jwt.StandardClaims{Subject: "qa"}})
+
+		id, err := db.CreateBanner(ctx, models.BannerBody{FeatureID: 1, TagID: 1, Content: first, Active: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = db.UpdateBanner(ctx, models.BannerBody{FeatureID: 1, TagID: 1, Content: second, Active: true}, id); err != nil {
+			t.Fatal(err)
+		}
+		if err = db.DeleteBanner(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+
+		records, err := db.GetAudit(context.Background(), models.AuditQuery{BannerID: id, Actor: "qa"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		actions := make([]string, 0, len(records))
+		for _, record := range records {
+			actions = append(actions, record.Action)
+		}
+		if len(actions) != 3 || actions[0] != models.AuditCreate || actions[1] != models.AuditUpdate || actions[2] != models.AuditDelete {
+			t.Fatalf("actions = %v", actions)
+		}
+
+		if records, err = db.GetAudit(context.Background(), models.AuditQuery{Limit: 1, Offset: 1}); err != nil || len(records) != 1 {
+			t.Fatalf("page of audit: %+v, %v", records, err)
+		}
+
+		// Публикация останавливается на первой ошибке
+		failed := errors.New("broker is down")
+		published := make([]string, 0)
+		n, err := db.RelayOutbox(context.Background(), 10, func(ctx context.Context, event models.Event) error {
+			if len(published) == 1 {
+				return failed
+			}
+			published = append(published, event.Type)
+			return nil
+		})
+		if !errors.Is(err, failed) || n != 1 {
+			t.Fatalf("relay: %d, %v", n, err)
+		}
+
+		n, err = db.RelayOutbox(context.Background(), 10, func(ctx context.Context, event models.Event) error {
+			published = append(published, event.Type)
+			return nil
+		})
+		if err != nil || n != 2 {
+			t.Fatalf("relay: %d, %v", n, err)
+		}
+
+		want := []string{models.EventBannerCreated, models.EventBannerUpdated, models.EventBannerDeleted}
+		if len(published) != len(want) {
+			t.Fatalf("published = %v, want %v", published, want)
+		}
+		for i := range want {
+			if published[i] != want[i] {
+				t.Fatalf("published = %v, want %v", published, want)
+			}
+		}
+	})
+}
+
+func TestExportImport(t *testing.T) {
+	forEachDBaser(t, func(t *testing.T, db database.DBaser) {
+
+		ctx := adminContext()
+		id := mustCreate(t, db, 1, 1, first)
+		if _, err := db.UpdateBanner(ctx, models.BannerBody{FeatureID: 1, TagID: 1, Content: second, Active: true}, id); err != nil {
+			t.Fatal(err)
+		}
+
+		exported := make([]models.ExportBanner, 0)
+		err := db.ExportBanners(context.Background(), true, func(banner models.ExportBanner) error {
+			exported = append(exported, banner)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(exported) != 1 || len(exported[0].History) != 2 {
+			t.Fatalf("exported = %+v", exported)
+		}
+
+		rows := []models.ExportBanner{
+			exported[0],
+			{FeatureID: 2, TagID: []uint32{1, 2}, Content: first, Active: true},
+			{FeatureID: 3, Content: first},
+		}
+
+		// Атомарная загрузка с ошибкой не применяется
+		results, committed, err := db.ImportBanners(ctx, rows, models.ImportOptions{Mode: models.ImportAtomic, Conflict: models.ConflictSkip})
+		if err != nil || committed {
+			t.Fatalf("atomic import: %v, %v", committed, err)
+		}
+		if results[0].Status != models.ImportSkipped || results[1].Status != models.ImportCreated || results[2].Status != models.ImportFailed {
+			t.Fatalf("results = %+v", results)
+		}
+		if banners := userBanner(t, db, 2, 2); len(banners) != 0 {
+			t.Fatalf("atomic import is applied: %+v", banners)
+		}
+
+		// Частичная загрузка применяет успешные строки
+		results, committed, err = db.ImportBanners(ctx, rows, models.ImportOptions{Mode: models.ImportBestEffort, Conflict: models.ConflictOverwrite})
+		if err != nil || !committed {
+			t.Fatalf("best effort import: %v, %v", committed, err)
+		}
+		if results[0].Status != models.ImportUpdated || results[1].Status != models.ImportCreated || results[2].Status != models.ImportFailed {
+			t.Fatalf("results = %+v", results)
+		}
+		if banners := userBanner(t, db, 2, 2); len(banners) != 1 {
+			t.Fatalf("banners = %+v", banners)
+		}
+
+		// Пары строки заняты разными баннерами
+		several := []models.ExportBanner{{FeatureID: 1, TagID: []uint32{1, 3}, Content: first}}
+		mustCreate(t, db, 1, 3, first)
+		if results, _, err = db.ImportBanners(ctx, several, models.ImportOptions{Mode: models.ImportBestEffort, Conflict: models.ConflictOverwrite}); err != nil {
+			t.Fatal(err)
+		}
+		if results[0].Status != models.ImportFailed {
+			t.Fatalf("results = %+v", results)
+		}
+
+		// Пробная загрузка ничего не меняет
+		dryRun := []models.ExportBanner{{FeatureID: 4, TagID: []uint32{4}, Content: first}}
+		if results, committed, err = db.ImportBanners(ctx, dryRun, models.ImportOptions{Mode: models.ImportAtomic, Conflict: models.ConflictSkip, DryRun: true}); err != nil || committed {
+			t.Fatalf("dry run: %v, %v", committed, err)
+		}
+		if results[0].Status != models.ImportCreated {
+			t.Fatalf("results = %+v", results)
+		}
+		if banners := userBanner(t, db, 4, 4); len(banners) != 0 {
+			t.Fatalf("dry run is applied: %+v", banners)
+		}
+	})
+}
+
+func TestStats(t *testing.T) {
+	forEachDBaser(t, func(t *testing.T, db database.DBaser) {
+
+		id := mustCreate(t, db, 1, 1, first)
+		bucket := time.Now().Truncate(time.Minute)
+
+		deltas := []models.StatsDelta{
+			{StatsKey: models.StatsKey{BannerID: id, FeatureID: 1, TagID: 1, Bucket: bucket}, Impressions: 3, Clicks: 1},
+		}
+		for i := 0; i < 2; i++ {
+			if err := db.SaveStats(context.Background(), deltas); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		stats, err := db.GetStats(context.Background(), models.StatsQuery{BannerID: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stats) != 1 || stats[0].Impressions != 6 || stats[0].Clicks != 2 {
+			t.Fatalf("stats = %+v", stats)
+		}
+
+		if stats, err = db.GetStats(context.Background(), models.StatsQuery{From: bucket.Add(time.Minute)}); err != nil || len(stats) != 0 {
+			t.Fatalf("stats after interval: %+v, %v", stats, err)
+		}
+
+		url, err := db.GetBannerURL(context.Background(), id)
+		if err != nil || url != first.Url {
+			t.Fatalf("url = %q, %v", url, err)
+		}
+		if url, err = db.GetBannerURL(context.Background(), id+100); err != nil || url != "" {
+			t.Fatalf("url of missing banner = %q, %v", url, err)
+		}
+	})
+}
+
+func TestExperiment(t *testing.T) {
+	forEachDBaser(t, func(t *testing.T, db database.DBaser) {
+
+		ctx := adminContext()
+		controlID := mustCreate(t, db, 1, 1, first)
+
+		var request models.ExperimentRequest
+		err := json.Unmarshal([]byte(`{"feature_id":1,"tag_id":1,"control_weight":70,
+			"variants":[{"content":{"title":"second","text":"text","url":"https://example.com/2"},"weight":30}]}`), &request)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		experiment, ok, err := db.CreateExperiment(ctx, request)
+		if err != nil || !ok {
+			t.Fatalf("create experiment: %v, %v", ok, err)
+		}
+		if int(experiment.ControlID) != controlID || len(experiment.Variants) != 2 {
+			t.Fatalf("experiment = %+v", experiment)
+		}
+
+		if _, _, err = db.CreateExperiment(ctx, request); !errors.Is(err, models.ErrExperimentExists) {
+			t.Fatalf("second experiment: %v", err)
+		}
+
+		request.FeatureID = 2
+		if _, ok, err = db.CreateExperiment(ctx, request); err != nil || ok {
+			t.Fatalf("experiment without banner: %v, %v", ok, err)
+		}
+
+		// Пользователю отдаются все варианты с весами
+		banners := userBanner(t, db, 1, 1)
+		if len(banners) != 2 || banners[0].Weight != 70 || banners[1].Weight != 30 {
+			t.Fatalf("banners = %+v", banners)
+		}
+
+		variantID := experiment.Variants[1].BannerID
+		if _, err = db.DeclareWinner(ctx, models.WinnerRequest{FeatureID: 1, TagID: 1, BannerID: variantID + 100}); !errors.Is(err, models.ErrNotVariant) {
+			t.Fatalf("winner is not variant: %v", err)
+		}
+
+		if ok, err = db.DeclareWinner(ctx, models.WinnerRequest{FeatureID: 1, TagID: 1, BannerID: variantID}); err != nil || !ok {
+			t.Fatalf("declare winner: %v, %v", ok, err)
+		}
+
+		if _, ok, err = db.GetExperiment(context.Background(), 1, 1); err != nil || ok {
+			t.Fatalf("experiment after winner: %v, %v", ok, err)
+		}
+
+		banners = userBanner(t, db, 1, 1)
+		if len(banners) != 1 || int(banners[0].BannerID) != controlID || banners[0].Content != second {
+			t.Fatalf("banners = %+v", banners)
+		}
+		if versions := history(t, db, controlID); len(versions) != 2 {
+			t.Fatalf("history = %+v", versions)
+		}
+	})
+}
+
+// Удаленный вариант не показывается, а удаление контрольного баннера завершает эксперимент,
+// так что новый баннер пары показывается без старых вариантов
+func TestDeleteExperimentBanners(t *testing.T) {
+	forEachDBaser(t, func(t *testing.T, db database.DBaser) {
+
+		ctx := adminContext()
+		controlID := mustCreate(t, db, 1, 1, first)
+
+		var request models.ExperimentRequest
+		err := json.Unmarshal([]byte(`{"feature_id":1,"tag_id":1,"control_weight":50,"variants":[
+			{"content":{"title":"b"},"weight":30},
+			{"content":{"title":"c"},"weight":20}]}`), &request)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		experiment, ok, err := db.CreateExperiment(ctx, request)
+		if err != nil || !ok || len(experiment.Variants) != 3 {
+			t.Fatalf("create experiment: %+v, %v, %v", experiment, ok, err)
+		}
+
+		variantID := int(experiment.Variants[1].BannerID)
+		if err = db.DeleteBanner(ctx, variantID); err != nil {
+			t.Fatal(err)
+		}
+		for _, banner := range userBanner(t, db, 1, 1) {
+			if int(banner.BannerID) == variantID {
+				t.Fatalf("deleted variant is served: %+v", banner)
+			}
+		}
+		if experiment, _, err = db.GetExperiment(ctx, 1, 1); err != nil || len(experiment.Variants) != 2 {
+			t.Fatalf("experiment after variant delete: %+v, %v", experiment, err)
+		}
+
+		if err = db.DeleteBanner(ctx, controlID); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok, err = db.GetExperiment(ctx, 1, 1); err != nil || ok {
+			t.Fatalf("experiment after control delete: %v, %v", ok, err)
+		}
+
+		newID := mustCreate(t, db, 1, 1, second)
+		banners := userBanner(t, db, 1, 1)
+		if len(banners) != 1 || int(banners[0].BannerID) != newID || banners[0].Content != second {
+			t.Fatalf("banners of new pair banner = %+v", banners)
+		}
+	})
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	forEachDBaser(t, func(t *testing.T, db database.DBaser) {
+
+		ctx := adminContext()
+		feature := uint32(1)
+
+		subscription, err := db.CreateWebhook(ctx, models.WebhookSubscription{
+			URL:        "https://example.com/hook",
+			Secret:     "secret",
+			FeatureID:  &feature,
+			EventTypes: []string{models.EventBannerCreated},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		subscriptions, err := db.GetWebhooks(context.Background())
+		if err != nil || len(subscriptions) != 1 || subscriptions[0].Secret != "" {
+			t.Fatalf("subscriptions = %+v, %v", subscriptions, err)
+		}
+
+		// Подходит только создание баннера фичи 1
+		id := mustCreate(t, db, 1, 1, first)
+		mustCreate(t, db, 2, 1, first)
+		if err = db.DeleteBanner(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+
+		claimed, err := db.ClaimDeliveries(context.Background(), 10)
+		if err != nil || len(claimed) != 1 {
+			t.Fatalf("claimed = %+v, %v", claimed, err)
+		}
+		if claimed[0].Attempts != 1 || claimed[0].URL != subscription.URL || claimed[0].Secret != "secret" || len(claimed[0].Payload) == 0 {
+			t.Fatalf("claimed = %+v", claimed[0])
+		}
+
+		// Арендованная доставка не выдается повторно
+		if again, err := db.ClaimDeliveries(context.Background(), 10); err != nil || len(again) != 0 {
+			t.Fatalf("claimed again = %+v, %v", again, err)
+		}
+
+		err = db.CompleteDelivery(context.Background(), models.DeliveryResult{
+			ID:            claimed[0].ID,
+			Status:        models.DeliveryDelivered,
+			LastStatus:    200,
+			NextAttemptAt: time.Now(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		deliveries, err := db.GetDeliveries(context.Background(), models.DeliveryQuery{SubscriptionID: subscription.ID, Status: models.DeliveryDelivered})
+		if err != nil || len(deliveries) != 1 || deliveries[0].DeliveredAt == nil || deliveries[0].LastStatus != 200 {
+			t.Fatalf("deliveries = %+v, %v", deliveries, err)
+		}
+
+		ok, err := db.DeleteWebhook(context.Background(), subscription.ID)
+		if err != nil || !ok {
+			t.Fatalf("delete webhook: %v, %v", ok, err)
+		}
+		if ok, err = db.DeleteWebhook(context.Background(), subscription.ID); err != nil || ok {
+			t.Fatalf("second delete webhook: %v, %v", ok, err)
+		}
+		if deliveries, err = db.GetDeliveries(context.Background(), models.DeliveryQuery{SubscriptionID: subscription.ID}); err != nil || len(deliveries) != 0 {
+			t.Fatalf("deliveries of deleted subscription = %+v, %v", deliveries, err)
+		}
+	})
+}
+
+func TestRegistry(t *testing.T) {
+	forEachDBaser(t, func(t *testing.T, db database.DBaser) {
+
+		ctx := adminContext()
+
+		// Без id берется следующий за наибольшим
+		entry, err := db.CreateRegistryEntry(ctx, models.RegistryFeature, models.RegistryEntry{Name: "checkout", Owner: "payments"})
+		if err != nil || entry.ID != 8 || entry.CreatedAt.IsZero() {
+			t.Fatalf("created = %+v, %v", entry, err)
+		}
+		if _, err = db.CreateRegistryEntry(ctx, models.RegistryFeature, models.RegistryEntry{ID: 8, Name: "other"}); !errors.Is(err, models.ErrEntryExists) {
+			t.Fatalf("taken id: %v", err)
+		}
+		if _, err = db.CreateRegistryEntry(ctx, models.RegistryFeature, models.RegistryEntry{Name: "checkout"}); !errors.Is(err, models.ErrNameTaken) {
+			t.Fatalf("taken name: %v", err)
+		}
+		if ok, err := db.UpdateRegistryEntry(ctx, models.RegistryFeature, models.RegistryEntry{ID: 8, Name: "feature 1"}); !errors.Is(err, models.ErrNameTaken) || ok {
+			t.Fatalf("update to taken name: %v, %v", ok, err)
+		}
+
+		entries, err := db.GetRegistryEntries(ctx, models.RegistryFeature, "checkout")
+		if err != nil || len(entries) != 1 || entries[0].Owner != "payments" {
+			t.Fatalf("entries = %+v, %v", entries, err)
+		}
+
+		// Баннер на незарегистрированную фичу или тэг не создается
+		if _, err = db.CreateBanner(ctx, models.BannerBody{FeatureID: 9, TagID: 1, Content: first}); !errors.Is(err, models.ErrUnknownFeature) {
+			t.Fatalf("unknown feature: %v", err)
+		}
+		if _, err = db.CreateBanner(ctx, models.BannerBody{FeatureID: 8, TagID: 9, Content: first}); !errors.Is(err, models.ErrUnknownTag) {
+			t.Fatalf("unknown tag: %v", err)
+		}
+
+		id := mustCreate(t, db, 8, 1, first)
+
+		// Фичу с баннерами удаляет только force, баннер остается
+		if ok, err := db.DeleteRegistryEntry(ctx, models.RegistryFeature, 8, false); !errors.Is(err, models.ErrEntryInUse) || ok {
+			t.Fatalf("delete used feature: %v, %v", ok, err)
+		}
+		if _, ok, err := db.GetRegistryEntry(ctx, models.RegistryFeature, 8); err != nil || !ok {
+			t.Fatalf("feature after refused delete: %v, %v", ok, err)
+		}
+		if ok, err := db.DeleteRegistryEntry(ctx, models.RegistryFeature, 8, true); err != nil || !ok {
+			t.Fatalf("forced delete: %v, %v", ok, err)
+		}
+		if banners := userBanner(t, db, 8, 1); len(banners) != 1 || int(banners[0].BannerID) != id {
+			t.Fatalf("banners after forced delete = %+v", banners)
+		}
+		if ok, err := db.DeleteRegistryEntry(ctx, models.RegistryFeature, 8, true); err != nil || ok {
+			t.Fatalf("second delete: %v, %v", ok, err)
+		}
+	})
+}
+
+func TestTenants(t *testing.T) {
+	forEachDBaser(t, func(t *testing.T, db database.DBaser) {
+
+		shop := token.WithClaims(context.Background(), &token.Claims{Role: models.RoleAdmin, Tenant: "shop"})
+
+		id := mustCreate(t, db, 1, 1, first)
+
+		// Справочники проекта пусты, пока в них ничего не зарегистрировано
+		banner := models.BannerBody{FeatureID: 1, TagID: 1, Content: second, Active: true}
+		if _, err := db.CreateBanner(shop, banner); !errors.Is(err, models.ErrUnknownFeature) {
+			t.Fatalf("banner before registration: %v", err)
+		}
+		for _, kind := range []string{models.RegistryFeature, models.RegistryTag} {
+			if _, err := db.CreateRegistryEntry(shop, kind, models.RegistryEntry{ID: 1, Name: kind + " 1"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Та же пара фича + тэг в другом проекте свободна
+		shopID, err := db.CreateBanner(shop, banner)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		banners, err := db.GetBanner(shop, 1, []int{1})
+		if err != nil || len(banners) != 1 || int(banners[0].BannerID) != shopID {
+			t.Fatalf("shop banner = %+v, %v", banners, err)
+		}
+		if banners := userBanner(t, db, 1, 1); len(banners) != 1 || int(banners[0].BannerID) != id {
+			t.Fatalf("default banner = %+v", banners)
+		}
+
+		listed, err := db.GetBanners(shop, models.Query{FeatureID: 1})
+		if err != nil || len(listed) != 1 || int(listed[0].BannerID) != shopID {
+			t.Fatalf("shop banners = %+v, %v", listed, err)
+		}
+
+		// Баннер другого проекта не меняется и не удаляется
+		if ok, err := db.UpdateBanner(shop, models.BannerBody{FeatureID: 1, TagID: 1, Content: second}, id); err != nil || ok {
+			t.Fatalf("update of foreign banner: %v, %v", ok, err)
+		}
+		if err = db.DeleteBanner(shop, id); err != nil {
+			t.Fatal(err)
+		}
+		if banners := userBanner(t, db, 1, 1); len(banners) != 1 || banners[0].Content != first {
+			t.Fatalf("default banner after foreign changes = %+v", banners)
+		}
+		if versions, err := db.GetHistoryBanner(shop, id); err != nil || len(versions) != 0 {
+			t.Fatalf("foreign history = %+v, %v", versions, err)
+		}
+
+		records, err := db.GetAudit(shop, models.AuditQuery{})
+		if err != nil || len(records) != 1 || int(records[0].BannerID) != shopID {
+			t.Fatalf("shop audit = %+v, %v", records, err)
+		}
+
+		// События несут проект
+		tenants := make(map[int]string)
+		if _, err = db.RelayOutbox(context.Background(), 10, func(ctx context.Context, event models.Event) error {
+			tenants[int(event.BannerID)] = event.Tenant
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if tenants[id] != models.DefaultTenant || tenants[shopID] != "shop" {
+			t.Fatalf("event tenants = %v", tenants)
+		}
+	})
+}
+
+func TestLocales(t *testing.T) {
+	forEachDBaser(t, func(t *testing.T, db database.DBaser) {
+
+		ctx := adminContext()
+		id := mustCreate(t, db, 1, 1, first)
+		english := models.BannerContent{Title: "english", Text: "text", Url: "https://example.com/en"}
+
+		for _, content := range []models.BannerContent{english, english, second} {
+			if _, ok, err := db.SetBannerLocale(ctx, id, models.BannerLocale{Locale: "en", Content: content}); err != nil || !ok {
+				t.Fatalf("set locale: %v, %v", ok, err)
+			}
+		}
+		if _, ok, err := db.SetBannerLocale(ctx, id+100, models.BannerLocale{Locale: "en", Content: english}); err != nil || ok {
+			t.Fatalf("locale of missing banner: %v, %v", ok, err)
+		}
+
+		// Повтор без изменений не создает версию, основная история не меняется
+		locales, ok, err := db.GetBannerLocales(ctx, id)
+		if err != nil || !ok || len(locales) != 1 || locales[0].Version != 2 || locales[0].Content != second {
+			t.Fatalf("locales = %+v, %v, %v", locales, ok, err)
+		}
+		versions, err := db.GetLocaleHistory(ctx, id, "en")
+		if err != nil || len(versions) != 2 || versions[0].Title != english.Title || versions[1].Locale != "en" {
+			t.Fatalf("locale history = %+v, %v", versions, err)
+		}
+		if versions := history(t, db, id); len(versions) != 1 {
+			t.Fatalf("main history = %+v", versions)
+		}
+
+		banners := userBanner(t, db, 1, 1)
+		if len(banners) != 1 || banners[0].Content != first || banners[0].Locales["en"] != second {
+			t.Fatalf("banners = %+v", banners)
+		}
+
+		// Локаль баннера другого проекта не видна
+		shop := token.WithClaims(context.Background(), &token.Claims{Role: models.RoleAdmin, Tenant: "shop"})
+		if _, ok, err := db.GetBannerLocales(shop, id); err != nil || ok {
+			t.Fatalf("foreign locales: %v, %v", ok, err)
+		}
+		if ok, err := db.DeleteBannerLocale(shop, id, "en"); err != nil || ok {
+			t.Fatalf("delete of foreign locale: %v, %v", ok, err)
+		}
+
+		if ok, err = db.DeleteBannerLocale(ctx, id, "en"); err != nil || !ok {
+			t.Fatalf("delete locale: %v, %v", ok, err)
+		}
+		if ok, err = db.DeleteBannerLocale(ctx, id, "en"); err != nil || ok {
+			t.Fatalf("second delete: %v, %v", ok, err)
+		}
+		if banners = userBanner(t, db, 1, 1); len(banners) != 1 || len(banners[0].Locales) != 0 {
+			t.Fatalf("banners after delete = %+v", banners)
+		}
+		if versions, err = db.GetLocaleHistory(ctx, id, "en"); err != nil || len(versions) != 2 {
+			t.Fatalf("history of deleted locale = %+v, %v", versions, err)
+		}
+
+		records, err := db.GetAudit(ctx, models.AuditQuery{BannerID: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		actions := make(map[string]int)
+		for _, record := range records {
+			actions[record.Action]++
+		}
+		if actions[models.AuditLocaleUpdate] != 3 || actions[models.AuditLocaleDelete] != 1 {
+			t.Fatalf("audit actions = %v", actions)
+		}
+	})
+}
+
+func TestPriorityAndFeatureDefault(t *testing.T) {
+	forEachDBaser(t, func(t *testing.T, db database.DBaser) {
+
+		ctx := adminContext()
+		low := mustCreate(t, db, 1, 1, first)
+
+		high, err := db.CreateBanner(ctx, models.BannerBody{FeatureID: 1, TagID: 2, Content: second, Active: true, Priority: 5})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fallback, err := db.CreateBanner(ctx, models.BannerBody{FeatureID: 1, TagID: 3, Content: second, Active: true, FeatureDefault: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Кандидаты: баннер фичи по умолчанию с нулевым тэгом и баннеры пар с приоритетами
+		banners, err := db.GetBanner(ctx, 1, []int{1, 2, 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(banners) != 3 ||
+			int(banners[0].BannerID) != fallback || banners[0].TagID != 0 ||
+			int(banners[1].BannerID) != low || banners[1].TagID != 1 || banners[1].Priority != 0 ||
+			int(banners[2].BannerID) != high || banners[2].TagID != 2 || banners[2].Priority != 5 {
+			t.Fatalf("candidates = %+v", banners)
+		}
+
+		listed, err := db.GetBanners(ctx, models.Query{FeatureID: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Slice(listed, func(i, j int) bool { return listed[i].BannerID < listed[j].BannerID })
+		if len(listed) != 3 || listed[1].Priority != 5 || !listed[2].FeatureDefault || listed[0].FeatureDefault {
+			t.Fatalf("banners = %+v", listed)
+		}
+
+		// Новый баннер по умолчанию заменяет прежний, изменение видно в аудите
+		ok, err := db.UpdateBanner(ctx, models.BannerBody{FeatureID: 1, TagID: 1, Content: first, Active: true, Priority: 7, FeatureDefault: true}, low)
+		if err != nil || !ok {
+			t.Fatalf("update: %v, %v", ok, err)
+		}
+		if banners = userBanner(t, db, 1, 4); len(banners) != 1 || int(banners[0].BannerID) != low || banners[0].Priority != 7 {
+			t.Fatalf("feature default = %+v", banners)
+		}
+
+		records, err := db.GetAudit(ctx, models.AuditQuery{BannerID: low})
+		if err != nil || len(records) != 2 {
+			t.Fatalf("audit = %+v, %v", records, err)
+		}
+		var after models.ResponseBody
+		if err = json.Unmarshal(records[1].After, &after); err != nil {
+			t.Fatal(err)
+		}
+		if after.Priority != 7 || !after.FeatureDefault {
+			t.Fatalf("audit after = %+v", after)
+		}
+
+		// Выключенный или удаленный баннер по умолчанию не отдается
+		if ok, err = db.UpdateBanner(ctx, models.BannerBody{FeatureID: 1, TagID: 1, Content: first, Priority: 7, FeatureDefault: true}, low); err != nil || !ok {
+			t.Fatalf("deactivate: %v, %v", ok, err)
+		}
+		if banners = userBanner(t, db, 1, 4); len(banners) != 0 {
+			t.Fatalf("inactive feature default = %+v", banners)
+		}
+
+		if ok, err = db.UpdateBanner(ctx, models.BannerBody{FeatureID: 1, TagID: 2, Content: second, Active: true, FeatureDefault: true}, high); err != nil || !ok {
+			t.Fatalf("update: %v, %v", ok, err)
+		}
+		if err = db.DeleteBanner(ctx, high); err != nil {
+			t.Fatal(err)
+		}
+		if banners = userBanner(t, db, 1, 4); len(banners) != 0 {
+			t.Fatalf("deleted feature default = %+v", banners)
+		}
+	})
+}
+
+func TestDrafts(t *testing.T) {
+	forEachDBaser(t, func(t *testing.T, db database.DBaser) {
+
+		author := adminContext()
+		reviewer := token.WithClaims(context.Background(), &token.Claims{Role: models.RoleAdmin, StandardClaims: jwt.StandardClaims{Subject: "reviewer"}})
+		id := mustCreate(t, db, 1, 1, first)
+
+		draft, ok, err := db.CreateDraft(author, models.DraftRequest{BannerID: uint32(id), Content: second, Comment: "new copy", Submit: true})
+		if err != nil || !ok {
+			t.Fatalf("create draft: %v, %v", ok, err)
+		}
+		if draft.Status != models.DraftStatusPending || draft.Author != models.RoleAdmin ||
+			len(draft.Comments) != 1 || draft.Comments[0].Action != models.DraftActionSubmit {
+			t.Fatalf("draft = %+v", draft)
+		}
+		if _, ok, err = db.CreateDraft(author, models.DraftRequest{BannerID: 404, Content: second}); err != nil || ok {
+			t.Fatalf("draft of missing banner: %v, %v", ok, err)
+		}
+
+		// Автор не проверяет свой черновик, ошибка не меняет статус
+		if _, _, err = db.UpdateDraft(author, draft.DraftID, models.DraftAction{Action: models.DraftActionApprove}); !errors.Is(err, models.ErrSelfReview) {
+			t.Fatalf("self review err = %v", err)
+		}
+		if _, _, err = db.UpdateDraft(reviewer, draft.DraftID, models.DraftAction{Action: models.DraftActionSubmit}); !errors.Is(err, models.ErrDraftStatus) {
+			t.Fatalf("submit pending err = %v", err)
+		}
+
+		// Пока черновик не одобрен, содержимое баннера прежнее
+		if banners := userBanner(t, db, 1, 1); len(banners) != 1 || banners[0].Content != first {
+			t.Fatalf("banner before approve = %+v", banners)
+		}
+
+		draft, ok, err = db.UpdateDraft(reviewer, draft.DraftID, models.DraftAction{Action: models.DraftActionApprove, Comment: "ok"})
+		if err != nil || !ok {
+			t.Fatalf("approve: %v, %v", ok, err)
+		}
+		if draft.Status != models.DraftStatusPublished || draft.Reviewer != "reviewer" || len(draft.Comments) != 2 {
+			t.Fatalf("approved draft = %+v", draft)
+		}
+		if banners := userBanner(t, db, 1, 1); len(banners) != 1 || banners[0].Content != second {
+			t.Fatalf("banner after approve = %+v", banners)
+		}
+		if versions := history(t, db, id); len(versions) != 2 || versions[1].Title != second.Title {
+			t.Fatalf("history = %+v", versions)
+		}
+
+		records, err := db.GetAudit(author, models.AuditQuery{BannerID: id})
+		if err != nil || len(records) != 2 || records[1].Action != models.AuditPublish || records[1].Actor != "reviewer" {
+			t.Fatalf("audit = %+v, %v", records, err)
+		}
+
+		// Событие о проверке и событие об изменении баннера
+		var events []string
+		if _, err = db.RelayOutbox(context.Background(), 100, func(ctx context.Context, event models.Event) error {
+			events = append(events, event.Type)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(events) != fmt.Sprint([]string{models.EventBannerCreated, models.EventReviewRequested, models.EventBannerUpdated}) {
+			t.Fatalf("events = %v", events)
+		}
+
+		// Обновление в обход проверки записывается отдельным действием
+		if ok, err = db.UpdateBanner(database.WithEmergency(author), models.BannerBody{FeatureID: 1, TagID: 1, Content: first, Active: true}, id); err != nil || !ok {
+			t.Fatalf("emergency update: %v, %v", ok, err)
+		}
+		if records, err = db.GetAudit(author, models.AuditQuery{BannerID: id}); err != nil || records[len(records)-1].Action != models.AuditEmergency {
+			t.Fatalf("audit = %+v, %v", records, err)
+		}
+
+		// Черновики удаленного баннера не видны
+		pending, err := db.GetDrafts(author, models.DraftQuery{BannerID: id})
+		if err != nil || len(pending) != 1 {
+			t.Fatalf("drafts = %+v, %v", pending, err)
+		}
+		if err = db.DeleteBanner(author, id); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok, err = db.GetDraft(author, draft.DraftID); err != nil || ok {
+			t.Fatalf("draft of deleted banner: %v, %v", ok, err)
+		}
+	})
+}
@@ -0,0 +1,328 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/storage/cache"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/storage/database"
+	"github.com/go-redis/redis"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// Окружение интеграционных тестов.
+// Если заданы BANNER_IT_DSN и BANNER_IT_REDIS_ADDR, используются эти серверы,
+// иначе запускаются локальные postgres и redis-server из PATH (или из каталога PG_BIN для postgres)
+const (
+	envDSN           = "BANNER_IT_DSN"
+	envRedisAddr     = "BANNER_IT_REDIS_ADDR"
+	envRedisPassword = "BANNER_IT_REDIS_PASSWORD"
+	envPGBin         = "PG_BIN"
+)
+
+const startTimeout = 30 * time.Second
+
+var env harness
+
+type harness struct {
+	dsn           string
+	redisAddr     string
+	redisPassword string
+
+	dsnSkip   string // reason to skip postgres tests
+	redisSkip string // reason to skip redis tests
+
+	dir       string      // temporary directory of spawned servers
+	processes []*exec.Cmd // spawned servers
+	schemas   atomic.Int64
+}
+
+func TestMain(m *testing.M) {
+
+	code := func() int {
+		defer env.teardown()
+
+		if err := env.setup(); err != nil {
+			fmt.Fprintln(os.Stderr, "integration environment:", err)
+			return 1
+		}
+
+		return m.Run()
+	}()
+
+	os.Exit(code)
+}
+
+func (h *harness) setup() error {
+
+	dir, err := os.MkdirTemp("", "banner-it-")
+	if err != nil {
+		return err
+	}
+	h.dir = dir
+
+	if h.dsn = os.Getenv(envDSN); h.dsn == "" {
+		if h.dsn, err = h.startPostgres(); err != nil {
+			if !errors.Is(err, exec.ErrNotFound) {
+				return err
+			}
+			h.dsnSkip = fmt.Sprintf("postgres is not found, set %s or put initdb and postgres to PATH", envDSN)
+		}
+	}
+
+	h.redisPassword = os.Getenv(envRedisPassword)
+	if h.redisAddr = os.Getenv(envRedisAddr); h.redisAddr == "" {
+		if h.redisAddr, err = h.startRedis(); err != nil {
+			if !errors.Is(err, exec.ErrNotFound) {
+				return err
+			}
+			h.redisSkip = fmt.Sprintf("redis-server is not found, set %s or put redis-server to PATH", envRedisAddr)
+		}
+	}
+
+	return nil
+}
+
+// Остановка запущенных серверов и удаление их данных
+func (h *harness) teardown() {
+
+	for _, process := range h.processes {
+		// SIGINT означает для postgres fast shutdown, redis тоже завершается штатно
+		_ = process.Process.Signal(syscall.SIGINT)
+
+		done := make(chan struct{})
+		go func() {
+			_ = process.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			_ = process.Process.Kill()
+			<-done
+		}
+	}
+
+	if h.dir != "" {
+		_ = os.RemoveAll(h.dir)
+	}
+}
+
+func (h *harness) binary(name string) (string, error) {
+	if dir := os.Getenv(envPGBin); dir != "" && name != "redis-server" {
+		return filepath.Join(dir, name), nil
+	}
+	return exec.LookPath(name)
+}
+
+func (h *harness) startPostgres() (string, error) {
+
+	initdb, err := h.binary("initdb")
+	if err != nil {
+		return "", err
+	}
+
+	postgres, err := h.binary("postgres")
+	if err != nil {
+		return "", err
+	}
+
+	data := filepath.Join(h.dir, "postgres")
+	if out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "-A", "trust", "--no-sync").CombinedOutput(); err != nil {
+		return "", fmt.Errorf("initdb: %w: %s", err, out)
+	}
+
+	port, err := freePort()
+	if err != nil {
+		return "", err
+	}
+
+	process := exec.Command(postgres, "-D", data, "-p", port, "-k", h.dir,
+		"-c", "listen_addresses=127.0.0.1", "-c", "fsync=off")
+	if err = h.start(process, filepath.Join(h.dir, "postgres.log")); err != nil {
+		return "", err
+	}
+
+	dsn := fmt.Sprintf("host=127.0.0.1 port=%s user=postgres dbname=postgres sslmode=disable", port)
+
+	err = waitFor(func() error {
+		db, err := sql.Open("pgx", dsn)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		return db.Ping()
+	})
+
+	return dsn, err
+}
+
+func (h *harness) startRedis() (string, error) {
+
+	server, err := h.binary("redis-server")
+	if err != nil {
+		return "", err
+	}
+
+	port, err := freePort()
+	if err != nil {
+		return "", err
+	}
+
+	process := exec.Command(server, "--port", port, "--bind", "127.0.0.1", "--save", "", "--appendonly", "no", "--dir", h.dir)
+	if err = h.start(process, filepath.Join(h.dir, "redis.log")); err != nil {
+		return "", err
+	}
+
+	addr := net.JoinHostPort("127.0.0.1", port)
+
+	err = waitFor(func() error {
+		client := cache.NewClient(addr, "", 0)
+		defer client.Close()
+		return client.Ping().Err()
+	})
+
+	return addr, err
+}
+
+func (h *harness) start(process *exec.Cmd, logFile string) error {
+
+	out, err := os.Create(logFile)
+	if err != nil {
+		return err
+	}
+	process.Stdout, process.Stderr = out, out
+
+	if err = process.Start(); err != nil {
+		return err
+	}
+
+	h.processes = append(h.processes, process)
+	return nil
+}
+
+func waitFor(ready func() error) error {
+
+	deadline := time.Now().Add(startTimeout)
+	for {
+		err := ready()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("server is not ready: %w", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func freePort() (string, error) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer listener.Close()
+
+	return strconv.Itoa(listener.Addr().(*net.TCPAddr).Port), nil
+}
+
+// Новая схема для теста: database.Connect создает в ней таблицы,
+// после теста схема удаляется, так что тесты не видят данных друг друга
+func newPostgres(t *testing.T) (database.DBaser, *sql.DB) {
+	t.Helper()
+
+	if env.dsnSkip != "" {
+		t.Skip(env.dsnSkip)
+	}
+
+	admin, err := sql.Open("pgx", env.dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := fmt.Sprintf("banner_it_%d_%d", os.Getpid(), env.schemas.Add(1))
+	if _, err = admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Errorf("drop schema: %v", err)
+		}
+	})
+
+	dsn := withSearchPath(env.dsn, schema)
+
+	db, err := database.Connect(dsn, database.Pool{MaxIdleConns: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Прямой доступ к таблицам для проверок, которых нет в DBaser
+	raw, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { raw.Close() })
+
+	return db, raw
+}
+
+// search_path передается серверу как параметр соединения
+func withSearchPath(dsn, schema string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		if strings.Contains(dsn, "?") {
+			return dsn + "&search_path=" + schema
+		}
+		return dsn + "?search_path=" + schema
+	}
+	return dsn + " search_path=" + schema
+}
+
+// Кэш над чистой базой redis
+func newRedis(t *testing.T, ttl time.Duration) (cache.Cacher, *redis.Client) {
+	t.Helper()
+
+	if env.redisSkip != "" {
+		t.Skip(env.redisSkip)
+	}
+
+	client := cache.NewClient(env.redisAddr, env.redisPassword, 0)
+	t.Cleanup(func() { client.Close() })
+
+	flush := func() {
+		if err := client.FlushDB().Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flush()
+	t.Cleanup(flush)
+
+	return cache.New(env.redisAddr, env.redisPassword, 0, ttl), client
+}
+
+func count(t *testing.T, db *sql.DB, query string, args ...interface{}) int {
+	t.Helper()
+
+	var n int
+	if err := db.QueryRowContext(context.Background(), query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
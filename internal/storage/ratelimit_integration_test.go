@@ -0,0 +1,58 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/ratelimit"
+)
+
+// Сценарии выполняются и на Redis, и на in-memory реализации
+func forEachLimiter(t *testing.T, scenario func(t *testing.T, l ratelimit.Limiter)) {
+
+	t.Run("redis", func(t *testing.T) {
+		_, client := newRedis(t, cacheTTL)
+		scenario(t, ratelimit.NewRedis(client))
+	})
+
+	t.Run("memory", func(t *testing.T) {
+		scenario(t, ratelimit.NewMemory())
+	})
+}
+
+func TestLimiterBurstAndRefill(t *testing.T) {
+	forEachLimiter(t, func(t *testing.T, l ratelimit.Limiter) {
+
+		limit := ratelimit.Limit{Rate: 5, Burst: 2}
+
+		for i := 0; i < limit.Burst; i++ {
+			if allowed, _, err := l.Allow("burst", limit); err != nil || !allowed {
+				t.Fatalf("request %d in burst: %v, %v", i, allowed, err)
+			}
+		}
+
+		// Токен появляется через 1/rate, Retry-After не больше этого
+		allowed, wait, err := l.Allow("burst", limit)
+		if err != nil || allowed {
+			t.Fatalf("request over burst: %v, %v", allowed, err)
+		}
+		if wait <= 0 || wait > 200*time.Millisecond {
+			t.Fatalf("wait = %v", wait)
+		}
+
+		// Другой ключ не затронут
+		if allowed, _, err = l.Allow("other", limit); err != nil || !allowed {
+			t.Fatalf("other key: %v, %v", allowed, err)
+		}
+
+		time.Sleep(wait + 20*time.Millisecond)
+		if allowed, _, err = l.Allow("burst", limit); err != nil || !allowed {
+			t.Fatalf("request after refill: %v, %v", allowed, err)
+		}
+		if allowed, _, err = l.Allow("burst", limit); err != nil || allowed {
+			t.Fatalf("refill is more than one token: %v, %v", allowed, err)
+		}
+	})
+}
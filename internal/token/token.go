@@ -0,0 +1,236 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/config"
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+	"github.com/golang-jwt/jwt"
+)
+
+var (
+	ErrUnknownRole    = errors.New("unknown token role")
+	ErrBadLifetime    = errors.New("token lifetime must be positive")
+	ErrBadTenant      = errors.New("tenant must be up to 64 lowercase letters, digits, '-' or '_'")
+	ErrForeignTenant  = errors.New("token of another tenant can not be issued")
+	ErrForeignScope   = errors.New("token can not carry scope its issuer does not have")
+	ErrForeignSubject = errors.New("admin token can be issued only for subject of its issuer")
+)
+
+var tenantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// Claims carried by issued tokens
+type Claims struct {
+	Role   string   `json:"role"`             // admin or user
+	Scopes []string `json:"scopes,omitempty"` // optional scopes of token
+	Tenant string   `json:"tenant,omitempty"` // project of token, default tenant if empty
+	jwt.StandardClaims
+}
+
+// TenantID returns tenant of token, tokens issued before tenants belong to default one
+func (c *Claims) TenantID() string {
+	if c.Tenant == "" {
+		return models.DefaultTenant
+	}
+	return c.Tenant
+}
+
+// Issuer signs tokens with the keys from config
+type Issuer struct {
+	keys atomic.Pointer[keys] // swapped on config reload
+}
+
+type keys struct {
+	adminSecretKey string // Secret key for signing admin token
+	userSecretKey  string // Secret key for signing user token
+	adminToken     string // Code word of admin tokens
+	userToken      string // Code word of user tokens
+}
+
+func NewIssuer(cfg config.Config) *Issuer {
+
+	issuer := &Issuer{}
+	issuer.SetKeys(cfg)
+
+	return issuer
+}
+
+// SetKeys swaps secret keys and code words used for tokens issued after call
+func (i *Issuer) SetKeys(cfg config.Config) {
+	i.keys.Store(&keys{
+		adminSecretKey: cfg.AdminSecretKey,
+		userSecretKey:  cfg.UserSecretKey,
+		adminToken:     cfg.AdminToken,
+		userToken:      cfg.UserToken,
+	})
+}
+
+// Issue mints signed token for passed role, subject, scopes and lifetime.
+// Code word of the role is used as issuer of token.
+func (i *Issuer) Issue(tokenRequest models.TokenRequest) (models.TokenResponse, error) {
+
+	var secretKey, codeWord string
+
+	keys := i.keys.Load()
+
+	switch strings.ToLower(tokenRequest.Role) {
+	case models.RoleAdmin:
+		secretKey, codeWord = keys.adminSecretKey, keys.adminToken
+	case models.RoleUser:
+		secretKey, codeWord = keys.userSecretKey, keys.userToken
+	default:
+		return models.TokenResponse{}, ErrUnknownRole
+	}
+
+	// Lifetime by default if not passed
+	ttl := models.DefaultTokenTTL
+	if tokenRequest.TTL != "" {
+		d, err := time.ParseDuration(tokenRequest.TTL)
+		if err != nil {
+			return models.TokenResponse{}, err
+		}
+		ttl = d
+	}
+
+	if ttl <= 0 {
+		return models.TokenResponse{}, ErrBadLifetime
+	}
+
+	if tokenRequest.Tenant != "" && !tenantPattern.MatchString(tokenRequest.Tenant) {
+		return models.TokenResponse{}, ErrBadTenant
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+
+	claims := Claims{
+		Role:   strings.ToLower(tokenRequest.Role),
+		Scopes: tokenRequest.Scopes,
+		Tenant: tokenRequest.Tenant,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   tokenRequest.Subject,
+			Issuer:    codeWord,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: expiresAt.Unix(),
+		},
+	}
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secretKey))
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+
+	return models.TokenResponse{
+		Token:     signed,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// Parse validates token signed with secret key and returns its claims,
+// second value is false if signature or lifetime of token is invalid
+func Parse(tokenString, secretKey string) (*Claims, bool, error) {
+	claims := &Claims{}
+	tok, err := jwt.ParseWithClaims(tokenString, claims,
+		func(t *jwt.Token) (interface{}, error) {
+			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+			}
+			return []byte(secretKey), nil
+		})
+
+	if tok == nil {
+		return nil, false, err
+	}
+	if !tok.Valid {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return claims, true, nil
+}
+
+type claimsKey struct{}
+
+// WithClaims returns copy of context carrying claims of request token
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// FromContext returns claims of request token if they were stored by middlewares
+func FromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+	return claims, ok
+}
+
+// Actor returns identity of request author for audit: subject of token or its role
+func Actor(ctx context.Context) string {
+	claims, ok := FromContext(ctx)
+	if !ok {
+		return "unknown"
+	}
+	if claims.Subject != "" {
+		return claims.Subject
+	}
+	if claims.Role != "" {
+		return claims.Role
+	}
+	return "unknown"
+}
+
+// HasScope reports whether request token carries scope
+func HasScope(ctx context.Context, scope string) bool {
+	claims, ok := FromContext(ctx)
+	if !ok {
+		return false
+	}
+	for _, s := range claims.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Delegate narrows token request made by request author: token carries only scopes the author
+// has and admin token gets subject of the author, so admin can not pose as another admin
+// or grant himself emergency access
+func Delegate(ctx context.Context, tokenRequest models.TokenRequest) (models.TokenRequest, error) {
+
+	for _, scope := range tokenRequest.Scopes {
+		if !HasScope(ctx, scope) {
+			return models.TokenRequest{}, ErrForeignScope
+		}
+	}
+
+	if strings.ToLower(tokenRequest.Role) != models.RoleAdmin {
+		return tokenRequest, nil
+	}
+
+	var subject string
+	if claims, ok := FromContext(ctx); ok {
+		subject = claims.Subject
+	}
+	if tokenRequest.Subject != "" && tokenRequest.Subject != subject {
+		return models.TokenRequest{}, ErrForeignSubject
+	}
+	tokenRequest.Subject = subject
+
+	return tokenRequest, nil
+}
+
+// Tenant returns project of request author, banners and everything around them are scoped by it.
+// Context without claims belongs to default tenant
+func Tenant(ctx context.Context) string {
+	claims, ok := FromContext(ctx)
+	if !ok {
+		return models.DefaultTenant
+	}
+	return claims.TenantID()
+}
@@ -0,0 +1,133 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+)
+
+// Headers of webhook request
+const (
+	HeaderSignature = "X-Webhook-Signature"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+)
+
+// Deliverer posts signed events to subscribers and decides when to retry
+type Deliverer struct {
+	client      *http.Client
+	maxAttempts int           // after this number of failures delivery is dead
+	backoff     time.Duration // delay after first failure, doubled after each next one
+	maxBackoff  time.Duration // upper bound of delay
+	now         func() time.Time
+}
+
+func NewDeliverer(timeout time.Duration, maxAttempts int, backoff, maxBackoff time.Duration) *Deliverer {
+	return &Deliverer{
+		client:      &http.Client{Timeout: timeout},
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		maxBackoff:  maxBackoff,
+		now:         time.Now,
+	}
+}
+
+// Sign returns HMAC SHA-256 signature of body in header format
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks signature of body, it is used by receivers of webhooks
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// NewSecret generates random secret for subscription
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Deliver posts event and returns result of attempt.
+// Any 2xx response means event is delivered.
+func (d *Deliverer) Deliver(ctx context.Context, delivery models.WebhookDelivery) models.DeliveryResult {
+
+	result := models.DeliveryResult{ID: delivery.ID}
+
+	status, err := d.post(ctx, delivery)
+	result.LastStatus = status
+
+	if err == nil {
+		result.Status = models.DeliveryDelivered
+		result.NextAttemptAt = d.now()
+		return result
+	}
+
+	result.LastError = err.Error()
+
+	// Попытки закончились
+	if delivery.Attempts >= d.maxAttempts {
+		result.Status = models.DeliveryDead
+		result.NextAttemptAt = d.now()
+		return result
+	}
+
+	result.Status = models.DeliveryPending
+	result.NextAttemptAt = d.now().Add(d.delay(delivery.Attempts))
+	return result
+}
+
+func (d *Deliverer) post(ctx context.Context, delivery models.WebhookDelivery) (int, error) {
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(HeaderSignature, Sign(delivery.Secret, delivery.Payload))
+	request.Header.Set(HeaderEvent, delivery.EventType)
+	request.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	// Дочитываем тело, чтобы соединение переиспользовалось
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return response.StatusCode, fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+
+	return response.StatusCode, nil
+}
+
+// Exponential backoff after attempt
+func (d *Deliverer) delay(attempt int) time.Duration {
+	delay := d.backoff
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= d.maxBackoff {
+			return d.maxBackoff
+		}
+	}
+	return delay
+}
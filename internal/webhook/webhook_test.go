@@ -0,0 +1,142 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/models"
+)
+
+var testNow = time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestDeliverer(maxAttempts int) *Deliverer {
+	d := NewDeliverer(time.Second, maxAttempts, time.Second, 10*time.Second)
+	d.now = func() time.Time { return testNow }
+	return d
+}
+
+func TestSignVerify(t *testing.T) {
+	body := []byte(`{"type":"banner.created"}`)
+	signature := Sign("secret", body)
+
+	tests := []struct {
+		name      string
+		secret    string
+		body      []byte
+		signature string
+		want      bool
+	}{
+		{"valid", "secret", body, signature, true},
+		{"other secret", "other", body, signature, false},
+		{"changed body", "secret", []byte(`{"type":"banner.deleted"}`), signature, false},
+		{"without prefix", "secret", body, signature[len("sha256="):], false},
+		{"empty signature", "secret", body, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Verify(tt.secret, tt.body, tt.signature); got != tt.want {
+				t.Fatalf("Verify = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Подпись детерминирована и зависит от секрета
+	if Sign("secret", body) != signature || Sign("other", body) == signature {
+		t.Fatal("signature must depend only on secret and body")
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		attempts   int
+		wantStatus string
+		wantNext   time.Time
+	}{
+		{"ok", http.StatusOK, 1, models.DeliveryDelivered, testNow},
+		{"no content", http.StatusNoContent, 3, models.DeliveryDelivered, testNow},
+		{"redirect is failure", http.StatusMultipleChoices, 1, models.DeliveryPending, testNow.Add(time.Second)},
+		{"server error", http.StatusInternalServerError, 1, models.DeliveryPending, testNow.Add(time.Second)},
+		{"server error again", http.StatusBadGateway, 2, models.DeliveryPending, testNow.Add(2 * time.Second)},
+		{"last attempt", http.StatusInternalServerError, 3, models.DeliveryDead, testNow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received *http.Request
+			var body []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received = r
+				body, _ = io.ReadAll(r.Body)
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			delivery := models.WebhookDelivery{
+				ID:        7,
+				EventType: models.EventBannerCreated,
+				Attempts:  tt.attempts,
+				URL:       server.URL,
+				Secret:    "secret",
+				Payload:   []byte(`{"banner_id":1}`),
+			}
+
+			result := newTestDeliverer(3).Deliver(context.Background(), delivery)
+			if result.ID != delivery.ID || result.Status != tt.wantStatus || result.LastStatus != tt.status {
+				t.Fatalf("result = %+v, want status %s and last status %d", result, tt.wantStatus, tt.status)
+			}
+			if !result.NextAttemptAt.Equal(tt.wantNext) {
+				t.Fatalf("next attempt = %v, want %v", result.NextAttemptAt, tt.wantNext)
+			}
+			if (result.LastError == "") != (tt.wantStatus == models.DeliveryDelivered) {
+				t.Fatalf("last error = %q", result.LastError)
+			}
+
+			if !Verify("secret", body, received.Header.Get(HeaderSignature)) {
+				t.Fatal("request signature does not match body")
+			}
+			if received.Header.Get(HeaderEvent) != models.EventBannerCreated || received.Header.Get(HeaderDelivery) != "7" {
+				t.Fatalf("headers = %v", received.Header)
+			}
+		})
+	}
+}
+
+// Недоступный получатель - такая же неудача, как ответ не 2xx
+func TestDeliverUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	result := newTestDeliverer(3).Deliver(context.Background(), models.WebhookDelivery{ID: 1, Attempts: 1, URL: server.URL})
+	if result.Status != models.DeliveryPending || result.LastStatus != 0 || result.LastError == "" {
+		t.Fatalf("result = %+v", result)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	d := newTestDeliverer(10)
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{20, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := d.delay(tt.attempt); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
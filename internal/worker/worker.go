@@ -0,0 +1,28 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/BelyaevEI/backend-trainee-assignment-2024/internal/logger"
+)
+
+// Every runs job each interval until context is cancelled.
+// Errors of job are logged, next run happens on schedule.
+func Every(ctx context.Context, name string, interval time.Duration, log *logger.Logger, job func(ctx context.Context) error) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Log.Infof("worker %s is stopped", name)
+			return
+		case <-ticker.C:
+			if err := job(ctx); err != nil && ctx.Err() == nil {
+				log.Log.Errorf("worker %s is failed: %v", name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,4 @@
+// Package bannerpb contains generated gRPC API of banner service.
+package bannerpb
+
+//go:generate protoc -I ../../api/proto --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative banner.proto